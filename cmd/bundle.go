@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"github.com/salsadigitalauorg/rockpool/pkg/lagoon"
+	r "github.com/salsadigitalauorg/rockpool/pkg/rockpool"
+
+	"github.com/spf13/cobra"
+)
+
+var bundleImages []string
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle [command]",
+	Short: "Create bundles to install the platform offline",
+}
+
+var bundleCreateCmd = &cobra.Command{
+	Use:   "create <file>",
+	Short: "Create a bundle of the charts, manifests and images of the platform",
+	Long: `create writes the charts, manifests and container images needed to
+set up the platform with the given Lagoon version into a single file, e.g,
+'rockpool bundle create rockpool.tar --lagoon-version v2.12.0', which can then
+be installed from without network access using 'rockpool up --bundle rockpool.tar'`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(r.CreateBundle(cmd.Context(), args[0], bundleImages),
+			"unable to create bundle")
+	},
+}
+
+func init() {
+	bundleCreateCmd.Flags().StringVarP(&lagoon.Version, "lagoon-version", "l",
+		lagoon.DefaultVersion, "The version of Lagoon to bundle")
+	bundleCreateCmd.Flags().StringSliceVar(&bundleImages, "image", nil,
+		"Additional images to bundle, e.g, the node image of other providers")
+	bundleCmd.AddCommand(bundleCreateCmd)
+	rootCmd.AddCommand(bundleCmd)
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/config"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config [command]",
+	Short: "Manage the platform's configuration.",
+	Long: `config is for viewing and updating the configuration saved for the
+platform in ~/.rockpool/<name>/config.yaml; it is written when running
+'rockpool up' and used by all subsequent commands, unless overridden by flags.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+	},
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Displays the platform's configuration",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := mustLoadConfig()
+		out, err := yaml.Marshal(cfg)
+		if err != nil {
+			log.WithError(err).Fatal("unable to encode config")
+		}
+		fmt.Print(string(out))
+	},
+}
+
+var configGetCmd = &cobra.Command{
+	Use:       "get key",
+	Short:     "Displays a single configuration value",
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: config.Default().Keys(),
+	Run: func(cmd *cobra.Command, args []string) {
+		val, err := mustLoadConfig().Get(args[0])
+		if err != nil {
+			log.WithError(err).Fatal("unable to get config value")
+		}
+		fmt.Println(val)
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:       "set key value",
+	Short:     "Updates a single configuration value",
+	Args:      cobra.ExactArgs(2),
+	ValidArgs: config.Default().Keys(),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := mustLoadConfig()
+		if err := cfg.Set(args[0], args[1]); err != nil {
+			log.WithError(err).Fatal("unable to set config value")
+		}
+		if err := cfg.Save(); err != nil {
+			log.WithField("file", config.Path()).WithError(err).
+				Fatal("unable to save config")
+		}
+	},
+}
+
+func mustLoadConfig() config.Config {
+	cfg, err := config.Load()
+	if err != nil {
+		log.WithField("file", config.Path()).WithError(err).
+			Fatal("unable to load config")
+	}
+	return cfg
+}
+
+func init() {
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	rootCmd.AddCommand(configCmd)
+}
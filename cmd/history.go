@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/command"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var historySlowest int
+
+var historyCmd = &cobra.Command{
+	Use:   "history [run]",
+	Short: "View the commands run by previous invocations",
+	Long: `history lists the previous runs of rockpool for the platform, as
+recorded in ~/.rockpool/<name>/logs; when a run is given, either by its id or
+as 'last', the slowest and failed commands of that run are displayed.`,
+	Args: cobra.MaximumNArgs(1),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		setLogLevel()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		logs, err := command.AuditLogs(auditLogDir())
+		if err != nil {
+			log.WithError(err).Fatal("unable to list logs")
+		}
+		if len(logs) == 0 {
+			fmt.Printf("No run recorded for '%s'\n", platform.Name)
+			return
+		}
+		if len(args) == 0 {
+			listRuns(logs)
+			return
+		}
+
+		path := filepath.Join(auditLogDir(), args[0]+".jsonl")
+		if args[0] == "last" {
+			path = logs[len(logs)-1]
+		}
+		run, err := command.ReadAuditLog(path)
+		if err != nil {
+			log.WithError(err).Fatal("unable to read log")
+		}
+		showRun(run)
+	},
+}
+
+func auditLogDir() string {
+	return filepath.Join(platform.Dir(), "logs")
+}
+
+func listRuns(logs []string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tSTARTED\tDURATION\tCOMMANDS\tFAILED\tARGS")
+	for _, l := range logs {
+		run, err := command.ReadAuditLog(l)
+		if err != nil {
+			log.WithError(err).Warn("unable to read log")
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n", run.ID,
+			run.Run.Time.Local().Format(time.DateTime),
+			run.Duration().Round(time.Second), len(run.Commands),
+			len(run.Failed()), strings.Join(run.Run.Run, " "))
+	}
+	w.Flush()
+}
+
+func showRun(run command.AuditRun) {
+	fmt.Println("Run:", run.ID)
+	fmt.Println("Args:", strings.Join(run.Run.Run, " "))
+	fmt.Println("Started:", run.Run.Time.Local().Format(time.DateTime))
+	fmt.Println("Duration:", run.Duration().Round(time.Second))
+	fmt.Println("Commands:", len(run.Commands))
+
+	fmt.Println()
+	fmt.Println("Slowest commands:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  DURATION\tEXIT\tCOMMAND")
+	for _, c := range run.Slowest(historySlowest) {
+		fmt.Fprintf(w, "  %s\t%d\t%s\n", c.Duration().Round(time.Millisecond),
+			c.ExitCode, strings.Join(c.Args, " "))
+	}
+	w.Flush()
+
+	failed := run.Failed()
+	if len(failed) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Println("Failed commands:")
+	for _, c := range failed {
+		fmt.Printf("  [%d] %s\n", c.ExitCode, strings.Join(c.Args, " "))
+		if c.Stderr != "" {
+			fmt.Printf("      %s\n", strings.ReplaceAll(strings.TrimSpace(c.Stderr), "\n", "\n      "))
+		}
+	}
+}
+
+func init() {
+	historyCmd.Flags().IntVarP(&historySlowest, "slowest", "s", 10,
+		"Number of slowest commands to display for a run; 0 displays all")
+	rootCmd.AddCommand(historyCmd)
+}
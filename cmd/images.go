@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	r "github.com/salsadigitalauorg/rockpool/pkg/rockpool"
+
+	"github.com/spf13/cobra"
+)
+
+var imagesManifest string
+var imagesArchive string
+var imagesComponents []string
+var imagesCluster string
+
+var imagesCmd = &cobra.Command{
+	Use:   "images [command]",
+	Short: "Preload images into the registry or import them into a cluster",
+}
+
+var imagesPreloadCmd = &cobra.Command{
+	Use:   "preload",
+	Short: "Push the images of the platform's components into the registry",
+	Long: `preload pushes the images listed in the images manifest into the
+platform's registry, from which the clusters pull them before trying their
+upstream registry. The images are taken from an archive created by
+'docker save' when using --archive, or else from the host's docker daemon`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(r.PreloadImages(cmd.Context(), imagesManifest, imagesArchive,
+			imagesComponents), "unable to preload images")
+	},
+}
+
+var imagesImportCmd = &cobra.Command{
+	Use:   "import <image...>",
+	Short: "Load images of the host's docker daemon into a cluster",
+	Long: `import loads locally built images into the nodes of a cluster, e.g,
+'rockpool images import uselagoon/build-deploy-image:local --cluster target-1'`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cn := fullClusterNamesFromArgs([]string{imagesCluster})[0]
+		exitOnError(r.ImportImages(cmd.Context(), cn, args), "unable to import images")
+	},
+}
+
+func init() {
+	imagesPreloadCmd.Flags().StringVar(&imagesManifest, "manifest", "",
+		"The images manifest, listing the images by component; defaults to the platform's components")
+	imagesPreloadCmd.Flags().StringVar(&imagesArchive, "archive", "",
+		"An archive created by 'docker save' to load the images from")
+	imagesPreloadCmd.Flags().StringSliceVar(&imagesComponents, "component", nil,
+		"The components whose images are preloaded, e.g, lagoon-core; defaults to all of them")
+	imagesImportCmd.Flags().StringVar(&imagesCluster, "cluster", "",
+		"The cluster to import the images into, e.g, target-1")
+	imagesImportCmd.MarkFlagRequired("cluster")
+	imagesCmd.AddCommand(imagesPreloadCmd)
+	imagesCmd.AddCommand(imagesImportCmd)
+	rootCmd.AddCommand(imagesCmd)
+}
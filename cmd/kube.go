@@ -3,8 +3,8 @@ package cmd
 import (
 	"fmt"
 
+	"github.com/salsadigitalauorg/rockpool/pkg/cluster"
 	"github.com/salsadigitalauorg/rockpool/pkg/command"
-	"github.com/salsadigitalauorg/rockpool/pkg/k3d"
 	"github.com/salsadigitalauorg/rockpool/pkg/kube"
 	"github.com/salsadigitalauorg/rockpool/pkg/platform"
 
@@ -13,9 +13,9 @@ import (
 )
 
 var kubeConfigClusterControllerOnly bool
-var kubeConfigClusterTargetOnly int
+var kubeConfigClusterTargetOnly string
 var kubeClusterControllerOnly bool
-var kubeClusterTargetOnly int
+var kubeClusterTargetOnly string
 var clusterNames []string
 var clusterName string
 
@@ -32,14 +32,16 @@ var kubeConfigCmd = &cobra.Command{
 			clusterNames = append(clusterNames, platform.ControllerClusterName())
 			return
 		}
-		if kubeConfigClusterTargetOnly > 0 {
-			clusterNames = append(clusterNames, platform.TargetClusterName(kubeClusterTargetOnly))
+		if kubeConfigClusterTargetOnly != "" {
+			clusterNames = append(clusterNames, targetClusterName(kubeConfigClusterTargetOnly))
 			return
 		}
 
-		k3d.ClusterFetch()
-		if len(k3d.Clusters) > 1 {
-			for _, c := range k3d.Clusters {
+		if err := cluster.Fetch(cmd.Context()); err != nil {
+			log.WithError(err).Fatal("unable to fetch clusters")
+		}
+		if len(cluster.Clusters) > 1 {
+			for _, c := range cluster.Clusters {
 				clusterNames = append(clusterNames, c.Name)
 			}
 		}
@@ -63,15 +65,17 @@ var kubeCtlCmd = &cobra.Command{
 		if kubeClusterControllerOnly {
 			clusterName = platform.ControllerClusterName()
 		}
-		if kubeClusterTargetOnly > 0 {
-			clusterName = platform.TargetClusterName(kubeClusterTargetOnly)
+		if kubeClusterTargetOnly != "" {
+			clusterName = targetClusterName(kubeClusterTargetOnly)
 		}
 		if clusterName == "" {
-			log.Fatal("no cluster specified - use one of --controller or --target=1")
+			log.Fatal("no cluster specified - use one of --controller or --target=target-1")
 		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		k3d.ClusterFetch()
+		if err := cluster.Fetch(cmd.Context()); err != nil {
+			log.WithError(err).Fatal("unable to fetch clusters")
+		}
 		kc := kube.KubeconfigPath(clusterName)
 		command.Syscall("kubectl", append([]string{"--kubeconfig", kc}, args...))
 	},
@@ -89,7 +93,9 @@ var kubeK9sCmd = &cobra.Command{
 	Short:  "Runs k9s with the specified cluster",
 	PreRun: kubeCtlCmd.PreRun,
 	Run: func(cmd *cobra.Command, args []string) {
-		k3d.ClusterFetch()
+		if err := cluster.Fetch(cmd.Context()); err != nil {
+			log.WithError(err).Fatal("unable to fetch clusters")
+		}
 		kc := kube.KubeconfigPath(clusterName)
 		command.Syscall("k9s", []string{"--kubeconfig", kc})
 	},
@@ -104,26 +110,26 @@ var rootK9sCmd = &cobra.Command{
 func init() {
 	kubeConfigCmd.Flags().BoolVar(&kubeConfigClusterControllerOnly, "controller",
 		false, "Get controller cluster kubeconfig only")
-	kubeConfigCmd.Flags().IntVar(&kubeConfigClusterTargetOnly, "target",
-		0, "Get single target cluster kubeconfig")
+	kubeConfigCmd.Flags().StringVar(&kubeConfigClusterTargetOnly, "target",
+		"", "Get single target cluster kubeconfig, e.g, target-1")
 
 	kubeCtlCmd.Flags().BoolVar(&kubeClusterControllerOnly, "controller",
 		true, "Get controller cluster kubeconfig only")
-	kubeCtlCmd.Flags().IntVar(&kubeClusterTargetOnly, "target",
-		0, "Get single target cluster kubeconfig")
+	kubeCtlCmd.Flags().StringVar(&kubeClusterTargetOnly, "target",
+		"", "Get single target cluster kubeconfig, e.g, target-1")
 	rootKubectlCmd.Flags().BoolVar(&kubeClusterControllerOnly, "controller",
 		true, "Get controller cluster kubeconfig only")
-	rootKubectlCmd.Flags().IntVar(&kubeClusterTargetOnly, "target",
-		0, "Get single target cluster kubeconfig")
+	rootKubectlCmd.Flags().StringVar(&kubeClusterTargetOnly, "target",
+		"", "Get single target cluster kubeconfig, e.g, target-1")
 
 	kubeK9sCmd.Flags().BoolVar(&kubeClusterControllerOnly, "controller",
 		true, "Get controller cluster kubeconfig only")
-	kubeK9sCmd.Flags().IntVar(&kubeClusterTargetOnly, "target",
-		0, "Get single target cluster kubeconfig")
+	kubeK9sCmd.Flags().StringVar(&kubeClusterTargetOnly, "target",
+		"", "Get single target cluster kubeconfig, e.g, target-1")
 	rootK9sCmd.Flags().BoolVar(&kubeClusterControllerOnly, "controller",
 		true, "Get controller cluster kubeconfig only")
-	rootK9sCmd.Flags().IntVar(&kubeClusterTargetOnly, "target",
-		0, "Get single target cluster kubeconfig")
+	rootK9sCmd.Flags().StringVar(&kubeClusterTargetOnly, "target",
+		"", "Get single target cluster kubeconfig, e.g, target-1")
 
 	kubeCmd.AddCommand(kubeConfigCmd)
 	kubeCmd.AddCommand(kubeCtlCmd)
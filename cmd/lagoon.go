@@ -4,6 +4,8 @@ import (
 	"fmt"
 
 	"github.com/salsadigitalauorg/rockpool/pkg/lagoon"
+
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -16,7 +18,11 @@ var lagoonAdminTokenCmd = &cobra.Command{
 	Use:   "admin-token",
 	Short: "Fetch an admin token for the Lagoon API.",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Print(lagoon.FetchApiAdminToken())
+		token, err := lagoon.FetchApiAdminToken(cmd.Context())
+		if err != nil {
+			log.WithError(err).Fatal("unable to fetch admin token")
+		}
+		fmt.Print(token)
 	},
 }
 
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/cluster"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var nodeCmd = &cobra.Command{
+	Use:   "node [command]",
+	Short: "Add or remove the agents of a running cluster",
+}
+
+var nodeAddCmd = &cobra.Command{
+	Use:   "add <cluster>",
+	Short: "Add an agent to a cluster",
+	Long: `add creates an agent with the memory limit, labels and taints of the
+cluster's topology and joins it to the cluster, e.g, 'rockpool node add target-1'`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cn := fullClusterNamesFromArgs(args)[0]
+		if err := cluster.Fetch(cmd.Context()); err != nil {
+			log.WithError(err).Fatal("unable to fetch clusters")
+		}
+		n, err := cluster.AddNode(cmd.Context(), cn)
+		exitOnError(err, "unable to add node")
+		fmt.Println(n)
+	},
+}
+
+var nodeRemoveCmd = &cobra.Command{
+	Use:   "remove <cluster> [node]",
+	Short: "Remove an agent from a cluster",
+	Long: `remove deletes the given agent of a cluster, or its last one, e.g,
+'rockpool node remove target-1'; servers can't be removed`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cn := fullClusterNamesFromArgs(args[:1])[0]
+		node := ""
+		if len(args) > 1 {
+			node = args[1]
+		}
+		if err := cluster.Fetch(cmd.Context()); err != nil {
+			log.WithError(err).Fatal("unable to fetch clusters")
+		}
+		exitOnError(cluster.RemoveNode(cmd.Context(), cn, node), "unable to remove node")
+	},
+}
+
+func init() {
+	nodeCmd.AddCommand(nodeAddCmd)
+	nodeCmd.AddCommand(nodeRemoveCmd)
+	rootCmd.AddCommand(nodeCmd)
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/k3d"
+	r "github.com/salsadigitalauorg/rockpool/pkg/rockpool"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var registryRepositories bool
+var registryResetAll bool
+
+var registryCmd = &cobra.Command{
+	Use:   "registry [command]",
+	Short: "Report on and clean up the platform's registries",
+}
+
+var registryStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state and disk usage of the registries",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(r.RegistryStatus(cmd.Context(), os.Stdout, registryRepositories),
+			"unable to get registry status")
+	},
+}
+
+var registryPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Run the garbage collection of the registries",
+	Long: `prune removes the untagged manifests and the blobs which are no longer
+referenced from the running registries; each registry is stopped during its
+garbage collection, and started again afterwards`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		freed, err := k3d.RegistryPrune(cmd.Context())
+		exitOnError(err, "unable to prune registries")
+		log.WithField("freed", r.FormatSize(freed)).Info("pruned registries")
+	},
+}
+
+var registryResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Wipe the cache of the mirrors' registries",
+	Long: `reset removes all the images cached by the mirrors' registries, so
+that they are pulled from their upstream again; the images preloaded into the
+main registry are only removed when using --all`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(k3d.RegistryReset(cmd.Context(), registryResetAll),
+			"unable to reset registries")
+	},
+}
+
+func init() {
+	registryStatusCmd.Flags().BoolVarP(&registryRepositories, "repositories", "r", false,
+		"List the repositories of each registry")
+	registryResetCmd.Flags().BoolVar(&registryResetAll, "all", false,
+		"Also remove the images preloaded into the main registry")
+	registryCmd.AddCommand(registryStatusCmd)
+	registryCmd.AddCommand(registryPruneCmd)
+	registryCmd.AddCommand(registryResetCmd)
+	rootCmd.AddCommand(registryCmd)
+}
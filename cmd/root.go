@@ -1,11 +1,27 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/salsadigitalauorg/rockpool/pkg/action"
+	"github.com/salsadigitalauorg/rockpool/pkg/bundle"
+	"github.com/salsadigitalauorg/rockpool/pkg/cluster"
+	"github.com/salsadigitalauorg/rockpool/pkg/command"
+	"github.com/salsadigitalauorg/rockpool/pkg/config"
 	"github.com/salsadigitalauorg/rockpool/pkg/helm"
+	"github.com/salsadigitalauorg/rockpool/pkg/k3d"
+	"github.com/salsadigitalauorg/rockpool/pkg/kube"
 	"github.com/salsadigitalauorg/rockpool/pkg/lagoon"
 	"github.com/salsadigitalauorg/rockpool/pkg/platform"
 	r "github.com/salsadigitalauorg/rockpool/pkg/rockpool"
@@ -23,27 +39,40 @@ var (
 var logLevel string
 var debug bool
 var trace bool
+var timeouts map[string]string
+var recordFile string
+var kubeconfigs map[string]string
+var k3sVersions map[string]string
+var servers map[string]int
+var agents map[string]int
+var nodeMemory map[string]string
+var namedTargets map[string]int
+var bundleFile string
+var purge bool
 
 var rootCmd = &cobra.Command{
 	Use:   "rockpool [command]",
 	Short: "Easily create local Lagoon instances.",
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
-		if debug {
-			logLevel = "debug"
-		}
-		if trace {
-			logLevel = "trace"
-		}
-		if logrusLevel, err := log.ParseLevel(logLevel); err != nil {
-			panic(err)
-		} else {
-			log.SetLevel(logrusLevel)
-		}
+		setLogLevel()
 		// Do not initialise when just running the root command.
 		if cmd.Use == "rockpool [command]" {
 			return
 		}
-		r.Initialise()
+		if err := setTimeouts(); err != nil {
+			log.WithError(err).Fatal("invalid timeout")
+		}
+		if recordFile != "" {
+			command.NewRecorder(recordFile).Install()
+		}
+		loadConfig(cmd)
+		if auditedCommands[topLevelCommand(cmd).Name()] {
+			openAuditLog()
+		}
+		if action.Graph {
+			action.DryRun = true
+		}
+		exitOnError(r.Initialise(cmd.Context()), "unable to initialise")
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		cmd.Usage()
@@ -66,9 +95,34 @@ var upCmd = &cobra.Command{
 	Long: `up is for creating or starting all the clusters, or the ones
 specified in the arguments, e.g, 'rockpool up controller target-1'`,
 	Run: func(cmd *cobra.Command, args []string) {
-		r.Up(fullClusterNamesFromArgs(args))
+		if err := action.ValidateStageFilters(); err != nil {
+			log.WithError(err).Fatal("invalid stage filter")
+		}
+		if err := k3d.ValidateK3sVersions(); err != nil {
+			log.WithError(err).Fatal("invalid k3s version")
+		}
+		if err := cluster.ValidateTopologies(); err != nil {
+			log.WithError(err).Fatal("invalid topology")
+		}
+		if err := platform.HostPorts.Validate(); err != nil {
+			log.WithError(err).Fatal("invalid host ports")
+		}
+		if err := k3d.ValidateMirrors(); err != nil {
+			log.WithError(err).Fatal("invalid mirrors")
+		}
+		if bundleFile != "" {
+			openBundle(cmd)
+		}
+		if action.DryRun {
+			exitOnError(r.Up(cmd.Context(), fullClusterNamesFromArgs(args)),
+				"unable to plan the platform")
+			return
+		}
+		saveConfig()
+		exitOnError(r.Up(cmd.Context(), fullClusterNamesFromArgs(args)),
+			"unable to bring up the platform")
 		fmt.Println()
-		r.Status()
+		exitOnError(r.Status(cmd.Context()), "unable to get status")
 	},
 }
 
@@ -78,7 +132,8 @@ var startCmd = &cobra.Command{
 	Long: `start is for starting all the clusters, or the ones
 specified in the arguments, e.g, 'rockpool start controller target-1'`,
 	Run: func(cmd *cobra.Command, args []string) {
-		r.Start(fullClusterNamesFromArgs(args))
+		exitOnError(r.Start(cmd.Context(), fullClusterNamesFromArgs(args)),
+			"unable to start clusters")
 	},
 }
 
@@ -88,7 +143,8 @@ var stopCmd = &cobra.Command{
 	Long: `stop is for stopping all the clusters, or the ones
 specified in the arguments, e.g, 'rockpool stop controller target-1'`,
 	Run: func(cmd *cobra.Command, args []string) {
-		r.Stop(fullClusterNamesFromArgs(args))
+		exitOnError(r.Stop(cmd.Context(), fullClusterNamesFromArgs(args)),
+			"unable to stop clusters")
 	},
 }
 
@@ -98,8 +154,10 @@ var restartCmd = &cobra.Command{
 	Long: `restart is for stopping and starting all the clusters, or the ones
 specified in the arguments, e.g, 'rockpool restart controller target-1'`,
 	Run: func(cmd *cobra.Command, args []string) {
-		r.Stop(fullClusterNamesFromArgs(args))
-		r.Start(fullClusterNamesFromArgs(args))
+		exitOnError(r.Stop(cmd.Context(), fullClusterNamesFromArgs(args)),
+			"unable to stop clusters")
+		exitOnError(r.Start(cmd.Context(), fullClusterNamesFromArgs(args)),
+			"unable to start clusters")
 	},
 }
 
@@ -107,7 +165,15 @@ var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "View the status of the clusters",
 	Run: func(cmd *cobra.Command, args []string) {
-		r.Status()
+		exitOnError(r.Status(cmd.Context()), "unable to get status")
+	},
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the platforms and the state of their clusters",
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(r.ListPlatforms(cmd.Context(), os.Stdout), "unable to list platforms")
 	},
 }
 
@@ -115,22 +181,212 @@ var downCmd = &cobra.Command{
 	Use:   "down [name...]",
 	Short: "Stop the clusters and delete them",
 	Long: `down is for stopping and deleting all the clusters, or the ones
-specified in the arguments, e.g, 'rockpool down controller target-1'`,
+specified in the arguments, e.g, 'rockpool down controller target-1'; the
+registries are only stopped, unless --purge is used`,
 	Run: func(cmd *cobra.Command, args []string) {
-		r.Down(fullClusterNamesFromArgs(args))
+		exitOnError(r.Down(cmd.Context(), fullClusterNamesFromArgs(args), purge),
+			"unable to delete clusters")
 	},
 }
 
+// targetsFromFlag returns the targets given using --target, sorted by remote
+// id, with the remote name and router pattern of the saved ones.
+func targetsFromFlag(saved []platform.Target) []platform.Target {
+	targets := []platform.Target{}
+	for n, id := range namedTargets {
+		t := platform.Target{Name: n}
+		for _, s := range saved {
+			if s.Name == n {
+				t = s
+			}
+		}
+		t.RemoteId = id
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].RemoteId < targets[j].RemoteId })
+	return targets
+}
+
+// fullClusterNamesFromArgs returns the cluster names of the controller and
+// targets given by their short name, e.g, controller or target-1.
 func fullClusterNamesFromArgs(argClusters []string) []string {
 	clusters := []string{}
 	for _, c := range argClusters {
-		clusters = append(clusters, platform.Name+"-"+c)
+		cn := platform.Name + "-" + c
+		if !slices.Contains(platform.ClusterNames(), cn) {
+			log.WithField("clusters", shortClusterNames()).
+				Fatalf("unknown cluster '%s'", c)
+		}
+		clusters = append(clusters, cn)
 	}
 	return clusters
 }
 
+// shortClusterNames returns the names of the platform's clusters without the
+// platform's name.
+func shortClusterNames() []string {
+	names := []string{}
+	for _, cn := range platform.ClusterNames() {
+		names = append(names, strings.TrimPrefix(cn, platform.Name+"-"))
+	}
+	return names
+}
+
+// openBundle extracts the bundle to install the platform from; the bundle
+// sets the Lagoon version.
+func openBundle(cmd *cobra.Command) {
+	b, err := bundle.Open(bundleFile)
+	exitOnError(err, "unable to open bundle")
+	if cmd.Flags().Changed("lagoon-version") && lagoon.Version != b.LagoonVersion {
+		log.WithField("bundle", b.LagoonVersion).
+			Fatalf("the bundle is for another Lagoon version than %s", lagoon.Version)
+	}
+	lagoon.Version = b.LagoonVersion
+}
+
+// targetClusterName returns the cluster name of the target given by name, or
+// by number for the numbered targets, e.g, 1 for target-1.
+func targetClusterName(name string) string {
+	if _, err := strconv.Atoi(name); err == nil {
+		name = "target-" + name
+	}
+	return platform.Name + "-" + name
+}
+
+func setLogLevel() {
+	if debug {
+		logLevel = "debug"
+	}
+	if trace {
+		logLevel = "trace"
+	}
+	if logrusLevel, err := log.ParseLevel(logLevel); err != nil {
+		panic(err)
+	} else {
+		log.SetLevel(logrusLevel)
+	}
+}
+
+// setTimeouts sets the command timeouts provided as flags.
+func setTimeouts() error {
+	for bin, t := range timeouts {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return fmt.Errorf("invalid timeout for %s: %w", bin, err)
+		}
+		command.Timeouts[bin] = d
+	}
+	return nil
+}
+
+// exitOnError logs the error and exits if it is not nil; if the command was
+// interrupted, the stage it was interrupted in is reported.
+func exitOnError(err error, msg string) {
+	if err == nil {
+		return
+	}
+	var ie *action.InterruptedError
+	if errors.As(err, &ie) {
+		log.WithFields(log.Fields{
+			"stage":   ie.Stage,
+			"cluster": ie.Cluster,
+			"actions": ie.Actions,
+		}).WithError(ie.Err).Fatal("interrupted")
+	}
+	log.WithError(err).Fatal(msg)
+}
+
+// auditedCommands are the top-level commands changing the platform, whose
+// runs are written to the audit log.
+var auditedCommands = map[string]bool{
+	"up":       true,
+	"down":     true,
+	"start":    true,
+	"stop":     true,
+	"restart":  true,
+	"target":   true,
+	"node":     true,
+	"registry": true,
+	"images":   true,
+}
+
+// topLevelCommand returns the child of the root command cmd belongs to.
+func topLevelCommand(cmd *cobra.Command) *cobra.Command {
+	for cmd.HasParent() && cmd.Parent().HasParent() {
+		cmd = cmd.Parent()
+	}
+	return cmd
+}
+
+// openAuditLog starts logging the commands run to a new file in the
+// platform's logs directory.
+func openAuditLog() {
+	l, err := command.OpenAuditLog(auditLogDir(),
+		append([]string{"rockpool"}, os.Args[1:]...))
+	if err != nil {
+		log.WithField("dir", auditLogDir()).WithError(err).
+			Warn("unable to open audit log")
+		return
+	}
+	command.Audit = l
+	// Fatal errors exit without returning to Execute.
+	log.RegisterExitHandler(closeAuditLog)
+}
+
+// closeAuditLog closes the audit log, if one was opened.
+func closeAuditLog() {
+	if command.Audit == nil {
+		return
+	}
+	command.Audit.Close()
+}
+
+// loadConfig sets the platform values from the saved config, unless they
+// have been explicitly provided as flags.
+func loadConfig(cmd *cobra.Command) {
+	for n, kc := range kubeconfigs {
+		if abs, err := filepath.Abs(kc); err == nil {
+			kc = abs
+		}
+		kube.Kubeconfigs[platform.Name+"-"+n] = kc
+	}
+	for n, v := range k3sVersions {
+		k3d.K3sVersions[platform.Name+"-"+n] = v
+	}
+	c := mustLoadConfig()
+	c.Apply(cmd.Flags().Changed)
+	if len(namedTargets) > 0 {
+		if cmd.Flags().Changed("targets") {
+			log.Fatal("only one of --targets and --target can be used")
+		}
+		platform.Targets = targetsFromFlag(c.NamedTargets)
+	}
+	if err := platform.ValidateTargets(platform.Targets); err != nil {
+		log.WithError(err).Fatal("invalid targets")
+	}
+
+	// The node counts and memory given as flags override the ones of the
+	// saved topologies.
+	setTopology := func(n string, set func(t *cluster.Topology)) {
+		cn := platform.Name + "-" + n
+		t := cluster.TopologyFor(cn)
+		set(&t)
+		cluster.Topologies[cn] = t
+	}
+	for n, v := range servers {
+		setTopology(n, func(t *cluster.Topology) { t.Servers = v })
+	}
+	for n, v := range agents {
+		setTopology(n, func(t *cluster.Topology) { t.Agents = v })
+	}
+	for n, v := range nodeMemory {
+		setTopology(n, func(t *cluster.Topology) { t.Memory = v })
+	}
+}
+
 func init() {
 	determineConfigDir()
+	defaults := config.Default()
 
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info",
 		"Sets the logging level")
@@ -141,23 +397,82 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVarP(&platform.Name, "name", "n",
 		"rockpool", "The name of the platform")
+	rootCmd.PersistentFlags().StringVar(&recordFile, "record", "",
+		`Record the commands run, with their output and exit code, to the given
+fixture file for replaying in tests`)
+	rootCmd.PersistentFlags().StringToStringVar(&timeouts, "timeout", nil,
+		`Maximum duration of a single command per binary, e.g,
+k3d=20m,docker=10m; there is none by default, except for the commands only
+querying some state, which are given a minute, and 0 disables it`)
 
-	upCmd.Flags().IntVarP(&platform.NumTargets, "targets", "t", 1,
+	upCmd.Flags().IntVarP(&platform.NumTargets, "targets", "t",
+		defaults.Targets,
 		"Number of targets (lagoon remotes) to create")
-	upCmd.Flags().StringVarP(&platform.Domain, "domain", "d", "k3d.local",
+	upCmd.Flags().StringToIntVar(&namedTargets, "target", nil,
+		`The targets to create by name, with the id of their Lagoon remote,
+e.g, prod=1,dev=2; replaces the targets numbered using --targets`)
+	upCmd.Flags().StringVarP(&platform.Domain, "domain", "d", defaults.Domain,
 		`The base domain of the platform; ancillary services will be created as
 its subdomains using the provided 'name', e.g, rockpool.k3d.local,
 lagoon.rockpool.k3d.local`)
 
 	upCmd.Flags().StringVarP(&lagoon.Version, "lagoon-version", "l",
-		lagoon.DefaultVersion, "The version of Lagoon to install")
+		defaults.LagoonVersion, "The version of Lagoon to install")
 	upCmd.Flags().StringSliceVar(&helm.UpgradeComponents, "upgrade-components",
 		[]string{},
 		"A list of components to upgrade, e.g, all or ingress-nginx,harbor")
 
+	upCmd.Flags().BoolVar(&action.DryRun, "dry-run", false,
+		`Print the plan of what would be done for each stage and cluster,
+without creating or modifying anything; the changes to the existing
+objects of the clusters are included`)
+	upCmd.Flags().BoolVar(&kube.Diff, "diff", false,
+		"Log the changes made to the existing objects of the clusters")
+	upCmd.Flags().BoolVar(&kube.ForceConflicts, "force-conflicts", kube.ForceConflicts,
+		`Take over the fields of the applied objects changed by others, e.g,
+using kubectl; when disabled, such changes make the apply fail`)
+
+	upCmd.Flags().BoolVar(&action.Graph, "graph", false,
+		`Print the dependency graph of the actions in each stage in the DOT
+format instead of running them; implies --dry-run`)
+	upCmd.Flags().IntVar(&action.Concurrency, "concurrency", action.Concurrency,
+		"The maximum number of actions to run in parallel within a stage")
+
+	upCmd.Flags().BoolVar(&action.Resume, "resume", false,
+		`Skip the actions completed in the previous run, e.g, to continue after
+a failure`)
+	upCmd.Flags().StringVar(&action.FromStage, "from-stage", "",
+		`Only run the actions from the given stage onwards, e.g,
+controller-setup or target-setup`)
+	upCmd.Flags().StringVar(&action.OnlyStage, "only-stage", "",
+		`Only run the actions in the given stage, e.g, controller-setup or
+target-setup`)
+
+	upCmd.Flags().StringVar(&cluster.DefaultProvider, "provider", defaults.Provider,
+		"The provider creating the clusters: k3d or kind")
+	upCmd.Flags().StringToStringVar(&kubeconfigs, "kubeconfig", nil,
+		`Use existing clusters instead of creating them, given their kubeconfig,
+e.g, target-1=$HOME/.kube/staging.yaml`)
+	upCmd.Flags().StringVar(&k3d.K3sVersion, "k3s-version", defaults.K3sVersion,
+		`The version of k3s to create the k3d clusters with: latest, a minor
+version such as v1.25 or a k3s version such as v1.25.6-k3s1`)
+	upCmd.Flags().StringToStringVar(&k3sVersions, "target-k3s-version", nil,
+		`Override the k3s version per cluster, e.g, target-1=v1.23`)
+	upCmd.Flags().StringToIntVar(&servers, "servers", nil,
+		`The number of servers per cluster, e.g, target-1=3 for an HA cluster;
+clusters have a single server by default`)
+	upCmd.Flags().StringToIntVar(&agents, "agents", nil,
+		"The number of agents per cluster, e.g, target-1=2 (default 1)")
+	upCmd.Flags().StringToStringVar(&nodeMemory, "node-memory", nil,
+		"The memory limit of the nodes per cluster, e.g, target-1=2g")
 	upCmd.Flags().StringVarP(&platform.LagoonSshKey, "ssh-key", "k", "",
 		`The ssh key to add to the lagoonadmin user. If empty, rockpool tries
 to use ~/.ssh/id_ed25519.pub first, then ~/.ssh/id_rsa.pub.`)
+	upCmd.Flags().StringVar(&bundleFile, "bundle", "",
+		"A bundle created by 'rockpool bundle create' to install the platform from, without network access")
+
+	downCmd.Flags().BoolVar(&purge, "purge", false,
+		"Also delete the registries and their content when no cluster is left")
 
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(upCmd)
@@ -166,6 +481,7 @@ to use ~/.ssh/id_ed25519.pub first, then ~/.ssh/id_rsa.pub.`)
 	rootCmd.AddCommand(restartCmd)
 	rootCmd.AddCommand(downCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(listCmd)
 }
 
 func determineConfigDir() {
@@ -178,7 +494,27 @@ func determineConfigDir() {
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	// Cancel the running commands on the first interrupt; the default
+	// behaviour is restored afterwards so that a second one exits immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+		case <-ctx.Done():
+			return
+		}
+		signal.Stop(signals)
+		log.Warn("interrupt received, stopping the running commands; " +
+			"press Ctrl-C again to exit immediately")
+		cancel()
+	}()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		log.WithError(err).Fatal()
 	}
+	closeAuditLog()
 }
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/config"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+	r "github.com/salsadigitalauorg/rockpool/pkg/rockpool"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var targetRemoteId int
+var targetRemoteName string
+var targetRouterPattern string
+var targetMounts []string
+var migrateTo string
+
+var targetCmd = &cobra.Command{
+	Use:   "target [command]",
+	Short: "Add or remove the targets of a running platform",
+}
+
+var targetAddCmd = &cobra.Command{
+	Use:   "add [name]",
+	Short: "Add a target",
+	Long: `add creates and sets up the cluster of a new target and registers its
+remote in Lagoon, e.g, 'rockpool target add staging'; the target is named and
+numbered after the existing ones by default`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := ""
+		if len(args) > 0 {
+			name = args[0]
+		}
+		t := r.NextTarget(name)
+		if cmd.Flags().Changed("remote-id") {
+			t.RemoteId = targetRemoteId
+		}
+		if targetRemoteName != "" {
+			t.RemoteName = targetRemoteName
+		}
+		if targetRouterPattern != "" {
+			t.RouterPattern = targetRouterPattern
+		}
+		for _, m := range targetMounts {
+			mount, err := platform.ParseMount(m)
+			exitOnError(err, "invalid mount")
+			t.Mounts = append(t.Mounts, mount)
+		}
+		err := r.AddTarget(cmd.Context(), t)
+		// The target is saved even if its set up failed, so that it can be
+		// resumed using up.
+		saveConfig()
+		exitOnError(err, "unable to add target")
+		fmt.Println()
+		exitOnError(r.Status(cmd.Context()), "unable to get status")
+	},
+}
+
+var targetRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a target",
+	Long: `remove deletes the remote of a target from Lagoon and its cluster, e.g,
+'rockpool target remove staging'; a target with environments is only removed
+if they are migrated to another target using --migrate-to`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		exitOnError(r.RemoveTarget(cmd.Context(), args[0], migrateTo),
+			"unable to remove target")
+		saveConfig()
+	},
+}
+
+// saveConfig saves the platform's current config.
+func saveConfig() {
+	if err := config.FromPlatform().Save(); err != nil {
+		log.WithField("file", config.Path()).WithError(err).
+			Fatal("unable to save config")
+	}
+}
+
+func init() {
+	targetAddCmd.Flags().IntVar(&targetRemoteId, "remote-id", 0,
+		"The id of the target's remote in Lagoon; defaults to the next free one")
+	targetAddCmd.Flags().StringVar(&targetRemoteName, "remote-name", "",
+		"The name of the target's remote in Lagoon; defaults to the target's name")
+	targetAddCmd.Flags().StringVar(&targetRouterPattern, "router-pattern", "",
+		"The router pattern of the target's remote")
+	targetAddCmd.Flags().StringArrayVar(&targetMounts, "mount", nil,
+		"A host directory to mount in the target's nodes, as <name>=<host path>; can be repeated")
+	targetRemoveCmd.Flags().StringVar(&migrateTo, "migrate-to", "",
+		"The target to migrate the target's environments to")
+	targetCmd.AddCommand(targetAddCmd)
+	targetCmd.AddCommand(targetRemoveCmd)
+	rootCmd.AddCommand(targetCmd)
+}
@@ -1,16 +1,83 @@
 package action
 
-import log "github.com/sirupsen/logrus"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
+	log "github.com/sirupsen/logrus"
+)
+
+// DryRun prevents the actions from being executed; their description is
+// printed instead.
+var DryRun bool
+
+// Concurrency is the maximum number of actions a chain runs at the same time
+// when it does not set its own limit.
+var Concurrency = 4
+
+// Graph prints the dependency graph of each chain in DOT format instead of
+// the plan when running in dry-run mode.
+var Graph bool
+
+// Chain is a set of actions run as a dependency graph: an action starts once
+// all the actions it depends on have completed, and independent actions run
+// in parallel, in the order they were added.
 type Chain struct {
 	Actions          []Action
 	ErrorMsg         string
 	FailOnFirstError *bool
+	// Concurrency overrides the package-level limit when greater than 0.
+	Concurrency int
 }
 
 type Action interface {
 	GetStage() string
-	Execute() bool
+	GetClusterName() string
+	// GetName returns an identifier for the action, unique within its stage
+	// and cluster.
+	GetName() string
+	// GetDependencies returns the names of the actions in the same chain
+	// which must complete before this one starts.
+	GetDependencies() []string
+	Describe() string
+	Execute(ctx context.Context) error
+}
+
+// Result holds the outcome of running a chain.
+type Result struct {
+	Completed []Action
+	Skipped   []Action
+	Failed    []*ActionError
+	// Blocked holds the actions which were not run because an action they
+	// depend on failed, or because the chain stopped after a failure.
+	Blocked  []Action
+	errorMsg string
+	// err holds an error preventing the chain from running to completion,
+	// such as an invalid graph or a cancellation.
+	err error
+}
+
+// Err returns the errors of the failed actions joined together, or nil if
+// there were none.
+func (r Result) Err() error {
+	errs := []error{}
+	if r.err != nil {
+		errs = append(errs, r.err)
+	}
+	for _, f := range r.Failed {
+		errs = append(errs, f)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	if r.errorMsg == "" {
+		return errors.Join(errs...)
+	}
+	return fmt.Errorf("%s: %w", r.errorMsg, errors.Join(errs...))
 }
 
 func (c *Chain) Add(a Action) *Chain {
@@ -18,21 +85,300 @@ func (c *Chain) Add(a Action) *Chain {
 	return c
 }
 
-func (c Chain) Run() {
-	log.WithField("actions", c.Actions).Debug("running chain")
+// dependencies returns the indices of the actions each action depends on,
+// validating that they exist.
+func (c Chain) dependencies() ([][]int, error) {
+	idx := map[string]int{}
+	for i, a := range c.Actions {
+		if a.GetName() == "" {
+			return nil, fmt.Errorf("action without a name in chain: %s", a.Describe())
+		}
+		if _, ok := idx[a.GetName()]; ok {
+			return nil, fmt.Errorf("duplicate action in chain: %s", a.GetName())
+		}
+		idx[a.GetName()] = i
+	}
+	deps := make([][]int, len(c.Actions))
+	for i, a := range c.Actions {
+		for _, d := range a.GetDependencies() {
+			j, ok := idx[d]
+			if !ok {
+				return nil, fmt.Errorf("action %s depends on unknown action %s",
+					a.GetName(), d)
+			}
+			deps[i] = append(deps[i], j)
+		}
+	}
+	return deps, nil
+}
+
+// Order returns the actions sorted so that each one comes after its
+// dependencies, keeping the order in which they were added otherwise. An
+// error is returned if a dependency is unknown or there is a cycle.
+func (c Chain) Order() ([]Action, error) {
+	deps, err := c.dependencies()
+	if err != nil {
+		return nil, err
+	}
+	done := make([]bool, len(c.Actions))
+	ordered := []Action{}
+	for len(ordered) < len(c.Actions) {
+		progress := false
+		for i, a := range c.Actions {
+			if done[i] || !allDone(deps[i], done) {
+				continue
+			}
+			done[i] = true
+			ordered = append(ordered, a)
+			progress = true
+			break
+		}
+		if !progress {
+			cycle := []string{}
+			for i, a := range c.Actions {
+				if !done[i] {
+					cycle = append(cycle, a.GetName())
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between actions: %s",
+				strings.Join(cycle, ", "))
+		}
+	}
+	return ordered, nil
+}
+
+func allDone(indices []int, done []bool) bool {
+	for _, i := range indices {
+		if !done[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Dot returns the dependency graph of the chain in the DOT format, with edges
+// going from each action to the ones depending on it.
+func (c Chain) Dot() string {
+	name := "chain"
+	if len(c.Actions) > 0 {
+		name = c.Actions[0].GetStage()
+		if cn := c.Actions[0].GetClusterName(); cn != "" {
+			name += " " + cn
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", name)
+	for _, a := range c.Actions {
+		fmt.Fprintf(&b, "  %q;\n", a.GetName())
+	}
+	for _, a := range c.Actions {
+		for _, d := range a.GetDependencies() {
+			fmt.Fprintf(&b, "  %q -> %q;\n", d, a.GetName())
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+type outcome struct {
+	index int
+	err   error
+}
+
+// Run executes the actions according to their dependencies, running up to
+// the concurrency limit at the same time. Unless FailOnFirstError is set to
+// false, no further actions are started after a failure; the actions
+// depending on a failed one are never run.
+func (c Chain) Run() Result {
+	return c.RunContext(context.Background())
+}
+
+// RunContext is like Run, but stops starting new actions once ctx is done.
+func (c Chain) RunContext(ctx context.Context) Result {
+	res := Result{errorMsg: c.ErrorMsg}
+	ordered, err := c.Order()
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	if DryRun {
+		if Graph {
+			fmt.Print(c.Dot())
+			return res
+		}
+		// The descriptions are computed first, since they may query the
+		// clusters.
+		descs := make([]string, len(ordered))
+		for i, a := range ordered {
+			descs[i] = a.Describe()
+			if run, reason := shouldRun(a); !run {
+				descs[i] += " (skipped: " + reason + ")"
+			}
+		}
+		planMu.Lock()
+		defer planMu.Unlock()
+		for i, a := range ordered {
+			plan(a.GetStage(), a.GetClusterName(), descs[i])
+		}
+		return res
+	}
+
+	log.WithField("graph", c.Dot()).Debug("running chain")
 	if c.FailOnFirstError == nil {
 		c.FailOnFirstError = &[]bool{true}[0]
 	}
-	failureEncountered := false
-	for _, a := range c.Actions {
-		success := a.Execute()
-		if !success && *c.FailOnFirstError {
-			log.Fatal(c.ErrorMsg)
-		} else if !success {
-			failureEncountered = true
+	limit := c.Concurrency
+	if limit <= 0 {
+		limit = Concurrency
+	}
+	if limit <= 0 {
+		limit = 1
+	}
+
+	deps, _ := c.dependencies()
+	dependents := make([][]int, len(c.Actions))
+	remaining := make([]int, len(c.Actions))
+	for i, d := range deps {
+		remaining[i] = len(d)
+		for _, j := range d {
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+	ready := []int{}
+	for i := range c.Actions {
+		if remaining[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	release := func(i int) {
+		for _, j := range dependents[i] {
+			remaining[j]--
+			if remaining[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+		sort.Ints(ready)
+	}
+
+	finished := make([]bool, len(c.Actions))
+	outcomes := make(chan outcome)
+	running := 0
+	stopped := false
+	interrupted := []Action{}
+	for {
+		if !stopped && len(ready) > 0 && ctx.Err() != nil {
+			stopped = true
+		}
+		for !stopped && running < limit && len(ready) > 0 {
+			i := ready[0]
+			ready = ready[1:]
+			a := c.Actions[i]
+			if run, reason := shouldRun(a); !run {
+				actionLogger(a).Debug("skipping action: " + reason)
+				res.Skipped = append(res.Skipped, a)
+				finished[i] = true
+				release(i)
+				continue
+			}
+			running++
+			go func(i int, a Action) {
+				outcomes <- outcome{index: i, err: a.Execute(ctx)}
+			}(i, a)
+		}
+		if running == 0 {
+			break
+		}
+
+		o := <-outcomes
+		running--
+		a := c.Actions[o.index]
+		finished[o.index] = true
+		if o.err != nil && ctx.Err() != nil {
+			actionLogger(a).WithError(o.err).Warn("action interrupted")
+			interrupted = append(interrupted, a)
+			stopped = true
+			continue
+		}
+		if o.err != nil {
+			actionLogger(a).WithError(o.err).Error("action failed")
+			res.Failed = append(res.Failed, &ActionError{Action: a, Err: o.err})
+			if *c.FailOnFirstError {
+				stopped = true
+			}
+			continue
+		}
+		MarkCompleted(a)
+		res.Completed = append(res.Completed, a)
+		release(o.index)
+	}
+
+	for i, a := range c.Actions {
+		if !finished[i] {
+			actionLogger(a).Debug("action blocked")
+			res.Blocked = append(res.Blocked, a)
 		}
 	}
-	if failureEncountered {
-		log.Fatal(c.ErrorMsg)
+	if ctx.Err() != nil && (len(interrupted) > 0 || len(res.Blocked) > 0) {
+		res.err = newInterruptedError(ctx.Err(), interrupted, res.Blocked)
+	}
+	return res
+}
+
+// newInterruptedError reports the stage of the actions which were running
+// when the chain was interrupted, or of the next one which would have run.
+func newInterruptedError(err error, running []Action, blocked []Action) *InterruptedError {
+	e := &InterruptedError{Err: err}
+	first := running
+	if len(first) == 0 {
+		first = blocked
+	}
+	e.Stage = first[0].GetStage()
+	e.Cluster = first[0].GetClusterName()
+	for _, a := range running {
+		e.Actions = append(e.Actions, a.GetName())
+	}
+	return e
+}
+
+func actionLogger(a Action) *log.Entry {
+	return log.WithFields(log.Fields{
+		"stage":   a.GetStage(),
+		"cluster": a.GetClusterName(),
+		"action":  a.GetName(),
+	})
+}
+
+// planMu guards lastPlanned, and keeps the steps of a chain together when
+// several are planned concurrently.
+var planMu sync.Mutex
+
+var lastPlanned struct {
+	stage   string
+	cluster string
+}
+
+// Plan prints the description of a step which would be run, grouped by stage
+// and cluster.
+func Plan(stage string, cluster string, description string) {
+	planMu.Lock()
+	defer planMu.Unlock()
+	plan(stage, cluster, description)
+}
+
+// plan is Plan, with planMu held.
+func plan(stage string, cluster string, description string) {
+	if Graph {
+		return
+	}
+	if stage != lastPlanned.stage || cluster != lastPlanned.cluster {
+		header := stage
+		if cluster != "" {
+			header += " [" + cluster + "]"
+		}
+		fmt.Printf("\n%s:\n", header)
+		lastPlanned.stage = stage
+		lastPlanned.cluster = cluster
 	}
+	fmt.Printf("  - %s\n", description)
 }
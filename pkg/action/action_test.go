@@ -0,0 +1,247 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// recorder tracks the actions run by a chain.
+type recorder struct {
+	mu         sync.Mutex
+	started    []string
+	running    int
+	maxRunning int
+}
+
+// handler returns an action recording its run, which takes d and fails with
+// err if not nil.
+func (r *recorder) handler(name string, d time.Duration, err error, deps ...string) Handler {
+	return Handler{
+		Stage:     "test",
+		Name:      name,
+		DependsOn: deps,
+		Func: func(ctx context.Context, logger *log.Entry) error {
+			r.mu.Lock()
+			r.started = append(r.started, name)
+			r.running++
+			r.maxRunning = max(r.maxRunning, r.running)
+			r.mu.Unlock()
+			defer func() {
+				r.mu.Lock()
+				r.running--
+				r.mu.Unlock()
+			}()
+			select {
+			case <-time.After(d):
+				return err
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+func names(actions []Action) []string {
+	n := []string{}
+	for _, a := range actions {
+		n = append(n, strings.TrimPrefix(a.GetName(), "handler:"))
+	}
+	return n
+}
+
+func TestChainRun(t *testing.T) {
+	failed := errors.New("failed")
+	tests := []struct {
+		name            string
+		actions         func(r *recorder) []Action
+		continueOnError bool
+		concurrency     int
+		wantStarted     []string
+		wantFailed      []string
+		wantBlocked     []string
+		wantErr         string
+	}{
+		{
+			name: "dependencies run first",
+			actions: func(r *recorder) []Action {
+				return []Action{
+					r.handler("c", 0, nil, "handler:b"),
+					r.handler("b", 10*time.Millisecond, nil, "handler:a"),
+					r.handler("a", 10*time.Millisecond, nil),
+				}
+			},
+			wantStarted: []string{"a", "b", "c"},
+		},
+		{
+			name: "dependents are blocked after a failure",
+			actions: func(r *recorder) []Action {
+				return []Action{
+					r.handler("a", 0, failed),
+					r.handler("b", 0, nil, "handler:a"),
+					r.handler("c", 0, nil),
+				}
+			},
+			concurrency: 1,
+			wantStarted: []string{"a"},
+			wantFailed:  []string{"a"},
+			wantBlocked: []string{"b", "c"},
+			wantErr:     "[test] handler:a failed: failed",
+		},
+		{
+			name: "all errors are collected unless failing on the first one",
+			actions: func(r *recorder) []Action {
+				return []Action{
+					r.handler("a", 0, failed),
+					r.handler("b", 0, errors.New("also failed")),
+					r.handler("c", 0, nil, "handler:a"),
+					r.handler("d", 0, nil),
+				}
+			},
+			continueOnError: true,
+			concurrency:     1,
+			wantStarted:     []string{"a", "b", "d"},
+			wantFailed:      []string{"a", "b"},
+			wantBlocked:     []string{"c"},
+			wantErr:         "[test] handler:a failed: failed\n[test] handler:b failed: also failed",
+		},
+		{
+			name: "cycles are rejected",
+			actions: func(r *recorder) []Action {
+				return []Action{
+					r.handler("a", 0, nil, "handler:b"),
+					r.handler("b", 0, nil, "handler:a"),
+					r.handler("c", 0, nil),
+				}
+			},
+			wantErr: "dependency cycle between actions: handler:a, handler:b",
+		},
+		{
+			name: "unknown dependencies are rejected",
+			actions: func(r *recorder) []Action {
+				return []Action{r.handler("a", 0, nil, "handler:missing")}
+			},
+			wantErr: "action handler:a depends on unknown action handler:missing",
+		},
+		{
+			name: "duplicate names are rejected",
+			actions: func(r *recorder) []Action {
+				return []Action{r.handler("a", 0, nil), r.handler("a", 0, nil)}
+			},
+			wantErr: "duplicate action in chain: handler:a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{}
+			c := Chain{Actions: tt.actions(r), Concurrency: tt.concurrency}
+			if tt.continueOnError {
+				c.FailOnFirstError = &[]bool{false}[0]
+			}
+			res := c.RunContext(context.Background())
+
+			if !reflect.DeepEqual(r.started, tt.wantStarted) &&
+				(len(r.started) > 0 || len(tt.wantStarted) > 0) {
+				t.Errorf("started %v, want %v", r.started, tt.wantStarted)
+			}
+			failedNames := []string{}
+			for _, f := range res.Failed {
+				failedNames = append(failedNames, strings.TrimPrefix(f.Action.GetName(), "handler:"))
+			}
+			if len(failedNames) > 0 || len(tt.wantFailed) > 0 {
+				if !reflect.DeepEqual(failedNames, tt.wantFailed) {
+					t.Errorf("failed %v, want %v", failedNames, tt.wantFailed)
+				}
+			}
+			if blocked := names(res.Blocked); len(blocked) > 0 || len(tt.wantBlocked) > 0 {
+				if !reflect.DeepEqual(blocked, tt.wantBlocked) {
+					t.Errorf("blocked %v, want %v", blocked, tt.wantBlocked)
+				}
+			}
+			err := res.Err()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChainConcurrency(t *testing.T) {
+	for _, limit := range []int{1, 2, 4} {
+		r := &recorder{}
+		c := Chain{Concurrency: limit}
+		for _, n := range []string{"a", "b", "c", "d", "e", "f"} {
+			c.Add(r.handler(n, 20*time.Millisecond, nil))
+		}
+		if err := c.RunContext(context.Background()).Err(); err != nil {
+			t.Fatal(err)
+		}
+		if r.maxRunning != limit {
+			t.Errorf("ran %d actions at the same time, want %d", r.maxRunning, limit)
+		}
+	}
+}
+
+func TestChainCheckpoints(t *testing.T) {
+	platform.ConfigDir = t.TempDir()
+	platform.Name = "rockpool"
+	Stages = []string{"test"}
+	t.Cleanup(func() {
+		Stages = nil
+		Resume = false
+	})
+
+	r := &recorder{}
+	always := r.handler("always", 0, nil)
+	always.AlwaysRun = true
+	c := Chain{Actions: []Action{r.handler("a", 0, nil), always}}
+	if err := c.RunContext(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	r.started = nil
+	Resume = true
+	res := c.RunContext(context.Background())
+	if err := res.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names(res.Skipped), []string{"a"}) {
+		t.Errorf("skipped %v, want the completed action", names(res.Skipped))
+	}
+	if !reflect.DeepEqual(r.started, []string{"always"}) {
+		t.Errorf("started %v, want the action which always runs", r.started)
+	}
+}
+
+func TestChainInterrupted(t *testing.T) {
+	r := &recorder{}
+	c := Chain{Actions: []Action{
+		r.handler("a", time.Minute, nil),
+		r.handler("b", 0, nil, "handler:a"),
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	res := c.RunContext(ctx)
+
+	var ie *InterruptedError
+	if !errors.As(res.Err(), &ie) {
+		t.Fatalf("expected an interruption, got %v", res.Err())
+	}
+	if ie.Stage != "test" || !reflect.DeepEqual(ie.Actions, []string{"handler:a"}) ||
+		!errors.Is(ie, context.Canceled) {
+		t.Errorf("unexpected interruption %+v", ie)
+	}
+	if !reflect.DeepEqual(names(res.Blocked), []string{"b"}) {
+		t.Errorf("blocked %v", names(res.Blocked))
+	}
+}
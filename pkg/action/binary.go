@@ -1,6 +1,8 @@
 package action
 
 import (
+	"context"
+	"fmt"
 	"os/exec"
 
 	"github.com/salsadigitalauorg/rockpool/pkg/command"
@@ -12,44 +14,58 @@ type BinaryExists struct {
 	Stage       string
 	Bin         string
 	VersionArgs []string
+	DependsOn   []string
 }
 
 func (b BinaryExists) GetStage() string {
+	if b.Stage == "" {
+		return "init"
+	}
 	return b.Stage
 }
 
-func (b BinaryExists) Execute() bool {
-	if b.Stage == "" {
-		b.Stage = "init"
-	}
+func (b BinaryExists) GetClusterName() string {
+	return ""
+}
+
+func (b BinaryExists) GetName() string {
+	return "binary:" + b.Bin
+}
+
+func (b BinaryExists) GetDependencies() []string {
+	return b.DependsOn
+}
+
+func (b BinaryExists) Describe() string {
+	return "check that the '" + b.Bin + "' binary is installed"
+}
+
+func (b BinaryExists) Execute(ctx context.Context) error {
 
 	logger := log.WithFields(log.Fields{
-		"stage": b.Stage,
+		"stage": b.GetStage(),
 		"bin":   b.Bin,
 	})
 
 	absPath, err := exec.LookPath(b.Bin)
 	if err != nil {
-		logger.WithError(err).
-			Error("could not find binary; please ensure it is installed " +
-				"and can be found in the $PATH")
-		return false
+		return fmt.Errorf("could not find binary; please ensure it is "+
+			"installed and can be found in the $PATH: %w", err)
 	}
 
-	versionCmd := command.ShellCommander(absPath, "version")
+	versionCmd := command.ShellCommander(command.Query(ctx), absPath, "version")
 	if len(b.VersionArgs) > 0 {
 		versionCmd.AddArgs(b.VersionArgs...)
 	}
 	out, err := versionCmd.Output()
 	if err != nil {
-		logger.WithError(command.GetMsgFromCommandError(err)).
-			Error("error getting version")
-		return false
+		return fmt.Errorf("error getting version: %w",
+			command.GetMsgFromCommandError(err))
 	}
 
 	logger.WithFields(log.Fields{
 		"binary": b.Bin,
 		"result": string(out),
 	}).Debug("fetched version")
-	return true
+	return nil
 }
@@ -0,0 +1,50 @@
+package action
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ActionError is returned when an action fails to execute.
+type ActionError struct {
+	Action Action
+	Err    error
+}
+
+func (e *ActionError) Error() string {
+	where := e.Action.GetStage()
+	if cn := e.Action.GetClusterName(); cn != "" {
+		where += " " + cn
+	}
+	return fmt.Sprintf("[%s] %s failed: %s", where, e.Action.GetName(), e.Err)
+}
+
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
+// InterruptedError is returned when a chain's context is done before all its
+// actions have run, e.g, on Ctrl-C.
+type InterruptedError struct {
+	Stage   string
+	Cluster string
+	// Actions holds the names of the actions which were running.
+	Actions []string
+	Err     error
+}
+
+func (e *InterruptedError) Error() string {
+	where := e.Stage
+	if e.Cluster != "" {
+		where += " " + e.Cluster
+	}
+	msg := fmt.Sprintf("[%s] interrupted", where)
+	if len(e.Actions) > 0 {
+		msg += " while running " + strings.Join(e.Actions, ", ")
+	}
+	return fmt.Sprintf("%s: %s", msg, e.Err)
+}
+
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
@@ -1,25 +1,72 @@
 package action
 
-import log "github.com/sirupsen/logrus"
+import (
+	"context"
+	"maps"
+
+	log "github.com/sirupsen/logrus"
+)
 
 type Handler struct {
-	Stage     string
+	Stage string
+	// Name identifies the handler; it must be unique within its stage and
+	// cluster.
+	Name      string
 	Info      string
 	LogFields log.Fields
-	Func      func(logger *log.Entry) bool
+	Func      func(ctx context.Context, logger *log.Entry) error
+	DependsOn []string
+	// AlwaysRun prevents the handler from being recorded as completed, so
+	// that it is not skipped when resuming, e.g, when it loads data used by
+	// the actions depending on it.
+	AlwaysRun bool
 }
 
 func (h Handler) GetStage() string {
 	return h.Stage
 }
 
-func (h Handler) Execute() bool {
+func (h Handler) GetClusterName() string {
+	cn, _ := h.LogFields["cluster"].(string)
+	return cn
+}
+
+func (h Handler) GetName() string {
+	if h.Name == "" {
+		return ""
+	}
+	return "handler:" + h.Name
+}
+
+func (h Handler) GetDependencies() []string {
+	return h.DependsOn
+}
+
+func (h Handler) Describe() string {
+	if h.Info == "" {
+		return "run custom handler"
+	}
+	return h.Info
+}
+
+// Checkpointed implements Checkpointer.
+func (h Handler) Checkpointed() bool {
+	return !h.AlwaysRun
+}
+
+func (h Handler) Execute(ctx context.Context) error {
+	// The fields are copied, as the map may be shared with other handlers
+	// running concurrently.
+	fields := maps.Clone(h.LogFields)
+	if fields == nil {
+		fields = log.Fields{}
+	}
 	if h.Stage != "" {
-		h.LogFields["stage"] = h.Stage
+		fields["stage"] = h.Stage
 	}
-	logger := log.WithFields(h.LogFields)
+	logger := log.WithFields(fields)
 	if h.Info != "" {
 		logger.Info(h.Info)
 	}
-	return h.Func(logger)
+	return h.Func(ctx, logger)
 }
@@ -0,0 +1,131 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/command"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PortAvailable checks that nothing listens on a host port a cluster is about
+// to publish, reporting the process or container holding it otherwise.
+type PortAvailable struct {
+	Stage       string
+	ClusterName string
+	Port        int
+	// Protocol is either tcp or udp.
+	Protocol  string
+	DependsOn []string
+}
+
+func (p PortAvailable) GetStage() string {
+	if p.Stage == "" {
+		return "preflight"
+	}
+	return p.Stage
+}
+
+func (p PortAvailable) GetClusterName() string {
+	return p.ClusterName
+}
+
+func (p PortAvailable) GetName() string {
+	return fmt.Sprintf("port:%d/%s", p.Port, p.Protocol)
+}
+
+func (p PortAvailable) GetDependencies() []string {
+	return p.DependsOn
+}
+
+func (p PortAvailable) Describe() string {
+	return fmt.Sprintf("check that host port %d/%s is available", p.Port, p.Protocol)
+}
+
+func (p PortAvailable) Execute(ctx context.Context) error {
+	logger := log.WithFields(log.Fields{
+		"stage":   p.GetStage(),
+		"cluster": p.ClusterName,
+		"port":    p.GetName(),
+	})
+	if !p.inUse(logger) {
+		logger.Debug("port is available")
+		return nil
+	}
+	return fmt.Errorf("port %d/%s is already in use by %s", p.Port, p.Protocol,
+		PortOwner(ctx, p.Port, p.Protocol))
+}
+
+// inUse checks whether a tcp port accepts connections, then tries to listen
+// on the port; failing to listen for other reasons than the port being in
+// use, e.g, for the privileged ports, is not considered a conflict.
+func (p PortAvailable) inUse(logger *log.Entry) bool {
+	addr := ":" + strconv.Itoa(p.Port)
+	var err error
+	if p.Protocol == "udp" {
+		var pc net.PacketConn
+		if pc, err = net.ListenPacket("udp", addr); err == nil {
+			pc.Close()
+		}
+	} else {
+		if conn, err := net.DialTimeout("tcp", "127.0.0.1"+addr, time.Second); err == nil {
+			conn.Close()
+			return true
+		}
+		var l net.Listener
+		if l, err = net.Listen("tcp", addr); err == nil {
+			l.Close()
+		}
+	}
+	if err != nil && !errors.Is(err, syscall.EADDRINUSE) {
+		logger.WithError(err).Debug("unable to listen on port")
+		return false
+	}
+	return err != nil
+}
+
+// PortOwner describes what holds a host port: the docker container
+// publishing it, or else the process listening on it, as reported by lsof.
+func PortOwner(ctx context.Context, port int, protocol string) string {
+	p := fmt.Sprintf("%d/%s", port, protocol)
+	out, err := command.ShellCommander(command.Query(ctx), "docker", "ps", "--filter",
+		"publish="+p, "--format", "{{.Names}}").Output()
+	if names := strings.Fields(string(out)); err == nil && len(names) > 0 {
+		return "container " + strings.Join(names, ", ")
+	}
+
+	lsofArgs := []string{"-nP", "-i" + strings.ToUpper(protocol) + ":" + strconv.Itoa(port), "-Fpc"}
+	if protocol == "tcp" {
+		lsofArgs = append(lsofArgs, "-sTCP:LISTEN")
+	}
+	out, err = command.ShellCommander(command.Query(ctx), "lsof", lsofArgs...).Output()
+	if err != nil {
+		return "an unknown process"
+	}
+	// The output has a line per field, prefixed with the field's name: p for
+	// the pid and c for the command.
+	var pid string
+	owners := []string{}
+	for _, l := range strings.Split(string(out), "\n") {
+		if len(l) < 2 {
+			continue
+		}
+		switch l[0] {
+		case 'p':
+			pid = l[1:]
+		case 'c':
+			owners = append(owners, fmt.Sprintf("%s (pid %s)", l[1:], pid))
+		}
+	}
+	if len(owners) == 0 {
+		return "an unknown process"
+	}
+	return strings.Join(owners, ", ")
+}
@@ -0,0 +1,195 @@
+package action
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Stages is the ordered list of stages which can be selected using FromStage
+// and OnlyStage, and whose completed actions are recorded in the state file.
+// Actions in other stages are always run.
+var Stages []string
+
+var (
+	// Resume skips the actions which have been completed in a previous run.
+	Resume bool
+	// FromStage skips the actions in the stages preceding it.
+	FromStage string
+	// OnlyStage skips the actions in all other stages.
+	OnlyStage string
+)
+
+// State holds the actions completed so far, keyed by stage, cluster and
+// action name.
+type State struct {
+	Completed map[string]time.Time `json:"completed"`
+}
+
+var stateMu sync.Mutex
+
+// StatePath returns the path to the file holding the state of the platform.
+func StatePath() string {
+	return filepath.Join(platform.Dir(), "state.json")
+}
+
+// ValidateStageFilters ensures the stages used as filters exist.
+func ValidateStageFilters() error {
+	for _, s := range []string{FromStage, OnlyStage} {
+		if s != "" && stageIndex(s) == -1 {
+			return fmt.Errorf("unknown stage '%s'; valid stages are: %s", s,
+				strings.Join(Stages, ", "))
+		}
+	}
+	if FromStage != "" && OnlyStage != "" {
+		return errors.New("only one of from-stage and only-stage can be used")
+	}
+	return nil
+}
+
+// StageSelected determines whether the actions in the given stage should be
+// run according to the stage filters.
+func StageSelected(stage string) bool {
+	idx := stageIndex(stage)
+	if idx == -1 {
+		return true
+	}
+	if OnlyStage != "" {
+		return stage == OnlyStage
+	}
+	if FromStage != "" {
+		return idx >= stageIndex(FromStage)
+	}
+	return true
+}
+
+func stageIndex(stage string) int {
+	for i, s := range Stages {
+		if s == stage {
+			return i
+		}
+	}
+	return -1
+}
+
+// Checkpointer is implemented by the actions which decide whether they are
+// recorded as completed; the other actions in one of the Stages always are.
+type Checkpointer interface {
+	Checkpointed() bool
+}
+
+func checkpointed(a Action) bool {
+	c, ok := a.(Checkpointer)
+	return !ok || c.Checkpointed()
+}
+
+func checkpointKey(a Action) string {
+	return strings.Join([]string{a.GetStage(), a.GetClusterName(), a.GetName()}, "/")
+}
+
+// shouldRun determines whether the action is to be run, and if not, why.
+func shouldRun(a Action) (bool, string) {
+	if !StageSelected(a.GetStage()) {
+		return false, "stage not selected"
+	}
+	if Resume && stageIndex(a.GetStage()) != -1 && checkpointed(a) && IsCompleted(a) {
+		return false, "completed in a previous run"
+	}
+	return true, ""
+}
+
+func loadState() (State, error) {
+	s := State{Completed: map[string]time.Time{}}
+	data, err := os.ReadFile(StatePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, err
+	}
+	if s.Completed == nil {
+		s.Completed = map[string]time.Time{}
+	}
+	return s, nil
+}
+
+func saveState(s State) error {
+	if err := os.MkdirAll(platform.Dir(), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(StatePath(), data, 0644)
+}
+
+// updateState applies the given function to the persisted state.
+func updateState(f func(s *State)) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	s, err := loadState()
+	if err != nil {
+		log.WithField("file", StatePath()).WithError(err).
+			Warn("unable to read state; it will be reset")
+	}
+	f(&s)
+	if err := saveState(s); err != nil {
+		log.WithField("file", StatePath()).WithError(err).
+			Warn("unable to save state")
+	}
+}
+
+// IsCompleted checks whether the action has been recorded as completed.
+func IsCompleted(a Action) bool {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	s, err := loadState()
+	if err != nil {
+		log.WithField("file", StatePath()).WithError(err).
+			Warn("unable to read state")
+		return false
+	}
+	_, ok := s.Completed[checkpointKey(a)]
+	return ok
+}
+
+// MarkCompleted records the action as completed. Only the checkpointed actions
+// in one of the Stages are recorded.
+func MarkCompleted(a Action) {
+	if stageIndex(a.GetStage()) == -1 || !checkpointed(a) {
+		return
+	}
+	updateState(func(s *State) {
+		s.Completed[checkpointKey(a)] = time.Now()
+	})
+}
+
+// ClearCheckpoints removes all recorded actions.
+func ClearCheckpoints() {
+	updateState(func(s *State) {
+		s.Completed = map[string]time.Time{}
+	})
+}
+
+// ClearClusterCheckpoints removes the recorded actions for a cluster.
+func ClearClusterCheckpoints(cn string) {
+	updateState(func(s *State) {
+		for k := range s.Completed {
+			if strings.Split(k, "/")[1] == cn {
+				delete(s.Completed, k)
+			}
+		}
+	})
+}
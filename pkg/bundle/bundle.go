@@ -0,0 +1,218 @@
+// Package bundle packs the charts, manifests and images needed to set up a
+// platform into a single archive, so that it can be set up offline.
+//
+// The archive is a tar file holding a bundle.yaml manifest, which lists the
+// other files by what they replace: the charts by chart reference, and the
+// manifests by URL.
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/command"
+	"github.com/salsadigitalauorg/rockpool/pkg/helm"
+	"github.com/salsadigitalauorg/rockpool/pkg/kube"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestFile is the name of the bundle's manifest.
+const ManifestFile = "bundle.yaml"
+
+// Manifest describes the content of a bundle; the paths are relative to the
+// bundle's root.
+type Manifest struct {
+	LagoonVersion string `yaml:"lagoon-version"`
+	// Charts are the chart archives, by chart reference, e.g, harbor/harbor.
+	Charts map[string]string `yaml:"charts"`
+	// Manifests are the manifest files, by URL.
+	Manifests map[string]string `yaml:"manifests"`
+	// Images is the manifest of the components' images, preloaded into the
+	// registry.
+	Images string `yaml:"images"`
+	// Archive is the archive of all the images, created by `docker save`.
+	Archive string `yaml:"archive"`
+}
+
+// Bundle is a bundle whose files have been extracted, except for the images'
+// archive, which is loaded from the bundle directly.
+type Bundle struct {
+	Manifest
+	// File is the path of the bundle.
+	File string
+	// Dir is the directory the bundle is extracted to.
+	Dir string
+}
+
+// Current is the bundle the platform is set up from, if any.
+var Current *Bundle
+
+// Path returns the path of an extracted file of the bundle.
+func (b *Bundle) Path(name string) string {
+	return filepath.Join(b.Dir, name)
+}
+
+// Open extracts the bundle into the platform's directory, and sets it as the
+// current one, so that its charts and manifests are used instead of being
+// fetched.
+func Open(file string) (*Bundle, error) {
+	b := &Bundle{File: file, Dir: filepath.Join(platform.Dir(), "bundle")}
+	log.WithFields(log.Fields{"bundle": file, "dir": b.Dir}).Info("extracting bundle")
+	if err := os.RemoveAll(b.Dir); err != nil {
+		return nil, err
+	}
+	if err := b.walk(func(name string, hdr *tar.Header, r io.Reader) error {
+		if name == b.Archive && b.Archive != "" {
+			return nil
+		}
+		return extract(b.Dir, name, hdr, r)
+	}); err != nil {
+		return nil, fmt.Errorf("unable to extract bundle %s: %w", file, err)
+	}
+
+	data, err := os.ReadFile(b.Path(ManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle %s: %w", file, err)
+	}
+	if err := yaml.Unmarshal(data, &b.Manifest); err != nil {
+		return nil, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	for ref, p := range b.Charts {
+		helm.LocalCharts[ref] = b.Path(p)
+	}
+	for url, p := range b.Manifests {
+		kube.LocalManifests[url] = b.Path(p)
+	}
+	Current = b
+	return b, nil
+}
+
+// walk calls fn with each file of the bundle; the manifest is the first one,
+// so that the files can be identified from it.
+func (b *Bundle) walk(fn func(name string, hdr *tar.Header, r io.Reader) error) error {
+	f, err := os.Open(b.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		name := filepath.Clean(hdr.Name)
+		if name == ManifestFile {
+			m := Manifest{}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			if err := yaml.Unmarshal(data, &m); err != nil {
+				return fmt.Errorf("invalid bundle manifest: %w", err)
+			}
+			b.Archive = m.Archive
+			if err := fn(name, hdr, bytes.NewReader(data)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(name, hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// extract writes a file of the bundle to dir.
+func extract(dir string, name string, hdr *tar.Header, r io.Reader) error {
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+	if !filepath.IsLocal(name) {
+		return fmt.Errorf("invalid file name %q", hdr.Name)
+	}
+	dest := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadImages loads the bundle's images into the docker daemon, streaming
+// them from the bundle.
+func (b *Bundle) LoadImages(ctx context.Context) error {
+	if b.Archive == "" {
+		return nil
+	}
+	log.WithField("bundle", b.File).Info("loading images")
+	return b.walk(func(name string, hdr *tar.Header, r io.Reader) error {
+		if name != b.Archive {
+			return nil
+		}
+		cmd := command.ShellCommander(command.WithoutTimeout(ctx), "docker", "load")
+		cmd.SetStdin(r)
+		if _, err := cmd.Output(); err != nil {
+			return fmt.Errorf("unable to load images: %w",
+				command.GetMsgFromCommandError(err))
+		}
+		return nil
+	})
+}
+
+// Write writes the manifest to dir, then packs the manifest and the files of
+// dir into the bundle file. The bundle is written to a temporary file which
+// replaces the bundle file only once complete, so that an existing bundle is
+// not lost if writing fails.
+func Write(dir string, m Manifest, file string) error {
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, ManifestFile), data, 0644); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+"-*")
+	if err != nil {
+		return fmt.Errorf("unable to write bundle %s: %w", file, err)
+	}
+	tw := tar.NewWriter(f)
+	// The files are added in lexical order, which puts the manifest first.
+	err = tw.AddFS(os.DirFS(dir))
+	if closeErr := tw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), file)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("unable to write bundle %s: %w", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package bundle
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/command"
+	"github.com/salsadigitalauorg/rockpool/pkg/helm"
+	"github.com/salsadigitalauorg/rockpool/pkg/kube"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+)
+
+func TestWriteAndOpen(t *testing.T) {
+	platform.ConfigDir = t.TempDir()
+	platform.Name = "rockpool"
+	t.Cleanup(func() {
+		Current = nil
+		helm.LocalCharts = map[string]string{}
+		kube.LocalManifests = map[string]string{}
+	})
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"charts/harbor-harbor.tgz": "chart",
+		"manifests/0-mariadb.yaml": "kind: CustomResourceDefinition",
+		"images.yml":               "gitea:\n  - gitea/gitea:latest\n",
+		"images.tar":               "images",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(t.TempDir(), "bundle.tar")
+	err := Write(dir, Manifest{
+		LagoonVersion: "v2.12.0",
+		Charts:        map[string]string{"harbor/harbor": "charts/harbor-harbor.tgz"},
+		Manifests:     map[string]string{"https://example.com/mariadb.yaml": "manifests/0-mariadb.yaml"},
+		Images:        "images.yml",
+		Archive:       "images.tar",
+	}, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Current != b || b.LagoonVersion != "v2.12.0" {
+		t.Errorf("unexpected bundle %+v", b)
+	}
+	if p := helm.LocalCharts["harbor/harbor"]; p != b.Path("charts/harbor-harbor.tgz") {
+		t.Errorf("got chart path %q", p)
+	}
+	data, err := os.ReadFile(kube.LocalManifests["https://example.com/mariadb.yaml"])
+	if err != nil || string(data) != "kind: CustomResourceDefinition" {
+		t.Errorf("got manifest %q, %v", data, err)
+	}
+	if _, err := os.Stat(b.Path("images.tar")); !os.IsNotExist(err) {
+		t.Error("expected the images archive not to be extracted")
+	}
+
+	f := command.NewFakeCommander(command.Interaction{Args: []string{"docker", "load"}})
+	t.Cleanup(f.Install())
+	if err := b.LoadImages(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Called("docker", "load") {
+		t.Error("expected the images to be loaded")
+	}
+}
+
+func TestWriteFailureKeepsBundle(t *testing.T) {
+	dir := t.TempDir()
+	// Named pipes can't be added to the bundle.
+	if err := syscall.Mkfifo(filepath.Join(dir, "pipe"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := t.TempDir()
+	file := filepath.Join(out, "bundle.tar")
+	if err := os.WriteFile(file, []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(dir, Manifest{LagoonVersion: "v2.12.0"}, file); err == nil {
+		t.Fatal("expected an error")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil || string(data) != "previous" {
+		t.Errorf("expected the previous bundle to be kept, got %q, %v", data, err)
+	}
+	if entries, _ := os.ReadDir(out); len(entries) != 1 {
+		t.Errorf("expected the temporary file to be removed, got %v", entries)
+	}
+}
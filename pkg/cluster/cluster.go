@@ -0,0 +1,340 @@
+// Package cluster manages the lifecycle of the platform's clusters through
+// the provider of each cluster, e.g, k3d or kind.
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"sort"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/kube"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ClusterProvider creates and manages clusters.
+type ClusterProvider interface {
+	// Name is the name of the provider, as used in the config.
+	Name() string
+	// Binaries returns the binaries the provider requires.
+	Binaries() []string
+	// List returns the clusters managed by the provider, which may include
+	// the other platforms' clusters.
+	List(ctx context.Context) ([]Cluster, error)
+	Create(ctx context.Context, cn string, isController bool) error
+	Start(ctx context.Context, cn string) error
+	Stop(ctx context.Context, cn string) error
+	// Delete stops and deletes the cluster; it is a no-op if the cluster
+	// does not exist.
+	Delete(ctx context.Context, cn string) error
+	// WriteKubeConfig writes the cluster's kubeconfig to
+	// kube.KubeconfigPath.
+	WriteKubeConfig(ctx context.Context, cn string) error
+}
+
+// Node is a node of a cluster, running as a container.
+type Node struct {
+	Name    string
+	Role    string
+	Running bool
+}
+
+type Cluster struct {
+	Name     string
+	Provider string
+	Running  bool
+	// IP is the address at which the cluster's ingress and API are reachable
+	// from the other clusters.
+	IP string
+	// API is the URL of the cluster's API server, as reachable from the
+	// controller.
+	API string
+	// Version is the Kubernetes version of the cluster's nodes, when known,
+	// e.g, v1.25.
+	Version string
+	// Nodes are the containers of the cluster's nodes; it is empty for the
+	// clusters whose nodes are not accessible, e.g, existing ones.
+	Nodes []Node
+}
+
+// Providers holds the registered providers, by name.
+var Providers = map[string]ClusterProvider{}
+
+// DefaultProvider is the provider of the clusters which have no kubeconfig in
+// kube.Kubeconfigs.
+var DefaultProvider = "k3d"
+
+// Clusters holds the platform's clusters, as fetched by Fetch.
+var Clusters []Cluster
+
+func init() {
+	kube.ClusterExists = func(cn string) bool {
+		exists, _ := Exists(cn)
+		return exists
+	}
+}
+
+// Register makes the providers available.
+func Register(providers ...ClusterProvider) {
+	for _, p := range providers {
+		Providers[p.Name()] = p
+	}
+}
+
+// ProviderFor returns the provider of the cluster: clusters given an existing
+// kubeconfig are adopted, the other ones are created by the default provider.
+func ProviderFor(cn string) (ClusterProvider, error) {
+	name := DefaultProvider
+	if _, ok := kube.Kubeconfigs[cn]; ok {
+		name = ExistingProviderName
+	}
+	p, ok := Providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cluster provider %q", name)
+	}
+	return p, nil
+}
+
+// inUse returns the providers of the platform's clusters, sorted by name.
+func inUse() ([]ClusterProvider, error) {
+	names := map[string]bool{DefaultProvider: true}
+	if len(kube.Kubeconfigs) > 0 {
+		names[ExistingProviderName] = true
+	}
+	providers := []ClusterProvider{}
+	for n := range names {
+		p, ok := Providers[n]
+		if !ok {
+			return nil, fmt.Errorf("unknown cluster provider %q", n)
+		}
+		providers = append(providers, p)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name() < providers[j].Name() })
+	return providers, nil
+}
+
+// Binaries returns the binaries required by the providers in use.
+func Binaries() ([]string, error) {
+	providers, err := inUse()
+	if err != nil {
+		return nil, err
+	}
+	bins := []string{}
+	for _, p := range providers {
+		bins = append(bins, p.Binaries()...)
+	}
+	return bins, nil
+}
+
+// Fetch lists the platform's clusters of the providers in use; only the
+// clusters named after the platform's controller and targets are kept.
+func Fetch(ctx context.Context) error {
+	log.Debug("fetching clusters")
+	providers, err := inUse()
+	if err != nil {
+		return err
+	}
+	all, err := list(ctx, providers)
+	if err != nil {
+		return err
+	}
+	clusters := []Cluster{}
+	for _, c := range all {
+		if slices.Contains(platform.ClusterNames(), c.Name) {
+			clusters = append(clusters, c)
+		}
+	}
+	Clusters = clusters
+	return nil
+}
+
+// ListAll returns the clusters of all the platforms, as listed by the
+// providers whose binaries are installed; the existing clusters can't be
+// listed.
+func ListAll(ctx context.Context) ([]Cluster, error) {
+	providers := []ClusterProvider{}
+	for _, p := range Providers {
+		if p.Name() == ExistingProviderName {
+			continue
+		}
+		installed := true
+		for _, b := range p.Binaries() {
+			if _, err := exec.LookPath(b); err != nil {
+				installed = false
+			}
+		}
+		if installed {
+			providers = append(providers, p)
+		}
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name() < providers[j].Name() })
+	return list(ctx, providers)
+}
+
+func list(ctx context.Context, providers []ClusterProvider) ([]Cluster, error) {
+	clusters := []Cluster{}
+	for _, p := range providers {
+		cls, err := p.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cls {
+			c.Provider = p.Name()
+			clusters = append(clusters, c)
+		}
+	}
+	return clusters, nil
+}
+
+func Exists(cn string) (bool, Cluster) {
+	for _, c := range Clusters {
+		if c.Name == cn {
+			return true, c
+		}
+	}
+	return false, Cluster{}
+}
+
+func IsRunning(cn string) bool {
+	exists, c := Exists(cn)
+	return exists && c.Running
+}
+
+// Names returns the names of the fetched clusters.
+func Names() []string {
+	names := []string{}
+	for _, c := range Clusters {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+// PortPublisher is implemented by the providers publishing host ports when
+// creating a cluster.
+type PortPublisher interface {
+	PublishedPorts(cn string, isController bool) []platform.PublishedPort
+}
+
+// PublishedPorts returns the host ports the cluster's provider publishes when
+// creating it.
+func PublishedPorts(cn string, isController bool) ([]platform.PublishedPort, error) {
+	p, err := ProviderFor(cn)
+	if err != nil {
+		return nil, err
+	}
+	if pp, ok := p.(PortPublisher); ok {
+		return pp.PublishedPorts(cn, isController), nil
+	}
+	return nil, nil
+}
+
+// ImageImporter is implemented by the providers which can load images of the
+// host's docker daemon into the nodes of a cluster.
+type ImageImporter interface {
+	ImportImages(ctx context.Context, cn string, images []string) error
+}
+
+// ImportImages loads the images of the host's docker daemon into the nodes of
+// the running cluster.
+func ImportImages(ctx context.Context, cn string, images []string) error {
+	p, err := ProviderFor(cn)
+	if err != nil {
+		return err
+	}
+	exists, c := Exists(cn)
+	if !exists {
+		return &NotFoundError{Name: cn}
+	}
+	if !c.Running {
+		return fmt.Errorf("cluster %s is not running", cn)
+	}
+	i, ok := p.(ImageImporter)
+	if !ok {
+		return fmt.Errorf("the %s provider can't import images", p.Name())
+	}
+	log.WithFields(log.Fields{"clusterName": cn, "images": images}).Info("importing images")
+	return i.ImportImages(ctx, cn, images)
+}
+
+// Create creates the cluster, or starts it if it exists but is stopped.
+func Create(ctx context.Context, cn string, isController bool) error {
+	p, err := ProviderFor(cn)
+	if err != nil {
+		return err
+	}
+	if err := p.Create(ctx, cn, isController); err != nil {
+		return err
+	}
+	return Fetch(ctx)
+}
+
+func Start(ctx context.Context, cn string) error {
+	p, err := ProviderFor(cn)
+	if err != nil {
+		return err
+	}
+	if exists, _ := Exists(cn); !exists {
+		return &NotFoundError{Name: cn}
+	}
+	if err := p.Start(ctx, cn); err != nil {
+		return err
+	}
+	return Fetch(ctx)
+}
+
+func Stop(ctx context.Context, cn string) error {
+	p, err := ProviderFor(cn)
+	if err != nil {
+		return err
+	}
+	if exists, _ := Exists(cn); !exists {
+		return &NotFoundError{Name: cn}
+	}
+	return p.Stop(ctx, cn)
+}
+
+func Restart(ctx context.Context, cn string) error {
+	if err := Stop(ctx, cn); err != nil {
+		return err
+	}
+	return Start(ctx, cn)
+}
+
+// Delete deletes the cluster; it is a no-op if the cluster does not exist.
+func Delete(ctx context.Context, cn string) error {
+	p, err := ProviderFor(cn)
+	if err != nil {
+		return err
+	}
+	if exists, _ := Exists(cn); !exists {
+		return nil
+	}
+	return p.Delete(ctx, cn)
+}
+
+func WriteKubeConfig(ctx context.Context, cn string) error {
+	p, err := ProviderFor(cn)
+	if err != nil {
+		return err
+	}
+	return p.WriteKubeConfig(ctx, cn)
+}
+
+func ControllerIP() (string, error) {
+	return IP(platform.ControllerClusterName())
+}
+
+// IP returns the address of the cluster, e.g, the IP of k3d's loadbalancer.
+func IP(cn string) (string, error) {
+	exists, c := Exists(cn)
+	if !exists {
+		return "", &NotFoundError{Name: cn}
+	}
+	if c.IP == "" {
+		return "", fmt.Errorf("no address found for cluster %s", cn)
+	}
+	return c.IP, nil
+}
@@ -0,0 +1,254 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/kube"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+)
+
+// fakeProvider records the operations on its clusters.
+type fakeProvider struct {
+	clusters []Cluster
+	calls    []string
+}
+
+func (p *fakeProvider) Name() string       { return "fake" }
+func (p *fakeProvider) Binaries() []string { return []string{"fake"} }
+
+func (p *fakeProvider) List(ctx context.Context) ([]Cluster, error) {
+	return p.clusters, nil
+}
+
+func (p *fakeProvider) Create(ctx context.Context, cn string, isController bool) error {
+	p.calls = append(p.calls, "create "+cn)
+	p.clusters = append(p.clusters, Cluster{Name: cn, Running: true, IP: "172.18.0.3"})
+	return nil
+}
+
+func (p *fakeProvider) Start(ctx context.Context, cn string) error {
+	p.calls = append(p.calls, "start "+cn)
+	return nil
+}
+
+func (p *fakeProvider) Stop(ctx context.Context, cn string) error {
+	p.calls = append(p.calls, "stop "+cn)
+	return nil
+}
+
+func (p *fakeProvider) Delete(ctx context.Context, cn string) error {
+	p.calls = append(p.calls, "delete "+cn)
+	return nil
+}
+
+func (p *fakeProvider) WriteKubeConfig(ctx context.Context, cn string) error {
+	p.calls = append(p.calls, "kubeconfig "+cn)
+	return nil
+}
+
+func setUp(t *testing.T) *fakeProvider {
+	t.Helper()
+	platform.Name = "rockpool"
+	platform.Targets = platform.NumberedTargets(1)
+	p := &fakeProvider{}
+	prevDefault, prevKubeconfigs := DefaultProvider, kube.Kubeconfigs
+	Register(p)
+	DefaultProvider = p.Name()
+	kube.Kubeconfigs = map[string]string{}
+	t.Cleanup(func() {
+		delete(Providers, p.Name())
+		DefaultProvider, kube.Kubeconfigs = prevDefault, prevKubeconfigs
+		Clusters = nil
+	})
+	return p
+}
+
+func writeKubeconfig(t *testing.T, server string) string {
+	t.Helper()
+	kc := filepath.Join(t.TempDir(), "kubeconfig.yaml")
+	err := os.WriteFile(kc, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: staging
+  cluster:
+    server: `+server+`
+contexts:
+- name: staging
+  context:
+    cluster: staging
+    user: staging
+current-context: staging
+users:
+- name: staging
+  user:
+    token: abc
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kc
+}
+
+func TestLifecycle(t *testing.T) {
+	p := setUp(t)
+	ctx := context.Background()
+
+	if err := Fetch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := Start(ctx, "rockpool-controller"); !errors.As(err, new(*NotFoundError)) {
+		t.Errorf("got error %v starting a missing cluster", err)
+	}
+	if err := Create(ctx, "rockpool-controller", true); err != nil {
+		t.Fatal(err)
+	}
+	if !IsRunning("rockpool-controller") || Clusters[0].Provider != "fake" {
+		t.Errorf("expected the created cluster to be fetched, got %+v", Clusters)
+	}
+	if ip, err := ControllerIP(); err != nil || ip != "172.18.0.3" {
+		t.Errorf("got controller ip %q, %v", ip, err)
+	}
+	if err := Restart(ctx, "rockpool-controller"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Delete(ctx, "rockpool-target-1"); err != nil {
+		t.Errorf("deleting a missing cluster should be a no-op, got %v", err)
+	}
+	if err := Delete(ctx, "rockpool-controller"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"create rockpool-controller", "stop rockpool-controller",
+		"start rockpool-controller", "delete rockpool-controller"}
+	if !reflect.DeepEqual(p.calls, want) {
+		t.Errorf("got calls %q", p.calls)
+	}
+}
+
+func TestExistingCluster(t *testing.T) {
+	p := setUp(t)
+	kube.Kubeconfigs["rockpool-target-1"] = writeKubeconfig(t, "https://10.0.0.5:16443")
+	ctx := context.Background()
+
+	if bins, err := Binaries(); err != nil || !reflect.DeepEqual(bins, []string{"fake"}) {
+		t.Errorf("got binaries %q, %v", bins, err)
+	}
+	for _, cn := range []string{"rockpool-controller", "rockpool-target-1"} {
+		if err := Create(ctx, cn, cn == "rockpool-controller"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(p.calls, []string{"create rockpool-controller"}) {
+		t.Errorf("the existing cluster should not be created, got calls %q", p.calls)
+	}
+	exists, c := Exists("rockpool-target-1")
+	if !exists || !c.Running || c.Provider != ExistingProviderName ||
+		c.IP != "10.0.0.5" || c.API != "https://10.0.0.5:16443" || len(c.Nodes) > 0 {
+		t.Errorf("got cluster %+v", c)
+	}
+	if kube.KubeconfigPath("rockpool-target-1") != kube.Kubeconfigs["rockpool-target-1"] {
+		t.Errorf("got kubeconfig %s", kube.KubeconfigPath("rockpool-target-1"))
+	}
+	if err := Delete(ctx, "rockpool-target-1"); err != nil || len(p.calls) > 1 {
+		t.Errorf("the existing cluster should be left in place, got %v, %q", err, p.calls)
+	}
+
+	kube.Kubeconfigs["rockpool-target-2"] = filepath.Join(t.TempDir(), "missing.yaml")
+	if err := Create(ctx, "rockpool-target-2", false); err == nil {
+		t.Error("expected an error for a missing kubeconfig")
+	}
+}
+
+func TestUnknownProvider(t *testing.T) {
+	setUp(t)
+	DefaultProvider = "minikube"
+	if _, err := ProviderFor("rockpool-controller"); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+	if err := Fetch(context.Background()); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+}
+
+// scalingProvider is a fakeProvider which can add and remove agents.
+type scalingProvider struct {
+	*fakeProvider
+}
+
+func (p scalingProvider) AddNode(ctx context.Context, cn string) (string, error) {
+	n := Node{Name: cn + "-agent-1", Role: "agent", Running: true}
+	p.clusters[0].Nodes = append(p.clusters[0].Nodes, n)
+	p.calls = append(p.calls, "add "+n.Name)
+	return n.Name, nil
+}
+
+func (p scalingProvider) RemoveNode(ctx context.Context, cn string, node string) error {
+	p.calls = append(p.calls, "remove "+node)
+	return nil
+}
+
+func TestNodes(t *testing.T) {
+	p := setUp(t)
+	ctx := context.Background()
+	p.clusters = []Cluster{{Name: "rockpool-target-1", Running: true, Nodes: []Node{
+		{Name: "rockpool-target-1-server-0", Role: "server"},
+		{Name: "rockpool-target-1-agent-0", Role: "agent"},
+	}}}
+	if err := Fetch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddNode(ctx, "rockpool-target-1"); err == nil {
+		t.Error("expected an error for a provider which can't add nodes")
+	}
+
+	s := scalingProvider{p}
+	Register(s)
+	if _, err := AddNode(ctx, "rockpool-target-2"); !errors.As(err, new(*NotFoundError)) {
+		t.Errorf("got error %v adding a node to a missing cluster", err)
+	}
+	if n, err := AddNode(ctx, "rockpool-target-1"); err != nil || n != "rockpool-target-1-agent-1" {
+		t.Fatalf("got node %q, %v", n, err)
+	}
+	if err := RemoveNode(ctx, "rockpool-target-1", "rockpool-target-1-server-0"); err == nil {
+		t.Error("expected an error removing a server")
+	}
+	if err := RemoveNode(ctx, "rockpool-target-1", ""); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"add rockpool-target-1-agent-1", "remove rockpool-target-1-agent-1"}
+	if !reflect.DeepEqual(p.calls, want) {
+		t.Errorf("got calls %q", p.calls)
+	}
+}
+
+func TestTopology(t *testing.T) {
+	setUp(t)
+	Topologies = map[string]Topology{"rockpool-target-1": {Agents: 3, Memory: "2g",
+		Labels: []string{"tier=batch"}, Taints: []string{"dedicated=batch:NoSchedule"}}}
+	t.Cleanup(func() { Topologies = map[string]Topology{} })
+
+	if got := TopologyFor("rockpool-controller"); !reflect.DeepEqual(got, DefaultTopology) {
+		t.Errorf("got topology %+v", got)
+	}
+	if got := TopologyFor("rockpool-target-1"); got.Servers != 1 || got.Agents != 3 {
+		t.Errorf("got topology %+v", got)
+	}
+	if err := ValidateTopologies(); err != nil {
+		t.Error(err)
+	}
+	for _, invalid := range []Topology{
+		{Servers: 1, Agents: -1},
+		{Servers: 1, Memory: "2 GB"},
+		{Servers: 1, Labels: []string{"tier"}},
+		{Servers: 1, Taints: []string{"dedicated=batch"}},
+		{Servers: 1, Taints: []string{"dedicated=batch:Never"}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package cluster
+
+import "fmt"
+
+// NotFoundError is returned when an operation requires a cluster which does
+// not exist.
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("cluster %s not found", e.Name)
+}
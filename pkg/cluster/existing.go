@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/kube"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const ExistingProviderName = "existing"
+
+// Existing adopts the clusters given a kubeconfig in kube.Kubeconfigs, e.g,
+// clusters already used by an engineer; they are never created, stopped or
+// deleted by rockpool.
+type Existing struct{}
+
+func init() {
+	Register(Existing{})
+}
+
+func (Existing) Name() string {
+	return ExistingProviderName
+}
+
+func (Existing) Binaries() []string {
+	return nil
+}
+
+func (Existing) List(ctx context.Context) ([]Cluster, error) {
+	names := []string{}
+	for cn := range kube.Kubeconfigs {
+		names = append(names, cn)
+	}
+	sort.Strings(names)
+
+	clusters := []Cluster{}
+	for _, cn := range names {
+		c := Cluster{Name: cn}
+		host, err := apiServer(kube.Kubeconfigs[cn])
+		if err != nil {
+			log.WithField("clusterName", cn).WithError(err).Warn("unable to load kubeconfig")
+		} else {
+			c.Running = true
+			c.API = host.String()
+			c.IP = host.Hostname()
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters, nil
+}
+
+// apiServer returns the URL of the API server of the kubeconfig's current
+// context.
+func apiServer(kubeconfig string) (*url.URL, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return url.Parse(config.Host)
+}
+
+func (Existing) Create(ctx context.Context, cn string, isController bool) error {
+	kc := kube.Kubeconfigs[cn]
+	if _, err := os.Stat(kc); err != nil {
+		return fmt.Errorf("unable to adopt cluster %s: %w", cn, err)
+	}
+	log.WithFields(log.Fields{"clusterName": cn, "kubeconfig": kc}).
+		Info("using existing cluster")
+	return nil
+}
+
+func (Existing) Start(ctx context.Context, cn string) error {
+	log.WithField("clusterName", cn).Debug("existing clusters are not started by rockpool")
+	return nil
+}
+
+func (Existing) Stop(ctx context.Context, cn string) error {
+	log.WithField("clusterName", cn).Debug("existing clusters are not stopped by rockpool")
+	return nil
+}
+
+func (Existing) Delete(ctx context.Context, cn string) error {
+	log.WithField("clusterName", cn).Info("leaving existing cluster in place")
+	return nil
+}
+
+func (Existing) WriteKubeConfig(ctx context.Context, cn string) error {
+	return nil
+}
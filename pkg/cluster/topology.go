@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Topology is the layout of a cluster's nodes.
+type Topology struct {
+	// Servers is the number of control-plane nodes; with more than one, the
+	// cluster runs an embedded etcd.
+	Servers int `yaml:"servers"`
+	Agents  int `yaml:"agents"`
+	// Memory limits the memory of each node, e.g, 2g.
+	Memory string `yaml:"memory,omitempty"`
+	// Labels are added to the agents, e.g, tier=batch.
+	Labels []string `yaml:"labels,omitempty"`
+	// Taints are added to the agents, e.g, dedicated=batch:NoSchedule.
+	Taints []string `yaml:"taints,omitempty"`
+}
+
+// DefaultTopology is the layout of the clusters which have no override.
+var DefaultTopology = Topology{Servers: 1, Agents: 1}
+
+// Topologies overrides DefaultTopology per cluster, by cluster name.
+var Topologies = map[string]Topology{}
+
+var (
+	memoryRegex = regexp.MustCompile(`^\d+[kmg]?$`)
+	labelRegex  = regexp.MustCompile(`^[\w./-]+=[\w.-]*$`)
+	taintRegex  = regexp.MustCompile(`^[\w./-]+(=[\w.-]*)?:(NoSchedule|PreferNoSchedule|NoExecute)$`)
+)
+
+// TopologyFor returns the topology of the cluster.
+func TopologyFor(cn string) Topology {
+	t, ok := Topologies[cn]
+	if !ok {
+		return DefaultTopology
+	}
+	if t.Servers < 1 {
+		t.Servers = DefaultTopology.Servers
+	}
+	return t
+}
+
+// Validate checks the node counts and the format of the memory limit,
+// labels and taints.
+func (t Topology) Validate() error {
+	if t.Servers < 1 || t.Agents < 0 {
+		return fmt.Errorf("invalid node counts: %d servers, %d agents", t.Servers, t.Agents)
+	}
+	if t.Memory != "" && !memoryRegex.MatchString(t.Memory) {
+		return fmt.Errorf("invalid memory limit %q, expected e.g, 2g or 512m", t.Memory)
+	}
+	for _, l := range t.Labels {
+		if !labelRegex.MatchString(l) {
+			return fmt.Errorf("invalid node label %q, expected key=value", l)
+		}
+	}
+	for _, tn := range t.Taints {
+		if !taintRegex.MatchString(tn) {
+			return fmt.Errorf("invalid node taint %q, expected key=value:Effect", tn)
+		}
+	}
+	return nil
+}
+
+// ValidateTopologies checks the default topology and the per-cluster
+// overrides.
+func ValidateTopologies() error {
+	if err := DefaultTopology.Validate(); err != nil {
+		return err
+	}
+	for cn := range Topologies {
+		if err := TopologyFor(cn).Validate(); err != nil {
+			return fmt.Errorf("%s: %w", cn, err)
+		}
+	}
+	return nil
+}
+
+// NodeScaler is implemented by the providers which can add agents to and
+// remove them from a running cluster.
+type NodeScaler interface {
+	// AddNode creates an agent with the cluster's topology and returns its
+	// name.
+	AddNode(ctx context.Context, cn string) (string, error)
+	RemoveNode(ctx context.Context, cn string, node string) error
+}
+
+// scalerFor returns the provider of the cluster if it exists, is running and
+// supports scaling.
+func scalerFor(cn string) (NodeScaler, Cluster, error) {
+	p, err := ProviderFor(cn)
+	if err != nil {
+		return nil, Cluster{}, err
+	}
+	exists, c := Exists(cn)
+	if !exists {
+		return nil, c, &NotFoundError{Name: cn}
+	}
+	if !c.Running {
+		return nil, c, fmt.Errorf("cluster %s is not running", cn)
+	}
+	s, ok := p.(NodeScaler)
+	if !ok {
+		return nil, c, fmt.Errorf("the %s provider can't add or remove nodes", p.Name())
+	}
+	return s, c, nil
+}
+
+// AddNode adds an agent to the cluster.
+func AddNode(ctx context.Context, cn string) (string, error) {
+	s, _, err := scalerFor(cn)
+	if err != nil {
+		return "", err
+	}
+	if err := TopologyFor(cn).Validate(); err != nil {
+		return "", err
+	}
+	n, err := s.AddNode(ctx, cn)
+	if err != nil {
+		return "", err
+	}
+	log.WithFields(log.Fields{"clusterName": cn, "node": n}).Info("added node")
+	return n, Fetch(ctx)
+}
+
+// RemoveNode removes an agent from the cluster; if node is empty, the last
+// agent is removed. Servers can't be removed.
+func RemoveNode(ctx context.Context, cn string, node string) error {
+	s, c, err := scalerFor(cn)
+	if err != nil {
+		return err
+	}
+	agents := []string{}
+	for _, n := range c.Nodes {
+		if n.Role == "agent" {
+			agents = append(agents, n.Name)
+		}
+	}
+	sort.Strings(agents)
+	if node == "" {
+		if len(agents) == 0 {
+			return fmt.Errorf("cluster %s has no agent to remove", cn)
+		}
+		node = agents[len(agents)-1]
+	} else if i := sort.SearchStrings(agents, node); i == len(agents) || agents[i] != node {
+		return fmt.Errorf("%s is not an agent of cluster %s", node, cn)
+	}
+	if err := s.RemoveNode(ctx, cn, node); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"clusterName": cn, "node": node}).Info("removed node")
+	return Fetch(ctx)
+}
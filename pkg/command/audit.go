@@ -0,0 +1,274 @@
+package command
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditLogRetention is the number of audit logs kept per platform; older
+// ones are removed when a new log is opened.
+var AuditLogRetention = 50
+
+// MaxAuditStderr is the maximum length of the stderr kept for a command in
+// the audit log; only its end is kept.
+var MaxAuditStderr = 2048
+
+// maxAuditArg is the maximum length of an argument in the audit log, e.g, for
+// the manifests passed to echo.
+const maxAuditArg = 512
+
+const auditTimeFormat = "20060102-150405.000"
+
+// Audit is the log to which the commands run are written; no log is written
+// when nil.
+var Audit *AuditLog
+
+// AuditEntry is a line of the audit log. The first line of each log holds
+// the arguments rockpool was run with, and each subsequent one a command.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Run holds the arguments rockpool was run with.
+	Run []string `json:"run,omitempty"`
+
+	Args       []string `json:"args,omitempty"`
+	Dir        string   `json:"dir,omitempty"`
+	DurationMs int64    `json:"durationMs"`
+	ExitCode   int      `json:"exitCode"`
+	Stderr     string   `json:"stderr,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Duration returns the time the command took.
+func (e AuditEntry) Duration() time.Duration {
+	return time.Duration(e.DurationMs) * time.Millisecond
+}
+
+// AuditLog writes the commands run to a jsonl file.
+type AuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenAuditLog creates a new log in dir, named after the current time, and
+// writes the rockpool arguments to it.
+func OpenAuditLog(dir string, run []string) (*AuditLog, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	pruneAuditLogs(dir)
+	now := time.Now()
+	f, err := os.OpenFile(filepath.Join(dir, now.Format(auditTimeFormat)+".jsonl"),
+		os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &AuditLog{file: f}
+	if err := l.write(AuditEntry{Time: now, Run: RedactArgs(run)}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Close closes the log file.
+func (l *AuditLog) Close() error {
+	return l.file.Close()
+}
+
+func (l *AuditLog) write(e AuditEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.file.Write(append(data, '\n'))
+	return err
+}
+
+// Log writes a command's execution to the log; failures to write are
+// ignored so as not to affect the command.
+func (l *AuditLog) Log(args []string, dir string, start time.Time, stderr []byte, err error) {
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	e := AuditEntry{
+		Time:       start,
+		Args:       RedactArgs(args),
+		Dir:        dir,
+		DurationMs: time.Since(start).Milliseconds(),
+		ExitCode:   exitCode(err),
+		Stderr:     Redact(truncateStart(string(stderr), MaxAuditStderr)),
+	}
+	if err != nil {
+		e.Error = Redact(err.Error())
+	}
+	l.write(e)
+}
+
+// exitCode returns the exit code of a command given its error, or -1 if the
+// command could not be run or was killed.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	var replayErr *ExitError
+	if err == nil {
+		return 0
+	} else if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	} else if errors.As(err, &replayErr) {
+		return replayErr.Code
+	}
+	return -1
+}
+
+func truncateStart(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return "..." + s[len(s)-max:]
+}
+
+var secretFlagRegex = regexp.MustCompile(`(?i)^--?[\w-]*(password|passwd|secret|token|api-?key)$`)
+
+var secretRegexes = []*regexp.Regexp{
+	// key=value or key: value, e.g, secret.MYSQL_ROOT_PASSWORD=pass.
+	regexp.MustCompile(`(?i)([\w.-]*(?:password|passwd|secret|token|api-?key)[\w.-]*["']?\s*[=:]\s*["']?)([^\s"',}]+)`),
+	regexp.MustCompile(`(?i)(bearer\s+)(\S+)`),
+	regexp.MustCompile(`(-----BEGIN [A-Z ]*PRIVATE KEY-----)[\s\S]*?(-----END [A-Z ]*PRIVATE KEY-----|$)`),
+}
+
+// Redact replaces the values which look like secrets in s.
+func Redact(s string) string {
+	for _, re := range secretRegexes {
+		s = re.ReplaceAllStringFunc(s, func(m string) string {
+			sub := re.FindStringSubmatch(m)
+			// Keep environment variable references, e.g, -p$MARIADB_PASSWORD.
+			if strings.HasPrefix(sub[2], "$") {
+				return m
+			}
+			return sub[1] + "[REDACTED]"
+		})
+	}
+	return s
+}
+
+// RedactArgs redacts the arguments which look like secrets, including the
+// values following flags such as --password, and truncates the long ones.
+func RedactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, a := range args {
+		if i > 0 && secretFlagRegex.MatchString(args[i-1]) {
+			redacted[i] = "[REDACTED]"
+			continue
+		}
+		redacted[i] = Redact(a)
+		if len(redacted[i]) > maxAuditArg {
+			redacted[i] = redacted[i][:maxAuditArg] + "..."
+		}
+	}
+	return redacted
+}
+
+// AuditRun is a past run of rockpool, read from its audit log.
+type AuditRun struct {
+	ID       string
+	Path     string
+	Run      AuditEntry
+	Commands []AuditEntry
+}
+
+// Duration returns the time between the start of the run and the end of its
+// last command.
+func (r AuditRun) Duration() time.Duration {
+	var end time.Time
+	for _, c := range r.Commands {
+		if cEnd := c.Time.Add(c.Duration()); cEnd.After(end) {
+			end = cEnd
+		}
+	}
+	if end.IsZero() {
+		return 0
+	}
+	return end.Sub(r.Run.Time)
+}
+
+// Failed returns the commands which exited with an error.
+func (r AuditRun) Failed() []AuditEntry {
+	failed := []AuditEntry{}
+	for _, c := range r.Commands {
+		if c.ExitCode != 0 {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+// Slowest returns the n commands which took the longest.
+func (r AuditRun) Slowest(n int) []AuditEntry {
+	cmds := append([]AuditEntry{}, r.Commands...)
+	sort.SliceStable(cmds, func(i, j int) bool {
+		return cmds[i].DurationMs > cmds[j].DurationMs
+	})
+	if n > 0 && len(cmds) > n {
+		cmds = cmds[:n]
+	}
+	return cmds
+}
+
+// AuditLogs returns the paths of the logs in dir, oldest first.
+func AuditLogs(dir string) ([]string, error) {
+	logs, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(logs)
+	return logs, nil
+}
+
+// ReadAuditLog parses the log at path.
+func ReadAuditLog(path string) (AuditRun, error) {
+	r := AuditRun{ID: strings.TrimSuffix(filepath.Base(path), ".jsonl"), Path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		return r, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return r, fmt.Errorf("invalid entry at line %d of %s: %w", line, path, err)
+		}
+		if e.Run != nil {
+			r.Run = e
+			continue
+		}
+		r.Commands = append(r.Commands, e)
+	}
+	return r, scanner.Err()
+}
+
+// pruneAuditLogs removes the oldest logs so that, including the one about to
+// be created, at most AuditLogRetention are kept.
+func pruneAuditLogs(dir string) {
+	logs, err := AuditLogs(dir)
+	if err != nil || AuditLogRetention <= 0 {
+		return
+	}
+	for len(logs) >= AuditLogRetention {
+		os.Remove(logs[0])
+		logs = logs[1:]
+	}
+}
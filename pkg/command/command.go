@@ -9,15 +9,18 @@ package command
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"text/template"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -38,9 +41,42 @@ type IShellCommand interface {
 	Wait() error
 }
 
+// Timeouts holds the maximum duration of a single command per binary, set
+// using --timeout; a duration of 0 disables the limit. There is none by
+// default, since pulling or saving images, for instance, may take very long.
+var Timeouts = map[string]time.Duration{}
+
+// QueryTimeout is the maximum duration of the commands which only query some
+// state, when no timeout is set for their binary.
+var QueryTimeout = time.Minute
+
+type queryKey struct{}
+
+type noTimeoutKey struct{}
+
+// Query marks the commands run with the returned context as only querying
+// some state, e.g, docker inspect, so that they are given QueryTimeout to
+// complete.
+func Query(ctx context.Context) context.Context {
+	return context.WithValue(ctx, queryKey{}, true)
+}
+
+// GracePeriod is how long a command is given to exit after being interrupted,
+// before it is killed.
+var GracePeriod = 10 * time.Second
+
 // ExecShellCommand implements IShellCommand.
 type ExecShellCommand struct {
 	*exec.Cmd
+	ctx    context.Context
+	cancel context.CancelFunc
+	audit  *commandAudit
+}
+
+// commandAudit holds the details of a command's execution for the audit log.
+type commandAudit struct {
+	start  time.Time
+	stderr bytes.Buffer
 }
 
 func (cmd ExecShellCommand) SetDir(dir string) {
@@ -51,6 +87,92 @@ func (cmd ExecShellCommand) AddArgs(args ...string) {
 	cmd.Args = append(cmd.Args, args...)
 }
 
+func (cmd ExecShellCommand) Run() error {
+	defer cmd.cancel()
+	cmd.auditStart(true)
+	err := cmd.contextError(cmd.Cmd.Run())
+	cmd.auditEnd(nil, err)
+	return err
+}
+
+func (cmd ExecShellCommand) Output() ([]byte, error) {
+	defer cmd.cancel()
+	// The stderr is captured in the error by Output.
+	cmd.auditStart(false)
+	out, err := cmd.Cmd.Output()
+	err = cmd.contextError(err)
+	cmd.auditEnd(nil, err)
+	return out, err
+}
+
+func (cmd ExecShellCommand) CombinedOutput() ([]byte, error) {
+	defer cmd.cancel()
+	cmd.auditStart(false)
+	out, err := cmd.Cmd.CombinedOutput()
+	err = cmd.contextError(err)
+	if err != nil {
+		cmd.auditEnd(out, err)
+	} else {
+		cmd.auditEnd(nil, err)
+	}
+	return out, err
+}
+
+func (cmd ExecShellCommand) Start() error {
+	// The stderr may be a pipe, so it is not captured.
+	cmd.auditStart(false)
+	if err := cmd.Cmd.Start(); err != nil {
+		err = cmd.contextError(err)
+		cmd.cancel()
+		cmd.auditEnd(nil, err)
+		return err
+	}
+	return nil
+}
+
+func (cmd ExecShellCommand) Wait() error {
+	defer cmd.cancel()
+	err := cmd.contextError(cmd.Cmd.Wait())
+	cmd.auditEnd(nil, err)
+	return err
+}
+
+// auditStart records the start of the command, and captures its stderr if
+// requested and the audit log is enabled.
+func (cmd ExecShellCommand) auditStart(captureStderr bool) {
+	cmd.audit.start = time.Now()
+	if captureStderr && Audit != nil {
+		if cmd.Stderr == nil {
+			cmd.Stderr = &cmd.audit.stderr
+		} else {
+			cmd.Stderr = io.MultiWriter(cmd.Stderr, &cmd.audit.stderr)
+		}
+	}
+}
+
+// auditEnd logs the command's execution, using stderr if it was not
+// captured.
+func (cmd ExecShellCommand) auditEnd(stderr []byte, err error) {
+	duration := time.Since(cmd.audit.start)
+	log.WithFields(log.Fields{
+		"command":  strings.Join(RedactArgs(cmd.Args), " "),
+		"duration": duration.Round(time.Millisecond),
+		"exitCode": exitCode(err),
+	}).Debug("command finished")
+
+	if Audit == nil {
+		return
+	}
+	if cmd.audit.stderr.Len() > 0 {
+		stderr = cmd.audit.stderr.Bytes()
+	}
+	var exitErr *exec.ExitError
+	if len(stderr) == 0 && errors.As(err, &exitErr) {
+		stderr = exitErr.Stderr
+	}
+	Audit.Log(cmd.Args, cmd.Dir, cmd.audit.start, stderr, err)
+}
+
 func (cmd ExecShellCommand) RunProgressive() error {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -61,6 +183,18 @@ func (cmd ExecShellCommand) RunProgressive() error {
 	return nil
 }
 
+// contextError replaces the error of a command which failed because its
+// context was done, so that the cause is not lost.
+func (cmd ExecShellCommand) contextError(err error) error {
+	if err == nil || cmd.ctx.Err() == nil {
+		return err
+	}
+	if errors.Is(cmd.ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out: %w", cmd.String(), cmd.ctx.Err())
+	}
+	return fmt.Errorf("%s was interrupted: %w", cmd.String(), cmd.ctx.Err())
+}
+
 func (cmd ExecShellCommand) SetStdin(in io.Reader) {
 	cmd.Stdin = in
 }
@@ -69,26 +203,69 @@ func (cmd ExecShellCommand) SetStdout(out io.Writer) {
 	cmd.Stdout = out
 }
 
-// NewExecShellCommander returns a command instance.
-func NewExecShellCommander(name string, arg ...string) IShellCommand {
-	execCmd := exec.Command(name, arg...)
-	return ExecShellCommand{Cmd: execCmd}
+// WithoutTimeout marks the commands run with the returned context as
+// transferring images, e.g, docker pull or save, so that they are not limited
+// by the timeout of their binary, however long they take.
+func WithoutTimeout(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noTimeoutKey{}, true)
+}
+
+// NewExecShellCommander returns a command instance bound to ctx, and limited
+// to the timeout of its binary if any. When ctx is done, the command is sent
+// an interrupt signal, and killed if it is still running after the grace
+// period.
+func NewExecShellCommander(ctx context.Context, name string, arg ...string) IShellCommand {
+	var cancel context.CancelFunc
+	timeout, ok := Timeouts[filepath.Base(name)]
+	if !ok && ctx.Value(queryKey{}) != nil {
+		timeout = QueryTimeout
+	}
+	if ctx.Value(noTimeoutKey{}) != nil {
+		timeout = 0
+	}
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	execCmd := exec.CommandContext(ctx, name, arg...)
+	execCmd.Cancel = func() error {
+		return execCmd.Process.Signal(os.Interrupt)
+	}
+	execCmd.WaitDelay = GracePeriod
+	return ExecShellCommand{Cmd: execCmd, ctx: ctx, cancel: cancel,
+		audit: &commandAudit{}}
 }
 
 // ShellCommander provides a wrapper around the commander to allow for better
 // testing and mocking.
 var ShellCommander = NewExecShellCommander
 
+// ExitError is returned when a replayed command exits with a non-zero code.
+type ExitError struct {
+	Code   int
+	Stderr []byte
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
 // GetMsgFromCommandError attempts to extract the error message from a command
 // run's stderr.
 func GetMsgFromCommandError(err error) error {
 	var pathErr *fs.PathError
 	var exitErr *exec.ExitError
+	var replayErr *ExitError
 	var errMsg string
-	if errors.As(err, &pathErr) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	} else if errors.As(err, &pathErr) {
 		errMsg = pathErr.Path + ": " + pathErr.Err.Error()
 	} else if errors.As(err, &exitErr) {
 		errMsg = string(exitErr.Stderr)
+	} else if errors.As(err, &replayErr) {
+		errMsg = string(replayErr.Stderr)
 	} else {
 		errMsg = err.Error()
 	}
@@ -0,0 +1,33 @@
+package command
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeouts(t *testing.T) {
+	defer func(d time.Duration) { QueryTimeout = d }(QueryTimeout)
+	QueryTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	err := NewExecShellCommander(Query(context.Background()), "sleep", "5").Run()
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected the query to time out, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("query took %s", time.Since(start))
+	}
+
+	Timeouts["sleep"] = 0
+	defer delete(Timeouts, "sleep")
+	if err := NewExecShellCommander(Query(context.Background()), "sleep", "0.1").Run(); err != nil {
+		t.Errorf("expected the timeout of the binary to be used, got %v", err)
+	}
+
+	Timeouts["sleep"] = 50 * time.Millisecond
+	if err := NewExecShellCommander(WithoutTimeout(context.Background()), "sleep", "0.1").Run(); err != nil {
+		t.Errorf("expected no timeout, got %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Interaction is a command run, along with its outcome. When replaying, each
+// element of Args is a pattern in which '*' matches any sequence of
+// characters; a last element of '**' matches any remaining arguments.
+type Interaction struct {
+	Args     []string `yaml:"args,flow"`
+	Stdout   string   `yaml:"stdout,omitempty"`
+	Stderr   string   `yaml:"stderr,omitempty"`
+	ExitCode int      `yaml:"exit-code,omitempty"`
+}
+
+// Matches checks whether the interaction's patterns match the command.
+func (i Interaction) Matches(args []string) bool {
+	patterns := i.Args
+	if len(patterns) > 0 && patterns[len(patterns)-1] == "**" {
+		patterns = patterns[:len(patterns)-1]
+		if len(args) < len(patterns) {
+			return false
+		}
+		args = args[:len(patterns)]
+	}
+	if len(patterns) != len(args) {
+		return false
+	}
+	for idx, p := range patterns {
+		if !matchArg(p, args[idx]) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchArg(pattern string, arg string) bool {
+	if !strings.Contains(pattern, "*") {
+		return pattern == arg
+	}
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	re := regexp.MustCompile("^" + strings.Join(parts, "(?s:.*)") + "$")
+	return re.MatchString(arg)
+}
+
+// LoadFixture reads the interactions from a yaml fixture file.
+func LoadFixture(path string) ([]Interaction, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	interactions := []Interaction{}
+	if err := yaml.Unmarshal(data, &interactions); err != nil {
+		return nil, fmt.Errorf("unable to parse fixture %s: %w", path, err)
+	}
+	return interactions, nil
+}
+
+// FakeCommander replays interactions instead of running commands. Commands
+// are matched against the interactions in order; each interaction is used
+// once, except for the last one matching a command, which is reused when
+// all the others have been consumed.
+type FakeCommander struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+	calls        [][]string
+	unmatched    [][]string
+}
+
+// NewFakeCommander creates a commander replaying the given interactions.
+func NewFakeCommander(interactions ...Interaction) *FakeCommander {
+	return &FakeCommander{
+		interactions: interactions,
+		used:         make([]bool, len(interactions)),
+	}
+}
+
+// NewFakeCommanderFromFixture creates a commander replaying the interactions
+// of a fixture file.
+func NewFakeCommanderFromFixture(path string) (*FakeCommander, error) {
+	interactions, err := LoadFixture(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewFakeCommander(interactions...), nil
+}
+
+// Install replaces ShellCommander with the fake, returning a function which
+// restores the previous one.
+func (f *FakeCommander) Install() func() {
+	prev := ShellCommander
+	ShellCommander = f.Command
+	return func() { ShellCommander = prev }
+}
+
+// Command has the same signature as ShellCommander.
+func (f *FakeCommander) Command(ctx context.Context, name string, arg ...string) IShellCommand {
+	return &FakeShellCommand{ctx: ctx, commander: f,
+		args: append([]string{filepath.Base(name)}, arg...)}
+}
+
+// Calls returns the arguments of the commands which have been run, with the
+// binary's base name first.
+func (f *FakeCommander) Calls() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]string{}, f.calls...)
+}
+
+// Unmatched returns the commands for which no interaction was found.
+func (f *FakeCommander) Unmatched() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]string{}, f.unmatched...)
+}
+
+// Called checks whether a command matching the patterns has been run.
+func (f *FakeCommander) Called(patterns ...string) bool {
+	i := Interaction{Args: patterns}
+	for _, c := range f.Calls() {
+		if i.Matches(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FakeCommander) match(args []string) (Interaction, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, args)
+	last := -1
+	for idx, i := range f.interactions {
+		if !i.Matches(args) {
+			continue
+		}
+		last = idx
+		if !f.used[idx] {
+			f.used[idx] = true
+			return i, true
+		}
+	}
+	if last >= 0 {
+		return f.interactions[last], true
+	}
+	f.unmatched = append(f.unmatched, args)
+	return Interaction{}, false
+}
+
+// FakeShellCommand implements IShellCommand using a FakeCommander.
+type FakeShellCommand struct {
+	ctx       context.Context
+	commander *FakeCommander
+	args      []string
+	dir       string
+	stdin     io.Reader
+	stdout    io.Writer
+	pipe      *io.PipeWriter
+	done      chan error
+}
+
+func (c *FakeShellCommand) String() string {
+	return strings.Join(c.args, " ")
+}
+
+func (c *FakeShellCommand) run(stdout io.Writer, stderr io.Writer) error {
+	if c.pipe != nil {
+		defer c.pipe.Close()
+	}
+	if err := c.ctx.Err(); err != nil {
+		return fmt.Errorf("%s was interrupted: %w", c, err)
+	}
+	if c.stdin != nil {
+		io.Copy(io.Discard, c.stdin)
+	}
+	i, ok := c.commander.match(c.args)
+	if !ok {
+		return &ExitError{Code: 127,
+			Stderr: []byte("no recorded interaction for: " + c.String())}
+	}
+	io.WriteString(stdout, i.Stdout)
+	io.WriteString(stderr, i.Stderr)
+	if i.ExitCode != 0 {
+		return &ExitError{Code: i.ExitCode, Stderr: []byte(i.Stderr)}
+	}
+	return nil
+}
+
+func (c *FakeShellCommand) writer() io.Writer {
+	if c.stdout != nil {
+		return c.stdout
+	}
+	return io.Discard
+}
+
+func (c *FakeShellCommand) Run() error {
+	return c.run(c.writer(), io.Discard)
+}
+
+func (c *FakeShellCommand) Output() ([]byte, error) {
+	var out bytes.Buffer
+	err := c.run(&out, io.Discard)
+	return out.Bytes(), err
+}
+
+func (c *FakeShellCommand) CombinedOutput() ([]byte, error) {
+	var out bytes.Buffer
+	err := c.run(&out, &out)
+	return out.Bytes(), err
+}
+
+func (c *FakeShellCommand) RunProgressive() error {
+	err := c.run(c.writer(), io.Discard)
+	if err != nil {
+		return GetMsgFromCommandError(err)
+	}
+	return nil
+}
+
+func (c *FakeShellCommand) SetDir(dir string) {
+	c.dir = dir
+}
+
+func (c *FakeShellCommand) AddArgs(args ...string) {
+	c.args = append(c.args, args...)
+}
+
+func (c *FakeShellCommand) Start() error {
+	c.done = make(chan error, 1)
+	go func() {
+		c.done <- c.run(c.writer(), io.Discard)
+	}()
+	return nil
+}
+
+func (c *FakeShellCommand) Wait() error {
+	if c.done == nil {
+		return errors.New("command not started")
+	}
+	return <-c.done
+}
+
+func (c *FakeShellCommand) SetStdin(in io.Reader) {
+	c.stdin = in
+}
+
+func (c *FakeShellCommand) SetStdout(out io.Writer) {
+	c.stdout = out
+}
+
+func (c *FakeShellCommand) StdoutPipe() (io.ReadCloser, error) {
+	r, w := io.Pipe()
+	c.stdout = w
+	c.pipe = w
+	return r, nil
+}
+
+func (c *FakeShellCommand) StderrPipe() (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
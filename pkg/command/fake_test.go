@@ -0,0 +1,176 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInteractionMatches(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		args     []string
+		want     bool
+	}{
+		{[]string{"k3d", "cluster", "list"}, []string{"k3d", "cluster", "list"}, true},
+		{[]string{"k3d", "cluster", "list"}, []string{"k3d", "cluster", "list", "-o"}, false},
+		{[]string{"k3d", "cluster", "get"}, []string{"k3d", "cluster", "list"}, false},
+		{[]string{"kubectl", "--kubeconfig", "*/.k3d/kubeconfig-*.yaml"},
+			[]string{"kubectl", "--kubeconfig", "/home/me/.k3d/kubeconfig-rockpool-controller.yaml"}, true},
+		{[]string{"helm", "*"}, []string{"helm", ""}, true},
+		{[]string{"echo", "a.b"}, []string{"echo", "axb"}, false},
+		{[]string{"k3d", "cluster", "create", "**"}, []string{"k3d", "cluster", "create", "--agents", "1"}, true},
+		{[]string{"k3d", "cluster", "create", "**"}, []string{"k3d", "cluster", "create"}, true},
+		{[]string{"k3d", "cluster", "create", "**"}, []string{"k3d", "cluster"}, false},
+	}
+	for _, tt := range tests {
+		i := Interaction{Args: tt.patterns}
+		if got := i.Matches(tt.args); got != tt.want {
+			t.Errorf("Matches(%v, %v) = %v, want %v", tt.patterns, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestFakeCommanderReplaysInOrder(t *testing.T) {
+	f := NewFakeCommander(
+		Interaction{Args: []string{"k3d", "cluster", "list"}, Stdout: "[]"},
+		Interaction{Args: []string{"k3d", "cluster", "list"}, Stdout: "[{}]"},
+	)
+	ctx := context.Background()
+	for _, want := range []string{"[]", "[{}]", "[{}]"} {
+		out, err := f.Command(ctx, "/usr/local/bin/k3d", "cluster", "list").Output()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(out) != want {
+			t.Errorf("got %q, want %q", out, want)
+		}
+	}
+	if len(f.Calls()) != 3 {
+		t.Errorf("got %d calls, want 3", len(f.Calls()))
+	}
+}
+
+func TestFakeCommanderExitCode(t *testing.T) {
+	f := NewFakeCommander(Interaction{
+		Args:     []string{"helm", "list"},
+		Stderr:   "cluster unreachable",
+		ExitCode: 1,
+	})
+	_, err := f.Command(context.Background(), "helm", "list").Output()
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Fatalf("got %v, want exit code 1", err)
+	}
+	if msg := GetMsgFromCommandError(err).Error(); msg != "cluster unreachable" {
+		t.Errorf("got message %q", msg)
+	}
+}
+
+func TestFakeCommanderUnmatched(t *testing.T) {
+	f := NewFakeCommander()
+	cmd := f.Command(context.Background(), "kubectl", "get")
+	cmd.AddArgs("pods")
+	if err := cmd.Run(); err == nil {
+		t.Fatal("expected an error for an unmatched command")
+	}
+	if u := f.Unmatched(); len(u) != 1 || len(u[0]) != 3 {
+		t.Errorf("got unmatched %v", u)
+	}
+}
+
+func TestFakeCommanderCancelled(t *testing.T) {
+	f := NewFakeCommander(Interaction{Args: []string{"k3d"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := f.Command(ctx, "k3d").Run(); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestFakeCommanderPipes(t *testing.T) {
+	f := NewFakeCommander(
+		Interaction{Args: []string{"echo", "*"}, Stdout: "content"},
+		Interaction{Args: []string{"kubectl", "replace"}, Stdout: "replaced"},
+	)
+	ctx := context.Background()
+	cat := f.Command(ctx, "echo", "content")
+	replace := f.Command(ctx, "kubectl", "replace")
+	reader, writer := io.Pipe()
+	cat.SetStdin(nil)
+	cat.SetStdout(writer)
+	replace.SetStdin(reader)
+	out, err := replace.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cat.Start()
+	replace.Start()
+	cat.Wait()
+	writer.Close()
+	data, _ := io.ReadAll(out)
+	if err := replace.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "replaced" {
+		t.Errorf("got %q", data)
+	}
+}
+
+func TestLoadFixture(t *testing.T) {
+	interactions, err := LoadFixture(filepath.Join("testdata", "fixture.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(interactions) != 2 {
+		t.Fatalf("got %d interactions, want 2", len(interactions))
+	}
+	if interactions[1].ExitCode != 1 || interactions[1].Stderr != "not found\n" {
+		t.Errorf("unexpected interaction: %+v", interactions[1])
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "recorded.yml")
+	rec := NewRecorder(fixture)
+	ctx := context.Background()
+
+	if out, err := rec.Command(ctx, "sh", "-c", "echo out; echo err >&2").Output(); err != nil || string(out) != "out\n" {
+		t.Fatalf("got %q, %v", out, err)
+	}
+	if err := rec.Command(ctx, "sh", "-c", "echo failed >&2; exit 3").Run(); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if err := rec.Command(ctx, "sh", "-c", "echo password=s3cr3t", "--token", "s3cr3t").Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cr3t") {
+		t.Errorf("expected the secrets to be redacted, got:\n%s", data)
+	}
+	f, err := NewFakeCommanderFromFixture(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Command(ctx, "sh", "-c", "echo password=s3cr3t", "--token", "s3cr3t").Run(); err != nil {
+		t.Errorf("expected the redacted command to be replayed, got %v", err)
+	}
+	out, err := f.Command(ctx, "sh", "-c", "echo out; echo err >&2").Output()
+	if err != nil || string(out) != "out\n" {
+		t.Errorf("replayed %q, %v", out, err)
+	}
+	err = f.Command(ctx, "sh", "-c", "echo failed >&2; exit 3").Run()
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 || string(exitErr.Stderr) != "failed\n" {
+		t.Errorf("replayed %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Recorder runs commands for real and saves their interactions to a fixture
+// file which can be replayed with a FakeCommander. The user's home directory
+// is replaced with '*' in the recorded arguments so that the fixtures can be
+// used on other machines, and so are the secrets, which are also redacted
+// from the output.
+type Recorder struct {
+	mu           sync.Mutex
+	path         string
+	home         string
+	interactions []Interaction
+}
+
+// NewRecorder creates a recorder saving to the given fixture file.
+func NewRecorder(path string) *Recorder {
+	home, _ := os.UserHomeDir()
+	return &Recorder{path: path, home: home}
+}
+
+// Install replaces ShellCommander with the recorder, returning a function
+// which restores the previous one.
+func (r *Recorder) Install() func() {
+	prev := ShellCommander
+	ShellCommander = r.Command
+	return func() { ShellCommander = prev }
+}
+
+// Command has the same signature as ShellCommander.
+func (r *Recorder) Command(ctx context.Context, name string, arg ...string) IShellCommand {
+	cmd := NewExecShellCommander(ctx, name, arg...).(ExecShellCommand)
+	return &recordingCommand{ExecShellCommand: cmd, recorder: r}
+}
+
+// add appends an interaction and saves the fixture file, so that the
+// interactions recorded so far are kept if rockpool exits early.
+func (r *Recorder) add(i Interaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, i)
+
+	data, err := yaml.Marshal(r.interactions)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(r.path), os.ModePerm)
+	}
+	if err == nil {
+		err = os.WriteFile(r.path, data, 0644)
+	}
+	if err != nil {
+		log.WithField("file", r.path).WithError(err).
+			Warn("unable to save recorded commands")
+	}
+}
+
+// recordingCommand captures the output of an ExecShellCommand.
+type recordingCommand struct {
+	ExecShellCommand
+	recorder *Recorder
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+}
+
+func (c *recordingCommand) record(stdout []byte, stderr []byte, err error) {
+	args := []string{filepath.Base(c.Args[0])}
+	for _, a := range RedactArgs(c.Args)[1:] {
+		if c.recorder.home != "" {
+			a = strings.ReplaceAll(a, c.recorder.home, "*")
+		}
+		args = append(args, recordedArg(a))
+	}
+	i := Interaction{Args: args, Stdout: Redact(string(stdout)), Stderr: Redact(string(stderr))}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		i.ExitCode = exitErr.ExitCode()
+		if len(stderr) == 0 {
+			i.Stderr = Redact(string(exitErr.Stderr))
+		}
+	} else if err != nil {
+		i.ExitCode = -1
+	}
+	c.recorder.add(i)
+}
+
+// recordedArg turns the redacted and truncated parts of an argument into
+// wildcards, so that the fixture still matches the original command.
+func recordedArg(a string) string {
+	a = strings.ReplaceAll(a, "[REDACTED]", "*")
+	if len(a) == maxAuditArg+len("...") && strings.HasSuffix(a, "...") {
+		a = strings.TrimSuffix(a, "...") + "*"
+	}
+	return a
+}
+
+// tee copies the command's output to the buffers, in addition to its
+// current destination.
+func (c *recordingCommand) tee(stdout io.Writer, stderr io.Writer) {
+	c.Stdout = teeWriter(stdout, &c.stdout)
+	c.Stderr = teeWriter(stderr, &c.stderr)
+}
+
+func teeWriter(w io.Writer, buf *bytes.Buffer) io.Writer {
+	if w == nil {
+		return buf
+	}
+	return io.MultiWriter(w, buf)
+}
+
+func (c *recordingCommand) Run() error {
+	c.tee(c.Stdout, c.Stderr)
+	err := c.ExecShellCommand.Run()
+	c.record(c.stdout.Bytes(), c.stderr.Bytes(), err)
+	return err
+}
+
+func (c *recordingCommand) Output() ([]byte, error) {
+	out, err := c.ExecShellCommand.Output()
+	c.record(out, nil, err)
+	return out, err
+}
+
+func (c *recordingCommand) CombinedOutput() ([]byte, error) {
+	out, err := c.ExecShellCommand.CombinedOutput()
+	c.record(out, nil, err)
+	return out, err
+}
+
+func (c *recordingCommand) RunProgressive() error {
+	c.tee(os.Stdout, os.Stderr)
+	err := c.ExecShellCommand.Run()
+	c.record(c.stdout.Bytes(), c.stderr.Bytes(), err)
+	if err != nil {
+		return GetMsgFromCommandError(err)
+	}
+	return nil
+}
+
+func (c *recordingCommand) Start() error {
+	c.tee(c.Stdout, c.Stderr)
+	return c.ExecShellCommand.Start()
+}
+
+func (c *recordingCommand) Wait() error {
+	err := c.ExecShellCommand.Wait()
+	c.record(c.stdout.Bytes(), c.stderr.Bytes(), err)
+	return err
+}
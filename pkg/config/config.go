@@ -0,0 +1,425 @@
+// Package config manages the persisted configuration of a platform, which is
+// stored at ~/.rockpool/<name>/config.yaml.
+//
+// The config is written on `rockpool up` and loaded before every command, so
+// that subsequent runs use the same values the platform was created with.
+// Flags explicitly passed on the command line take precedence over it.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/cluster"
+	"github.com/salsadigitalauorg/rockpool/pkg/k3d"
+	"github.com/salsadigitalauorg/rockpool/pkg/kube"
+	"github.com/salsadigitalauorg/rockpool/pkg/lagoon"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings of a platform. The yaml keys match the names of
+// the flags they can be overridden with.
+type Config struct {
+	Domain string `yaml:"domain"`
+	// Targets is the number of targets created when none is named in
+	// NamedTargets.
+	Targets       int    `yaml:"targets"`
+	LagoonVersion string `yaml:"lagoon-version"`
+	SshKey        string `yaml:"ssh-key"`
+	// Provider creates the clusters, e.g, k3d or kind.
+	Provider string `yaml:"provider"`
+	// Kubeconfigs are the kubeconfigs of existing clusters to use instead
+	// of creating them, by short cluster name, e.g, target-1.
+	Kubeconfigs map[string]string `yaml:"kubeconfigs,omitempty"`
+	// K3sVersion is the version of k3s the k3d clusters are created with.
+	K3sVersion string `yaml:"k3s-version"`
+	// TargetK3sVersions override K3sVersion by short cluster name.
+	TargetK3sVersions map[string]string `yaml:"target-k3s-versions"`
+	// Topology overrides the default layout of the nodes, by short cluster
+	// name.
+	Topology map[string]cluster.Topology `yaml:"topology"`
+	// NamedTargets defines the targets by name, along with their Lagoon
+	// remote.
+	NamedTargets []platform.Target `yaml:"named-targets"`
+	// Ports are the host ports of the registry and the controller's services;
+	// a block of free ports is allocated to the platform when unset.
+	Ports platform.Ports `yaml:"ports"`
+	// Mirrors are the upstream registries cached by the platform's proxy
+	// registries; the default ones are used when unset.
+	Mirrors []k3d.Mirror `yaml:"mirrors,omitempty"`
+}
+
+// Default returns the config used when none has been saved yet.
+func Default() Config {
+	return Config{
+		Domain:        "k3d.local",
+		Targets:       1,
+		LagoonVersion: lagoon.DefaultVersion,
+		Provider:      "k3d",
+		K3sVersion:    k3d.DefaultK3sVersion,
+	}
+}
+
+// Path returns the path to the config file for the current platform.
+func Path() string {
+	return filepath.Join(platform.Dir(), "config.yaml")
+}
+
+// Load reads the config file of the current platform on top of the defaults.
+// A missing file is not an error.
+func Load() (Config, error) {
+	return load(Path())
+}
+
+// LoadPlatform reads the config file of the platform with the given name.
+func LoadPlatform(name string) (Config, error) {
+	return load(filepath.Join(platform.ConfigDir, name, "config.yaml"))
+}
+
+func load(path string) (Config, error) {
+	c := Default()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return c, err
+	}
+	if err := decode(data, &c); err != nil {
+		return c, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Platforms returns the names of the platforms whose config has been saved.
+func Platforms() ([]string, error) {
+	entries, err := os.ReadDir(platform.ConfigDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(platform.ConfigDir, e.Name(), "config.yaml")); err == nil {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// ClusterNames returns the names of the clusters of the platform with the
+// given name and config.
+func (c Config) ClusterNames(name string) []string {
+	names := []string{name + "-controller"}
+	if len(c.NamedTargets) > 0 {
+		for _, t := range c.NamedTargets {
+			names = append(names, name+"-"+t.Name)
+		}
+		return names
+	}
+	for i := 1; i <= c.Targets; i++ {
+		names = append(names, fmt.Sprintf("%s-target-%d", name, i))
+	}
+	return names
+}
+
+// allocatePorts returns the first block of ports none of the other platforms
+// uses; the platforms saved without ports use the default ones.
+func allocatePorts() platform.Ports {
+	others := []platform.Ports{}
+	names, err := Platforms()
+	if err != nil {
+		log.WithError(err).Warn("unable to list the platforms")
+	}
+	for _, n := range names {
+		if n == platform.Name {
+			continue
+		}
+		c, err := LoadPlatform(n)
+		if err != nil {
+			log.WithField("platform", n).WithError(err).Warn("unable to load config")
+			continue
+		}
+		others = append(others, c.Ports.Or(platform.DefaultPorts))
+	}
+	for n := 0; ; n++ {
+		b := platform.PortBlock(n)
+		free := true
+		for _, o := range others {
+			if b.Overlaps(o) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return b
+		}
+	}
+}
+
+// Save writes the config to the platform's config file.
+func (c Config) Save() error {
+	if err := os.MkdirAll(platform.Dir(), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(Path(), data, 0644)
+}
+
+// FromPlatform creates a config from the current platform values.
+func FromPlatform() Config {
+	return Config{
+		Domain:            platform.Domain,
+		Targets:           len(platform.Targets),
+		NamedTargets:      namedTargets(),
+		LagoonVersion:     lagoon.Version,
+		SshKey:            platform.LagoonSshKey,
+		Provider:          cluster.DefaultProvider,
+		Kubeconfigs:       byShortName(kube.Kubeconfigs),
+		K3sVersion:        k3d.K3sVersion,
+		TargetK3sVersions: byShortName(k3d.K3sVersions),
+		Topology:          byShortName(cluster.Topologies),
+		Ports:             platform.HostPorts,
+		Mirrors:           customMirrors(),
+	}
+}
+
+// customMirrors returns the platform's mirrors, unless they are the default
+// ones.
+func customMirrors() []k3d.Mirror {
+	if reflect.DeepEqual(k3d.Mirrors, k3d.DefaultMirrors) {
+		return nil
+	}
+	return k3d.Mirrors
+}
+
+// namedTargets returns the platform's targets, unless they are the numbered
+// ones generated from the number of targets.
+func namedTargets() []platform.Target {
+	if reflect.DeepEqual(platform.Targets, platform.NumberedTargets(len(platform.Targets))) {
+		return nil
+	}
+	return platform.Targets
+}
+
+// byShortName returns the values by cluster name, with the platform's name
+// removed from the cluster names.
+func byShortName[V any](m map[string]V) map[string]V {
+	if len(m) == 0 {
+		return nil
+	}
+	byName := map[string]V{}
+	for cn, v := range m {
+		byName[strings.TrimPrefix(cn, platform.Name+"-")] = v
+	}
+	return byName
+}
+
+// mergeByShortName adds the values to m by full cluster name, without
+// overriding the existing ones.
+func mergeByShortName[V any](m map[string]V, values map[string]V) {
+	for n, v := range values {
+		if _, ok := m[platform.Name+"-"+n]; !ok {
+			m[platform.Name+"-"+n] = v
+		}
+	}
+}
+
+// Apply sets the platform values from the config, except for the ones whose
+// flag has been explicitly set.
+func (c Config) Apply(flagChanged func(name string) bool) {
+	if !flagChanged("domain") {
+		platform.Domain = c.Domain
+	}
+	switch {
+	case flagChanged("targets"):
+		platform.Targets = platform.NumberedTargets(platform.NumTargets)
+	case len(c.NamedTargets) > 0:
+		platform.Targets = c.NamedTargets
+	default:
+		platform.NumTargets = c.Targets
+		platform.Targets = platform.NumberedTargets(c.Targets)
+	}
+	if !flagChanged("lagoon-version") {
+		lagoon.Version = c.LagoonVersion
+	}
+	if !flagChanged("ssh-key") {
+		platform.LagoonSshKey = c.SshKey
+	}
+	if !flagChanged("provider") {
+		cluster.DefaultProvider = c.Provider
+	}
+	if !flagChanged("k3s-version") && c.K3sVersion != "" {
+		k3d.K3sVersion = c.K3sVersion
+	}
+	if c.Ports.IsSet() {
+		platform.HostPorts = c.Ports
+	} else {
+		platform.HostPorts = c.Ports.Or(allocatePorts())
+	}
+	if len(c.Mirrors) > 0 {
+		k3d.Mirrors = c.Mirrors
+	}
+	// The kubeconfigs, k3s versions and topologies given as flags are added
+	// to the saved ones.
+	mergeByShortName(kube.Kubeconfigs, c.Kubeconfigs)
+	mergeByShortName(k3d.K3sVersions, c.TargetK3sVersions)
+	mergeByShortName(cluster.Topologies, c.Topology)
+}
+
+// Keys returns the list of top-level config keys.
+func (c Config) Keys() []string {
+	m, _ := c.toMap()
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Get returns the yaml representation of the value at the given key, e.g,
+// 'lagoon-version'. Nested values can be fetched using dots.
+func (c Config) Get(key string) (string, error) {
+	m, err := c.toMap()
+	if err != nil {
+		return "", err
+	}
+	var val interface{} = m
+	for _, k := range strings.Split(key, ".") {
+		if val, err = child(val, k); err != nil {
+			return "", fmt.Errorf("unknown key: %s", key)
+		}
+	}
+	if s, ok := val.(string); ok {
+		return s, nil
+	}
+	out, err := yaml.Marshal(val)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// Set updates the value at the given key. The value is parsed as yaml, so
+// that numbers, booleans and lists end up with the right type.
+func (c *Config) Set(key string, value string) error {
+	m, err := c.toMap()
+	if err != nil {
+		return err
+	}
+
+	var parsed interface{}
+	if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+
+	// The keys missing from maps are added, e.g, for new entries or the
+	// fields omitted when empty; unknown fields are then rejected when
+	// decoding.
+	parts := strings.Split(key, ".")
+	if !isConfigKey(parts[0]) {
+		return fmt.Errorf("unknown key: %s", key)
+	}
+	var parent interface{} = m
+	for _, k := range parts[:len(parts)-1] {
+		next, err := child(parent, k)
+		if p, ok := parent.(map[string]interface{}); ok && (err != nil || next == nil) {
+			next = map[string]interface{}{}
+			p[k] = next
+		} else if err != nil {
+			return fmt.Errorf("unknown key: %s", key)
+		}
+		parent = next
+	}
+	last := parts[len(parts)-1]
+	if _, ok := parent.(map[string]interface{}); !ok {
+		if _, err := child(parent, last); err != nil {
+			return fmt.Errorf("unknown key: %s", key)
+		}
+	}
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[last] = parsed
+	case []interface{}:
+		i, _ := strconv.Atoi(last)
+		p[i] = parsed
+	}
+
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	updated := Config{}
+	if err := decode(data, &updated); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	*c = updated
+	return nil
+}
+
+// isConfigKey checks whether k is the yaml key of one of the Config's fields.
+func isConfigKey(k string) bool {
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ","); name == k {
+			return true
+		}
+	}
+	return false
+}
+
+// child returns the element at key k of a map or list.
+func child(node interface{}, k string) (interface{}, error) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if v, ok := n[k]; ok {
+			return v, nil
+		}
+	case []interface{}:
+		if i, err := strconv.Atoi(k); err == nil && i >= 0 && i < len(n) {
+			return n[i], nil
+		}
+	}
+	return nil, fmt.Errorf("key not found: %s", k)
+}
+
+func (c Config) toMap() (map[string]interface{}, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// decode strictly unmarshals yaml data into the config, failing on unknown
+// keys.
+func decode(data []byte, c *Config) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+)
+
+func TestApplyAllocatesPorts(t *testing.T) {
+	platform.ConfigDir = t.TempDir()
+	t.Cleanup(func() { platform.HostPorts = platform.DefaultPorts })
+	notChanged := func(string) bool { return false }
+	save := func(name string, cfg string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(platform.ConfigDir, name), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(platform.ConfigDir, name, "config.yaml"), []byte(cfg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first platform gets the default ports.
+	platform.Name = "rockpool"
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Apply(notChanged)
+	if platform.HostPorts != platform.DefaultPorts {
+		t.Errorf("expected the default ports, got %+v", platform.HostPorts)
+	}
+
+	// A platform saved without ports is considered to use the default ones.
+	save("rockpool", "domain: k3d.local\n")
+	save("rc", "ports: {http: 20180, https: 20143, ssh: 20122, amqp: 20172, dns: 20153, registry: 20111}\n")
+	platform.Name = "next"
+	c, err = Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Apply(notChanged)
+	if platform.HostPorts != platform.PortBlock(2) {
+		t.Errorf("expected the third block of ports, got %+v", platform.HostPorts)
+	}
+
+	// The saved ports are kept, the missing ones being allocated.
+	save("next", "ports: {ssh: 2222}\n")
+	c, err = Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Apply(notChanged)
+	if want := platform.PortBlock(2); platform.HostPorts.SSH != 2222 || platform.HostPorts.HTTP != want.HTTP {
+		t.Errorf("got ports %+v", platform.HostPorts)
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		key     string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{key: "domain", value: "rockpool.test", want: "rockpool.test"},
+		{key: "targets", value: "2", want: "2"},
+		// Maps omitted when empty, and their new entries.
+		{key: "kubeconfigs.target-1", value: "/tmp/kubeconfig", want: "/tmp/kubeconfig"},
+		{key: "topology.target-1.agents", value: "2", want: "2"},
+		{key: "mirrors", value: "[{upstream: quay.io}]", want: "- upstream: quay.io"},
+		{key: "domian", value: "rockpool.test", wantErr: true},
+		{key: "topology.target-1.agent", value: "2", wantErr: true},
+		{key: "ports.unknown", value: "2", wantErr: true},
+		{key: "targets", value: "two", wantErr: true},
+	}
+	for _, tt := range tests {
+		c := Default()
+		err := c.Set(tt.key, tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Set(%q, %q): expected an error", tt.key, tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Set(%q, %q): %s", tt.key, tt.value, err)
+			continue
+		}
+		if got, err := c.Get(tt.key); err != nil || got != tt.want {
+			t.Errorf("Get(%q) = %q, %v, want %q", tt.key, got, err, tt.want)
+		}
+	}
+}
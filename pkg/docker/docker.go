@@ -1,7 +1,9 @@
 package docker
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"regexp"
 	"strings"
 
@@ -10,34 +12,33 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func GetCurrentContext() Context {
-	out, err := command.ShellCommander("docker", "context", "ls", "--format", "json").Output()
+func GetCurrentContext(ctx context.Context) (Context, error) {
+	out, err := command.ShellCommander(command.Query(ctx), "docker", "context", "ls", "--format", "json").Output()
 	if err != nil {
-		log.WithError(command.GetMsgFromCommandError(err)).
-			Fatal("unable to get docker context list")
+		return Context{}, fmt.Errorf("unable to get docker context list: %w",
+			command.GetMsgFromCommandError(err))
 	}
 
 	var contexts []Context
 	err = json.Unmarshal(out, &contexts)
 	if err != nil {
-		log.WithError(err).
-			Fatal("unable to get parse docker contexts")
+		return Context{}, fmt.Errorf("unable to parse docker contexts: %w", err)
 	}
 
 	for _, c := range contexts {
 		if !c.Current {
 			continue
 		}
-		return c
+		return c, nil
 	}
-	return Context{}
+	return Context{}, nil
 }
 
-func ColimaGetProfiles() []ColimaProfile {
-	out, err := command.ShellCommander("colima", "ls", "--json").Output()
+func ColimaGetProfiles(ctx context.Context) ([]ColimaProfile, error) {
+	out, err := command.ShellCommander(command.Query(ctx), "colima", "ls", "--json").Output()
 	if err != nil {
-		log.WithError(command.GetMsgFromCommandError(err)).
-			Fatal("unable to get colima profiles")
+		return nil, fmt.Errorf("unable to get colima profiles: %w",
+			command.GetMsgFromCommandError(err))
 	}
 
 	var profiles []ColimaProfile
@@ -49,18 +50,27 @@ func ColimaGetProfiles() []ColimaProfile {
 		var profile ColimaProfile
 		err = json.Unmarshal([]byte(c), &profile)
 		if err != nil {
-			log.WithField("line", c).WithError(err).
-				Fatal("unable to parse colima profiles")
+			return nil, fmt.Errorf("unable to parse colima profile '%s': %w", c, err)
 		}
 		profiles = append(profiles, profile)
 	}
 	log.WithField("profiles", profiles).Debug()
-	return profiles
+	return profiles, nil
 }
 
+// GetVmIp returns the IP of the VM running docker, defaulting to 127.0.0.1
+// when it cannot be determined.
 func GetVmIp() string {
+	// The lookup is quick and used to build template values, so it is not
+	// tied to the caller's context.
+	ctx := context.Background()
+
 	// Check if colima is being used.
-	currentContext := GetCurrentContext()
+	currentContext, err := GetCurrentContext(ctx)
+	if err != nil {
+		log.WithError(err).Warn("unable to determine docker context")
+		return "127.0.0.1"
+	}
 
 	var colimaProfileName string
 	if currentContext.Description == "colima" {
@@ -75,7 +85,11 @@ func GetVmIp() string {
 	log.WithField("colimaProfileName", colimaProfileName).Debug()
 
 	if colimaProfileName != "" {
-		profiles := ColimaGetProfiles()
+		profiles, err := ColimaGetProfiles(ctx)
+		if err != nil {
+			log.WithError(err).Warn("unable to determine colima address")
+			return "127.0.0.1"
+		}
 		for _, p := range profiles {
 			if p.Name != colimaProfileName {
 				continue
@@ -86,51 +100,143 @@ func GetVmIp() string {
 	return "127.0.0.1"
 }
 
-func Exec(n string, cmdStr string) command.IShellCommand {
-	return command.ShellCommander("docker", "exec", n, "ash", "-c", cmdStr)
+func Exec(ctx context.Context, n string, cmdStr string) command.IShellCommand {
+	return ExecWith(ctx, n, "ash", cmdStr)
+}
+
+// ExecWith runs the command using the given shell, e.g, sh for the
+// containers which don't have ash.
+func ExecWith(ctx context.Context, n string, shell string, cmdStr string) command.IShellCommand {
+	return command.ShellCommander(ctx, "docker", "exec", n, shell, "-c", cmdStr)
 }
 
-func Stop(n string) ([]byte, error) {
+func Stop(ctx context.Context, n string) ([]byte, error) {
 	log.WithField("container", n).Debug("stopping container")
-	return command.ShellCommander("docker", "stop", n).Output()
+	return command.ShellCommander(ctx, "docker", "stop", n).Output()
 }
 
-func Start(n string) ([]byte, error) {
+func Start(ctx context.Context, n string) ([]byte, error) {
 	log.WithField("container", n).Debug("starting container")
-	return command.ShellCommander("docker", "start", n).Output()
+	return command.ShellCommander(ctx, "docker", "start", n).Output()
 }
 
-func Restart(n string) ([]byte, error) {
+func Restart(ctx context.Context, n string) ([]byte, error) {
 	log.WithField("container", n).Debug("restarting container")
-	return command.ShellCommander("docker", "restart", n).Output()
+	return command.ShellCommander(ctx, "docker", "restart", n).Output()
 }
 
-func Inspect(n string) []Container {
+func Inspect(ctx context.Context, n string) ([]Container, error) {
 	log.WithField("container", n).Debug("inspecting container")
-	cmd := command.ShellCommander("docker", "inspect", n)
+	cmd := command.ShellCommander(command.Query(ctx), "docker", "inspect", n)
 	out, err := cmd.Output()
 	if err != nil {
-		log.WithFields(log.Fields{
-			"container": n,
-			"out":       string(out),
-		}).WithError(command.GetMsgFromCommandError(err)).
-			Fatal("unable to get list of docker containers")
+		return nil, fmt.Errorf("unable to inspect container %s: %w", n,
+			command.GetMsgFromCommandError(err))
 	}
 	containers := []Container{}
 	err = json.Unmarshal(out, &containers)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"container": n,
-			"err":       err,
-		}).Fatal("unable to parse docker containers")
+		return nil, fmt.Errorf("unable to parse container %s: %w", n, err)
+	}
+	return containers, nil
+}
+
+// NetworkConnect connects the container to the network; it is a no-op if it
+// is already connected.
+func NetworkConnect(ctx context.Context, network string, n string) error {
+	log.WithFields(log.Fields{"network": network, "container": n}).
+		Debug("connecting container to network")
+	_, err := command.ShellCommander(ctx, "docker", "network", "connect", network, n).Output()
+	if err != nil && !strings.Contains(command.GetMsgFromCommandError(err).Error(), "already exists") {
+		return fmt.Errorf("unable to connect %s to network %s: %w", n, network,
+			command.GetMsgFromCommandError(err))
 	}
-	return containers
+	return nil
 }
 
-func Cp(src string, dest string) ([]byte, error) {
+func Cp(ctx context.Context, src string, dest string) ([]byte, error) {
 	log.WithFields(log.Fields{
 		"src":  src,
 		"dest": dest,
 	}).Debug("copying files")
-	return command.ShellCommander("docker", "cp", src, dest).Output()
+	return command.ShellCommander(ctx, "docker", "cp", src, dest).Output()
+}
+
+// Load loads the images of an archive created by `docker save`.
+func Load(ctx context.Context, archive string) error {
+	log.WithField("archive", archive).Debug("loading images")
+	if _, err := command.ShellCommander(command.WithoutTimeout(ctx), "docker", "load", "-i", archive).Output(); err != nil {
+		return fmt.Errorf("unable to load images from %s: %w", archive,
+			command.GetMsgFromCommandError(err))
+	}
+	return nil
+}
+
+// ImageExists checks whether the image is in the docker daemon.
+func ImageExists(ctx context.Context, image string) bool {
+	return command.ShellCommander(command.Query(ctx), "docker", "image", "inspect",
+		"--format", "{{.Id}}", image).Run() == nil
+}
+
+// Pull pulls the image into the docker daemon.
+func Pull(ctx context.Context, image string) error {
+	log.WithField("image", image).Debug("pulling image")
+	if _, err := command.ShellCommander(command.WithoutTimeout(ctx), "docker", "pull", image).Output(); err != nil {
+		return fmt.Errorf("unable to pull %s: %w", image,
+			command.GetMsgFromCommandError(err))
+	}
+	return nil
+}
+
+// PushAs pushes the image under another reference; the reference is removed
+// from the docker daemon afterwards.
+func PushAs(ctx context.Context, image string, ref string) error {
+	log.WithFields(log.Fields{"image": image, "ref": ref}).Debug("pushing image")
+	if _, err := command.ShellCommander(ctx, "docker", "tag", image, ref).Output(); err != nil {
+		return fmt.Errorf("unable to tag %s: %w", image,
+			command.GetMsgFromCommandError(err))
+	}
+	defer command.ShellCommander(ctx, "docker", "image", "rm", ref).Run()
+	if _, err := command.ShellCommander(command.WithoutTimeout(ctx), "docker", "push", ref).Output(); err != nil {
+		return fmt.Errorf("unable to push %s: %w", ref,
+			command.GetMsgFromCommandError(err))
+	}
+	return nil
+}
+
+// ParseImage splits an image reference into the host of its registry, its
+// repository and its tag or digest, e.g, ghcr.io, org/image and :v1. The
+// images without registry are from Docker Hub, whose official images are in
+// the library namespace.
+func ParseImage(image string) (host string, repository string, version string) {
+	host = "docker.io"
+	repository = image
+	if i := strings.Index(image, "/"); i > 0 {
+		first := image[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			host, repository = first, image[i+1:]
+		}
+	}
+	if i := strings.Index(repository, "@"); i > 0 {
+		repository, version = repository[:i], repository[i:]
+	} else if i := strings.LastIndex(repository, ":"); i > 0 {
+		repository, version = repository[:i], repository[i:]
+	} else {
+		version = ":latest"
+	}
+	if host == "docker.io" && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	return host, repository, version
+}
+
+// Save writes the images to an archive, which can be loaded using Load.
+func Save(ctx context.Context, archive string, images ...string) error {
+	log.WithFields(log.Fields{"archive": archive, "images": images}).Debug("saving images")
+	args := append([]string{"save", "-o", archive}, images...)
+	if _, err := command.ShellCommander(command.WithoutTimeout(ctx), "docker", args...).Output(); err != nil {
+		return fmt.Errorf("unable to save images to %s: %w", archive,
+			command.GetMsgFromCommandError(err))
+	}
+	return nil
 }
@@ -13,7 +13,10 @@ type ColimaProfile struct {
 }
 
 type Container struct {
-	Name            string
+	Name  string
+	State struct {
+		Running bool
+	}
 	NetworkSettings struct {
 		Networks map[string]struct {
 			IPAddress string
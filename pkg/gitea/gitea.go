@@ -2,6 +2,7 @@ package gitea
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,9 +16,9 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func ApiReq(method string, endpoint string, data []byte) (*http.Request, error) {
+func ApiReq(ctx context.Context, method string, endpoint string, data []byte) (*http.Request, error) {
 	url := fmt.Sprintf("http://gitea.lagoon.%s/api/v1/%s", platform.Hostname(), endpoint)
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(data))
 	if err != nil {
 		return nil, err
 	}
@@ -25,8 +26,8 @@ func ApiReq(method string, endpoint string, data []byte) (*http.Request, error)
 	return req, nil
 }
 
-func ApiCall(method string, endpoint string, token string, data []byte) (*http.Response, error) {
-	req, err := ApiReq(method, endpoint, data)
+func ApiCall(ctx context.Context, method string, endpoint string, token string, data []byte) (*http.Response, error) {
+	req, err := ApiReq(ctx, method, endpoint, data)
 	if err != nil {
 		return nil, err
 	}
@@ -42,12 +43,12 @@ func ApiCall(method string, endpoint string, token string, data []byte) (*http.R
 	return resp, nil
 }
 
-func TokenApiCall(method string, data []byte, delete bool) (*http.Response, error) {
+func TokenApiCall(ctx context.Context, method string, data []byte, delete bool) (*http.Response, error) {
 	endpoint := "users/rockpool/tokens"
 	if delete {
 		endpoint += "/" + string(data)
 	}
-	req, err := ApiReq(method, endpoint, data)
+	req, err := ApiReq(ctx, method, endpoint, data)
 	if err != nil {
 		return nil, err
 	}
@@ -63,7 +64,7 @@ func TokenApiCall(method string, data []byte, delete bool) (*http.Response, erro
 	return resp, nil
 }
 
-func HasToken() (string, error) {
+func HasToken(ctx context.Context) (string, error) {
 	var tokens []struct {
 		Id   json.Number `json:"id"`
 		Name string      `json:"name"`
@@ -77,15 +78,17 @@ func HasToken() (string, error) {
 	var err error
 	var dump []byte
 	for !done && retries > 0 {
-		resp, err = TokenApiCall("GET", nil, false)
+		resp, err = TokenApiCall(ctx, "GET", nil, false)
 		if err != nil {
-			return "", fmt.Errorf("error calling gitea token endpoint: %s", err)
+			return "", fmt.Errorf("error calling gitea token endpoint: %w", err)
 		}
 		dump, _ = httputil.DumpResponse(resp, true)
 
 		if err = json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
 			if err.Error() == "invalid character '<' looking for beginning of value" {
-				time.Sleep(5 * time.Second)
+				if err := platform.Sleep(ctx, 5*time.Second); err != nil {
+					return "", err
+				}
 				retries--
 				continue
 			}
@@ -104,18 +107,18 @@ func HasToken() (string, error) {
 	return "", nil
 }
 
-func CreateToken() (string, error) {
-	if id, err := HasToken(); err != nil {
+func CreateToken(ctx context.Context) (string, error) {
+	if id, err := HasToken(ctx); err != nil {
 		return "", fmt.Errorf("error checking gitea token: %s", err)
 	} else if id != "" {
-		_, err := TokenApiCall("DELETE", []byte(id), true)
+		_, err := TokenApiCall(ctx, "DELETE", []byte(id), true)
 		if err != nil {
 			return "", fmt.Errorf("error when deleting token: %s", err)
 		}
 	}
 
 	data, _ := json.Marshal(map[string]string{"name": "test"})
-	resp, err := TokenApiCall("POST", data, false)
+	resp, err := TokenApiCall(ctx, "POST", data, false)
 	if err != nil {
 		return "", err
 	}
@@ -135,8 +138,8 @@ func CreateToken() (string, error) {
 	return res.Token, nil
 }
 
-func HasTestRepo(token string) (bool, error) {
-	resp, err := ApiCall("GET", "user/repos", token, nil)
+func HasTestRepo(ctx context.Context, token string) (bool, error) {
+	resp, err := ApiCall(ctx, "GET", "user/repos", token, nil)
 	if err != nil {
 		return false, err
 	}
@@ -154,23 +157,24 @@ func HasTestRepo(token string) (bool, error) {
 	return false, nil
 }
 
-func CreateRepo() {
-	token, err := CreateToken()
+func CreateRepo(ctx context.Context) error {
+	token, err := CreateToken(ctx)
 	if err != nil {
-		log.WithError(err).Fatal("error creating gitea token")
+		return fmt.Errorf("error creating gitea token: %w", err)
 	}
 
-	if has, err := HasTestRepo(token); err != nil {
-		log.WithError(err).Fatal("error looking up gitea test repo")
+	if has, err := HasTestRepo(ctx, token); err != nil {
+		return fmt.Errorf("error looking up gitea test repo: %w", err)
 	} else if has {
 		log.Debug("gitea test repo already exists")
-		return
+		return nil
 	}
 
 	log.Info("creating gitea test repo")
 	data, _ := json.Marshal(map[string]string{"name": "test"})
-	_, err = ApiCall("POST", "user/repos", token, data)
+	_, err = ApiCall(ctx, "POST", "user/repos", token, data)
 	if err != nil {
-		log.WithError(err).Fatal("unable to create gitea test repo")
+		return fmt.Errorf("unable to create gitea test repo: %w", err)
 	}
+	return nil
 }
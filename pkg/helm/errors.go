@@ -0,0 +1,20 @@
+package helm
+
+import "fmt"
+
+// InstallFailedError is returned when a helm release could not be installed
+// or upgraded.
+type InstallFailedError struct {
+	ClusterName string
+	ReleaseName string
+	Err         error
+}
+
+func (e *InstallFailedError) Error() string {
+	return fmt.Sprintf("unable to install helm release %s on cluster %s: %s",
+		e.ReleaseName, e.ClusterName, e.Err)
+}
+
+func (e *InstallFailedError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,108 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// Fake stores the releases in memory and renders them without a cluster,
+// for testing; the charts are created on the fly unless found in Charts.
+type Fake struct {
+	// Charts are the charts to load, by reference, e.g, harbor/harbor.
+	Charts map[string]*chart.Chart
+	// InstallError fails the installs and upgrades when set.
+	InstallError error
+
+	mu     sync.Mutex
+	memory map[string]*driver.Memory
+	loaded []string
+}
+
+func NewFake() *Fake {
+	return &Fake{Charts: map[string]*chart.Chart{}, memory: map[string]*driver.Memory{}}
+}
+
+// Install makes the helm package use the fake, returning a function which
+// restores the previous behaviour.
+func (f *Fake) Install() func() {
+	prevConfiguration, prevLoadChart := NewConfiguration, LoadChart
+	NewConfiguration = f.configuration
+	LoadChart = f.loadChart
+	return func() {
+		NewConfiguration, LoadChart = prevConfiguration, prevLoadChart
+	}
+}
+
+// Loaded returns the references of the charts loaded so far.
+func (f *Fake) Loaded() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.loaded...)
+}
+
+func (f *Fake) configuration(cn string, ns string) (*action.Configuration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var mem *driver.Memory
+	if ns == "" {
+		mem = f.allNamespaces(cn)
+	} else if mem = f.memory[cn+"/"+ns]; mem == nil {
+		mem = driver.NewMemory()
+		mem.SetNamespace(ns)
+		f.memory[cn+"/"+ns] = mem
+	}
+	kc := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: io.Discard}}
+	kc.CreateError, kc.UpdateError = f.InstallError, f.InstallError
+	return &action.Configuration{
+		Releases:     storage.Init(mem),
+		KubeClient:   kc,
+		Capabilities: chartutil.DefaultCapabilities,
+		Log:          func(string, ...interface{}) {},
+	}, nil
+}
+
+// allNamespaces returns a snapshot of the releases of all the cluster's
+// namespaces.
+func (f *Fake) allNamespaces(cn string) *driver.Memory {
+	all := driver.NewMemory()
+	for k, mem := range f.memory {
+		if !strings.HasPrefix(k, cn+"/") {
+			continue
+		}
+		rels, _ := mem.List(func(*release.Release) bool { return true })
+		for _, r := range rels {
+			all.Create(fmt.Sprintf("sh.helm.release.v1.%s.v%d", r.Name, r.Version), r)
+		}
+	}
+	all.SetNamespace("")
+	return all
+}
+
+func (f *Fake) loadChart(ctx context.Context, r HelmRepo, ref string, version string) (*chart.Chart, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.loaded = append(f.loaded, ref)
+	if c, ok := f.Charts[ref]; ok {
+		return c, nil
+	}
+	if version == "" {
+		version = "0.1.0"
+	}
+	return &chart.Chart{Metadata: &chart.Metadata{
+		APIVersion: chart.APIVersionV2,
+		Name:       strings.TrimSuffix(path.Base(ref), ".tgz"),
+		Version:    version,
+	}}, nil
+}
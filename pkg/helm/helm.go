@@ -1,59 +1,89 @@
 package helm
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/salsadigitalauorg/rockpool/pkg/command"
 	"github.com/salsadigitalauorg/rockpool/pkg/kube"
 
 	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/action"
+	helmkube "helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
 )
 
 // List of Helm releases per cluster.
 var Releases sync.Map
 var UpgradeComponents []string
 
-func Exec(cn string, ns string, args ...string) command.IShellCommand {
-	cmd := command.ShellCommander("helm", "--kubeconfig", kube.KubeconfigPath(cn))
-	if ns != "" {
-		cmd.AddArgs("--namespace", ns)
+// MaxHistory is the number of revisions kept per release, as in the helm CLI.
+var MaxHistory = 10
+
+// NewConfiguration creates the helm configuration for a cluster's namespace;
+// an empty namespace gives access to the releases of all namespaces.
+var NewConfiguration = func(cn string, ns string) (*action.Configuration, error) {
+	logger := log.WithFields(log.Fields{"cluster": cn, "namespace": ns})
+	cfg := &action.Configuration{}
+	getter := helmkube.GetConfig(kube.KubeconfigPath(cn), "", ns)
+	if err := cfg.Init(getter, ns, "secret", logger.Debugf); err != nil {
+		return nil, fmt.Errorf("unable to create helm configuration for %s: %w", cn, err)
 	}
-	cmd.AddArgs(args...)
-	return cmd
+	return cfg, nil
 }
 
-func FetchInstalledReleases(cn string) {
-	logger := log.WithField("clusterName", cn)
-	out, err := Exec(cn, "", "list", "--all-namespaces", "--output", "json").Output()
+func FetchInstalledReleases(ctx context.Context, cn string) error {
+	cfg, err := NewConfiguration(cn, "")
 	if err != nil {
-		logger.WithField("out", string(out)).
-			WithError(command.GetMsgFromCommandError(err)).
-			Fatal("unable to get list of helm releases")
+		return err
 	}
-	releases := []HelmRelease{}
-	err = json.Unmarshal(out, &releases)
+	list := action.NewList(cfg)
+	list.AllNamespaces = true
+	rels, err := list.Run()
 	if err != nil {
-		logger.WithError(err).Fatal("unable to parse helm releases")
+		return fmt.Errorf("unable to get list of helm releases: %w", err)
+	}
+	releases := []HelmRelease{}
+	for _, r := range rels {
+		releases = append(releases, releaseFromSDK(r))
 	}
 	Releases.Store(cn, releases)
+	return nil
 }
 
-func GetReleases(key string) []HelmRelease {
-	logger := log.WithField("key", key)
+func GetReleases(key string) ([]HelmRelease, error) {
 	valueIfc, ok := Releases.Load(key)
 	if !ok {
-		logger.Panic("releases not found")
+		return nil, fmt.Errorf("releases not found for %s", key)
 	}
 	val, ok := valueIfc.([]HelmRelease)
 	if !ok {
-		logger.WithField("valueIfc", valueIfc).
-			Panic("unable to convert binpath to string")
+		return nil, fmt.Errorf("invalid releases stored for %s", key)
+	}
+	return val, nil
+}
+
+// UpgradeRequested checks whether the release is part of the components to
+// upgrade.
+func UpgradeRequested(releaseName string) bool {
+	for _, u := range UpgradeComponents {
+		if u == "all" || u == releaseName {
+			return true
+		}
 	}
-	return val
+	return false
 }
 
-func InstallOrUpgrade(cn string, ns string, releaseName string, chartName string, args []string) error {
+// InstallOrUpgrade installs the release from the chart if it is not installed
+// yet, or upgrades it if requested and either its chart version or its values
+// have changed. The args are those of `helm upgrade --install`, see
+// ParseArgs.
+func InstallOrUpgrade(ctx context.Context, cn string, ns string, releaseName string, repo HelmRepo, chartName string, args []string) (Status, error) {
 	logger := log.WithFields(log.Fields{
 		"clusterName": cn,
 		"namespace":   ns,
@@ -61,31 +91,104 @@ func InstallOrUpgrade(cn string, ns string, releaseName string, chartName string
 		"chartName":   chartName,
 		"args":        args,
 	})
-	upgrade := false
-	for _, u := range UpgradeComponents {
-		if u == "all" || u == releaseName {
-			upgrade = true
-			break
-		}
+	opts, err := ParseArgs(args)
+	if err != nil {
+		return Status{}, err
 	}
+
+	upgrade := UpgradeRequested(releaseName)
 	if !upgrade {
-		for _, r := range GetReleases(cn) {
+		releases, err := GetReleases(cn)
+		if err != nil {
+			return Status{}, err
+		}
+		for _, r := range releases {
 			if r.Name == releaseName {
 				logger.Debug("helm release is already installed")
-				return nil
+				return Status{Release: r, Action: ActionUnchanged}, nil
 			}
 		}
-	} else {
-		logger.Debug("upgrading")
 	}
 
-	// New install.
-	if !upgrade {
+	fail := func(err error) (Status, error) {
+		return Status{}, &InstallFailedError{ClusterName: cn, ReleaseName: releaseName, Err: err}
+	}
+	cfg, err := NewConfiguration(cn, ns)
+	if err != nil {
+		return fail(err)
+	}
+	vals, err := opts.Values.MergeValues(getters)
+	if err != nil {
+		return fail(fmt.Errorf("unable to read values: %w", err))
+	}
+	chrt, err := LoadChart(ctx, repo, chartName, opts.Version)
+	if err != nil {
+		return fail(err)
+	}
+
+	current, err := action.NewGet(cfg).Run(releaseName)
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return fail(err)
+	}
+
+	start := time.Now()
+	status := Status{}
+	var rel *release.Release
+	if current == nil {
 		logger.Debug("installing")
+		i := action.NewInstall(cfg)
+		i.ReleaseName = releaseName
+		i.Namespace = ns
+		i.CreateNamespace = opts.CreateNamespace
+		i.Wait = opts.Wait
+		i.Timeout = opts.Timeout
+		status.Action = ActionInstalled
+		rel, err = i.RunWithContext(ctx, chrt, vals)
+	} else {
+		status.Changes = DiffValues(current.Config, vals)
+		if len(status.Changes) == 0 && current.Info.Status == release.StatusDeployed &&
+			current.Chart.Metadata.Version == chrt.Metadata.Version {
+			logger.Debug("helm release is up to date")
+			return Status{Release: releaseFromSDK(current), Action: ActionUnchanged}, nil
+		}
+		logger.WithFields(log.Fields{
+			"chartVersion": current.Chart.Metadata.Version + " -> " + chrt.Metadata.Version,
+			"changes":      status.Changes,
+		}).Debug("upgrading")
+		u := action.NewUpgrade(cfg)
+		u.Namespace = ns
+		u.Install = true
+		u.Wait = opts.Wait
+		u.Timeout = opts.Timeout
+		u.MaxHistory = MaxHistory
+		status.Action = ActionUpgraded
+		rel, err = u.RunWithContext(ctx, releaseName, chrt, vals)
+	}
+	if command.Audit != nil {
+		command.Audit.Log(append([]string{"helm:" + status.Action, releaseName, chartName},
+			command.RedactArgs(args)...), "", start, nil, err)
+	}
+	if err != nil {
+		return fail(err)
 	}
+	status.Release = releaseFromSDK(rel)
+	return status, nil
+}
 
-	cmd := Exec(cn, ns, "upgrade", "--install", releaseName, chartName)
-	cmd.AddArgs(args...)
-	logger.WithField("command", cmd).Debug("running command for helm release")
-	return cmd.RunProgressive()
+// releaseFromSDK summarises a release as in `helm list`.
+func releaseFromSDK(r *release.Release) HelmRelease {
+	hr := HelmRelease{
+		Name:      r.Name,
+		Namespace: r.Namespace,
+		Revision:  r.Version,
+	}
+	if r.Info != nil {
+		hr.Updated = r.Info.LastDeployed.Time
+		hr.Status = r.Info.Status.String()
+	}
+	if r.Chart != nil && r.Chart.Metadata != nil {
+		hr.Chart = strings.Join([]string{r.Chart.Metadata.Name, r.Chart.Metadata.Version}, "-")
+		hr.AppVersion = r.Chart.Metadata.AppVersion
+	}
+	return hr
 }
@@ -0,0 +1,227 @@
+package helm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func setUp(t *testing.T, upgrade ...string) *Fake {
+	t.Helper()
+	f := NewFake()
+	t.Cleanup(f.Install())
+	UpgradeComponents = upgrade
+	t.Cleanup(func() {
+		Releases.Delete("rockpool-controller")
+		UpgradeComponents = nil
+	})
+	return f
+}
+
+func fetchReleases(t *testing.T) []HelmRelease {
+	t.Helper()
+	if err := FetchInstalledReleases(context.Background(), "rockpool-controller"); err != nil {
+		t.Fatal(err)
+	}
+	releases, _ := GetReleases("rockpool-controller")
+	return releases
+}
+
+func install(t *testing.T, version string, args ...string) (Status, error) {
+	t.Helper()
+	return InstallOrUpgrade(context.Background(), "rockpool-controller", "harbor",
+		"harbor", HelmRepo{Name: "harbor", Url: "https://helm.goharbor.io"}, "harbor/harbor",
+		append([]string{"--create-namespace", "--wait", "--version=" + version}, args...))
+}
+
+func TestInstallOrUpgrade(t *testing.T) {
+	f := setUp(t)
+	fetchReleases(t)
+	status, err := install(t, "1.5.6", "--set", "expose.tls.enabled=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Action != ActionInstalled || status.Release.Revision != 1 ||
+		status.Release.Status != "deployed" || status.Release.Chart != "harbor-1.5.6" {
+		t.Errorf("got status %+v", status)
+	}
+	if loaded := f.Loaded(); !reflect.DeepEqual(loaded, []string{"harbor/harbor"}) {
+		t.Errorf("loaded charts %q", loaded)
+	}
+
+	// Installed releases are left alone unless an upgrade is requested.
+	releases := fetchReleases(t)
+	if len(releases) != 1 || releases[0].Name != "harbor" || releases[0].Namespace != "harbor" {
+		t.Fatalf("got releases %+v", releases)
+	}
+	status, err = install(t, "1.6.0")
+	if err != nil || status.Action != ActionUnchanged || len(f.Loaded()) != 1 {
+		t.Errorf("got status %+v, %v", status, err)
+	}
+
+	UpgradeComponents = []string{"harbor"}
+	status, err = install(t, "1.5.6", "--set", "expose.tls.enabled=false")
+	if err != nil || status.Action != ActionUnchanged {
+		t.Errorf("expected no upgrade without changes, got %+v, %v", status, err)
+	}
+	status, err = install(t, "1.5.6", "--set", "expose.tls.enabled=true",
+		"--set", "externalURL=https://harbor.rockpool.k3d.local")
+	if err != nil || status.Action != ActionUpgraded || status.Release.Revision != 2 {
+		t.Fatalf("got status %+v, %v", status, err)
+	}
+	wantChanges := []ValueChange{
+		{Key: "expose.tls.enabled", Old: false, New: true},
+		{Key: "externalURL", New: "https://harbor.rockpool.k3d.local"},
+	}
+	if !reflect.DeepEqual(status.Changes, wantChanges) {
+		t.Errorf("got changes %v", status.Changes)
+	}
+	status, err = install(t, "1.6.0", "--set", "expose.tls.enabled=true",
+		"--set", "externalURL=https://harbor.rockpool.k3d.local")
+	if err != nil || status.Action != ActionUpgraded || len(status.Changes) != 0 ||
+		status.Release.Chart != "harbor-1.6.0" {
+		t.Errorf("expected an upgrade of the chart, got %+v, %v", status, err)
+	}
+}
+
+func TestInstallOrUpgradeFailure(t *testing.T) {
+	f := setUp(t)
+	f.InstallError = errors.New("timed out waiting for the condition")
+	fetchReleases(t)
+	_, err := install(t, "1.5.6")
+	var installErr *InstallFailedError
+	if !errors.As(err, &installErr) || installErr.ReleaseName != "harbor" {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestInstallOrUpgradeWithoutReleases(t *testing.T) {
+	f := setUp(t)
+	_, err := InstallOrUpgrade(context.Background(), "rockpool-unknown", "", "harbor",
+		HelmRepo{}, "harbor/harbor", nil)
+	if err == nil {
+		t.Error("expected an error when the releases have not been fetched")
+	}
+	if len(f.Loaded()) > 0 {
+		t.Errorf("unexpected charts loaded: %q", f.Loaded())
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	opts, err := ParseArgs([]string{"--create-namespace", "--wait", "--version=1.5.6",
+		"--timeout", "30m0s", "--set", "a=b", "--set=c=d", "-f", "values.yml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.CreateNamespace || !opts.Wait || opts.Version != "1.5.6" ||
+		opts.Timeout != 30*time.Minute ||
+		!reflect.DeepEqual(opts.Values.Values, []string{"a=b", "c=d"}) ||
+		!reflect.DeepEqual(opts.Values.ValueFiles, []string{"values.yml"}) {
+		t.Errorf("got options %+v", opts)
+	}
+	if opts, _ := ParseArgs(nil); opts.Timeout != DefaultTimeout {
+		t.Errorf("got timeout %s", opts.Timeout)
+	}
+	for _, args := range [][]string{{"--atomic"}, {"--version"}, {"harbor"}} {
+		if _, err := ParseArgs(args); err == nil {
+			t.Errorf("expected an error for %q", args)
+		}
+	}
+}
+
+func TestDiffValues(t *testing.T) {
+	old := map[string]interface{}{
+		"replicas": float64(1),
+		"persistence": map[string]interface{}{
+			"enabled": true,
+			"size":    "1Gi",
+		},
+		"hosts": []interface{}{"a"},
+	}
+	new := map[string]interface{}{
+		"replicas": int64(1),
+		"persistence": map[string]interface{}{
+			"enabled": false,
+		},
+		"hosts": []interface{}{"a", "b"},
+	}
+	got := fmt.Sprint(DiffValues(old, new))
+	want := "[~hosts: [a] -> [a b] ~persistence.enabled: true -> false -persistence.size: 1Gi]"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestLoadChartFromRepo(t *testing.T) {
+	t.Cleanup(ResetCache)
+	dir := t.TempDir()
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		c := &chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2,
+			Name: "mariadb", Version: version}}
+		if _, err := chartutil.Save(c, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx, err := repo.IndexDirectory(dir, "charts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.WriteFile(filepath.Join(dir, "index.yaml"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var indexRequests int32
+	files := http.FileServer(http.Dir(dir))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index.yaml":
+			atomic.AddInt32(&indexRequests, 1)
+		case "/charts/mariadb-1.0.0.tgz", "/charts/mariadb-1.1.0.tgz":
+			r.URL.Path = "/" + filepath.Base(r.URL.Path)
+		}
+		files.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	r := HelmRepo{Name: "nicholaswilde", Url: srv.URL + "/"}
+	ctx := context.Background()
+	for _, tt := range []struct{ version, want string }{
+		{"", "1.1.0"},
+		{"1.0.0", "1.0.0"},
+		{"~1.0", "1.0.0"},
+	} {
+		c, err := LoadChart(ctx, r, "nicholaswilde/mariadb", tt.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Metadata.Version != tt.want {
+			t.Errorf("got version %s for %q, want %s", c.Metadata.Version, tt.version, tt.want)
+		}
+	}
+	if indexRequests != 1 {
+		t.Errorf("index fetched %d times", indexRequests)
+	}
+	if _, err := LoadChart(ctx, r, "nicholaswilde/missing", ""); err == nil {
+		t.Error("expected an error for a missing chart")
+	}
+
+	c, err := LoadChart(ctx, HelmRepo{}, srv.URL+"/charts/mariadb-1.0.0.tgz", "")
+	if err != nil || c.Metadata.Version != "1.0.0" {
+		t.Errorf("got %v, %v loading from a URL", c, err)
+	}
+	if _, err := LoadChart(ctx, HelmRepo{}, filepath.Join(dir, "missing.tgz"), ""); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %v for a missing local chart", err)
+	}
+}
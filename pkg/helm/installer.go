@@ -1,16 +1,14 @@
 package helm
 
 import (
-	"github.com/salsadigitalauorg/rockpool/pkg/command"
+	"context"
+	"fmt"
+	"strings"
+
 	"github.com/salsadigitalauorg/rockpool/pkg/platform/templates"
 	log "github.com/sirupsen/logrus"
 )
 
-type HelmRepo struct {
-	Name string
-	Url  string
-}
-
 type Installer struct {
 	Stage              string
 	Info               string
@@ -22,13 +20,56 @@ type Installer struct {
 	Args               []string
 	ValuesTemplate     string
 	ValuesTemplateVars interface{}
+	// ValuesFile is the name of the rendered values file; it defaults to
+	// the name of the template.
+	ValuesFile string
+	DependsOn  []string
 }
 
 func (i Installer) GetStage() string {
 	return i.Stage
 }
 
-func (i Installer) Execute() bool {
+func (i Installer) GetClusterName() string {
+	return i.ClusterName
+}
+
+func (i Installer) GetName() string {
+	return "release:" + i.ReleaseName
+}
+
+func (i Installer) GetDependencies() []string {
+	return i.DependsOn
+}
+
+// Describe renders the values template, if any, and returns a summary of the
+// release that would be installed.
+func (i Installer) Describe() string {
+	desc := fmt.Sprintf("install or upgrade release %s from chart %s in namespace %s",
+		i.ReleaseName, i.Chart, i.Namespace)
+	if i.AddRepo.Url != "" {
+		desc += fmt.Sprintf(" (repo %s: %s)", i.AddRepo.Name, i.AddRepo.Url)
+	}
+	if i.ValuesTemplate != "" {
+		valuesFile, err := templates.Render(i.ValuesTemplate, i.ValuesTemplateVars, i.ValuesFile)
+		if err != nil {
+			valuesFile = fmt.Sprintf("render error: %s", err)
+		}
+		desc += fmt.Sprintf(", values: %s", valuesFile)
+	}
+	if len(i.Args) > 0 {
+		desc += fmt.Sprintf(", args: %s", strings.Join(i.Args, " "))
+	}
+	if UpgradeRequested(i.ReleaseName) {
+		desc += ", upgrade requested"
+	}
+	if i.Info != "" {
+		desc = i.Info + ": " + desc
+	}
+	return desc
+}
+
+func (i Installer) Execute(ctx context.Context) error {
 	logger := log.WithFields(log.Fields{
 		"stage":     i.Stage,
 		"cluster":   i.ClusterName,
@@ -40,28 +81,41 @@ func (i Installer) Execute() bool {
 		logger.Info(i.Info)
 	}
 
-	if i.AddRepo.Url != "" {
-		logger.WithField("addRepo", i.AddRepo)
-		err := Exec(i.ClusterName, "", "repo", "add", i.AddRepo.Name,
-			i.AddRepo.Url).Run()
-		if err != nil {
-			logger.WithError(command.GetMsgFromCommandError(err)).
-				Fatal("error adding helm repository")
-		}
+	args, err := i.args()
+	if err != nil {
+		return err
 	}
+	status, err := InstallOrUpgrade(ctx, i.ClusterName, i.Namespace, i.ReleaseName,
+		i.AddRepo, i.Chart, args)
+	if err != nil {
+		return err
+	}
+	logger = logger.WithFields(log.Fields{
+		"revision": status.Release.Revision,
+		"status":   status.Release.Status,
+	})
+	for _, c := range status.Changes {
+		logger.Debugf("value change: %s", c)
+	}
+	if status.Action == ActionUnchanged {
+		logger.Debug("helm release unchanged")
+		return nil
+	}
+	logger.WithField("changes", len(status.Changes)).Infof("helm release %s", status.Action)
+	return nil
+}
 
-	args := i.Args
+// args returns the arguments of the release, along with the rendered values
+// template if any.
+func (i Installer) args() ([]string, error) {
+	args := append([]string{}, i.Args...)
 	if i.ValuesTemplate != "" {
-		valuesFile, err := templates.Render(i.ValuesTemplate, i.ValuesTemplateVars, "")
+		valuesFile, err := templates.Render(i.ValuesTemplate, i.ValuesTemplateVars, i.ValuesFile)
 		if err != nil {
-			logger.WithError(err).Fatal("error rendering gitea values template")
+			return nil, fmt.Errorf("error rendering values template %s: %w",
+				i.ValuesTemplate, err)
 		}
 		args = append(args, "-f", valuesFile)
 	}
-
-	err := InstallOrUpgrade(i.ClusterName, i.Namespace, i.ReleaseName, i.Chart, args)
-	if err != nil {
-		logger.WithError(err).Fatal("unable to install helm chart")
-	}
-	return true
+	return args, nil
 }
@@ -0,0 +1,182 @@
+package helm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+// HelmRepo is the repository the charts prefixed with its name are fetched
+// from, e.g, harbor/harbor.
+type HelmRepo struct {
+	Name string
+	Url  string
+}
+
+// DownloadTimeout is the maximum duration of a repository index or chart
+// download.
+var DownloadTimeout = 5 * time.Minute
+
+var getters = getter.Providers{{
+	Schemes: []string{"http", "https"},
+	New:     getter.NewHTTPGetter,
+}}
+
+// cache holds the data downloaded from a URL for the lifetime of the process,
+// so that e.g, a repository shared by several releases and clusters is only
+// fetched once; failed downloads are retried on next use.
+type cache[T any] struct {
+	entries sync.Map
+}
+
+type cacheEntry[T any] struct {
+	sync.Mutex
+	value   T
+	fetched bool
+}
+
+func (c *cache[T]) get(url string, fetch func() (T, error)) (T, error) {
+	e, _ := c.entries.LoadOrStore(url, &cacheEntry[T]{})
+	entry := e.(*cacheEntry[T])
+	entry.Lock()
+	defer entry.Unlock()
+	if entry.fetched {
+		return entry.value, nil
+	}
+	v, err := fetch()
+	if err != nil {
+		return v, err
+	}
+	entry.value, entry.fetched = v, true
+	return v, nil
+}
+
+func (c *cache[T]) reset() {
+	c.entries.Range(func(k, _ interface{}) bool {
+		c.entries.Delete(k)
+		return true
+	})
+}
+
+// Repository indexes and chart archives, per URL.
+var indexes cache[*repo.IndexFile]
+var archives cache[[]byte]
+
+// ResetCache removes the downloaded repository indexes and charts.
+func ResetCache() {
+	indexes.reset()
+	archives.reset()
+}
+
+// LocalCharts are the paths of the chart archives used instead of fetching
+// the charts, by chart reference, e.g, harbor/harbor; they are set when
+// installing from a bundle.
+var LocalCharts = map[string]string{}
+
+// LoadChart loads a chart given as <repo>/<name> for the charts of the
+// repository, as the URL of an archive, or as a local path; version is a
+// semver constraint for the charts of a repository, the latest version being
+// used if empty.
+var LoadChart = func(ctx context.Context, r HelmRepo, ref string, version string) (*chart.Chart, error) {
+	if p, ok := LocalCharts[ref]; ok {
+		return loader.Load(p)
+	}
+	if !strings.HasPrefix(ref, "http://") && !strings.HasPrefix(ref, "https://") &&
+		(r.Url == "" || !strings.HasPrefix(ref, r.Name+"/")) {
+		return loader.Load(ref)
+	}
+	data, err := ChartArchive(r, ref, version)
+	if err != nil {
+		return nil, err
+	}
+	c, err := loader.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load chart %s: %w", ref, err)
+	}
+	return c, nil
+}
+
+// ChartArchive downloads the archive of a chart given as <repo>/<name> for
+// the charts of the repository, or as the URL of an archive.
+func ChartArchive(r HelmRepo, ref string, version string) ([]byte, error) {
+	url := ref
+	if r.Url != "" && strings.HasPrefix(ref, r.Name+"/") {
+		var err error
+		url, err = chartURL(r, strings.TrimPrefix(ref, r.Name+"/"), version)
+		if err != nil {
+			return nil, err
+		}
+	}
+	data, err := archives.get(url, func() ([]byte, error) {
+		return download(url)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch chart %s: %w", ref, err)
+	}
+	return data, nil
+}
+
+// RepoIndex returns the index of the repository, downloading it on first use.
+func RepoIndex(r HelmRepo) (*repo.IndexFile, error) {
+	return indexes.get(r.Url, func() (*repo.IndexFile, error) {
+		log.WithField("repo", r).Debug("fetching helm repository index")
+		data, err := download(strings.TrimSuffix(r.Url, "/") + "/index.yaml")
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch index of helm repository %s: %w", r.Name, err)
+		}
+		idx := repo.NewIndexFile()
+		if err := yaml.Unmarshal(data, idx); err != nil {
+			return nil, fmt.Errorf("unable to parse index of helm repository %s: %w", r.Name, err)
+		}
+		for name, versions := range idx.Entries {
+			valid := repo.ChartVersions{}
+			for _, v := range versions {
+				if v != nil && v.Metadata != nil {
+					valid = append(valid, v)
+				}
+			}
+			idx.Entries[name] = valid
+		}
+		idx.SortEntries()
+		return idx, nil
+	})
+}
+
+// chartURL returns the URL of the archive of the chart's version in the
+// repository.
+func chartURL(r HelmRepo, name string, version string) (string, error) {
+	idx, err := RepoIndex(r)
+	if err != nil {
+		return "", err
+	}
+	cv, err := idx.Get(name, version)
+	if err != nil {
+		return "", fmt.Errorf("chart %s/%s not found (version %q): %w", r.Name, name, version, err)
+	}
+	if len(cv.URLs) == 0 {
+		return "", fmt.Errorf("chart %s/%s %s has no download URL", r.Name, name, cv.Version)
+	}
+	return repo.ResolveReferenceURL(r.Url, cv.URLs[0])
+}
+
+func download(url string) ([]byte, error) {
+	g, err := getters.ByScheme(strings.SplitN(url, ":", 2)[0])
+	if err != nil {
+		return nil, err
+	}
+	buf, err := g.Get(url, getter.WithURL(url), getter.WithTimeout(DownloadTimeout))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
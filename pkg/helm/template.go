@@ -0,0 +1,58 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// Template renders the manifests of the release from its chart and values
+// without a cluster, as `helm template`, returning the chart's name and the
+// manifests, hooks included.
+func (i Installer) Template(ctx context.Context) (string, string, error) {
+	args, err := i.args()
+	if err != nil {
+		return "", "", err
+	}
+	opts, err := ParseArgs(args)
+	if err != nil {
+		return "", "", err
+	}
+	vals, err := opts.Values.MergeValues(getters)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to read values: %w", err)
+	}
+	chrt, err := LoadChart(ctx, i.AddRepo, i.Chart, opts.Version)
+	if err != nil {
+		return "", "", err
+	}
+
+	cfg := &action.Configuration{
+		Releases:     storage.Init(driver.NewMemory()),
+		KubeClient:   &kubefake.PrintingKubeClient{Out: io.Discard},
+		Capabilities: chartutil.DefaultCapabilities,
+		Log:          func(string, ...interface{}) {},
+	}
+	inst := action.NewInstall(cfg)
+	inst.ReleaseName = i.ReleaseName
+	inst.Namespace = i.Namespace
+	inst.DryRun = true
+	inst.ClientOnly = true
+	inst.Replace = true
+	rel, err := inst.RunWithContext(ctx, chrt, vals)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to render chart %s: %w", i.Chart, err)
+	}
+	manifests := []string{rel.Manifest}
+	for _, h := range rel.Hooks {
+		manifests = append(manifests, h.Manifest)
+	}
+	return chrt.Metadata.Name, strings.Join(manifests, "\n---\n"), nil
+}
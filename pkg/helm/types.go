@@ -1,11 +1,50 @@
 package helm
 
+import (
+	"fmt"
+	"time"
+)
+
 type HelmRelease struct {
-	Name       string `json:"name"`
-	Namespace  string `json:"namespace"`
-	Revision   string `json:"revision"`
-	Updated    string `json:"updated"`
-	Status     string `json:"status"`
-	Chart      string `json:"chart"`
-	AppVersion string `json:"app_version"`
+	Name       string    `json:"name"`
+	Namespace  string    `json:"namespace"`
+	Revision   int       `json:"revision"`
+	Updated    time.Time `json:"updated"`
+	Status     string    `json:"status"`
+	Chart      string    `json:"chart"`
+	AppVersion string    `json:"app_version"`
+}
+
+// The actions taken by InstallOrUpgrade.
+const (
+	ActionInstalled = "installed"
+	ActionUpgraded  = "upgraded"
+	ActionUnchanged = "unchanged"
+)
+
+// Status is the result of installing or upgrading a release.
+type Status struct {
+	Release HelmRelease
+	Action  string
+	// Changes holds the differences between the values of the previous
+	// revision and the upgrade.
+	Changes []ValueChange
+}
+
+// ValueChange is a value added, removed or changed by an upgrade, keyed by
+// its dotted path, e.g, controller.config.ssl-redirect.
+type ValueChange struct {
+	Key string
+	Old interface{}
+	New interface{}
+}
+
+func (c ValueChange) String() string {
+	switch {
+	case c.Old == nil:
+		return fmt.Sprintf("+%s: %v", c.Key, c.New)
+	case c.New == nil:
+		return fmt.Sprintf("-%s: %v", c.Key, c.Old)
+	}
+	return fmt.Sprintf("~%s: %v -> %v", c.Key, c.Old, c.New)
 }
@@ -0,0 +1,125 @@
+package helm
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/cli/values"
+)
+
+// Options holds the settings of an install or upgrade.
+type Options struct {
+	CreateNamespace bool
+	Wait            bool
+	Version         string
+	Timeout         time.Duration
+	Values          values.Options
+}
+
+// DefaultTimeout is the time to wait for the resources of a release to be
+// ready, as in the helm CLI.
+var DefaultTimeout = 5 * time.Minute
+
+// ParseArgs parses the flags of `helm upgrade --install` used by the
+// installers, e.g, --create-namespace, --wait, --version=1.5.6,
+// --timeout 30m0s, --set key=value or -f values.yml.
+func ParseArgs(args []string) (Options, error) {
+	opts := Options{Timeout: DefaultTimeout}
+	for i := 0; i < len(args); i++ {
+		flag, value, hasValue := strings.Cut(args[i], "=")
+		if !strings.HasPrefix(flag, "-") || flag == "--" {
+			return opts, fmt.Errorf("unexpected helm argument %q", args[i])
+		}
+		switch flag {
+		case "--create-namespace":
+			opts.CreateNamespace = true
+			continue
+		case "--wait":
+			opts.Wait = true
+			continue
+		}
+
+		if !hasValue {
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("missing value for helm flag %s", flag)
+			}
+			i++
+			value = args[i]
+		}
+		switch flag {
+		case "--version":
+			opts.Version = value
+		case "--timeout":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return opts, fmt.Errorf("invalid helm timeout %q: %w", value, err)
+			}
+			opts.Timeout = d
+		case "--set":
+			opts.Values.Values = append(opts.Values.Values, value)
+		case "--set-string":
+			opts.Values.StringValues = append(opts.Values.StringValues, value)
+		case "-f", "--values":
+			opts.Values.ValueFiles = append(opts.Values.ValueFiles, value)
+		default:
+			return opts, fmt.Errorf("unsupported helm flag %s", flag)
+		}
+	}
+	return opts, nil
+}
+
+// DiffValues returns the changes between the values of two revisions,
+// sorted by key; lists are compared as a whole.
+func DiffValues(old map[string]interface{}, new map[string]interface{}) []ValueChange {
+	oldFlat, newFlat := map[string]interface{}{}, map[string]interface{}{}
+	flatten("", normalize(old), oldFlat)
+	flatten("", normalize(new), newFlat)
+
+	changes := []ValueChange{}
+	for k, o := range oldFlat {
+		n, ok := newFlat[k]
+		if !ok {
+			changes = append(changes, ValueChange{Key: k, Old: o})
+		} else if !reflect.DeepEqual(o, n) {
+			changes = append(changes, ValueChange{Key: k, Old: o, New: n})
+		}
+	}
+	for k, n := range newFlat {
+		if _, ok := oldFlat[k]; !ok {
+			changes = append(changes, ValueChange{Key: k, New: n})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// normalize converts the values as stored in a release, so that e.g, the
+// numbers given with --set compare equal to those of the previous revision.
+func normalize(m map[string]interface{}) map[string]interface{} {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return m
+	}
+	normalized := map[string]interface{}{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return m
+	}
+	return normalized
+}
+
+func flatten(prefix string, m map[string]interface{}, flat map[string]interface{}) {
+	for k, v := range m {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		if sub, ok := v.(map[string]interface{}); ok && len(sub) > 0 {
+			flatten(k, sub, flat)
+			continue
+		}
+		flat[k] = v
+	}
+}
@@ -1,10 +1,11 @@
 package interceptor
 
 import (
-	"context"
 	"net/http"
+	"strconv"
 
 	"github.com/salsadigitalauorg/rockpool/pkg/docker"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
 )
 
 // Interceptor creates an HTTP interceptor which allows us to modify requests.
@@ -18,8 +19,11 @@ func New() Interceptor {
 }
 
 func (Interceptor) modifyRequest(r *http.Request) *http.Request {
-	req := r.Clone(context.Background())
+	req := r.Clone(r.Context())
 	req.URL.Host = docker.GetVmIp()
+	if platform.HostPorts.HTTP != 80 {
+		req.URL.Host += ":" + strconv.Itoa(platform.HostPorts.HTTP)
+	}
 	return req
 }
 
@@ -0,0 +1,13 @@
+package k3d
+
+import "fmt"
+
+// ClusterNotFoundError is returned when an operation requires a cluster
+// which does not exist.
+type ClusterNotFoundError struct {
+	Name string
+}
+
+func (e *ClusterNotFoundError) Error() string {
+	return fmt.Sprintf("cluster %s not found", e.Name)
+}
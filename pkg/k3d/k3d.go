@@ -1,172 +1,355 @@
 package k3d
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/salsadigitalauorg/rockpool/pkg/cluster"
 	"github.com/salsadigitalauorg/rockpool/pkg/command"
 	"github.com/salsadigitalauorg/rockpool/pkg/docker"
+	"github.com/salsadigitalauorg/rockpool/pkg/kube"
 	"github.com/salsadigitalauorg/rockpool/pkg/platform"
 	"github.com/salsadigitalauorg/rockpool/pkg/platform/templates"
 
 	log "github.com/sirupsen/logrus"
 )
 
-var registryName = "rockpool-registry"
-var registryNameFull = "k3d-" + registryName
-
 var registries []Registry
 var Reg Registry
 
+// Clusters holds the platform's clusters, as last fetched; it is guarded by
+// clustersMu since clusters are started and stopped concurrently.
 var Clusters ClusterList
+var clustersMu sync.RWMutex
+
+// K3sImage is the image of the cluster nodes, built for each supported
+// Kubernetes version by docker-bake.hcl.
+var K3sImage = "ghcr.io/salsadigitalauorg/rockpool/k3s"
+
+// DefaultK3sVersion is the tag of K3sImage used when none is given.
+var DefaultK3sVersion = "latest"
+
+// K3sVersion is the tag of K3sImage the clusters are created with, e.g,
+// v1.25 or v1.25.6-k3s1.
+var K3sVersion = DefaultK3sVersion
+
+// K3sVersions overrides K3sVersion per cluster, by cluster name.
+var K3sVersions = map[string]string{}
+
+var k3sVersionRegex = regexp.MustCompile(`^(latest|v1\.\d+(\.\d+-k3s\d+)?)$`)
+
+// ValidateK3sVersion checks that v is a tag of K3sImage: latest, a minor
+// version such as v1.23, or a full k3s version such as v1.23.16-k3s1.
+func ValidateK3sVersion(v string) error {
+	if !k3sVersionRegex.MatchString(v) {
+		return fmt.Errorf("invalid k3s version %q, expected latest, a minor version such as v1.25 or a k3s version such as v1.25.6-k3s1", v)
+	}
+	return nil
+}
+
+// ValidateK3sVersions checks K3sVersion and the per-cluster overrides.
+func ValidateK3sVersions() error {
+	if err := ValidateK3sVersion(K3sVersion); err != nil {
+		return err
+	}
+	for cn, v := range K3sVersions {
+		if err := ValidateK3sVersion(v); err != nil {
+			return fmt.Errorf("%s: %w", cn, err)
+		}
+	}
+	return nil
+}
+
+// ClusterK3sVersion returns the k3s version the cluster is created with.
+func ClusterK3sVersion(cn string) string {
+	if v, ok := K3sVersions[cn]; ok && v != "" {
+		return v
+	}
+	return K3sVersion
+}
+
+// registryConfigFile is the path of the config in the registry containers.
+const registryConfigFile = "/etc/docker/registry/config.yml"
+
+// registryImage is the image of the registries created by k3d.
+const registryImage = "registry:2"
+
+// registryName returns the name of the platform's registry, as given to k3d.
+func registryName() string {
+	return platform.Name + "-registry"
+}
+
+// RegistryName returns the full name of the registry container.
+func RegistryName() string {
+	return "k3d-" + registryName()
+}
 
-func RegistryList() {
+func RegistryList(ctx context.Context) error {
 	log.Debug("fetching registry list")
 	res, err := command.
-		ShellCommander("k3d", "registry", "list", "-o", "json").
+		ShellCommander(command.Query(ctx), "k3d", "registry", "list", "-o", "json").
 		Output()
 	if err != nil {
-		log.WithError(command.GetMsgFromCommandError(err)).
-			Fatal("unable to get registry list")
+		return fmt.Errorf("unable to get registry list: %w",
+			command.GetMsgFromCommandError(err))
 	}
 
 	err = json.Unmarshal(res, &registries)
 	if err != nil {
-		log.WithError(err).Fatal("unable to parse registry list")
+		return fmt.Errorf("unable to parse registry list: %w", err)
 	}
+	return nil
 }
 
-func RegistryGet() {
-	RegistryList()
+func RegistryGet(ctx context.Context) error {
+	if err := RegistryList(ctx); err != nil {
+		return err
+	}
 	for _, reg := range registries {
-		if reg.Name == registryNameFull {
+		if reg.Name == RegistryName() {
 			Reg = reg
 			break
 		}
 	}
+	return nil
+}
+
+// RegistryNames returns the names of the platform's registry containers: the
+// main one, which holds the preloaded images, and a proxy registry per mirror.
+func RegistryNames() []string {
+	names := []string{RegistryName()}
+	for _, m := range mirrored() {
+		names = append(names, m.RegistryName())
+	}
+	return names
+}
+
+// registryExists checks whether the registry container is in the list
+// fetched by RegistryList.
+func registryExists(name string) bool {
+	for _, reg := range registries {
+		if reg.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
-func RegistryCreate() {
-	logger := log.WithField("registry", registryNameFull)
+// RegistryCreate creates the platform's main registry and the proxy
+// registries of the mirrors.
+func RegistryCreate(ctx context.Context) error {
+	if err := RegistryList(ctx); err != nil {
+		return err
+	}
+	if err := registryCreate(ctx, registryName(), ""); err != nil {
+		return err
+	}
+	for _, m := range mirrored() {
+		if err := registryCreate(ctx, m.registryName(), m.Remote()); err != nil {
+			return err
+		}
+	}
+	return RegistryGet(ctx)
+}
+
+// registryCreate creates a registry, configured as a proxy of the remote
+// unless it is empty. The main registry is published on the platform's
+// registry port; the proxy registries are only reachable from the clusters.
+func registryCreate(ctx context.Context, name string, remote string) error {
+	container := "k3d-" + name
+	logger := log.WithFields(log.Fields{"registry": container, "remote": remote})
 	logger.Info("creating registry")
 
-	RegistryGet()
-	if Reg.Name == registryNameFull {
+	if registryExists(container) {
 		logger.Debug("registry container exists")
-		return
+		return nil
 	}
 
-	err := command.ShellCommander("k3d", "registry", "create",
-		registryName, "--port", "5111").Run()
-	if err != nil {
-		logger.WithError(command.GetMsgFromCommandError(err)).
-			Fatal("unable to create registry")
+	cmd := command.ShellCommander(ctx, "k3d", "registry", "create", name)
+	if remote == "" {
+		cmd.AddArgs("--port", strconv.Itoa(platform.HostPorts.Registry))
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("unable to create registry %s: %w", name,
+			command.GetMsgFromCommandError(err))
+	}
+	if remote == "" {
+		return nil
 	}
 
 	// Configure registry to enable proxy.
-	regCfgFile := "/etc/docker/registry/config.yml"
-	proxyLine := "proxy:\n  remoteurl: https://registry-1.docker.io"
+	regCfgFile := registryConfigFile
+	proxyLine := "proxy:\n  remoteurl: " + remote
 	proxyLineCmdStr := fmt.Sprintf("echo '%s' >> "+regCfgFile, proxyLine)
 
 	var registryConfig []byte
+	var err error
 	done := false
 	retries := 12
 	for !done && retries > 0 {
-		registryConfig, err = docker.Exec(registryNameFull, "cat "+regCfgFile).Output()
+		registryConfig, err = docker.Exec(ctx, container, "cat "+regCfgFile).Output()
 		if err != nil {
 			logger.WithError(command.GetMsgFromCommandError(err)).
 				Warn("unable to find registry container")
-			time.Sleep(5 * time.Second)
+			if err := platform.Sleep(ctx, 5*time.Second); err != nil {
+				return err
+			}
 			retries--
 			continue
 		}
 		done = true
 	}
 	if err != nil {
-		logger.WithError(command.GetMsgFromCommandError(err)).
-			Fatal("unable to find registry container")
+		return fmt.Errorf("unable to find registry container: %w",
+			command.GetMsgFromCommandError(err))
 	}
 
 	if !strings.Contains(string(registryConfig), proxyLine) {
 		logger.WithField("proxyLine", proxyLine).
 			Debug("adding registry proxy config")
-		err := docker.Exec(registryNameFull, proxyLineCmdStr).Run()
+		err := docker.Exec(ctx, container, proxyLineCmdStr).Run()
 		if err != nil {
-			logger.WithField("proxyLine", proxyLine).
-				WithError(command.GetMsgFromCommandError(err)).
-				Fatal("error adding registry proxy config")
+			return fmt.Errorf("error adding registry proxy config: %w",
+				command.GetMsgFromCommandError(err))
+		}
+		if _, err := docker.Restart(ctx, container); err != nil {
+			return fmt.Errorf("error restarting registry: %w",
+				command.GetMsgFromCommandError(err))
 		}
-		docker.Restart(registryNameFull)
 	}
+	return nil
 }
 
-func RegistryRenderConfig() {
-	_, err := templates.Render("registries.yaml", nil, "")
-	if err != nil {
-		panic(err)
-	}
+// RegistryRenderConfig renders the registries.yaml of the k3d clusters,
+// pointing each mirrored upstream to the main registry, then to its proxy
+// registry.
+func RegistryRenderConfig() error {
+	if _, err := templates.Render("registries.yaml", map[string]interface{}{
+		"Registry": RegistryName(),
+		"Mirrors":  RegistryMirrors(),
+	}, ""); err != nil {
+		return fmt.Errorf("unable to render registries config: %w", err)
+	}
+	return nil
 }
 
-func RegistryStop() {
-	RegistryGet()
-	if Reg.Name != registryNameFull {
-		return
+// RegistryCheckPush ensures the main registry is running and accepts pushes;
+// the registries created by earlier versions mirror Docker Hub, which makes
+// them read-only, and have to be recreated.
+func RegistryCheckPush(ctx context.Context) error {
+	if err := RegistryGet(ctx); err != nil {
+		return err
+	}
+	if Reg.Name != RegistryName() || !Reg.State.Running {
+		return fmt.Errorf("registry %s is not running", RegistryName())
+	}
+	cfg, err := docker.Exec(ctx, RegistryName(), "cat "+registryConfigFile).Output()
+	if err != nil {
+		return fmt.Errorf("unable to read registry config: %w",
+			command.GetMsgFromCommandError(err))
 	}
-	logger := log.WithField("registry", registryNameFull)
-	logger.Info("stopping registry")
+	if strings.Contains(string(cfg), "proxy:") {
+		return fmt.Errorf("registry %s mirrors Docker Hub and doesn't accept pushes; "+
+			"it has to be deleted and recreated", RegistryName())
+	}
+	return nil
+}
 
-	_, err := docker.Stop(Reg.Name)
+// RegistryPush pushes an image of the docker daemon to the main registry,
+// under the image's repository, so that the clusters pull it from there
+// rather than from its upstream.
+func RegistryPush(ctx context.Context, image string) error {
+	ref, err := RegistryRef(image)
 	if err != nil {
-		logger.WithError(command.GetMsgFromCommandError(err)).
-			Fatal("error stopping registry")
+		return err
 	}
+	return docker.PushAs(ctx, image, ref)
 }
 
-func RegistryStart() {
-	logger := log.WithField("registry", registryNameFull)
-	logger.Info("starting registry")
+// RegistryRef returns the reference an image is pushed to in the main
+// registry. Since the main registry is the first endpoint of every mirrored
+// upstream, the host of the image's registry is not part of it: images with
+// the same repository and tag from different registries collide.
+func RegistryRef(image string) (string, error) {
+	_, repository, version := docker.ParseImage(image)
+	if strings.HasPrefix(version, "@") {
+		return "", fmt.Errorf("unable to push %s: images referenced by digest can't be retagged", image)
+	}
+	return fmt.Sprintf("localhost:%d/%s%s", platform.HostPorts.Registry, repository, version), nil
+}
 
-	_, err := docker.Start(registryNameFull)
-	if err != nil {
-		logger.WithError(command.GetMsgFromCommandError(err)).
-			Fatal("error starting registry")
+func RegistryStop(ctx context.Context) error {
+	if err := RegistryList(ctx); err != nil {
+		return err
 	}
+	for _, name := range RegistryNames() {
+		if !registryExists(name) {
+			continue
+		}
+		log.WithField("registry", name).Info("stopping registry")
+		if _, err := docker.Stop(ctx, name); err != nil {
+			return fmt.Errorf("error stopping registry %s: %w", name,
+				command.GetMsgFromCommandError(err))
+		}
+	}
+	return nil
 }
 
-func RegistryDelete() {
-	RegistryGet()
-	if Reg.Name != registryNameFull {
-		return
+func RegistryStart(ctx context.Context) error {
+	for _, name := range RegistryNames() {
+		log.WithField("registry", name).Info("starting registry")
+		if _, err := docker.Start(ctx, name); err != nil {
+			return fmt.Errorf("error starting registry %s: %w", name,
+				command.GetMsgFromCommandError(err))
+		}
 	}
-	logger := log.WithField("registry", registryNameFull)
-	logger.Info("deleting registry")
+	return nil
+}
 
-	err := command.ShellCommander("k3d", "registry", "delete", Reg.Name).Run()
-	if err != nil {
-		log.WithError(command.GetMsgFromCommandError(err)).
-			Fatal("unable to delete registry")
+func RegistryDelete(ctx context.Context) error {
+	if err := RegistryList(ctx); err != nil {
+		return err
+	}
+	for _, name := range RegistryNames() {
+		if !registryExists(name) {
+			continue
+		}
+		log.WithField("registry", name).Info("deleting registry")
+		err := command.ShellCommander(ctx, "k3d", "registry", "delete", name).Run()
+		if err != nil {
+			return fmt.Errorf("unable to delete registry %s: %w", name,
+				command.GetMsgFromCommandError(err))
+		}
 	}
+	return nil
 }
 
-func ClusterFetchAll() ClusterList {
+func ClusterFetchAll(ctx context.Context) (ClusterList, error) {
 	var cl ClusterList
-	res, err := command.ShellCommander("k3d", "cluster", "list", "-o", "json").Output()
+	res, err := command.ShellCommander(command.Query(ctx), "k3d", "cluster", "list", "-o", "json").Output()
 	log.Debug("cluster list: ", string(res))
 	if err != nil {
-		log.WithError(command.GetMsgFromCommandError(err)).
-			Fatal("unable to get cluster list")
+		return nil, fmt.Errorf("unable to get cluster list: %w",
+			command.GetMsgFromCommandError(err))
 	}
 
 	err = json.Unmarshal(res, &cl)
 	if err != nil {
-		log.WithError(err).Fatal("unable to parse cluster list")
+		return nil, fmt.Errorf("unable to parse cluster list: %w", err)
 	}
-	return cl
+	return cl, nil
 }
 
 func ClusterExists(clusterName string) (bool, Cluster) {
+	clustersMu.RLock()
+	defer clustersMu.RUnlock()
 	for _, c := range Clusters {
 		if c.Name == clusterName {
 			return true, c
@@ -175,22 +358,32 @@ func ClusterExists(clusterName string) (bool, Cluster) {
 	return false, Cluster{}
 }
 
-func ClusterFetch() {
+func ClusterFetch(ctx context.Context) error {
 	log.Debug("fetching clusters")
-	for _, c := range ClusterFetchAll() {
-		if !strings.HasPrefix(c.Name, platform.Name) {
-			continue
-		}
-		// Skip if already present.
-		if exists, _ := ClusterExists(c.Name); exists {
-			continue
+	all, err := ClusterFetchAll(ctx)
+	if err != nil {
+		return err
+	}
+	setClusters(all)
+	return nil
+}
+
+// setClusters replaces Clusters with the platform's clusters among all.
+func setClusters(all ClusterList) {
+	clusters := ClusterList{}
+	for _, c := range all {
+		if slices.Contains(platform.ClusterNames(), c.Name) {
+			clusters = append(clusters, c)
 		}
-		Clusters = append(Clusters, c)
 	}
+	clustersMu.Lock()
+	defer clustersMu.Unlock()
+	Clusters = clusters
 }
 
 func ClusterIsRunning(clusterName string) bool {
-	ClusterFetch()
+	clustersMu.RLock()
+	defer clustersMu.RUnlock()
 	for _, c := range Clusters {
 		if c.Name != clusterName {
 			continue
@@ -200,151 +393,298 @@ func ClusterIsRunning(clusterName string) bool {
 	return false
 }
 
-func ClusterCreate(cn string, isController bool) {
+func ClusterCreate(ctx context.Context, cn string, isController bool) error {
 	logger := log.WithFields(log.Fields{
 		"clusterName":  cn,
 		"isController": isController,
 	})
 
-	ClusterFetch()
+	if err := ClusterFetch(ctx); err != nil {
+		return err
+	}
 	if exists, _ := ClusterExists(cn); exists && ClusterIsRunning(cn) {
 		logger.Debug("cluster already exists and is running")
-		return
+		return nil
 	} else if exists {
 		logger.Info("cluster exists, but is stopped; starting now")
-		ClusterStart(cn)
-		return
+		if err := ClusterStart(ctx, cn); err != nil {
+			return err
+		}
+		return ClusterFetch(ctx)
 	}
 
-	k3sArgs := []string{"--k3s-arg", "--disable=traefik@server:0"}
+	version := ClusterK3sVersion(cn)
+	if err := ValidateK3sVersion(version); err != nil {
+		return err
+	}
+	logger = logger.WithField("k3sVersion", version)
+
+	topology := cluster.TopologyFor(cn)
+	if err := topology.Validate(); err != nil {
+		return err
+	}
+	logger = logger.WithFields(log.Fields{
+		"servers": topology.Servers,
+		"agents":  topology.Agents,
+	})
+
+	k3sArgs := []string{"--k3s-arg", "--disable=traefik@server:*"}
 	cmdArgs := []string{
 		"cluster", "create", "--kubeconfig-update-default=false",
-		"--image=ghcr.io/salsadigitalauorg/rockpool/k3s:latest",
-		"--agents", "1", "--network", "k3d-rockpool",
-		"--registry-use", registryName + ":5000",
-		"--registry-config", fmt.Sprintf("%s/registries.yaml", templates.RenderedPath(false)),
+		"--image=" + K3sImage + ":" + version,
+		"--servers", strconv.Itoa(topology.Servers),
+		"--agents", strconv.Itoa(topology.Agents), "--network", platform.Network(),
+		"--registry-config", fmt.Sprintf("%s/registries.yaml", templates.RenderedPath(true)),
+		"--registry-use", registryName() + ":5000",
+	}
+	for _, m := range mirrored() {
+		cmdArgs = append(cmdArgs, "--registry-use", m.registryName()+":5000")
+	}
+	if topology.Memory != "" {
+		cmdArgs = append(cmdArgs, "--servers-memory", topology.Memory,
+			"--agents-memory", topology.Memory)
+	}
+	for _, l := range topology.Labels {
+		cmdArgs = append(cmdArgs, "--k3s-node-label", l+"@agent:*")
+	}
+	for _, t := range topology.Taints {
+		k3sArgs = append(k3sArgs, "--k3s-arg", "--node-taint="+t+"@agent:*")
 	}
 
 	if isController {
-		cmdArgs = append(cmdArgs,
-			"--port", "80:80@loadbalancer",
-			"--port", "443:443@loadbalancer",
-			"--port", "2022:22@loadbalancer",
-			// Required for cross-cluster amqp.
-			"--port", "5672:5672@loadbalancer",
-			"--port", "6153:6153/udp@loadbalancer",
-			"--port", "6153:6153/tcp@loadbalancer",
-		)
+		// The amqp port is required for cross-cluster amqp.
+		for _, p := range platform.HostPorts.Published() {
+			port := fmt.Sprintf("%d:%d", p.Host, p.Container)
+			if p.Protocol != "tcp" {
+				port += "/" + p.Protocol
+			}
+			cmdArgs = append(cmdArgs, "--port", port+"@loadbalancer")
+		}
 	} else { // Target cluster exposed ports.
 		cmdArgs = append(cmdArgs,
 			// Expose arbitrary ports for ingress-nginx.
 			"--port", "80@loadbalancer",
 			"--port", "443@loadbalancer",
 		)
+		// Mount the target's host directories in all its nodes, so that
+		// the pods using their volumes can be scheduled anywhere.
+		nodes := "server:*"
+		if topology.Agents > 0 {
+			nodes += ";agent:*"
+		}
+		target, _ := platform.TargetFor(cn)
+		for _, m := range target.Mounts {
+			cmdArgs = append(cmdArgs, "--volume", m.HostPath+":"+m.NodePath()+"@"+nodes)
+		}
 	}
 
 	cmdArgs = append(cmdArgs, k3sArgs...)
 	cmdArgs = append(cmdArgs, cn)
-	cmd := command.ShellCommander("k3d", cmdArgs...)
+	cmd := command.ShellCommander(ctx, "k3d", cmdArgs...)
 
 	logger.WithField("command", cmd).Info("creating cluster")
 	err := cmd.RunProgressive()
 	if err != nil {
-		logger.WithError(err).Fatal("unable to create cluster")
+		return fmt.Errorf("unable to create cluster %s: %w", cn, err)
 	}
-	ClusterFetch()
+	return ClusterFetch(ctx)
 }
 
-func ClusterStart(cn string) {
+func ClusterStart(ctx context.Context, cn string) error {
 	logger := log.WithField("clusterName", cn)
 	if exists, _ := ClusterExists(cn); !exists {
-		logger.Info("cluster does not exist")
-		return
+		return &ClusterNotFoundError{Name: cn}
 	}
 	logger.Info("starting cluster")
-	err := command.ShellCommander("k3d", "cluster", "start", cn).RunProgressive()
+	err := command.ShellCommander(ctx, "k3d", "cluster", "start", cn).RunProgressive()
 	if err != nil {
-		logger.WithError(err).Fatal("unable to start cluster")
+		return fmt.Errorf("unable to start cluster %s: %w", cn, err)
 	}
-	ClusterFetch()
 	logger.Info("started cluster")
+	return nil
 }
 
-func ClusterStop(cn string) {
+func ClusterStop(ctx context.Context, cn string) error {
 	logger := log.WithField("clusterName", cn)
 	if exists, _ := ClusterExists(cn); !exists {
-		logger.Info("cluster does not exist")
-		return
+		return &ClusterNotFoundError{Name: cn}
 	}
 	logger.Info("stopping cluster")
-	err := command.ShellCommander("k3d", "cluster", "stop", cn).RunProgressive()
+	err := command.ShellCommander(ctx, "k3d", "cluster", "stop", cn).RunProgressive()
 	if err != nil {
-		logger.WithError(err).Fatal("unable to stop cluster")
+		return fmt.Errorf("unable to stop cluster %s: %w", cn, err)
 	}
-	ClusterFetch()
 	logger.Info("stopped cluster")
+	return nil
 }
 
-func ClusterRestart(cn string) {
-	ClusterStop(cn)
-	ClusterStart(cn)
+func ClusterRestart(ctx context.Context, cn string) error {
+	if err := ClusterStop(ctx, cn); err != nil {
+		return err
+	}
+	return ClusterStart(ctx, cn)
 }
 
-func ClusterDelete(cn string) {
+// ClusterDelete stops and deletes the cluster; it is a no-op if the cluster
+// does not exist.
+func ClusterDelete(ctx context.Context, cn string) error {
 	logger := log.WithField("clusterName", cn)
-	defer platform.WgDone()
 	if exists, _ := ClusterExists(cn); !exists {
-		return
+		return nil
+	}
+	if err := ClusterStop(ctx, cn); err != nil {
+		return err
 	}
-	ClusterStop(cn)
 	logger.Info("deleting cluster")
-	_, err := command.ShellCommander("k3d", "cluster", "delete", cn).Output()
+	_, err := command.ShellCommander(ctx, "k3d", "cluster", "delete", cn).Output()
 	if err != nil {
-		logger.WithError(command.GetMsgFromCommandError(err)).
-			Fatal("unable to delete cluster")
+		return fmt.Errorf("unable to delete cluster %s: %w", cn,
+			command.GetMsgFromCommandError(err))
 	}
-	ClusterFetch()
 	logger.Info("deleted cluster")
+	return nil
 }
 
-func WriteKubeConfig(cn string) {
+func WriteKubeConfig(ctx context.Context, cn string) error {
 	logger := log.WithField("clusterName", cn)
 	logger.Info("writing kubeconfig")
-	_, err := command.ShellCommander("k3d", "kubeconfig", "write", cn).Output()
+	_, err := command.ShellCommander(ctx, "k3d", "kubeconfig", "write", cn).Output()
 	if err != nil {
-		logger.WithError(command.GetMsgFromCommandError(err)).
-			Panic("unable to write kubeconfig:")
+		return fmt.Errorf("unable to write kubeconfig for %s: %w", cn,
+			command.GetMsgFromCommandError(err))
 	}
+	return nil
+}
+
+func ControllerIP() (string, error) {
+	return TargetIP(platform.ControllerClusterName())
 }
 
-func ControllerIP() string {
+// TargetIP returns the IP of the cluster's loadbalancer.
+func TargetIP(cn string) (string, error) {
+	clustersMu.RLock()
+	defer clustersMu.RUnlock()
 	for _, c := range Clusters {
-		if c.Name != platform.ControllerClusterName() {
+		if c.Name != cn {
 			continue
 		}
 
 		for _, n := range c.Nodes {
 			if n.Role == "loadbalancer" {
-				return n.IP.IP
+				return n.IP.IP, nil
 			}
 		}
+		return "", fmt.Errorf("no loadbalancer found for cluster %s", cn)
 	}
-	log.Fatal("unable to get controller ip")
-	return ""
+	return "", &ClusterNotFoundError{Name: cn}
 }
 
-func TargetIP(cn string) string {
-	for _, c := range Clusters {
-		if c.Name != cn {
-			continue
+// NodeCreate adds an agent to the cluster, with the labels and taints of its
+// topology, and returns the name of its container.
+func NodeCreate(ctx context.Context, cn string) (string, error) {
+	if err := ClusterFetch(ctx); err != nil {
+		return "", err
+	}
+	exists, c := ClusterExists(cn)
+	if !exists {
+		return "", &ClusterNotFoundError{Name: cn}
+	}
+
+	// Use the same image as the servers, in case the version has been
+	// changed since the cluster was created.
+	image := K3sImage + ":" + ClusterK3sVersion(cn)
+	names := map[string]bool{}
+	for _, n := range c.Nodes {
+		names[n.Name] = true
+		if n.Role == "server" && n.Image != "" {
+			image = n.Image
 		}
+	}
+	// k3d suffixes the name of the created nodes with their index.
+	name := ""
+	for i := 0; name == ""; i++ {
+		n := fmt.Sprintf("%s-agent-%d", cn, i)
+		if !names["k3d-"+n] && !names["k3d-"+n+"-0"] {
+			name = n
+		}
+	}
 
-		for _, n := range c.Nodes {
-			if n.Role == "loadbalancer" {
-				return n.IP.IP
-			}
+	topology := cluster.TopologyFor(cn)
+	args := []string{"node", "create", name, "--cluster", cn, "--role", "agent",
+		"--image", image}
+	if topology.Memory != "" {
+		args = append(args, "--memory", topology.Memory)
+	}
+	for _, l := range topology.Labels {
+		args = append(args, "--k3s-node-label", l)
+	}
+	cmd := command.ShellCommander(ctx, "k3d", args...)
+	log.WithFields(log.Fields{
+		"clusterName": cn,
+		"command":     cmd,
+	}).Info("adding node")
+	if err := cmd.RunProgressive(); err != nil {
+		return "", fmt.Errorf("unable to add node to cluster %s: %w", cn, err)
+	}
+
+	node := "k3d-" + name + "-0"
+	if err := kube.TaintNode(ctx, cn, node, topology.Taints); err != nil {
+		return node, err
+	}
+	return node, ClusterFetch(ctx)
+}
+
+// NodeDelete deletes the node's container and removes it from the cluster.
+func NodeDelete(ctx context.Context, cn string, node string) error {
+	log.WithFields(log.Fields{
+		"clusterName": cn,
+		"node":        node,
+	}).Info("deleting node")
+	_, err := command.ShellCommander(ctx, "k3d", "node", "delete", node).Output()
+	if err != nil {
+		return fmt.Errorf("unable to delete node %s: %w", node,
+			command.GetMsgFromCommandError(err))
+	}
+	if err := kube.DeleteNode(ctx, cn, node); err != nil {
+		return err
+	}
+	return ClusterFetch(ctx)
+}
+
+// ImageImport loads images of the docker daemon into the cluster's nodes.
+func ImageImport(ctx context.Context, cn string, images []string) error {
+	args := append([]string{"image", "import", "--cluster", cn}, images...)
+	if err := command.ShellCommander(command.WithoutTimeout(ctx), "k3d", args...).RunProgressive(); err != nil {
+		return fmt.Errorf("unable to import images into cluster %s: %w", cn, err)
+	}
+	return nil
+}
+
+// Images returns the images k3d needs to create the platform's registries and
+// clusters: the registry image, k3d's helper images and the k3s images.
+func Images(ctx context.Context) ([]string, error) {
+	out, err := command.ShellCommander(command.Query(ctx), "k3d", "version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get k3d version: %w",
+			command.GetMsgFromCommandError(err))
+	}
+	// The first line is e.g, 'k3d version v5.6.0', while the helper images
+	// are tagged without the v.
+	fields := strings.Fields(strings.SplitN(string(out), "\n", 2)[0])
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("unable to parse k3d version %q", out)
+	}
+	version := strings.TrimPrefix(fields[len(fields)-1], "v")
+	images := []string{
+		registryImage,
+		"ghcr.io/k3d-io/k3d-proxy:" + version,
+		"ghcr.io/k3d-io/k3d-tools:" + version,
+	}
+	for _, cn := range platform.ClusterNames() {
+		img := K3sImage + ":" + ClusterK3sVersion(cn)
+		if !slices.Contains(images, img) {
+			images = append(images, img)
 		}
 	}
-	log.Fatal("unable to get target ip")
-	return ""
+	return images, nil
 }
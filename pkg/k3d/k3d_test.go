@@ -0,0 +1,383 @@
+package k3d
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/cluster"
+	"github.com/salsadigitalauorg/rockpool/pkg/command"
+	"github.com/salsadigitalauorg/rockpool/pkg/kube"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func fakeCommander(t *testing.T, fixture string) *command.FakeCommander {
+	t.Helper()
+	f, err := command.NewFakeCommanderFromFixture(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(f.Install())
+	return f
+}
+
+func TestClusterCreate(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	platform.Name = "rockpool"
+	platform.Targets = platform.NumberedTargets(1)
+	Clusters = nil
+	t.Cleanup(func() { Clusters = nil })
+	f := fakeCommander(t, "cluster-create.yml")
+	platform.HostPorts.SSH = 2222
+	t.Cleanup(func() { platform.HostPorts = platform.DefaultPorts })
+
+	if err := ClusterCreate(context.Background(), "rockpool-controller", true); err != nil {
+		t.Fatal(err)
+	}
+	if u := f.Unmatched(); len(u) > 0 {
+		t.Fatalf("unexpected commands: %v", u)
+	}
+	if !ClusterIsRunning("rockpool-controller") {
+		t.Error("expected the created cluster to be fetched as running")
+	}
+
+	var create []string
+	for _, c := range f.Calls() {
+		if len(c) > 2 && c[1] == "cluster" && c[2] == "create" {
+			create = c
+		}
+	}
+	args := strings.Join(create, " ")
+	for _, want := range []string{
+		"--port 80:80@loadbalancer",
+		"--port 2222:22@loadbalancer",
+		"--port 6153:6153/udp@loadbalancer",
+		"--registry-use rockpool-registry:5000",
+		"--registry-use rockpool-registry-docker-io:5000",
+		"--registry-use rockpool-registry-registry-k8s-io:5000",
+		"--servers 1 --agents 1",
+		"--disable=traefik@server:* rockpool-controller",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("create command %q does not contain %q", args, want)
+		}
+	}
+}
+
+func TestClusterCreateMounts(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	platform.Name = "rockpool"
+	platform.Targets = []platform.Target{{Name: "dev", RemoteId: 1, Mounts: []platform.Mount{
+		{Name: "site", HostPath: "/home/dev/site"},
+	}}}
+	Clusters = nil
+	t.Cleanup(func() { Clusters = nil })
+	f := fakeCommander(t, "cluster-create.yml")
+
+	if err := ClusterCreate(context.Background(), "rockpool-dev", false); err != nil {
+		t.Fatal(err)
+	}
+	want := "--volume /home/dev/site:/mnt/rockpool/site@server:*;agent:*"
+	for _, c := range f.Calls() {
+		if len(c) > 2 && c[1] == "cluster" && c[2] == "create" &&
+			!strings.Contains(strings.Join(c, " "), want) {
+			t.Errorf("create command %q does not contain %q", c, want)
+		}
+	}
+}
+
+func TestClusterFetch(t *testing.T) {
+	platform.Name = "rockpool"
+	platform.Targets = platform.NumberedTargets(1)
+	Clusters = nil
+	t.Cleanup(func() { Clusters = nil })
+	f := command.NewFakeCommander(command.Interaction{
+		Args: []string{"k3d", "cluster", "list", "-o", "json"},
+		Stdout: `[{"name": "rockpool-controller"}, {"name": "rockpool2-controller"},
+			{"name": "rockpool-rc-controller"}, {"name": "rockpool-target-1"}]`,
+	})
+	t.Cleanup(f.Install())
+
+	if err := ClusterFetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, c := range Clusters {
+		names = append(names, c.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"rockpool-controller", "rockpool-target-1"}) {
+		t.Errorf("expected only the platform's clusters, got %q", names)
+	}
+}
+
+func TestClusterCreateStartsStoppedCluster(t *testing.T) {
+	platform.Name = "rockpool"
+	platform.Targets = platform.NumberedTargets(1)
+	Clusters = nil
+	t.Cleanup(func() { Clusters = nil })
+	f := command.NewFakeCommander(
+		command.Interaction{
+			Args:   []string{"k3d", "cluster", "list", "-o", "json"},
+			Stdout: `[{"name": "rockpool-target-1", "serversCount": 1, "agentsCount": 1}]`,
+		},
+		command.Interaction{Args: []string{"k3d", "cluster", "start", "rockpool-target-1"}},
+	)
+	t.Cleanup(f.Install())
+
+	if err := ClusterCreate(context.Background(), "rockpool-target-1", false); err != nil {
+		t.Fatal(err)
+	}
+	if f.Called("k3d", "cluster", "create", "**") {
+		t.Error("an existing cluster should not be created again")
+	}
+	if !f.Called("k3d", "cluster", "start", "rockpool-target-1") {
+		t.Errorf("expected the cluster to be started, got %v", f.Calls())
+	}
+}
+
+func TestClusterCreateK3sVersion(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	platform.Name = "rockpool"
+	platform.Targets = platform.NumberedTargets(1)
+	Clusters = nil
+	K3sVersions = map[string]string{"rockpool-target-1": "v1.23"}
+	t.Cleanup(func() {
+		Clusters = nil
+		K3sVersions = map[string]string{}
+		K3sVersion = DefaultK3sVersion
+	})
+	K3sVersion = "v1.25"
+	cluster.Topologies = map[string]cluster.Topology{"rockpool-target-1": {Servers: 3, Agents: 2,
+		Memory: "2g", Taints: []string{"dedicated=batch:NoSchedule"}}}
+	t.Cleanup(func() { cluster.Topologies = map[string]cluster.Topology{} })
+	f := command.NewFakeCommander(
+		command.Interaction{Args: []string{"k3d", "cluster", "list", "-o", "json"}, Stdout: "[]"},
+		command.Interaction{Args: []string{"k3d", "cluster", "create", "**"}},
+	)
+	t.Cleanup(f.Install())
+
+	for cn, image := range map[string]string{
+		"rockpool-controller": "--image=ghcr.io/salsadigitalauorg/rockpool/k3s:v1.25",
+		"rockpool-target-1":   "--image=ghcr.io/salsadigitalauorg/rockpool/k3s:v1.23",
+	} {
+		if err := ClusterCreate(context.Background(), cn, false); err != nil {
+			t.Fatal(err)
+		}
+		if !f.Called("k3d", "cluster", "create", "--kubeconfig-update-default=false", image, "**") {
+			t.Errorf("expected %s to be created with %s, got %q", cn, image, f.Calls())
+		}
+	}
+	var args string
+	for _, c := range f.Calls() {
+		if c[len(c)-1] == "rockpool-target-1" {
+			args = strings.Join(c, " ")
+		}
+	}
+	for _, want := range []string{
+		"--servers 3 --agents 2",
+		"--servers-memory 2g --agents-memory 2g",
+		"--k3s-arg --node-taint=dedicated=batch:NoSchedule@agent:*",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("create command %q does not contain %q", args, want)
+		}
+	}
+
+	K3sVersions["rockpool-target-2"] = "1.24"
+	if err := ValidateK3sVersions(); err == nil {
+		t.Error("expected an error for an invalid version")
+	}
+	if err := ClusterCreate(context.Background(), "rockpool-target-2", false); err == nil {
+		t.Error("expected an error creating a cluster with an invalid version")
+	}
+}
+
+func TestValidateK3sVersion(t *testing.T) {
+	for v, valid := range map[string]bool{
+		"latest":       true,
+		"v1.23":        true,
+		"v1.25.6-k3s1": true,
+		"1.25":         false,
+		"v1.25.6":      false,
+		"":             false,
+	} {
+		if err := ValidateK3sVersion(v); (err == nil) != valid {
+			t.Errorf("got %v validating %q", err, v)
+		}
+	}
+}
+
+func TestRegistryMirrors(t *testing.T) {
+	platform.Name = "rockpool"
+	Mirrors = []Mirror{{Upstream: "ghcr.io"}, {Upstream: "docker.io"}, {Upstream: "localhost:5001"}}
+	t.Cleanup(func() { Mirrors = DefaultMirrors })
+
+	if err := ValidateMirrors(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"docker.io":      "k3d-rockpool-registry-docker-io",
+		"ghcr.io":        "k3d-rockpool-registry-ghcr-io",
+		"localhost:5001": "k3d-rockpool-registry-localhost-5001",
+	}
+	if got := RegistryMirrors(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := RegistryNames(); got[0] != "k3d-rockpool-registry" || len(got) != 4 {
+		t.Errorf("expected the main registry first, got %v", got)
+	}
+
+	for _, mirrors := range [][]Mirror{
+		{{Upstream: "ghcr.io"}, {Upstream: "ghcr.io"}},
+		{{Upstream: "https://ghcr.io"}},
+	} {
+		Mirrors = mirrors
+		if err := ValidateMirrors(); err == nil {
+			t.Errorf("expected an error validating %v", mirrors)
+		}
+	}
+}
+
+func TestRegistryMaintenance(t *testing.T) {
+	platform.Name = "rockpool"
+	Mirrors = []Mirror{{Upstream: "docker.io"}, {Upstream: "ghcr.io"}}
+	t.Cleanup(func() { Mirrors = DefaultMirrors })
+	f := fakeCommander(t, "registry-maintenance.yml")
+
+	usages, err := RegistryStatus(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := f.Unmatched(); len(u) > 0 {
+		t.Fatalf("unexpected commands: %v", u)
+	}
+	want := []RegistryUsage{
+		{Name: "k3d-rockpool-registry", Exists: true, Running: true, Size: 4096,
+			Repositories: []string{}},
+		{Name: "k3d-rockpool-registry-docker-io", Upstream: "docker.io", Exists: true,
+			Running: true, Size: 2048 * 1024,
+			Repositories: []string{"library/nginx", "uselagoon/api"}},
+		{Name: "k3d-rockpool-registry-ghcr-io", Upstream: "ghcr.io", Exists: true},
+	}
+	if !reflect.DeepEqual(usages, want) {
+		t.Errorf("got %+v, want %+v", usages, want)
+	}
+
+	if err := RegistryReset(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if f.Called("docker", "exec", "k3d-rockpool-registry", "ash", "-c", "rm -rf /var/lib/registry/docker") {
+		t.Error("expected the main registry to be kept")
+	}
+	if !f.Called("docker", "restart", "k3d-rockpool-registry-docker-io") {
+		t.Error("expected the docker.io mirror to be reset")
+	}
+	if f.Called("docker", "restart", "k3d-rockpool-registry-ghcr-io") {
+		t.Error("expected the stopped registry to be skipped")
+	}
+}
+
+func TestRegistryPrune(t *testing.T) {
+	platform.Name = "rockpool"
+	Mirrors = []Mirror{{Upstream: "docker.io"}, {Upstream: "ghcr.io"}}
+	t.Cleanup(func() { Mirrors = DefaultMirrors })
+	f := fakeCommander(t, "registry-maintenance.yml")
+
+	if _, err := RegistryPrune(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if u := f.Unmatched(); len(u) > 0 {
+		t.Fatalf("unexpected commands: %v", u)
+	}
+	// The registries are stopped while their storage is collected.
+	got := []string{}
+	for _, c := range f.Calls() {
+		if c[0] == "docker" && c[1] != "exec" {
+			got = append(got, strings.Join(c[:2], " ")+" "+c[len(c)-1])
+		}
+	}
+	want := []string{
+		"docker stop k3d-rockpool-registry",
+		"docker run /etc/docker/registry/config.yml",
+		"docker start k3d-rockpool-registry",
+		"docker stop k3d-rockpool-registry-docker-io",
+		"docker run /etc/docker/registry/config.yml",
+		"docker start k3d-rockpool-registry-docker-io",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got commands %q, want %q", got, want)
+	}
+	if !f.Called("docker", "run", "--rm", "--volumes-from", "k3d-rockpool-registry-docker-io", "**") {
+		t.Error("expected the storage of the mirror to be collected")
+	}
+	if f.Called("docker", "stop", "k3d-rockpool-registry-ghcr-io") {
+		t.Error("expected the stopped registry to be skipped")
+	}
+}
+
+func TestNodeCreateAndDelete(t *testing.T) {
+	platform.Name = "rockpool"
+	platform.Targets = platform.NumberedTargets(1)
+	Clusters = nil
+	cluster.Topologies = map[string]cluster.Topology{"rockpool-target-1": {Servers: 1, Agents: 1,
+		Labels: []string{"tier=batch"}, Taints: []string{"dedicated=batch:NoSchedule"}}}
+	t.Cleanup(func() {
+		Clusters = nil
+		cluster.Topologies = map[string]cluster.Topology{}
+	})
+	f := command.NewFakeCommander(
+		command.Interaction{
+			Args: []string{"k3d", "cluster", "list", "-o", "json"},
+			Stdout: `[{"name": "rockpool-target-1", "serversCount": 1, "agentsCount": 1, "nodes": [
+				{"name": "k3d-rockpool-target-1-server-0", "role": "server", "image": "ghcr.io/salsadigitalauorg/rockpool/k3s:v1.23"},
+				{"name": "k3d-rockpool-target-1-agent-0", "role": "agent"}]}]`,
+		},
+		command.Interaction{Args: []string{"k3d", "node", "**"}},
+	)
+	t.Cleanup(f.Install())
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "k3d-rockpool-target-1-agent-1-0"},
+		Spec: corev1.NodeSpec{Taints: []corev1.Taint{{Key: "dedicated", Value: "web", Effect: corev1.TaintEffectNoSchedule}}}}
+	kc := kube.NewFakeClient(nil, node)
+	t.Cleanup(kube.InstallFakeClient(kc))
+	ctx := context.Background()
+
+	n, err := NodeCreate(ctx, "rockpool-target-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != "k3d-rockpool-target-1-agent-1-0" {
+		t.Errorf("got node %s", n)
+	}
+	want := []string{"k3d", "node", "create", "rockpool-target-1-agent-1", "--cluster", "rockpool-target-1",
+		"--role", "agent", "--image", "ghcr.io/salsadigitalauorg/rockpool/k3s:v1.23",
+		"--k3s-node-label", "tier=batch"}
+	if !f.Called(want...) {
+		t.Errorf("expected %q to be run, got %q", want, f.Calls())
+	}
+	nodesGVR := schema.GroupVersionResource{Version: "v1", Resource: "nodes"}
+	u, err := kc.Dynamic.Resource(nodesGVR).Get(ctx, n, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	taints, _, _ := unstructured.NestedSlice(u.Object, "spec", "taints")
+	if len(taints) != 1 || taints[0].(map[string]interface{})["value"] != "batch" {
+		t.Errorf("got taints %v", taints)
+	}
+
+	if err := NodeDelete(ctx, "rockpool-target-1", n); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Called("k3d", "node", "delete", n) {
+		t.Errorf("expected the node to be deleted, got %q", f.Calls())
+	}
+	if _, err := kc.Dynamic.Resource(nodesGVR).Get(ctx, n, metav1.GetOptions{}); err == nil {
+		t.Error("expected the node to be removed from the cluster")
+	}
+}
@@ -0,0 +1,93 @@
+package k3d
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Mirror is an upstream registry whose images are cached by a proxy registry
+// of the platform.
+type Mirror struct {
+	// Upstream is the host of the registry, as referenced in the images'
+	// names, e.g, ghcr.io.
+	Upstream string `yaml:"upstream"`
+	// RemoteURL is the URL of the registry's API; it defaults to
+	// https://<upstream>.
+	RemoteURL string `yaml:"remote-url,omitempty"`
+}
+
+// DefaultMirrors are the registries the images of the platform's components
+// are pulled from.
+var DefaultMirrors = []Mirror{
+	{Upstream: "docker.io", RemoteURL: "https://registry-1.docker.io"},
+	{Upstream: "ghcr.io"},
+	{Upstream: "quay.io"},
+	{Upstream: "registry.k8s.io"},
+}
+
+// Mirrors are the registries mirrored by the platform.
+var Mirrors = DefaultMirrors
+
+var upstreamRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?(:[0-9]+)?$`)
+
+// Remote returns the URL the proxy registry pulls the images from.
+func (m Mirror) Remote() string {
+	if m.RemoteURL != "" {
+		return m.RemoteURL
+	}
+	return "https://" + m.Upstream
+}
+
+// registryName returns the name of the mirror's proxy registry, as given to
+// k3d.
+func (m Mirror) registryName() string {
+	r := strings.NewReplacer(".", "-", ":", "-")
+	return registryName() + "-" + r.Replace(m.Upstream)
+}
+
+// RegistryName returns the full name of the mirror's proxy registry
+// container.
+func (m Mirror) RegistryName() string {
+	return "k3d-" + m.registryName()
+}
+
+// ValidateMirrors ensures the mirrors' upstreams are valid hosts, and that
+// each one is mirrored once.
+func ValidateMirrors() error {
+	seen := map[string]bool{}
+	for _, m := range Mirrors {
+		if !upstreamRegex.MatchString(m.Upstream) {
+			return fmt.Errorf("invalid mirror upstream %q", m.Upstream)
+		}
+		if seen[m.Upstream] {
+			return fmt.Errorf("duplicate mirror upstream %q", m.Upstream)
+		}
+		seen[m.Upstream] = true
+	}
+	return nil
+}
+
+// mirrored returns the mirrors, starting with Docker Hub, which is always
+// mirrored.
+func mirrored() []Mirror {
+	mirrors := []Mirror{DefaultMirrors[0]}
+	for _, m := range Mirrors {
+		if m.Upstream == "docker.io" {
+			mirrors[0] = m
+			continue
+		}
+		mirrors = append(mirrors, m)
+	}
+	return mirrors
+}
+
+// RegistryMirrors returns the names of the proxy registry containers, by
+// upstream.
+func RegistryMirrors() map[string]string {
+	registries := map[string]string{}
+	for _, m := range mirrored() {
+		registries[m.Upstream] = m.RegistryName()
+	}
+	return registries
+}
@@ -0,0 +1,94 @@
+package k3d
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/cluster"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+)
+
+// Provider creates the clusters using k3d; it also supports adding and
+// removing agents.
+type Provider struct{}
+
+func init() {
+	cluster.Register(Provider{})
+}
+
+func (Provider) Name() string {
+	return "k3d"
+}
+
+func (Provider) Binaries() []string {
+	return []string{"k3d"}
+}
+
+// List returns all the k3d clusters, including the other platforms' ones.
+func (Provider) List(ctx context.Context) ([]cluster.Cluster, error) {
+	all, err := ClusterFetchAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	setClusters(all)
+	clusters := []cluster.Cluster{}
+	for _, c := range all {
+		cl := cluster.Cluster{Name: c.Name, Running: c.AgentsCount == c.AgentsRunning &&
+			c.ServersCount == c.ServersRunning}
+		for _, n := range c.Nodes {
+			if n.Role == "loadbalancer" {
+				cl.IP = n.IP.IP
+				cl.API = fmt.Sprintf("https://%s:6443", n.IP.IP)
+				continue
+			}
+			if i := strings.LastIndex(n.Image, ":"); i > 0 && n.Role == "server" {
+				cl.Version = n.Image[i+1:]
+			}
+			cl.Nodes = append(cl.Nodes, cluster.Node{Name: n.Name, Role: n.Role,
+				Running: n.State.Running})
+		}
+		clusters = append(clusters, cl)
+	}
+	return clusters, nil
+}
+
+func (Provider) Create(ctx context.Context, cn string, isController bool) error {
+	return ClusterCreate(ctx, cn, isController)
+}
+
+func (Provider) Start(ctx context.Context, cn string) error {
+	return ClusterStart(ctx, cn)
+}
+
+func (Provider) Stop(ctx context.Context, cn string) error {
+	return ClusterStop(ctx, cn)
+}
+
+func (Provider) Delete(ctx context.Context, cn string) error {
+	return ClusterDelete(ctx, cn)
+}
+
+func (Provider) WriteKubeConfig(ctx context.Context, cn string) error {
+	return WriteKubeConfig(ctx, cn)
+}
+
+func (Provider) AddNode(ctx context.Context, cn string) (string, error) {
+	return NodeCreate(ctx, cn)
+}
+
+func (Provider) RemoveNode(ctx context.Context, cn string, node string) error {
+	return NodeDelete(ctx, cn, node)
+}
+
+func (Provider) ImportImages(ctx context.Context, cn string, images []string) error {
+	return ImageImport(ctx, cn, images)
+}
+
+func (Provider) PublishedPorts(cn string, isController bool) []platform.PublishedPort {
+	if !isController {
+		// The targets' ports are assigned by docker.
+		return nil
+	}
+	return platform.HostPorts.Published()
+}
@@ -0,0 +1,176 @@
+package k3d
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/command"
+	"github.com/salsadigitalauorg/rockpool/pkg/docker"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// registryStorage is the directory holding the content of the registries.
+const registryStorage = "/var/lib/registry"
+
+// registryRepositories is the directory of the repositories in the storage.
+const registryRepositories = registryStorage + "/docker/registry/v2/repositories"
+
+// RegistryUsage is the state and content of one of the platform's registries.
+type RegistryUsage struct {
+	Name string
+	// Upstream is the registry mirrored by the registry; it is empty for the
+	// main registry.
+	Upstream string
+	Exists   bool
+	Running  bool
+	// Size is the disk usage of the registry's content, in bytes.
+	Size         int64
+	Repositories []string
+}
+
+// upstreams returns the upstream mirrored by each of the platform's
+// registries, by registry container name.
+func upstreams() map[string]string {
+	u := map[string]string{RegistryName(): ""}
+	for _, m := range mirrored() {
+		u[m.RegistryName()] = m.Upstream
+	}
+	return u
+}
+
+// RegistryStatus returns the state, disk usage and repositories of the
+// platform's registries; the usage is only available for the running ones.
+func RegistryStatus(ctx context.Context) ([]RegistryUsage, error) {
+	if err := RegistryList(ctx); err != nil {
+		return nil, err
+	}
+	ups := upstreams()
+	usages := []RegistryUsage{}
+	for _, name := range RegistryNames() {
+		u := RegistryUsage{Name: name, Upstream: ups[name]}
+		for _, reg := range registries {
+			if reg.Name == name {
+				u.Exists, u.Running = true, reg.State.Running
+			}
+		}
+		if u.Running {
+			var err error
+			if u.Size, err = registrySize(ctx, name); err != nil {
+				return nil, err
+			}
+			if u.Repositories, err = registryRepositoryList(ctx, name); err != nil {
+				return nil, err
+			}
+		}
+		usages = append(usages, u)
+	}
+	return usages, nil
+}
+
+// registrySize returns the disk usage of the registry's content, in bytes.
+func registrySize(ctx context.Context, name string) (int64, error) {
+	out, err := docker.Exec(ctx, name, "du -sk "+registryStorage).Output()
+	if err != nil {
+		return 0, fmt.Errorf("unable to get disk usage of registry %s: %w", name,
+			command.GetMsgFromCommandError(err))
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unable to parse disk usage of registry %s: %q", name, out)
+	}
+	kb, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse disk usage of registry %s: %w", name, err)
+	}
+	return kb * 1024, nil
+}
+
+// registryRepositoryList returns the repositories stored in the registry,
+// which are the directories holding a _manifests directory.
+func registryRepositoryList(ctx context.Context, name string) ([]string, error) {
+	out, err := docker.Exec(ctx, name, fmt.Sprintf(
+		"find %s -type d -name _manifests 2>/dev/null || true", registryRepositories)).Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list repositories of registry %s: %w", name,
+			command.GetMsgFromCommandError(err))
+	}
+	repos := []string{}
+	for _, l := range strings.Split(string(out), "\n") {
+		l = strings.TrimSuffix(strings.TrimPrefix(l, registryRepositories+"/"), "/_manifests")
+		if l != "" {
+			repos = append(repos, l)
+		}
+	}
+	return repos, nil
+}
+
+// RegistryPrune removes the blobs no longer referenced by the manifests of
+// the running registries, along with the untagged manifests. Each registry is
+// stopped while its storage is collected by a one-off container, so that no
+// blob is deleted while being pushed or pulled, then started again. It
+// returns the disk space freed, in bytes.
+func RegistryPrune(ctx context.Context) (int64, error) {
+	usages, err := RegistryStatus(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var freed int64
+	for _, u := range usages {
+		if !u.Running {
+			continue
+		}
+		logger := log.WithField("registry", u.Name)
+		logger.Info("pruning registry")
+		if _, err := docker.Stop(ctx, u.Name); err != nil {
+			return freed, fmt.Errorf("error stopping registry %s: %w", u.Name,
+				command.GetMsgFromCommandError(err))
+		}
+		// The storage is collected using the image's default config, since
+		// the proxy settings of the mirrors are not needed.
+		gcErr := command.ShellCommander(ctx, "docker", "run", "--rm", "--volumes-from", u.Name,
+			registryImage, "garbage-collect", "--delete-untagged", registryConfigFile).Run()
+		// The registry is started again even if the run was interrupted.
+		if _, err := docker.Start(context.WithoutCancel(ctx), u.Name); err != nil {
+			return freed, fmt.Errorf("error starting registry %s: %w", u.Name,
+				command.GetMsgFromCommandError(err))
+		}
+		if gcErr != nil {
+			return freed, fmt.Errorf("unable to prune registry %s: %w", u.Name,
+				command.GetMsgFromCommandError(gcErr))
+		}
+		size, err := registrySize(ctx, u.Name)
+		if err != nil {
+			return freed, err
+		}
+		logger.WithField("freed", u.Size-size).Debug("pruned registry")
+		freed += u.Size - size
+	}
+	return freed, nil
+}
+
+// RegistryReset removes the content of the running mirrors' registries, and
+// of the main registry too if all is set, then restarts them.
+func RegistryReset(ctx context.Context, all bool) error {
+	usages, err := RegistryStatus(ctx)
+	if err != nil {
+		return err
+	}
+	for _, u := range usages {
+		if !u.Running || (u.Upstream == "" && !all) {
+			continue
+		}
+		log.WithField("registry", u.Name).Info("resetting registry")
+		if err := docker.Exec(ctx, u.Name, "rm -rf "+registryStorage+"/docker").Run(); err != nil {
+			return fmt.Errorf("unable to reset registry %s: %w", u.Name,
+				command.GetMsgFromCommandError(err))
+		}
+		if _, err := docker.Restart(ctx, u.Name); err != nil {
+			return fmt.Errorf("error restarting registry %s: %w", u.Name,
+				command.GetMsgFromCommandError(err))
+		}
+	}
+	return nil
+}
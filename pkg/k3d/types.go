@@ -3,6 +3,7 @@ package k3d
 type ClusterNode struct {
 	Name  string `json:"name"`
 	Role  string `json:"role"`
+	Image string `json:"image"`
 	State struct {
 		Running bool
 		Status  string
@@ -0,0 +1,241 @@
+// Package kind implements a cluster provider using kind, for the hosts where
+// k3d can't be used.
+package kind
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/cluster"
+	"github.com/salsadigitalauorg/rockpool/pkg/command"
+	"github.com/salsadigitalauorg/rockpool/pkg/docker"
+	"github.com/salsadigitalauorg/rockpool/pkg/k3d"
+	"github.com/salsadigitalauorg/rockpool/pkg/kube"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform/templates"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// IngressNginxArgs expose ingress-nginx on the nodes' ports, since kind has
+// no loadbalancer.
+var IngressNginxArgs = []string{
+	"--set", "controller.hostPort.enabled=true",
+	"--set", "controller.service.type=NodePort",
+	"--set", "controller.nodeSelector.ingress-ready=true",
+	// The control-plane is tainted when the cluster has workers.
+	"--set", "controller.tolerations[0].key=node-role.kubernetes.io/control-plane",
+	"--set", "controller.tolerations[0].operator=Exists",
+	"--set", "controller.tolerations[0].effect=NoSchedule",
+}
+
+// Provider creates the clusters using kind.
+type Provider struct{}
+
+func init() {
+	cluster.Register(Provider{})
+}
+
+func (Provider) Name() string {
+	return "kind"
+}
+
+func (Provider) Binaries() []string {
+	return []string{"kind"}
+}
+
+// List returns all the kind clusters, including the other platforms' ones.
+func (p Provider) List(ctx context.Context) ([]cluster.Cluster, error) {
+	out, err := command.ShellCommander(command.Query(ctx), "kind", "get", "clusters").Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get cluster list: %w",
+			command.GetMsgFromCommandError(err))
+	}
+	clusters := []cluster.Cluster{}
+	for _, cn := range strings.Fields(string(out)) {
+		c, err := p.get(ctx, cn)
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters, nil
+}
+
+// get inspects the nodes of the cluster.
+func (Provider) get(ctx context.Context, cn string) (cluster.Cluster, error) {
+	c := cluster.Cluster{Name: cn, Running: true}
+	out, err := command.ShellCommander(command.Query(ctx), "kind", "get", "nodes", "--name", cn).Output()
+	if err != nil {
+		return c, fmt.Errorf("unable to get nodes of cluster %s: %w", cn,
+			command.GetMsgFromCommandError(err))
+	}
+	for _, n := range strings.Fields(string(out)) {
+		containers, err := docker.Inspect(ctx, n)
+		if err != nil {
+			return c, err
+		}
+		node := cluster.Node{Name: n, Role: "agent"}
+		if strings.HasSuffix(n, "-control-plane") {
+			node.Role = "server"
+		}
+		for _, ct := range containers {
+			node.Running = ct.State.Running
+			c.Running = c.Running && ct.State.Running
+			if ip := ct.NetworkSettings.Networks[platform.Network()].IPAddress; node.Role == "server" && ip != "" {
+				c.IP = ip
+				c.API = fmt.Sprintf("https://%s:6443", ip)
+			}
+		}
+		c.Nodes = append(c.Nodes, node)
+	}
+	c.Running = c.Running && len(c.Nodes) > 0
+	return c, nil
+}
+
+// PublishedPorts returns the http and https ports of ingress-nginx, the only
+// ones mapped to the host.
+func (Provider) PublishedPorts(cn string, isController bool) []platform.PublishedPort {
+	if !isController {
+		return nil
+	}
+	return platform.HostPorts.Published()[:2]
+}
+
+func (p Provider) Create(ctx context.Context, cn string, isController bool) error {
+	logger := log.WithFields(log.Fields{
+		"clusterName":  cn,
+		"isController": isController,
+	})
+	if exists, c := cluster.Exists(cn); exists && c.Running {
+		logger.Debug("cluster already exists and is running")
+		return nil
+	} else if exists {
+		logger.Info("cluster exists, but is stopped; starting now")
+		return p.Start(ctx, cn)
+	}
+
+	topology := cluster.TopologyFor(cn)
+	if err := topology.Validate(); err != nil {
+		return err
+	}
+	if topology.Memory != "" {
+		logger.Warn("kind does not support limiting the nodes' memory; ignoring it")
+	}
+	labels := map[string]string{}
+	for _, l := range topology.Labels {
+		k, v, _ := strings.Cut(l, "=")
+		labels[k] = v
+	}
+	target, _ := platform.TargetFor(cn)
+	config, err := templates.Render("kind-config.yml.tmpl", map[string]interface{}{
+		"IsController": isController,
+		"Registry":     k3d.RegistryName(),
+		"Mirrors":      k3d.RegistryMirrors(),
+		"ExtraServers": make([]int, topology.Servers-1),
+		"Agents":       make([]int, topology.Agents),
+		"Labels":       labels,
+		"Ports":        platform.HostPorts,
+		"Mounts":       target.Mounts,
+	}, cn+"-kind-config.yml")
+	if err != nil {
+		return fmt.Errorf("unable to render kind config: %w", err)
+	}
+	kc := kube.KubeconfigPath(cn)
+	if err := os.MkdirAll(filepath.Dir(kc), os.ModePerm); err != nil {
+		return err
+	}
+	// kind creates the nodes on the network given in its environment.
+	os.Setenv("KIND_EXPERIMENTAL_DOCKER_NETWORK", platform.Network())
+	cmd := command.ShellCommander(ctx, "kind", "create", "cluster", "--name", cn,
+		"--config", config, "--kubeconfig", kc)
+	logger.WithField("command", cmd).Info("creating cluster")
+	if err := cmd.RunProgressive(); err != nil {
+		return fmt.Errorf("unable to create cluster %s: %w", cn, err)
+	}
+	// Make the registries reachable from the nodes.
+	for _, r := range k3d.RegistryNames() {
+		if err := docker.NetworkConnect(ctx, platform.Network(), r); err != nil {
+			return err
+		}
+	}
+	if len(topology.Taints) == 0 {
+		return nil
+	}
+	c, err := p.get(ctx, cn)
+	if err != nil {
+		return err
+	}
+	for _, n := range c.Nodes {
+		if n.Role != "agent" {
+			continue
+		}
+		if err := kube.TaintNode(ctx, cn, n.Name, topology.Taints); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (Provider) Start(ctx context.Context, cn string) error {
+	logger := log.WithField("clusterName", cn)
+	logger.Info("starting cluster")
+	_, c := cluster.Exists(cn)
+	for _, n := range c.Nodes {
+		if _, err := docker.Start(ctx, n.Name); err != nil {
+			return fmt.Errorf("unable to start cluster %s: %w", cn,
+				command.GetMsgFromCommandError(err))
+		}
+	}
+	logger.Info("started cluster")
+	return nil
+}
+
+func (Provider) Stop(ctx context.Context, cn string) error {
+	logger := log.WithField("clusterName", cn)
+	logger.Info("stopping cluster")
+	_, c := cluster.Exists(cn)
+	for _, n := range c.Nodes {
+		if _, err := docker.Stop(ctx, n.Name); err != nil {
+			return fmt.Errorf("unable to stop cluster %s: %w", cn,
+				command.GetMsgFromCommandError(err))
+		}
+	}
+	logger.Info("stopped cluster")
+	return nil
+}
+
+func (Provider) Delete(ctx context.Context, cn string) error {
+	logger := log.WithField("clusterName", cn)
+	logger.Info("deleting cluster")
+	_, err := command.ShellCommander(ctx, "kind", "delete", "cluster", "--name", cn).Output()
+	if err != nil {
+		return fmt.Errorf("unable to delete cluster %s: %w", cn,
+			command.GetMsgFromCommandError(err))
+	}
+	logger.Info("deleted cluster")
+	return nil
+}
+
+func (Provider) ImportImages(ctx context.Context, cn string, images []string) error {
+	args := append([]string{"load", "docker-image", "--name", cn}, images...)
+	if err := command.ShellCommander(command.WithoutTimeout(ctx), "kind", args...).RunProgressive(); err != nil {
+		return fmt.Errorf("unable to load images into cluster %s: %w", cn, err)
+	}
+	return nil
+}
+
+func (Provider) WriteKubeConfig(ctx context.Context, cn string) error {
+	logger := log.WithField("clusterName", cn)
+	logger.Info("writing kubeconfig")
+	_, err := command.ShellCommander(ctx, "kind", "export", "kubeconfig", "--name", cn,
+		"--kubeconfig", kube.KubeconfigPath(cn)).Output()
+	if err != nil {
+		return fmt.Errorf("unable to write kubeconfig for %s: %w", cn,
+			command.GetMsgFromCommandError(err))
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package kind
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/cluster"
+	"github.com/salsadigitalauorg/rockpool/pkg/command"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+)
+
+func setUp(t *testing.T, f *command.FakeCommander) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("KIND_EXPERIMENTAL_DOCKER_NETWORK", "")
+	platform.ConfigDir = t.TempDir()
+	platform.Name = "rockpool"
+	platform.Targets = platform.NumberedTargets(1)
+	if err := os.MkdirAll(filepath.Join(platform.ConfigDir, "rendered", platform.Name), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	prev := cluster.DefaultProvider
+	cluster.DefaultProvider = Provider{}.Name()
+	t.Cleanup(func() {
+		cluster.DefaultProvider = prev
+		cluster.Clusters = nil
+	})
+	t.Cleanup(f.Install())
+}
+
+func TestCreate(t *testing.T) {
+	f, err := command.NewFakeCommanderFromFixture(filepath.Join("testdata", "cluster-create.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setUp(t, f)
+	ctx := context.Background()
+
+	if err := cluster.Fetch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.Create(ctx, "rockpool-controller", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.WriteKubeConfig(ctx, "rockpool-controller"); err != nil {
+		t.Fatal(err)
+	}
+	if u := f.Unmatched(); len(u) > 0 {
+		t.Fatalf("unexpected commands: %v", u)
+	}
+
+	if n := os.Getenv("KIND_EXPERIMENTAL_DOCKER_NETWORK"); n != "k3d-rockpool" {
+		t.Errorf("expected the cluster to be created on the platform's network, got %q", n)
+	}
+	// The clusters of the other platforms are ignored.
+	want := cluster.Cluster{Name: "rockpool-controller", Provider: "kind", Running: true,
+		IP: "172.19.0.2", API: "https://172.19.0.2:6443",
+		Nodes: []cluster.Node{{Name: "rockpool-controller-control-plane", Role: "server", Running: true}}}
+	if !reflect.DeepEqual(cluster.Clusters, []cluster.Cluster{want}) {
+		t.Errorf("got clusters %+v", cluster.Clusters)
+	}
+
+	config, err := os.ReadFile(filepath.Join(platform.ConfigDir, "rendered", platform.Name,
+		"rockpool-controller-kind-config.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`endpoint = ["http://k3d-rockpool-registry:5000"]`, "hostPort: 443"} {
+		if !strings.Contains(string(config), s) {
+			t.Errorf("kind config does not contain %q:\n%s", s, config)
+		}
+	}
+}
+
+func TestStopAndStart(t *testing.T) {
+	f := command.NewFakeCommander(
+		command.Interaction{Args: []string{"kind", "get", "clusters"}, Stdout: "rockpool-target-1\n"},
+		command.Interaction{Args: []string{"kind", "get", "nodes", "--name", "rockpool-target-1"},
+			Stdout: "rockpool-target-1-control-plane\n"},
+		command.Interaction{Args: []string{"docker", "inspect", "rockpool-target-1-control-plane"},
+			Stdout: `[{"State": {"Running": false}}]`},
+		command.Interaction{Args: []string{"docker", "stop", "rockpool-target-1-control-plane"}},
+		command.Interaction{Args: []string{"docker", "start", "rockpool-target-1-control-plane"}},
+		command.Interaction{Args: []string{"docker", "inspect", "rockpool-target-1-control-plane"},
+			Stdout: `[{"State": {"Running": true}}]`},
+	)
+	setUp(t, f)
+	ctx := context.Background()
+
+	if err := cluster.Fetch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if cluster.IsRunning("rockpool-target-1") {
+		t.Error("expected the cluster to be stopped")
+	}
+	if err := cluster.Restart(ctx, "rockpool-target-1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range [][]string{
+		{"docker", "stop", "rockpool-target-1-control-plane"},
+		{"docker", "start", "rockpool-target-1-control-plane"},
+	} {
+		if !f.Called(c...) {
+			t.Errorf("expected %q to be run, got %q", c, f.Calls())
+		}
+	}
+	if !cluster.IsRunning("rockpool-target-1") {
+		t.Error("expected the cluster to be running after the restart")
+	}
+}
@@ -1,6 +1,15 @@
 package kube
 
-import log "github.com/sirupsen/logrus"
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform/templates"
+
+	log "github.com/sirupsen/logrus"
+)
 
 type Applyer struct {
 	Stage       string
@@ -12,13 +21,60 @@ type Applyer struct {
 	Force       bool
 	Retries     int
 	Delay       int
+	DependsOn   []string
 }
 
 func (t Applyer) GetStage() string {
 	return t.Stage
 }
 
-func (t Applyer) Execute() bool {
+func (t Applyer) GetClusterName() string {
+	return t.ClusterName
+}
+
+func (t Applyer) GetName() string {
+	if t.Template != "" {
+		return "apply:" + t.Template
+	}
+	return "apply:" + strings.Join(t.Urls, ",")
+}
+
+func (t Applyer) GetDependencies() []string {
+	return t.DependsOn
+}
+
+// Describe renders the template, if any, and returns a summary of what would
+// be applied, followed by the changes to the live objects when the cluster
+// exists.
+func (t Applyer) Describe() string {
+	sources := []string{}
+	files := []string{}
+	if t.Template != "" {
+		f, err := templates.Render(t.Template, platform.ToMap(), "")
+		if err != nil {
+			sources = append(sources, fmt.Sprintf("%s (render error: %s)", t.Template, err))
+		} else {
+			sources = append(sources, fmt.Sprintf("%s (%s)", t.Template, f))
+			files = append(files, f)
+		}
+	}
+	sources = append(sources, t.Urls...)
+	files = append(files, t.Urls...)
+
+	desc := "apply " + strings.Join(sources, ", ")
+	if t.Namespace != "" {
+		desc += " in namespace " + t.Namespace
+	}
+	if t.Info != "" {
+		desc = t.Info + ": " + desc
+	}
+	for _, f := range files {
+		desc += DescribeDiff(t.ClusterName, t.Namespace, f)
+	}
+	return desc
+}
+
+func (t Applyer) Execute(ctx context.Context) error {
 	logger := log.WithFields(log.Fields{
 		"stage":     t.Stage,
 		"cluster":   t.ClusterName,
@@ -36,13 +92,17 @@ func (t Applyer) Execute() bool {
 	}
 
 	if t.Template != "" {
-		ApplyTemplate(t.ClusterName, t.Namespace, t.Template, t.Force, t.Retries, t.Delay)
+		err := ApplyTemplate(ctx, t.ClusterName, t.Namespace, t.Template, t.Force,
+			t.Retries, t.Delay)
+		if err != nil {
+			return err
+		}
 	}
 
-	if len(t.Urls) > 0 {
-		for _, u := range t.Urls {
-			Apply(t.ClusterName, t.Namespace, u, t.Force)
+	for _, u := range t.Urls {
+		if err := Apply(ctx, t.ClusterName, t.Namespace, u, t.Force); err != nil {
+			return err
 		}
 	}
-	return true
+	return nil
 }
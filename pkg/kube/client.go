@@ -0,0 +1,157 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// FieldManager is the name under which rockpool owns the fields it applies.
+const FieldManager = "rockpool"
+
+// ExecFunc runs a command in a pod's container.
+type ExecFunc func(ctx context.Context, ns string, pod string, container string,
+	cmd []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error
+
+// Client holds the clients used to interact with a cluster.
+type Client struct {
+	Config    *rest.Config
+	Clientset kubernetes.Interface
+	Dynamic   dynamic.Interface
+	Mapper    meta.RESTMapper
+	Exec      ExecFunc
+}
+
+// NewClient creates the clients for a cluster from its kubeconfig.
+var NewClient = func(cn string) (*Client, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", KubeconfigPath(cn))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load kubeconfig for %s: %w", cn, err)
+	}
+	config.UserAgent = "rockpool"
+	config.QPS = 50
+	config.Burst = 100
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create client for %s: %w", cn, err)
+	}
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create dynamic client for %s: %w", cn, err)
+	}
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create discovery client for %s: %w", cn, err)
+	}
+	c := &Client{
+		Config:    config,
+		Clientset: clientset,
+		Dynamic:   dyn,
+		Mapper:    restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc)),
+	}
+	c.Exec = c.spdyExec
+	return c, nil
+}
+
+// List of clients per cluster.
+var clients sync.Map
+
+// ClientFor returns the clients for a cluster, creating them on first use.
+func ClientFor(cn string) (*Client, error) {
+	if c, ok := clients.Load(cn); ok {
+		return c.(*Client), nil
+	}
+	c, err := NewClient(cn)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := clients.LoadOrStore(cn, c)
+	return actual.(*Client), nil
+}
+
+// ResetClients removes the cached clients, e.g, after the clusters have been
+// recreated.
+func ResetClients() {
+	clients.Range(func(k, _ interface{}) bool {
+		clients.Delete(k)
+		return true
+	})
+}
+
+// mapping returns the REST mapping for a kind, resetting the cached discovery
+// information once if the kind is not found, e.g, when its
+// CustomResourceDefinition has just been created.
+func (c *Client) mapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	m, err := c.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		if r, ok := c.Mapper.(meta.ResettableRESTMapper); ok {
+			r.Reset()
+			m, err = c.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		}
+	}
+	return m, err
+}
+
+// resourceFor returns the resource for a type given as in kubectl, e.g,
+// deployment, deploy or deployments.apps.
+func (c *Client) resourceFor(kind string) (schema.GroupVersionResource, error) {
+	if short, ok := shortNames[kind]; ok {
+		kind = short
+	}
+	gr := schema.ParseGroupResource(kind)
+	return c.Mapper.ResourceFor(gr.WithVersion(""))
+}
+
+// shortNames holds the abbreviations used for the resources rockpool
+// interacts with.
+var shortNames = map[string]string{
+	"cm":     "configmaps",
+	"deploy": "deployments",
+	"ds":     "daemonsets",
+	"ns":     "namespaces",
+	"po":     "pods",
+	"sts":    "statefulsets",
+	"svc":    "services",
+}
+
+// resourceInterface returns the dynamic client for an object's resource,
+// scoped to the object's namespace, or ns if it has none, when namespaced.
+func (c *Client) resourceInterface(obj *unstructured.Unstructured, ns string) (dynamic.ResourceInterface, error) {
+	m, err := c.mapping(obj.GroupVersionKind())
+	if err != nil {
+		return nil, err
+	}
+	if m.Scope.Name() != meta.RESTScopeNameNamespace {
+		obj.SetNamespace("")
+		return c.Dynamic.Resource(m.Resource), nil
+	}
+	if obj.GetNamespace() == "" {
+		if ns == "" {
+			ns = "default"
+		}
+		obj.SetNamespace(ns)
+	}
+	return c.Dynamic.Resource(m.Resource).Namespace(obj.GetNamespace()), nil
+}
+
+// namespaced returns the dynamic client for the resource, scoped to ns if it
+// is not empty.
+func (c *Client) namespaced(gvr schema.GroupVersionResource, ns string) dynamic.ResourceInterface {
+	if ns == "" {
+		return c.Dynamic.Resource(gvr)
+	}
+	return c.Dynamic.Resource(gvr).Namespace(ns)
+}
@@ -0,0 +1,250 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// Diff makes Apply and Patch log the changes they are about to make to the
+// live objects, at info level.
+var Diff bool
+
+// ForceConflicts makes server-side apply take over the fields managed by
+// other field managers, e.g, when edited using kubectl; otherwise, applying
+// fails with the conflicting fields and their managers. Either way, the
+// conflicts are reported by the diffs.
+var ForceConflicts = true
+
+// ObjectDiff is the change applying a manifest makes to a live object.
+type ObjectDiff struct {
+	Kind      string
+	Namespace string
+	Name      string
+	// Diff is the unified diff between the yaml of the live object, empty
+	// if it does not exist, and of the applied one.
+	Diff string
+	// Conflicts are the applied fields managed by other field managers, e.g,
+	// after being edited using kubectl; they are taken over when
+	// ForceConflicts is set, otherwise applying fails.
+	Conflicts []string
+}
+
+func (d ObjectDiff) String() string {
+	name := d.Name
+	if d.Namespace != "" {
+		name = d.Namespace + "/" + name
+	}
+	s := fmt.Sprintf("%s %s:\n%s", d.Kind, name, d.Diff)
+	if len(d.Conflicts) > 0 {
+		if ForceConflicts {
+			s += "conflicts, taken over from their managers:\n"
+		} else {
+			s += "conflicts, applying fails unless forced:\n"
+		}
+		s += indent(strings.Join(d.Conflicts, "\n"), "  ") + "\n"
+	}
+	return s
+}
+
+// DiffManifests returns the changes applying the manifests in the file or url
+// fn would make, computed using a server-side dry run, along with the
+// conflicts with other field managers; the objects which would not change are
+// omitted.
+func DiffManifests(ctx context.Context, cn string, ns string, fn string) ([]ObjectDiff, error) {
+	c, err := ClientFor(cn)
+	if err != nil {
+		return nil, err
+	}
+	objs, err := ReadManifests(ctx, fn)
+	if err != nil {
+		return nil, err
+	}
+	diffs := []ObjectDiff{}
+	for _, obj := range objs {
+		d, err := c.diff(ctx, obj, ns)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+		if d.Diff != "" || len(d.Conflicts) > 0 {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs, nil
+}
+
+// ClusterExists reports whether the cluster exists, so that no diff is
+// computed against the clusters which are yet to be created; it is set by the
+// cluster package.
+var ClusterExists = func(cn string) bool { return true }
+
+// DescribeDiff returns the changes applying the manifests in fn would make, to
+// be appended to the description of an action in plan mode; it is empty when
+// there are none, or the cluster does not exist yet, and reports why when
+// they can't be computed.
+func DescribeDiff(cn string, ns string, fn string) string {
+	if !ClusterExists(cn) {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	diffs, err := DiffManifests(ctx, cn, ns, fn)
+	if err != nil {
+		return "\n" + indent("diff unavailable: "+err.Error(), "      ")
+	}
+	desc := ""
+	for _, d := range diffs {
+		desc += "\n" + indent(d.String(), "      ")
+	}
+	return desc
+}
+
+// diff computes the change applying obj would make, using a server-side dry
+// run; the conflicts are found by a first dry run which does not force them.
+func (c *Client) diff(ctx context.Context, obj *unstructured.Unstructured, ns string) (ObjectDiff, error) {
+	ri, err := c.resourceInterface(obj, ns)
+	if err != nil {
+		return ObjectDiff{}, err
+	}
+	d := ObjectDiff{Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	if d.Namespace == "" {
+		d.Namespace = ns
+	}
+	live, err := ri.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		live = nil
+	} else if err != nil {
+		return d, err
+	}
+	opts := metav1.ApplyOptions{FieldManager: FieldManager, DryRun: []string{metav1.DryRunAll}}
+	applied, err := ri.Apply(ctx, obj.GetName(), obj, opts)
+	if apierrors.IsConflict(err) {
+		d.Conflicts = conflicts(err)
+		if !ForceConflicts {
+			return d, nil
+		}
+		opts.Force = true
+		applied, err = ri.Apply(ctx, obj.GetName(), obj, opts)
+	}
+	if err != nil {
+		return d, err
+	}
+	d.Diff, err = unifiedDiff(live, applied)
+	return d, err
+}
+
+// conflicts returns the fields and managers a server-side apply conflicted
+// with.
+func conflicts(err error) []string {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) || status.Status().Details == nil ||
+		len(status.Status().Details.Causes) == 0 {
+		return []string{err.Error()}
+	}
+	c := []string{}
+	for _, cause := range status.Status().Details.Causes {
+		c = append(c, fmt.Sprintf("%s: %s", cause.Field, cause.Message))
+	}
+	return c
+}
+
+// unifiedDiff returns the differences between the yaml of two versions of an
+// object, ignoring the fields set by the server; a is nil for a new object.
+// The values of secrets are redacted, see redactSecret.
+func unifiedDiff(a *unstructured.Unstructured, b *unstructured.Unstructured) (string, error) {
+	var live map[string]interface{}
+	if a != nil {
+		if !changed(a, b) {
+			return "", nil
+		}
+		live = withoutServerFields(a)
+	}
+	applied := withoutServerFields(b)
+	if b.GetKind() == "Secret" && b.GroupVersionKind().Group == "" {
+		redactSecret(live, applied)
+	}
+
+	var before []string
+	if live != nil {
+		data, err := yaml.Marshal(live)
+		if err != nil {
+			return "", err
+		}
+		before = difflib.SplitLines(strings.TrimSuffix(string(data), "\n"))
+	}
+	after, err := yaml.Marshal(applied)
+	if err != nil {
+		return "", err
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        before,
+		B:        difflib.SplitLines(strings.TrimSuffix(string(after), "\n")),
+		FromFile: "live",
+		ToFile:   "applied",
+		Context:  3,
+	})
+}
+
+// withoutServerFields returns the content of the object without the fields
+// set by the server.
+func withoutServerFields(u *unstructured.Unstructured) map[string]interface{} {
+	u = u.DeepCopy()
+	for _, f := range []string{"resourceVersion", "managedFields", "generation", "uid",
+		"creationTimestamp"} {
+		unstructured.RemoveNestedField(u.Object, "metadata", f)
+	}
+	unstructured.RemoveNestedField(u.Object, "status")
+	return u.Object
+}
+
+// redactSecret replaces the values of the live and applied versions of a
+// secret, so that they are not printed. The applied values only tell whether
+// they change, by comparison with the live ones, which are all redacted the
+// same way; the live version is nil for a new secret.
+func redactSecret(live map[string]interface{}, applied map[string]interface{}) {
+	for _, f := range []string{"data", "stringData"} {
+		liveValues, _ := live[f].(map[string]interface{})
+		appliedValues, _ := applied[f].(map[string]interface{})
+		unchanged := map[string]bool{}
+		for k, v := range appliedValues {
+			lv, ok := liveValues[k]
+			unchanged[k] = ok && equality.Semantic.DeepEqual(lv, v)
+			if unchanged[k] {
+				appliedValues[k] = "[REDACTED, unchanged]"
+			} else {
+				appliedValues[k] = "[REDACTED, changed]"
+			}
+		}
+		for k := range liveValues {
+			if unchanged[k] {
+				liveValues[k] = "[REDACTED, unchanged]"
+			} else {
+				liveValues[k] = "[REDACTED]"
+			}
+		}
+	}
+}
+
+// changed compares two versions of an object, ignoring the fields set by the
+// server.
+func changed(a *unstructured.Unstructured, b *unstructured.Unstructured) bool {
+	return !equality.Semantic.DeepEqual(withoutServerFields(a), withoutServerFields(b))
+}
+
+// indent prefixes each line of s.
+func indent(s string, prefix string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = prefix + l
+	}
+	return strings.Join(lines, "\n")
+}
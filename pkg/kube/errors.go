@@ -0,0 +1,65 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ApplyFailedError is returned when a manifest could not be applied to a
+// cluster.
+type ApplyFailedError struct {
+	ClusterName string
+	Namespace   string
+	File        string
+	Err         error
+}
+
+func (e *ApplyFailedError) Error() string {
+	return fmt.Sprintf("unable to apply %s to cluster %s: %s", e.File,
+		e.ClusterName, e.Err)
+}
+
+func (e *ApplyFailedError) Unwrap() error {
+	return e.Err
+}
+
+// WaitFailedError is returned when a resource did not reach the expected
+// condition in time.
+type WaitFailedError struct {
+	ClusterName string
+	Namespace   string
+	Resource    string
+	Condition   string
+	Err         error
+}
+
+func (e *WaitFailedError) Error() string {
+	return fmt.Sprintf("%s did not reach condition %s in cluster %s: %s",
+		e.Resource, e.Condition, e.ClusterName, e.Err)
+}
+
+func (e *WaitFailedError) Unwrap() error {
+	return e.Err
+}
+
+// ExecFailedError is returned when a command run in a pod failed.
+type ExecFailedError struct {
+	ClusterName string
+	Namespace   string
+	Workload    string
+	Stderr      string
+	Err         error
+}
+
+func (e *ExecFailedError) Error() string {
+	msg := strings.TrimSpace(e.Stderr)
+	if msg == "" {
+		msg = e.Err.Error()
+	}
+	return fmt.Sprintf("command failed in %s in cluster %s: %s", e.Workload,
+		e.ClusterName, msg)
+}
+
+func (e *ExecFailedError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,151 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// Exec runs a bash script in a pod of the deployment.
+func Exec(ctx context.Context, cn string, ns string, deploy string, cmdStr string) ([]byte, error) {
+	return ExecIn(ctx, cn, ns, "deploy/"+deploy, nil, "bash", "-c", cmdStr)
+}
+
+// ExecIn runs a command in a running pod of the workload, given as
+// kind/name, e.g, sts/lagoon-core-api-db, or as a pod name, and returns its
+// stdout.
+func ExecIn(ctx context.Context, cn string, ns string, workload string, stdin io.Reader, cmd ...string) ([]byte, error) {
+	logger := log.WithFields(log.Fields{
+		"clusterName": cn,
+		"namespace":   ns,
+		"workload":    workload,
+	})
+	fail := func(stderr string, err error) error {
+		return &ExecFailedError{ClusterName: cn, Namespace: ns, Workload: workload,
+			Stderr: stderr, Err: err}
+	}
+
+	c, err := ClientFor(cn)
+	if err != nil {
+		return nil, fail("", err)
+	}
+	pod, container, err := c.podFor(ctx, ns, workload)
+	if err != nil {
+		return nil, fail("", err)
+	}
+
+	logger.WithFields(log.Fields{"pod": pod, "container": container}).
+		Debug("running command in pod")
+	var stdout, stderr bytes.Buffer
+	start := time.Now()
+	err = c.Exec(ctx, ns, pod, container, cmd, stdin, &stdout, &stderr)
+	audit("exec", append([]string{cn, ns, pod}, cmd...), start, stderr.Bytes(), err)
+	if err != nil {
+		return stdout.Bytes(), fail(stderr.String(), err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// podFor returns a running pod of the workload and its default container.
+func (c *Client) podFor(ctx context.Context, ns string, workload string) (string, string, error) {
+	kind, name, found := strings.Cut(workload, "/")
+	if !found {
+		kind, name = "pods", workload
+	}
+	gvr, err := c.resourceFor(kind)
+	if err != nil {
+		return "", "", err
+	}
+
+	var pods []unstructured.Unstructured
+	if gvr.Resource == "pods" {
+		p, err := c.Dynamic.Resource(podsGVR).Namespace(ns).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", "", err
+		}
+		pods = append(pods, *p)
+	} else {
+		w, err := c.Dynamic.Resource(gvr).Namespace(ns).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", "", err
+		}
+		selector, err := labelSelector(w)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid selector for %s: %w", workload, err)
+		}
+		list, err := c.Dynamic.Resource(podsGVR).Namespace(ns).List(ctx,
+			metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return "", "", err
+		}
+		pods = list.Items
+	}
+
+	for _, u := range pods {
+		pod := corev1.Pod{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &pod); err != nil {
+			return "", "", err
+		}
+		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil ||
+			len(pod.Spec.Containers) == 0 {
+			continue
+		}
+		container := pod.Annotations["kubectl.kubernetes.io/default-container"]
+		if container == "" {
+			container = pod.Spec.Containers[0].Name
+		}
+		return pod.Name, container, nil
+	}
+	return "", "", fmt.Errorf("no running pod found for %s", workload)
+}
+
+// labelSelector returns the pod selector of a workload as a string.
+func labelSelector(w *unstructured.Unstructured) (string, error) {
+	m, found, err := unstructured.NestedMap(w.Object, "spec", "selector")
+	if err != nil || !found {
+		return "", fmt.Errorf("no selector found")
+	}
+	ls := metav1.LabelSelector{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &ls); err != nil {
+		return "", err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(&ls)
+	if err != nil {
+		return "", err
+	}
+	return selector.String(), nil
+}
+
+// spdyExec runs a command in a pod's container using the exec subresource.
+func (c *Client) spdyExec(ctx context.Context, ns string, pod string, container string,
+	cmd []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	req := c.Clientset.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(ns).Name(pod).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   cmd,
+			Stdin:     stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(c.Config, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+	return executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+}
@@ -0,0 +1,173 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// fakeKinds are the kinds known to the fake client's mapper; other kinds are
+// guessed to be namespaced.
+var fakeKinds = []struct {
+	gvk        schema.GroupVersionKind
+	namespaced bool
+}{
+	{schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, true},
+	{schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, false},
+	{schema.GroupVersionKind{Version: "v1", Kind: "Node"}, false},
+	{schema.GroupVersionKind{Version: "v1", Kind: "PersistentVolume"}, false},
+	{schema.GroupVersionKind{Version: "v1", Kind: "PersistentVolumeClaim"}, true},
+	{schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, true},
+	{schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, true},
+	{schema.GroupVersionKind{Version: "v1", Kind: "Service"}, true},
+	{schema.GroupVersionKind{Version: "v1", Kind: "ServiceAccount"}, true},
+	{schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"}, true},
+	{schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, true},
+	{schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}, true},
+	{schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}, true},
+	{schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}, true},
+	{schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, false},
+	{schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRoleBinding"}, false},
+	{schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "Role"}, true},
+	{schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "RoleBinding"}, true},
+	{schema.GroupVersionKind{Group: "storage.k8s.io", Version: "v1", Kind: "StorageClass"}, false},
+	{schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}, false},
+	{schema.GroupVersionKind{Group: "admissionregistration.k8s.io", Version: "v1", Kind: "MutatingWebhookConfiguration"}, false},
+	{schema.GroupVersionKind{Group: "admissionregistration.k8s.io", Version: "v1", Kind: "ValidatingWebhookConfiguration"}, false},
+	{schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "ClusterIssuer"}, false},
+	{schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Issuer"}, true},
+	{schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}, true},
+}
+
+// fakeMapper maps the fakeKinds, and guesses the resource of other kinds.
+type fakeMapper struct {
+	*meta.DefaultRESTMapper
+}
+
+func (m fakeMapper) RESTMapping(gk schema.GroupKind, versions ...string) (*meta.RESTMapping, error) {
+	mapping, err := m.DefaultRESTMapper.RESTMapping(gk, versions...)
+	if err == nil || !meta.IsNoMatchError(err) || len(versions) == 0 {
+		return mapping, err
+	}
+	gvk := gk.WithVersion(versions[0])
+	plural, _ := meta.UnsafeGuessKindToResource(gvk)
+	return &meta.RESTMapping{Resource: plural, GroupVersionKind: gvk,
+		Scope: meta.RESTScopeNamespace}, nil
+}
+
+// NewFakeClient creates a client backed by an in-memory object tracker
+// holding the given objects, for testing; the commands run in pods are
+// passed to exec.
+func NewFakeClient(exec ExecFunc, objects ...runtime.Object) *Client {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	listKinds := map[schema.GroupVersionResource]string{}
+	for _, k := range fakeKinds {
+		scope := meta.RESTScopeRoot
+		if k.namespaced {
+			scope = meta.RESTScopeNamespace
+		}
+		mapper.Add(k.gvk, scope)
+		plural, _ := meta.UnsafeGuessKindToResource(k.gvk)
+		listKinds[plural] = k.gvk.Kind + "List"
+	}
+
+	unstructuredObjects := []runtime.Object{}
+	for _, o := range objects {
+		unstructuredObjects = append(unstructuredObjects, toUnstructured(o))
+	}
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		listKinds, unstructuredObjects...)
+	dyn.PrependReactor("patch", "*", applyReactor(dyn.Tracker()))
+
+	if exec == nil {
+		exec = func(ctx context.Context, ns string, pod string, container string,
+			cmd []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+			return fmt.Errorf("exec is not supported by the fake client")
+		}
+	}
+	return &Client{
+		Config:  &rest.Config{},
+		Dynamic: dyn,
+		Mapper:  fakeMapper{mapper},
+		Exec:    exec,
+	}
+}
+
+// InstallFakeClient makes all clusters use the client, returning a function
+// which restores the previous behaviour.
+func InstallFakeClient(c *Client) func() {
+	prev := NewClient
+	ResetClients()
+	NewClient = func(cn string) (*Client, error) { return c, nil }
+	return func() {
+		NewClient = prev
+		ResetClients()
+	}
+}
+
+// toUnstructured converts a typed object, e.g, a corev1.Secret.
+func toUnstructured(o runtime.Object) *unstructured.Unstructured {
+	if u, ok := o.(*unstructured.Unstructured); ok {
+		return u
+	}
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o)
+	if err != nil {
+		panic(err)
+	}
+	u := &unstructured.Unstructured{Object: m}
+	if gvks, _, err := scheme.Scheme.ObjectKinds(o); err == nil && len(gvks) > 0 {
+		u.SetGroupVersionKind(gvks[0])
+	}
+	return u
+}
+
+// applyReactor implements server-side apply for the fake client; the applied
+// fields are merged into the existing object, if any. Dry runs are not
+// supported, the changes being persisted.
+func applyReactor(tracker clienttesting.ObjectTracker) clienttesting.ReactionFunc {
+	return func(action clienttesting.Action) (bool, runtime.Object, error) {
+		pa, ok := action.(clienttesting.PatchAction)
+		if !ok || pa.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		applied := &unstructured.Unstructured{}
+		if err := json.Unmarshal(pa.GetPatch(), &applied.Object); err != nil {
+			return true, nil, err
+		}
+		gvr, ns := pa.GetResource(), pa.GetNamespace()
+		existing, err := tracker.Get(gvr, ns, pa.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, applied, tracker.Create(gvr, applied, ns)
+		} else if err != nil {
+			return true, nil, err
+		}
+		merged := toUnstructured(existing).DeepCopy()
+		mergeMaps(merged.Object, applied.Object)
+		return true, merged, tracker.Update(gvr, merged, ns)
+	}
+}
+
+func mergeMaps(dst map[string]interface{}, src map[string]interface{}) {
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeMaps(dstMap, srcMap)
+			continue
+		}
+		dst[k] = v
+	}
+}
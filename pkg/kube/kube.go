@@ -1,11 +1,16 @@
 package kube
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 
@@ -14,69 +19,229 @@ import (
 	"github.com/salsadigitalauorg/rockpool/pkg/platform/templates"
 
 	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
 )
 
+var (
+	secretsGVR    = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	configMapsGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	podsGVR       = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+)
+
+// LocalManifests are the paths of the files used instead of downloading the
+// manifests, by URL; they are set when installing from a bundle.
+var LocalManifests = map[string]string{}
+
+// Kubeconfigs holds the kubeconfig of the clusters which have not been
+// created by rockpool, by cluster name.
+var Kubeconfigs = map[string]string{}
+
 func KubeconfigPath(clusterName string) string {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		panic(fmt.Sprintln("unable to get user home directory:", err))
+	if kc, ok := Kubeconfigs[clusterName]; ok {
+		return kc
 	}
+	home, _ := os.UserHomeDir()
 	return fmt.Sprintf("%s/.k3d/kubeconfig-%s.yaml", home, clusterName)
 }
 
-func GetTargetIdFromCn(cn string) int {
-	cnParts := strings.Split(cn, "-")
-	idStr := cnParts[len(cnParts)-1]
-	id, err := strconv.Atoi(idStr)
+// Apply applies the manifests in the file or url fn using server-side apply;
+// objects without a namespace are created in ns. When force is set, objects
+// which cannot be updated, e.g, because of an immutable field, are deleted
+// and created again.
+func Apply(ctx context.Context, cn string, ns string, fn string, force bool) (err error) {
+	start := time.Now()
+	defer func() { audit("apply", []string{cn, ns, fn}, start, nil, err) }()
+	logger := log.WithFields(log.Fields{
+		"clusterName": cn,
+		"namespace":   ns,
+		"file":        fn,
+		"force":       force,
+	})
+	c, err := ClientFor(cn)
+	if err != nil {
+		return &ApplyFailedError{ClusterName: cn, Namespace: ns, File: fn, Err: err}
+	}
+	objs, err := ReadManifests(ctx, fn)
+	if err != nil {
+		return &ApplyFailedError{ClusterName: cn, Namespace: ns, File: fn, Err: err}
+	}
+
+	logger.WithField("objects", len(objs)).Debug("applying manifest")
+	for _, obj := range objs {
+		if Diff {
+			c.logDiff(ctx, obj, ns)
+		}
+		if err := c.apply(ctx, obj, ns, force); err != nil {
+			return &ApplyFailedError{ClusterName: cn, Namespace: ns, File: fn,
+				Err: fmt.Errorf("%s %s: %w", obj.GetKind(), obj.GetName(), err)}
+		}
+	}
+	return nil
+}
+
+func (c *Client) apply(ctx context.Context, obj *unstructured.Unstructured, ns string, force bool) error {
+	ri, err := c.resourceInterface(obj, ns)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"cluster": cn,
-			"id":      idStr,
-		}).Fatal("invalid cluster id")
+		return err
+	}
+	// Rockpool takes ownership of the fields it applies, including the ones
+	// set by a previous client-side apply, unless conflicts are not forced.
+	opts := metav1.ApplyOptions{FieldManager: FieldManager, Force: ForceConflicts}
+	_, err = ri.Apply(ctx, obj.GetName(), obj, opts)
+	if err == nil || !force || !apierrors.IsInvalid(err) {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"kind":      obj.GetKind(),
+		"name":      obj.GetName(),
+		"namespace": obj.GetNamespace(),
+	}).WithError(err).Debug("recreating object")
+	propagation := metav1.DeletePropagationForeground
+	err = ri.Delete(ctx, obj.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	_, err = ri.Apply(ctx, obj.GetName(), obj, opts)
+	return err
+}
+
+// logDiff logs the changes applying obj is about to make; the errors are left
+// to be reported by the apply.
+func (c *Client) logDiff(ctx context.Context, obj *unstructured.Unstructured, ns string) {
+	d, err := c.diff(ctx, obj, ns)
+	if err != nil || (d.Diff == "" && len(d.Conflicts) == 0) {
+		return
+	}
+	logger := log.WithFields(log.Fields{
+		"kind":      d.Kind,
+		"name":      d.Name,
+		"namespace": d.Namespace,
+	})
+	if len(d.Conflicts) > 0 {
+		logger = logger.WithField("conflicts", d.Conflicts)
 	}
-	return id
+	logger.Info("applying changes:\n" + d.Diff)
 }
 
-func Cmd(cn string, ns string, args ...string) command.IShellCommand {
-	cmd := command.ShellCommander("kubectl", "--kubeconfig", KubeconfigPath(cn))
-	if ns != "" {
-		cmd.AddArgs("--namespace", ns)
+// audit writes a call to the API server to the audit log, alongside the
+// commands run, e.g, as kube:apply.
+func audit(verb string, args []string, start time.Time, stderr []byte, err error) {
+	if command.Audit != nil {
+		command.Audit.Log(append([]string{"kube:" + verb}, args...), "", start, stderr, err)
 	}
-	cmd.AddArgs(args...)
-	return cmd
 }
 
-func Apply(cn string, ns string, fn string, force bool) error {
-	// dry-run first to check for changes.
-	out, err := Cmd(cn, ns, "apply", "-f", fn, "--dry-run=server").Output()
+// ReadManifests reads the objects in the yaml or json file, or url, fn.
+func ReadManifests(ctx context.Context, fn string) ([]*unstructured.Unstructured, error) {
+	var data []byte
+	var err error
+	if p, ok := LocalManifests[fn]; ok {
+		data, err = os.ReadFile(p)
+	} else if strings.HasPrefix(fn, "http://") || strings.HasPrefix(fn, "https://") {
+		data, err = Download(ctx, fn)
+	} else {
+		data, err = os.ReadFile(fn)
+	}
 	if err != nil {
-		return command.GetMsgFromCommandError(err)
+		return nil, err
 	}
-	changesRequired := false
-	for _, l := range strings.Split(strings.Trim(string(out), "\n"), "\n") {
-		if !strings.Contains(l, "unchanged (server dry run)") {
-			changesRequired = true
+	return DecodeManifests(data)
+}
+
+// DecodeManifests parses the objects in a multi-document yaml or json.
+func DecodeManifests(data []byte) ([]*unstructured.Unstructured, error) {
+	objs := []*unstructured.Unstructured{}
+	decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		var m map[string]interface{}
+		if err := decoder.Decode(&m); errors.Is(err, io.EOF) {
 			break
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to parse manifest: %w", err)
+		}
+		if len(m) == 0 {
+			continue
+		}
+		obj := &unstructured.Unstructured{Object: m}
+		if obj.GetKind() == "" {
+			return nil, fmt.Errorf("missing kind in manifest for %s", obj.GetName())
+		}
+		if !obj.IsList() {
+			objs = append(objs, obj)
+			continue
+		}
+		err := obj.EachListItem(func(item runtime.Object) error {
+			objs = append(objs, item.(*unstructured.Unstructured))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objs, nil
+}
+
+// ManifestImages returns the sorted images referenced by the objects in a
+// multi-document yaml or json, i.e, the string values of their image fields.
+func ManifestImages(data []byte) ([]string, error) {
+	objs, err := DecodeManifests(data)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, f := range v {
+				if img, ok := f.(string); ok && k == "image" && img != "" {
+					seen[img] = true
+					continue
+				}
+				walk(f)
+			}
+		case []interface{}:
+			for _, f := range v {
+				walk(f)
+			}
 		}
 	}
-	if !changesRequired {
-		return nil
+	for _, obj := range objs {
+		walk(obj.Object)
 	}
+	images := []string{}
+	for img := range seen {
+		images = append(images, img)
+	}
+	sort.Strings(images)
+	return images, nil
+}
 
-	cmd := Cmd(cn, ns, "apply", "-f", fn)
-	if force {
-		cmd.AddArgs("--force=true")
+// Download fetches the content at the url.
+func Download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
 	}
-	log.WithFields(log.Fields{
-		"clusterName": cn,
-		"namespace":   ns,
-		"file":        fn,
-		"force":       force,
-	}).Debug("applying manifest")
-	return cmd.RunProgressive()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
 }
 
-func ApplyTemplate(cn string, ns string, fn string, force bool, retries int, delay int) {
+func ApplyTemplate(ctx context.Context, cn string, ns string, fn string, force bool, retries int, delay int) error {
 	logger := log.WithFields(log.Fields{
 		"clusterName": cn,
 		"namespace":   ns,
@@ -86,42 +251,22 @@ func ApplyTemplate(cn string, ns string, fn string, force bool, retries int, del
 
 	f, err := templates.Render(fn, platform.ToMap(), "")
 	if err != nil {
-		logger.Fatal("unable to render template")
+		return fmt.Errorf("unable to render template %s: %w", fn, err)
 	}
 	logger.Debug("applying generated manifest")
-	err = Apply(cn, ns, f, force)
-	if err != nil {
-		var failedErr error
-		if retries > 0 {
-			failed := true
-			for retries > 0 && failed {
-				failedErr = nil
-				err = Apply(cn, ns, f, force)
-				if err != nil {
-					failed = true
-					failedErr = err
-					retries--
-					time.Sleep(time.Duration(delay) * time.Second)
-					continue
-				}
-				failed = false
-			}
-		}
-
-		if failedErr != nil {
-			logger.WithField("retryFailedErr", failedErr)
+	err = Apply(ctx, cn, ns, f, force)
+	for err != nil && retries > 0 {
+		logger.WithError(err).Debug("retrying apply")
+		retries--
+		if err := platform.Sleep(ctx, time.Duration(delay)*time.Second); err != nil {
+			return err
 		}
-
-		logger.Fatal("unable to apply generated template")
+		err = Apply(ctx, cn, ns, f, force)
 	}
-
+	return err
 }
 
-func Exec(cn string, ns string, deploy string, cmdStr string) command.IShellCommand {
-	return Cmd(cn, ns, "exec", "deploy/"+deploy, "--", "bash", "-c", cmdStr)
-}
-
-func GetSecret(cn string, ns string, secret string, field string) ([]byte, string) {
+func GetSecret(ctx context.Context, cn string, ns string, secret string, field string) ([]byte, string, error) {
 	logger := log.WithFields(log.Fields{
 		"clusterName": cn,
 		"namespace":   ns,
@@ -130,33 +275,60 @@ func GetSecret(cn string, ns string, secret string, field string) ([]byte, strin
 	})
 	logger.Debug("fetching secret")
 
-	cmd := Cmd(cn, ns, "get", "secret", secret, "--output")
-	if field != "" {
-		cmd.AddArgs(fmt.Sprintf("jsonpath='{.data.%s}'", field))
-	} else {
-		cmd.AddArgs("json")
+	c, err := ClientFor(cn)
+	if err != nil {
+		return nil, "", err
+	}
+	s, err := c.Dynamic.Resource(secretsGVR).Namespace(ns).Get(ctx, secret, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", fmt.Errorf("error getting secret %s: %w", secret, err)
 	}
 
-	out, err := cmd.Output()
-	logger.WithField("out", string(out)).Debug()
+	if field == "" {
+		out, err := s.MarshalJSON()
+		return out, "", err
+	}
+	val, found, err := unstructured.NestedString(s.Object, "data", field)
+	if err != nil || !found {
+		return nil, "", fmt.Errorf("field %s not found in secret %s", field, secret)
+	}
+	logger.Debug("decoding secret")
+	decoded, err := base64.StdEncoding.DecodeString(val)
 	if err != nil {
-		logger.WithError(command.GetMsgFromCommandError(err)).
-			Fatal("error getting secret")
+		return nil, "", fmt.Errorf("error decoding secret %s: %w", secret, err)
 	}
+	return nil, string(decoded), nil
+}
 
-	if field != "" {
-		logger.Debug("decoding secret")
-		out = []byte(strings.Trim(string(out), "'"))
-		if decoded, err := base64.URLEncoding.DecodeString(string(out)); err != nil {
-			logger.WithError(err).Fatal("error decoding secret")
-		} else {
-			return nil, string(decoded)
+// ServiceAccountToken returns the token of a service account from its
+// secret.
+func ServiceAccountToken(ctx context.Context, cn string, ns string, sa string) (string, error) {
+	c, err := ClientFor(cn)
+	if err != nil {
+		return "", err
+	}
+	secrets, err := c.Dynamic.Resource(secretsGVR).Namespace(ns).List(ctx, metav1.ListOptions{
+		FieldSelector: "type=kubernetes.io/service-account-token",
+	})
+	if err != nil {
+		return "", fmt.Errorf("error listing secrets: %w", err)
+	}
+	for _, s := range secrets.Items {
+		if s.Object["type"] != "kubernetes.io/service-account-token" ||
+			s.GetAnnotations()["kubernetes.io/service-account.name"] != sa {
+			continue
 		}
+		token, _, _ := unstructured.NestedString(s.Object, "data", "token")
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return "", fmt.Errorf("error decoding token for %s: %w", sa, err)
+		}
+		return string(decoded), nil
 	}
-	return out, ""
+	return "", fmt.Errorf("no token found for service account %s", sa)
 }
 
-func GetConfigMap(cn string, ns string, name string) []byte {
+func GetConfigMap(ctx context.Context, cn string, ns string, name string) ([]byte, error) {
 	logger := log.WithFields(log.Fields{
 		"clusterName": cn,
 		"namespace":   ns,
@@ -164,16 +336,19 @@ func GetConfigMap(cn string, ns string, name string) []byte {
 	})
 	logger.Debug("fetching ConfigMap")
 
-	cmd := Cmd(cn, ns, "get", "configmap", name, "--output", "json")
-	out, err := cmd.Output()
+	c, err := ClientFor(cn)
 	if err != nil {
-		logger.WithError(command.GetMsgFromCommandError(err)).
-			Fatal("error getting configmap")
+		return nil, err
 	}
-	return out
+	cm, err := c.Dynamic.Resource(configMapsGVR).Namespace(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting configmap %s: %w", name, err)
+	}
+	return cm.MarshalJSON()
 }
 
-func Replace(cn string, ns string, name string, content string) {
+// Replace updates the object in content, a yaml or json manifest.
+func Replace(ctx context.Context, cn string, ns string, name string, content string) error {
 	logger := log.WithFields(log.Fields{
 		"clusterName": cn,
 		"namespace":   ns,
@@ -182,27 +357,32 @@ func Replace(cn string, ns string, name string, content string) {
 	})
 	logger.Debug("replacing manifest")
 
-	cat := command.ShellCommander("echo", content)
-	replace := Cmd(cn, ns, "replace", "-f", "-")
-
-	reader, writer := io.Pipe()
-	cat.SetStdout(writer)
-	replace.SetStdin(reader)
-
-	replace.SetStdout(os.Stdout)
-
-	cat.Start()
-	replace.Start()
-	cat.Wait()
-	writer.Close()
-
-	if err := replace.Wait(); err != nil {
-		logger.WithError(command.GetMsgFromCommandError(err)).
-			Fatal("error replacing manifest")
+	c, err := ClientFor(cn)
+	if err != nil {
+		return err
 	}
+	objs, err := DecodeManifests([]byte(content))
+	if err != nil {
+		return fmt.Errorf("error replacing %s: %w", name, err)
+	}
+	for _, obj := range objs {
+		ri, err := c.resourceInterface(obj, ns)
+		if err != nil {
+			return fmt.Errorf("error replacing %s: %w", name, err)
+		}
+		_, err = ri.Update(ctx, obj, metav1.UpdateOptions{FieldManager: FieldManager})
+		if err != nil {
+			return fmt.Errorf("error replacing %s: %w", name, err)
+		}
+	}
+	return nil
 }
 
-func Patch(cn string, ns string, kind string, name string, fn string) ([]byte, error) {
+// Patch applies the strategic merge patch in the file fn to an object. The
+// patched object is returned, or nil if the patch did not change it.
+func Patch(ctx context.Context, cn string, ns string, kind string, name string, fn string) (_ []byte, err error) {
+	start := time.Now()
+	defer func() { audit("patch", []string{cn, ns, kind + "/" + name, fn}, start, nil, err) }()
 	logger := log.WithFields(log.Fields{
 		"clusterName": cn,
 		"namespace":   ns,
@@ -212,14 +392,78 @@ func Patch(cn string, ns string, kind string, name string, fn string) ([]byte, e
 	})
 	logger.Debug("applying patch")
 
-	out, err := Cmd(cn, ns, "patch", kind, name, "--patch-file", fn,
-		"--dry-run=server").Output()
+	fail := func(err error) error {
+		return &ApplyFailedError{ClusterName: cn, Namespace: ns, File: fn, Err: err}
+	}
+	c, err := ClientFor(cn)
+	if err != nil {
+		return nil, fail(err)
+	}
+	gvr, err := c.resourceFor(kind)
 	if err != nil {
-		logger.WithError(command.GetMsgFromCommandError(err)).
-			Fatal("error executing dry-run patch")
+		return nil, fail(err)
 	}
-	if strings.Contains(string(out), "(no change)") {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, fail(err)
+	}
+	patch, err := yamlutil.ToJSON(data)
+	if err != nil {
+		return nil, fail(err)
+	}
+
+	ri := c.namespaced(gvr, ns)
+	current, err := ri.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fail(err)
+	}
+	patched, err := ri.Patch(ctx, name, types.StrategicMergePatchType, patch,
+		metav1.PatchOptions{FieldManager: FieldManager, DryRun: []string{metav1.DryRunAll}})
+	if err != nil {
+		return nil, fail(err)
+	}
+	if !changed(current, patched) {
 		return nil, nil
 	}
-	return Cmd(cn, ns, "patch", kind, name, "--patch-file", fn).Output()
+	if Diff {
+		if d, err := unifiedDiff(current, patched); err == nil {
+			logger.Info("patching changes:\n" + d)
+		}
+	}
+	patched, err = ri.Patch(ctx, name, types.StrategicMergePatchType, patch,
+		metav1.PatchOptions{FieldManager: FieldManager})
+	if err != nil {
+		return nil, fail(err)
+	}
+	return patched.MarshalJSON()
+}
+
+// RolloutRestart restarts the pods of a workload, as done by
+// 'kubectl rollout restart'.
+func RolloutRestart(ctx context.Context, cn string, ns string, kind string, name string) error {
+	c, err := ClientFor(cn)
+	if err != nil {
+		return err
+	}
+	gvr, err := c.resourceFor(kind)
+	if err != nil {
+		return err
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						"kubectl.kubernetes.io/restartedAt": time.Now().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	})
+	_, err = c.namespaced(gvr, ns).Patch(ctx, name, types.MergePatchType, patch,
+		metav1.PatchOptions{FieldManager: FieldManager})
+	if err != nil {
+		return fmt.Errorf("unable to restart %s/%s: %w", kind, name, err)
+	}
+	return nil
 }
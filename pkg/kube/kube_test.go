@@ -0,0 +1,622 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/command"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var deploymentsGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+func installFake(t *testing.T, exec ExecFunc, objects ...runtime.Object) *Client {
+	t.Helper()
+	c := NewFakeClient(exec, objects...)
+	t.Cleanup(InstallFakeClient(c))
+	return c
+}
+
+func writeManifest(t *testing.T, content string) string {
+	t.Helper()
+	fn := filepath.Join(t.TempDir(), "manifest.yml")
+	if err := os.WriteFile(fn, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func TestApply(t *testing.T) {
+	c := installFake(t, nil)
+	fn := writeManifest(t, `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: harbor
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: harbor-config
+data:
+  key: value
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Secret
+  metadata:
+    name: harbor-secret
+    namespace: other
+`)
+	ctx := context.Background()
+	if err := Apply(ctx, "rockpool-controller", "harbor", fn, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Dynamic.Resource(schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}).
+		Get(ctx, "harbor", metav1.GetOptions{}); err != nil {
+		t.Errorf("namespace not created: %s", err)
+	}
+	cm, err := c.Dynamic.Resource(configMapsGVR).Namespace("harbor").Get(ctx, "harbor-config", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("configmap not created in the default namespace: %s", err)
+	}
+	if v, _, _ := unstructured.NestedString(cm.Object, "data", "key"); v != "value" {
+		t.Errorf("got value %q", v)
+	}
+	if _, err := c.Dynamic.Resource(secretsGVR).Namespace("other").
+		Get(ctx, "harbor-secret", metav1.GetOptions{}); err != nil {
+		t.Errorf("list item not created in its namespace: %s", err)
+	}
+
+	// Applying again updates the existing objects.
+	fn = writeManifest(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: harbor-config
+data:
+  key: updated
+`)
+	if err := Apply(ctx, "rockpool-controller", "harbor", fn, false); err != nil {
+		t.Fatal(err)
+	}
+	cm, _ = c.Dynamic.Resource(configMapsGVR).Namespace("harbor").Get(ctx, "harbor-config", metav1.GetOptions{})
+	if v, _, _ := unstructured.NestedString(cm.Object, "data", "key"); v != "updated" {
+		t.Errorf("got value %q after update", v)
+	}
+}
+
+func TestApplyAudit(t *testing.T) {
+	installFake(t, nil)
+	dir := t.TempDir()
+	l, err := command.OpenAuditLog(dir, []string{"rockpool", "up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	command.Audit = l
+	t.Cleanup(func() {
+		command.Audit = nil
+		l.Close()
+	})
+
+	fn := writeManifest(t, "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: harbor\n")
+	if err := Apply(context.Background(), "rockpool-controller", "harbor", fn, false); err != nil {
+		t.Fatal(err)
+	}
+	logs, _ := command.AuditLogs(dir)
+	if len(logs) != 1 {
+		t.Fatalf("expected an audit log, got %q", logs)
+	}
+	run, err := command.ReadAuditLog(logs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Commands) != 1 || strings.Join(run.Commands[0].Args, " ") !=
+		"kube:apply rockpool-controller harbor "+fn {
+		t.Errorf("got audited commands %v", run.Commands)
+	}
+}
+
+func TestApplyInvalidManifest(t *testing.T) {
+	installFake(t, nil)
+	fn := writeManifest(t, "metadata:\n  name: no-kind\n")
+	err := Apply(context.Background(), "rockpool-controller", "harbor", fn, false)
+	var applyErr *ApplyFailedError
+	if !errors.As(err, &applyErr) || applyErr.File != fn {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestManifestImages(t *testing.T) {
+	images, err := ManifestImages([]byte(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: init
+          image: busybox:1.36
+      containers:
+        - name: web
+          image: nginx@sha256:abc
+        - name: sidecar
+          image: busybox:1.36
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  registry: registry.example.com
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(images, []string{"busybox:1.36", "nginx@sha256:abc"}) {
+		t.Errorf("got images %v", images)
+	}
+}
+
+func TestGetSecret(t *testing.T) {
+	installFake(t, nil, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "lagoon-core-broker", Namespace: "lagoon-core"},
+		Data:       map[string][]byte{"RABBITMQ_PASSWORD": []byte("s3cr3t+/")},
+	})
+	ctx := context.Background()
+	_, password, err := GetSecret(ctx, "rockpool-controller", "lagoon-core", "lagoon-core-broker", "RABBITMQ_PASSWORD")
+	if err != nil || password != "s3cr3t+/" {
+		t.Errorf("got %q, %v", password, err)
+	}
+	out, _, err := GetSecret(ctx, "rockpool-controller", "lagoon-core", "lagoon-core-broker", "")
+	if err != nil || !strings.Contains(string(out), `"RABBITMQ_PASSWORD":"czNjcjN0Ky8="`) {
+		t.Errorf("got %s, %v", out, err)
+	}
+	if _, _, err := GetSecret(ctx, "rockpool-controller", "lagoon-core", "lagoon-core-broker", "missing"); err == nil {
+		t.Error("expected an error for a missing field")
+	}
+}
+
+func TestServiceAccountToken(t *testing.T) {
+	installFake(t, nil,
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "other-token", Namespace: "lagoon",
+				Annotations: map[string]string{"kubernetes.io/service-account.name": "other"}},
+			Type: corev1.SecretTypeServiceAccountToken,
+			Data: map[string][]byte{"token": []byte("other")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "build-deploy-token", Namespace: "lagoon",
+				Annotations: map[string]string{"kubernetes.io/service-account.name": "lagoon-remote-kubernetes-build-deploy"}},
+			Type: corev1.SecretTypeServiceAccountToken,
+			Data: map[string][]byte{"token": []byte("eyJhbGciOi")},
+		},
+	)
+	token, err := ServiceAccountToken(context.Background(), "rockpool-target-1", "lagoon",
+		"lagoon-remote-kubernetes-build-deploy")
+	if err != nil || token != "eyJhbGciOi" {
+		t.Errorf("got %q, %v", token, err)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	c := installFake(t, nil, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: "kube-system"},
+		Data:       map[string]string{"NodeHosts": "172.18.0.2 host\n"},
+	})
+	ctx := context.Background()
+	cm, err := GetConfigMap(ctx, "rockpool-target-1", "kube-system", "coredns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := strings.Replace(string(cm), `172.18.0.2 host\n`, `172.18.0.2 host\n172.18.0.3 harbor\n`, 1)
+	if err := Replace(ctx, "rockpool-target-1", "kube-system", "coredns", updated); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := c.Dynamic.Resource(configMapsGVR).Namespace("kube-system").Get(ctx, "coredns", metav1.GetOptions{})
+	if v, _, _ := unstructured.NestedString(u.Object, "data", "NodeHosts"); v != "172.18.0.2 host\n172.18.0.3 harbor\n" {
+		t.Errorf("got %q", v)
+	}
+}
+
+func TestExecIn(t *testing.T) {
+	labels := map[string]string{"app": "keycloak"}
+	pod := func(name string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "lagoon-core", Labels: labels},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "keycloak"}}},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+	var gotPod, gotContainer string
+	var gotCmd []string
+	exec := func(ctx context.Context, ns string, pod string, container string,
+		cmd []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+		gotPod, gotContainer, gotCmd = pod, container, cmd
+		if cmd[2] == "exit 1" {
+			fmt.Fprint(stderr, "command failed")
+			return errors.New("command terminated with exit code 1")
+		}
+		fmt.Fprint(stdout, "output")
+		return nil
+	}
+	installFake(t, exec,
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "lagoon-core-keycloak", Namespace: "lagoon-core"},
+			Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: labels}},
+		},
+		pod("keycloak-pending", corev1.PodPending),
+		pod("keycloak-running", corev1.PodRunning),
+	)
+
+	ctx := context.Background()
+	out, err := Exec(ctx, "rockpool-controller", "lagoon-core", "lagoon-core-keycloak", "echo output")
+	if err != nil || string(out) != "output" {
+		t.Fatalf("got %q, %v", out, err)
+	}
+	if gotPod != "keycloak-running" || gotContainer != "keycloak" ||
+		strings.Join(gotCmd, " ") != "bash -c echo output" {
+		t.Errorf("ran %q in %s/%s", gotCmd, gotPod, gotContainer)
+	}
+
+	_, err = Exec(ctx, "rockpool-controller", "lagoon-core", "lagoon-core-keycloak", "exit 1")
+	var execErr *ExecFailedError
+	if !errors.As(err, &execErr) || !strings.HasSuffix(err.Error(), ": command failed") {
+		t.Errorf("got error %v", err)
+	}
+	if _, err := Exec(ctx, "rockpool-controller", "lagoon-core", "missing", "true"); err == nil {
+		t.Error("expected an error for a missing deployment")
+	}
+}
+
+func TestWaiter(t *testing.T) {
+	c := installFake(t, nil, &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "cert-manager-webhook", Namespace: "cert-manager"},
+	})
+	w := Waiter{
+		ClusterName: "rockpool-controller",
+		Namespace:   "cert-manager",
+		Resource:    "deployment/cert-manager-webhook",
+		Condition:   "Available=true",
+		Retries:     5,
+		Delay:       1,
+	}
+
+	ctx := context.Background()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		d, _ := c.Dynamic.Resource(deploymentsGVR).Namespace("cert-manager").
+			Get(ctx, "cert-manager-webhook", metav1.GetOptions{})
+		unstructured.SetNestedSlice(d.Object, []interface{}{
+			map[string]interface{}{"type": "Available", "status": "True"},
+		}, "status", "conditions")
+		c.Dynamic.Resource(deploymentsGVR).Namespace("cert-manager").Update(ctx, d, metav1.UpdateOptions{})
+	}()
+	if err := w.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaiterTimeout(t *testing.T) {
+	installFake(t, nil)
+	w := Waiter{
+		ClusterName: "rockpool-controller",
+		Namespace:   "cert-manager",
+		Resource:    "deployment/cert-manager-webhook",
+		Condition:   "Available=true",
+		Retries:     1,
+		Delay:       1,
+	}
+	err := w.Execute(context.Background())
+	var waitErr *WaitFailedError
+	if !errors.As(err, &waitErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestWaiterRollout(t *testing.T) {
+	replicas := int32(1)
+	deployment := func(name string, available int32) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "lagoon-core", Generation: 2},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status: appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1,
+				UpdatedReplicas: 1, AvailableReplicas: available},
+		}
+	}
+	c := installFake(t, nil, deployment("lagoon-core-api", 1),
+		deployment("lagoon-core-keycloak", 0))
+	w := Waiter{
+		ClusterName: "rockpool-controller",
+		Namespace:   "lagoon-core",
+		Resource:    "deployment",
+		Timeout:     200 * time.Millisecond,
+	}
+	err := w.Execute(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "still waiting for lagoon-core-keycloak:") {
+		t.Errorf("expected the pending deployment to be reported, got %v", err)
+	}
+
+	ctx := context.Background()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		d, _ := c.Dynamic.Resource(deploymentsGVR).Namespace("lagoon-core").
+			Get(ctx, "lagoon-core-keycloak", metav1.GetOptions{})
+		unstructured.SetNestedField(d.Object, int64(1), "status", "availableReplicas")
+		c.Dynamic.Resource(deploymentsGVR).Namespace("lagoon-core").Update(ctx, d, metav1.UpdateOptions{})
+	}()
+	w.Timeout = 5 * time.Second
+	if err := w.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expiringWatcher lists a pod, which is only ready from the second list, and
+// expires its watches.
+type expiringWatcher struct {
+	lists int
+}
+
+func (e *expiringWatcher) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	e.lists++
+	ready := corev1.ConditionFalse
+	if e.lists > 1 {
+		ready = corev1.ConditionTrue
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "gitea-0", Namespace: "gitea"},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning, Conditions: []corev1.PodCondition{
+			{Type: corev1.PodReady, Status: ready},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.UnstructuredList{Items: []unstructured.Unstructured{{Object: obj}}}, nil
+}
+
+func (e *expiringWatcher) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	w := watch.NewFake()
+	go w.Error(&apierrors.NewResourceExpired("too old resource version").ErrStatus)
+	return w, nil
+}
+
+func TestWaitForExpiredWatch(t *testing.T) {
+	ri := &expiringWatcher{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := (&Client{}).waitFor(ctx, "pods", ri, metav1.ListOptions{}, podReady); err != nil {
+		t.Fatal(err)
+	}
+	if ri.lists != 2 {
+		t.Errorf("expected the pods to be listed again, got %d lists", ri.lists)
+	}
+}
+
+func TestWaiterPods(t *testing.T) {
+	pod := func(name string, phase corev1.PodPhase, ready corev1.ConditionStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "gitea",
+				Labels: map[string]string{"app": strings.Split(name, "-")[0]}},
+			Status: corev1.PodStatus{Phase: phase, Conditions: []corev1.PodCondition{
+				{Type: corev1.PodReady, Status: ready},
+			}},
+		}
+	}
+	installFake(t, nil,
+		pod("gitea-0", corev1.PodRunning, corev1.ConditionTrue),
+		pod("migrate-x1", corev1.PodSucceeded, corev1.ConditionFalse),
+		pod("memcached-0", corev1.PodRunning, corev1.ConditionFalse),
+	)
+	ctx := context.Background()
+	for _, tc := range []struct {
+		w    Waiter
+		done bool
+	}{
+		{Waiter{Resource: "pod", Selector: "app in (gitea,migrate)"}, true},
+		{Waiter{Resource: "pod"}, false},
+		{Waiter{Resource: "pod/gitea-0", JSONPath: "{.status.phase}=Running"}, true},
+		{Waiter{Resource: "pod", Selector: "app=memcached", JSONPath: "{.status.phase}=Running"}, true},
+		{Waiter{Resource: "pod", Selector: "app=missing"}, false},
+	} {
+		tc.w.ClusterName, tc.w.Namespace = "rockpool-controller", "gitea"
+		tc.w.Timeout = 200 * time.Millisecond
+		if err := tc.w.Execute(ctx); (err == nil) != tc.done {
+			t.Errorf("%s: got error %v", tc.w.Describe(), err)
+		}
+	}
+}
+
+func TestPodReady(t *testing.T) {
+	jobPod := func(phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "migrate-x1", OwnerReferences: []metav1.OwnerReference{
+				{Kind: "Job", Name: "migrate"},
+			}},
+			Status: corev1.PodStatus{Phase: phase, Message: "exit code 1"},
+		}
+	}
+	for _, tc := range []struct {
+		pod     *corev1.Pod
+		ready   bool
+		wantErr string
+	}{
+		{pod: jobPod(corev1.PodSucceeded), ready: true},
+		{pod: jobPod(corev1.PodRunning)},
+		{pod: jobPod(corev1.PodFailed), wantErr: "pod migrate-x1 of job migrate failed: exit code 1"},
+		{pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "gitea-0"},
+			Status: corev1.PodStatus{Phase: corev1.PodFailed}}},
+	} {
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(tc.pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ready, err := podReady(&unstructured.Unstructured{Object: obj})
+		if ready != tc.ready {
+			t.Errorf("%s %s: got ready %t", tc.pod.Name, tc.pod.Status.Phase, ready)
+		}
+		if (err == nil && tc.wantErr != "") || (err != nil && err.Error() != tc.wantErr) {
+			t.Errorf("%s %s: got error %v, want %q", tc.pod.Name, tc.pod.Status.Phase, err, tc.wantErr)
+		}
+	}
+}
+
+func TestDiffManifests(t *testing.T) {
+	installFake(t, nil, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress-nginx-controller", Namespace: "ingress-nginx"},
+		Data:       map[string]string{"http-snippet": "server {}\n", "ssl-redirect": "true"},
+	})
+	fn := filepath.Join(t.TempDir(), "manifest.yml")
+	os.WriteFile(fn, []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ingress-nginx-controller
+data:
+  ssl-redirect: "false"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: mailhog
+data:
+  port: "1025"
+`), 0644)
+
+	diffs, err := DiffManifests(context.Background(), "rockpool-controller", "ingress-nginx", fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 2 {
+		t.Fatalf("expected both config maps to change, got %v", diffs)
+	}
+	for _, want := range []string{"ConfigMap ingress-nginx/ingress-nginx-controller:",
+		"-  ssl-redirect: \"true\"", "+  ssl-redirect: \"false\"", " http-snippet"} {
+		if !strings.Contains(diffs[0].String(), want) {
+			t.Errorf("diff does not contain %q:\n%s", want, diffs[0])
+		}
+	}
+	if !strings.Contains(diffs[1].Diff, "+  port: \"1025\"") {
+		t.Errorf("expected the new config map to be added:\n%s", diffs[1].Diff)
+	}
+
+	// The fake client persists the dry run, so nothing changes anymore.
+	diffs, err = DiffManifests(context.Background(), "rockpool-controller", "ingress-nginx", fn)
+	if err != nil || len(diffs) != 0 {
+		t.Errorf("expected no changes, got %v, %v", diffs, err)
+	}
+}
+
+func TestDiffManifestsSecret(t *testing.T) {
+	installFake(t, nil, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "harbor-cert", Namespace: "harbor"},
+		Data: map[string][]byte{"tls.key": []byte("old-private-key"),
+			"ca.crt": []byte("certificate")},
+	})
+	fn := writeManifest(t, `apiVersion: v1
+kind: Secret
+metadata:
+  name: harbor-cert
+data:
+  ca.crt: Y2VydGlmaWNhdGU=
+  tls.key: bmV3LXByaXZhdGUta2V5
+stringData:
+  password: s3cr3t
+`)
+	diffs, err := DiffManifests(context.Background(), "rockpool-controller", "harbor", fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 {
+		t.Fatalf("expected the secret to change, got %v", diffs)
+	}
+	d := diffs[0].String()
+	for _, secret := range []string{"b2xkLXByaXZhdGUta2V5", "bmV3LXByaXZhdGUta2V5", "s3cr3t",
+		"Y2VydGlmaWNhdGU="} {
+		if strings.Contains(d, secret) {
+			t.Errorf("diff contains the secret value %q:\n%s", secret, d)
+		}
+	}
+	for _, want := range []string{"-  tls.key: '[REDACTED]'", "+  tls.key: '[REDACTED, changed]'",
+		"+  password: '[REDACTED, changed]'", "   ca.crt: '[REDACTED, unchanged]'"} {
+		if !strings.Contains(d, want) {
+			t.Errorf("diff does not contain %q:\n%s", want, d)
+		}
+	}
+}
+
+func TestDescribeDiff(t *testing.T) {
+	installFake(t, nil)
+	exists := false
+	prev := ClusterExists
+	ClusterExists = func(cn string) bool { return exists }
+	t.Cleanup(func() { ClusterExists = prev })
+	fn := writeManifest(t, "metadata:\n  name: no-kind\n")
+
+	if d := DescribeDiff("rockpool-controller", "harbor", fn); d != "" {
+		t.Errorf("expected no diff for a missing cluster, got %q", d)
+	}
+	exists = true
+	d := DescribeDiff("rockpool-controller", "harbor", fn)
+	if !strings.Contains(d, "diff unavailable: missing kind in manifest") {
+		t.Errorf("expected the error to be reported, got %q", d)
+	}
+}
+
+func TestDiffManifestsConflicts(t *testing.T) {
+	c := installFake(t, nil)
+	// The first, unforced, apply conflicts with another field manager.
+	applies := 0
+	c.Dynamic.(*dynamicfake.FakeDynamicClient).PrependReactor("patch", "configmaps",
+		func(action clienttesting.Action) (bool, runtime.Object, error) {
+			if applies++; applies > 1 {
+				return false, nil, nil
+			}
+			return true, nil, &apierrors.StatusError{ErrStatus: metav1.Status{
+				Status: metav1.StatusFailure,
+				Code:   409,
+				Reason: metav1.StatusReasonConflict,
+				Details: &metav1.StatusDetails{Causes: []metav1.StatusCause{{
+					Type:    metav1.CauseTypeFieldManagerConflict,
+					Message: `conflict with "kubectl-edit" using v1`,
+					Field:   ".data.ssl-redirect",
+				}}},
+			}}
+		})
+	fn := writeManifest(t, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: ingress-nginx-controller\n"+
+		"data:\n  ssl-redirect: \"false\"\n")
+
+	diffs, err := DiffManifests(context.Background(), "rockpool-controller", "ingress-nginx", fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || !reflect.DeepEqual(diffs[0].Conflicts,
+		[]string{`.data.ssl-redirect: conflict with "kubectl-edit" using v1`}) {
+		t.Fatalf("expected the conflict to be reported, got %v", diffs)
+	}
+	if !strings.Contains(diffs[0].String(), "taken over") || diffs[0].Diff == "" {
+		t.Errorf("expected the forced changes to be reported:\n%s", diffs[0])
+	}
+}
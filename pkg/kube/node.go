@@ -0,0 +1,91 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var nodesGVR = schema.GroupVersionResource{Version: "v1", Resource: "nodes"}
+
+// ParseTaint parses a taint in the key=value:Effect format, as used by
+// 'kubectl taint'.
+func ParseTaint(s string) (corev1.Taint, error) {
+	kv, effect, ok := strings.Cut(s, ":")
+	if !ok {
+		return corev1.Taint{}, fmt.Errorf("invalid taint %q, expected key=value:Effect", s)
+	}
+	key, value, _ := strings.Cut(kv, "=")
+	return corev1.Taint{Key: key, Value: value, Effect: corev1.TaintEffect(effect)}, nil
+}
+
+// TaintNode adds the taints to a node, replacing the ones with the same key
+// and effect.
+func TaintNode(ctx context.Context, cn string, node string, taints []string) error {
+	if len(taints) == 0 {
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"clusterName": cn,
+		"node":        node,
+		"taints":      taints,
+	}).Debug("tainting node")
+
+	c, err := ClientFor(cn)
+	if err != nil {
+		return err
+	}
+	u, err := c.Dynamic.Resource(nodesGVR).Get(ctx, node, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get node %s: %w", node, err)
+	}
+	n := &corev1.Node{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, n); err != nil {
+		return fmt.Errorf("unable to parse node %s: %w", node, err)
+	}
+	for _, s := range taints {
+		t, err := ParseTaint(s)
+		if err != nil {
+			return err
+		}
+		kept := []corev1.Taint{}
+		for _, existing := range n.Spec.Taints {
+			if !t.MatchTaint(&existing) {
+				kept = append(kept, existing)
+			}
+		}
+		n.Spec.Taints = append(kept, t)
+	}
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(n)
+	if err != nil {
+		return err
+	}
+	_, err = c.Dynamic.Resource(nodesGVR).Update(ctx, &unstructured.Unstructured{Object: m},
+		metav1.UpdateOptions{FieldManager: FieldManager})
+	if err != nil {
+		return fmt.Errorf("unable to taint node %s: %w", node, err)
+	}
+	return nil
+}
+
+// DeleteNode removes a node from the cluster; it is a no-op if the node does
+// not exist.
+func DeleteNode(ctx context.Context, cn string, node string) error {
+	c, err := ClientFor(cn)
+	if err != nil {
+		return err
+	}
+	err = c.Dynamic.Resource(nodesGVR).Delete(ctx, node, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete node %s: %w", node, err)
+	}
+	return nil
+}
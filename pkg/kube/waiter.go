@@ -1,58 +1,447 @@
 package kube
 
 import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/salsadigitalauorg/rockpool/pkg/command"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+
 	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/util/jsonpath"
 )
 
+// DefaultWaitTimeout is the deadline of the waiters which set neither Timeout
+// nor Retries.
+var DefaultWaitTimeout = 10 * time.Minute
+
+// Waiter waits for resources to reach a state, for up to Timeout, or
+// Retries * Delay seconds. Resource is either kind/name, or a kind, in which
+// case all the objects of the kind in the namespace matching Selector are
+// waited for, at least one of them having to exist. The state is either:
+//   - Condition, a condition of the objects, e.g, Available=true;
+//   - JSONPath, an expression with the value it must have, e.g,
+//     {.status.phase}=Running, or without a value to only check it matches;
+//   - otherwise, pods are waited for to be ready, and deployments,
+//     statefulsets and daemonsets to be rolled out.
 type Waiter struct {
 	Stage       string
 	ClusterName string
 	Namespace   string
 	Resource    string
+	Selector    string
 	Condition   string
+	JSONPath    string
+	Timeout     time.Duration
 	Retries     int
 	Delay       int
 	Info        string
+	DependsOn   []string
 }
 
 func (w Waiter) GetStage() string {
 	return w.Stage
 }
 
-func (w Waiter) Execute() bool {
+func (w Waiter) GetClusterName() string {
+	return w.ClusterName
+}
+
+func (w Waiter) GetName() string {
+	name := "wait:"
+	if w.Namespace != "" {
+		name += w.Namespace + ":"
+	}
+	name += w.Resource
+	if w.Selector != "" {
+		name += "[" + w.Selector + "]"
+	}
+	return name + ":" + w.state()
+}
+
+func (w Waiter) GetDependencies() []string {
+	return w.DependsOn
+}
+
+func (w Waiter) Describe() string {
+	desc := fmt.Sprintf("wait for %s to be %s", w.subject(), w.state())
+	if w.Namespace != "" {
+		desc += " in namespace " + w.Namespace
+	}
+	desc += fmt.Sprintf(" (timeout %s)", w.timeout())
+	if w.Info != "" {
+		desc = w.Info + ": " + desc
+	}
+	return desc
+}
+
+// subject describes the objects waited for.
+func (w Waiter) subject() string {
+	switch {
+	case strings.Contains(w.Resource, "/"):
+		return w.Resource
+	case w.Selector != "":
+		return fmt.Sprintf("each %s matching %s", w.Resource, w.Selector)
+	}
+	return "every " + w.Resource
+}
+
+// state describes the state waited for.
+func (w Waiter) state() string {
+	kind, _, _ := strings.Cut(w.Resource, "/")
+	switch {
+	case w.Condition != "":
+		return w.Condition
+	case w.JSONPath != "":
+		return w.JSONPath
+	case slices.Contains([]string{"po", "pod", "pods"}, strings.ToLower(kind)):
+		return "ready"
+	}
+	return "rolled out"
+}
+
+func (w Waiter) timeout() time.Duration {
+	switch {
+	case w.Timeout > 0:
+		return w.Timeout
+	case w.Retries > 0:
+		return time.Duration(w.Retries*w.Delay) * time.Second
+	}
+	return DefaultWaitTimeout
+}
+
+func (w Waiter) Execute(ctx context.Context) error {
 	logger := log.WithFields(log.Fields{
 		"stage":     w.Stage,
 		"cluster":   w.ClusterName,
 		"namespace": w.Namespace,
 		"resource":  w.Resource,
-		"condition": w.Condition,
+		"selector":  w.Selector,
+		"state":     w.state(),
 	})
 	if w.Info != "" {
 		logger.Info(w.Info)
 	}
 
-	resourceNotFound := true
-	var failedErr error
-	retries := w.Retries
-	for resourceNotFound && retries > 0 {
-		failedErr = nil
-		_, err := Cmd(w.ClusterName, w.Namespace, "wait",
-			"--for=condition="+w.Condition, w.Resource).Output()
-		if err != nil {
-			failedErr = err
-			retries--
-			time.Sleep(time.Duration(w.Delay) * time.Second)
+	fail := func(err error) error {
+		return &WaitFailedError{
+			ClusterName: w.ClusterName,
+			Namespace:   w.Namespace,
+			Resource:    w.subject(),
+			Condition:   w.state(),
+			Err:         err,
+		}
+	}
+	c, err := ClientFor(w.ClusterName)
+	if err != nil {
+		return fail(err)
+	}
+	kind, name, _ := strings.Cut(w.Resource, "/")
+	gvr, err := c.resourceFor(kind)
+	if err != nil {
+		return fail(err)
+	}
+	check, err := w.check(gvr.Resource)
+	if err != nil {
+		return fail(err)
+	}
+	opts := metav1.ListOptions{LabelSelector: w.Selector}
+	if name != "" {
+		opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, w.timeout())
+	defer cancel()
+	start := time.Now()
+	err = c.waitFor(waitCtx, gvr.Resource, c.namespaced(gvr, w.Namespace), opts, check)
+	audit("wait", []string{w.ClusterName, w.Namespace, w.subject(), w.state()}, start, nil, err)
+	if err != nil && ctx.Err() != nil {
+		return fail(ctx.Err())
+	} else if err != nil {
+		return fail(err)
+	}
+	logger.Debug("state reached")
+	return nil
+}
+
+// waitCheck checks whether an object has reached the state waited for; an
+// error is returned if it can't reach it anymore.
+type waitCheck func(u *unstructured.Unstructured) (bool, error)
+
+// check returns the check of the waiter's state for objects of the resource.
+func (w Waiter) check(resource string) (waitCheck, error) {
+	switch {
+	case w.Condition != "":
+		condType, condStatus, found := strings.Cut(w.Condition, "=")
+		if !found {
+			condStatus = "true"
+		}
+		return func(u *unstructured.Unstructured) (bool, error) {
+			return conditionMet(u, condType, condStatus), nil
+		}, nil
+	case w.JSONPath != "":
+		return jsonPathCheck(w.JSONPath)
+	case resource == "pods":
+		return podReady, nil
+	case slices.Contains([]string{"deployments", "statefulsets", "daemonsets"}, resource):
+		return rolledOut(resource), nil
+	}
+	return nil, fmt.Errorf("no condition or jsonpath given to wait for %s", resource)
+}
+
+// conditionMet checks whether the object has a condition of the given type
+// and status.
+func conditionMet(u *unstructured.Unstructured, condType string, condStatus string) bool {
+	conditions, _, _ := unstructured.NestedSlice(u.Object, "status", "conditions")
+	for _, c := range conditions {
+		cm, ok := c.(map[string]interface{})
+		if !ok {
 			continue
 		}
-		resourceNotFound = false
+		if strings.EqualFold(fmt.Sprint(cm["type"]), condType) &&
+			strings.EqualFold(fmt.Sprint(cm["status"]), condStatus) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonPathCheck returns a check evaluating the expression, e.g,
+// {.status.phase}=Running, against the objects; without a value, the
+// expression only needs to match.
+func jsonPathCheck(expr string) (waitCheck, error) {
+	path, value, hasValue := expr, "", false
+	if i := strings.LastIndex(expr, "}="); i >= 0 {
+		path, value, hasValue = expr[:i+1], expr[i+2:], true
+	}
+	jp := jsonpath.New("wait").AllowMissingKeys(true)
+	if err := jp.Parse(path); err != nil {
+		return nil, fmt.Errorf("invalid jsonpath %s: %w", path, err)
+	}
+	return func(u *unstructured.Unstructured) (bool, error) {
+		results, err := jp.FindResults(u.Object)
+		if err != nil {
+			return false, nil
+		}
+		for _, r := range results {
+			for _, v := range r {
+				if !hasValue || fmt.Sprint(v.Interface()) == value {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}, nil
+}
+
+// podReady checks whether the pod is ready; the pods run by jobs only need
+// to have succeeded, and fail the wait otherwise.
+func podReady(u *unstructured.Unstructured) (bool, error) {
+	phase, _, _ := unstructured.NestedString(u.Object, "status", "phase")
+	if phase == "Succeeded" {
+		return true, nil
+	}
+	for _, o := range u.GetOwnerReferences() {
+		if o.Kind == "Job" && phase == "Failed" {
+			err := fmt.Errorf("pod %s of job %s failed", u.GetName(), o.Name)
+			if msg, _, _ := unstructured.NestedString(u.Object, "status", "message"); msg != "" {
+				err = fmt.Errorf("%w: %s", err, msg)
+			}
+			return false, err
+		}
+	}
+	return conditionMet(u, "Ready", "true"), nil
+}
+
+// rolledOut returns a check of the rollout of a workload of the resource,
+// following kubectl rollout status: all its replicas must be updated and
+// available.
+func rolledOut(resource string) waitCheck {
+	return func(u *unstructured.Unstructured) (bool, error) {
+		status := func(field string) int64 {
+			v, _, _ := unstructured.NestedInt64(u.Object, "status", field)
+			return v
+		}
+		if status("observedGeneration") < u.GetGeneration() {
+			return false, nil
+		}
+		replicas, found, _ := unstructured.NestedInt64(u.Object, "spec", "replicas")
+		if !found {
+			replicas = 1
+		}
+		switch resource {
+		case "deployments":
+			conditions, _, _ := unstructured.NestedSlice(u.Object, "status", "conditions")
+			for _, c := range conditions {
+				if cm, ok := c.(map[string]interface{}); ok && cm["type"] == "Progressing" &&
+					cm["reason"] == "ProgressDeadlineExceeded" {
+					return false, fmt.Errorf("rollout of %s exceeded its progress deadline", u.GetName())
+				}
+			}
+			updated := status("updatedReplicas")
+			return updated >= replicas && status("replicas") <= updated &&
+				status("availableReplicas") >= updated, nil
+		case "statefulsets":
+			if status("readyReplicas") < replicas {
+				return false, nil
+			}
+			strategy, _, _ := unstructured.NestedString(u.Object, "spec", "updateStrategy", "type")
+			if strategy == "OnDelete" {
+				return true, nil
+			}
+			partition, found, _ := unstructured.NestedInt64(u.Object, "spec", "updateStrategy",
+				"rollingUpdate", "partition")
+			if found {
+				return status("updatedReplicas") >= replicas-partition, nil
+			}
+			current, _, _ := unstructured.NestedString(u.Object, "status", "currentRevision")
+			update, _, _ := unstructured.NestedString(u.Object, "status", "updateRevision")
+			return current == update, nil
+		}
+		desired := status("desiredNumberScheduled")
+		return status("updatedNumberScheduled") >= desired && status("numberAvailable") >= desired, nil
 	}
-	if failedErr != nil {
-		logger.WithError(command.GetMsgFromCommandError(failedErr)).
-			Fatal("error while waiting")
+}
+
+// waitSet holds the objects being waited for, by name.
+type waitSet struct {
+	check   waitCheck
+	matches func(u *unstructured.Unstructured) bool
+	objects map[string]*unstructured.Unstructured
+	// pending holds the names of the objects which have not reached the
+	// state yet, when last evaluated.
+	pending []string
+}
+
+// done checks whether all the objects, and at least one, have reached the
+// state.
+func (s *waitSet) done() (bool, error) {
+	s.pending = []string{}
+	for name, u := range s.objects {
+		ok, err := s.check(u)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			s.pending = append(s.pending, name)
+		}
+	}
+	sort.Strings(s.pending)
+	return len(s.objects) > 0 && len(s.pending) == 0, nil
+}
+
+// waitFor watches the objects selected by opts until they have all reached
+// the state, or ctx is done; none needs to exist when starting.
+func (c *Client) waitFor(ctx context.Context, resource string, ri resourceWatcher, opts metav1.ListOptions, check waitCheck) error {
+	labelSelector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return err
 	}
+	fieldSelector, err := fields.ParseSelector(opts.FieldSelector)
+	if err != nil {
+		return err
+	}
+	s := &waitSet{check: check, matches: func(u *unstructured.Unstructured) bool {
+		return labelSelector.Matches(labels.Set(u.GetLabels())) &&
+			fieldSelector.Matches(fields.Set{"metadata.name": u.GetName()})
+	}}
+	err = func() error {
+		for {
+			list, err := ri.List(ctx, opts)
+			if err != nil {
+				return err
+			}
+			s.objects = map[string]*unstructured.Unstructured{}
+			for i := range list.Items {
+				if s.matches(&list.Items[i]) {
+					s.objects[list.Items[i].GetName()] = &list.Items[i]
+				}
+			}
+			if done, err := s.done(); done || err != nil {
+				return err
+			}
+
+			watchOpts := opts
+			watchOpts.ResourceVersion = list.GetResourceVersion()
+			watcher, err := ri.Watch(ctx, watchOpts)
+			if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			done, err := consume(ctx, watcher, s)
+			watcher.Stop()
+			if done || err != nil {
+				return err
+			}
+			// The watch was closed or expired; list again after a short
+			// delay.
+			log.WithFields(log.Fields{"resource": resource, "selector": opts.LabelSelector}).
+				Trace("watch closed, restarting")
+			if err := platform.Sleep(ctx, time.Second); err != nil {
+				return err
+			}
+		}
+	}()
+	if err != nil && ctx.Err() != nil {
+		if len(s.objects) == 0 {
+			return fmt.Errorf("no %s found: %w", resource, err)
+		}
+		return fmt.Errorf("still waiting for %s: %w", strings.Join(s.pending, ", "), err)
+	}
+	return err
+}
+
+// resourceWatcher is the subset of dynamic.ResourceInterface used to wait.
+type resourceWatcher interface {
+	List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+}
 
-	return true
+// consume reads the watch events, updating the set, until all its objects
+// have reached the state, the watch is closed or ctx is done.
+func consume(ctx context.Context, watcher watch.Interface, s *waitSet) (bool, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case ev, ok := <-watcher.ResultChan():
+			if !ok {
+				return false, nil
+			}
+			if ev.Type == watch.Error {
+				err := apierrors.FromObject(ev.Object)
+				// The resource version listed is too old to watch from; the
+				// objects are listed again.
+				if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+					return false, nil
+				}
+				return false, err
+			}
+			u, ok := ev.Object.(*unstructured.Unstructured)
+			if !ok || !s.matches(u) {
+				continue
+			}
+			switch ev.Type {
+			case watch.Added, watch.Modified:
+				s.objects[u.GetName()] = u
+			case watch.Deleted:
+				delete(s.objects, u.GetName())
+			default:
+				continue
+			}
+			if done, err := s.done(); done || err != nil {
+				return done, err
+			}
+		}
+	}
 }
@@ -0,0 +1,17 @@
+package lagoon
+
+import "fmt"
+
+// ApiAuthFailedError is returned when a token for the Lagoon API could not be
+// obtained.
+type ApiAuthFailedError struct {
+	Err error
+}
+
+func (e *ApiAuthFailedError) Error() string {
+	return fmt.Sprintf("unable to authenticate against the Lagoon API: %s", e.Err)
+}
+
+func (e *ApiAuthFailedError) Unwrap() error {
+	return e.Err
+}
@@ -8,8 +8,8 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"sync"
 
-	"github.com/salsadigitalauorg/rockpool/pkg/command"
 	"github.com/salsadigitalauorg/rockpool/pkg/interceptor"
 	"github.com/salsadigitalauorg/rockpool/pkg/kube"
 	"github.com/salsadigitalauorg/rockpool/pkg/platform"
@@ -29,6 +29,10 @@ var GqlClient *graphql.Client
 
 var Remotes []Remote
 
+// remotesMu guards Remotes, which is updated as targets are set up
+// concurrently.
+var remotesMu sync.Mutex
+
 var lagoonUserinfo struct {
 	Me struct {
 		Id      graphql.String
@@ -42,24 +46,27 @@ var lagoonUserinfo struct {
 
 // FetchApiAdminToken creates an admin token with superpowers.
 // See https://docs.lagoon.sh/administering-lagoon/graphql-queries/#running-graphql-queries
-func FetchApiAdminToken() string {
+func FetchApiAdminToken(ctx context.Context) (string, error) {
 	log.Debug("fetching lagoon api admin token")
 	out, err := kube.Exec(
-		platform.ControllerClusterName(), "lagoon-core",
-		"lagoon-core-ssh", "/create_60_sec_jwt.py").Output()
+		ctx, platform.ControllerClusterName(), "lagoon-core",
+		"lagoon-core-ssh", "/create_60_sec_jwt.py")
 	if err != nil {
-		log.WithError(command.GetMsgFromCommandError(err)).Panic()
+		return "", &ApiAuthFailedError{Err: err}
 	}
-	return string(out)
+	return string(out), nil
 }
 
-func FetchApiToken() string {
+func FetchApiToken(ctx context.Context) (string, error) {
 	log.Info("fetching lagoon api token")
-	_, password := kube.GetSecret(platform.ControllerClusterName(),
+	_, password, err := kube.GetSecret(ctx, platform.ControllerClusterName(),
 		"lagoon-core",
 		"lagoon-core-keycloak",
 		"KEYCLOAK_LAGOON_ADMIN_PASSWORD",
 	)
+	if err != nil {
+		return "", &ApiAuthFailedError{Err: err}
+	}
 
 	data := url.Values{
 		"client_id":  {"lagoon-ui"},
@@ -68,12 +75,9 @@ func FetchApiToken() string {
 		"password":   {password},
 	}
 	url := fmt.Sprintf("http://keycloak.lagoon.%s/auth/realms/lagoon/protocol/openid-connect/token", platform.Hostname())
-	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(data.Encode()))
 	if err != nil {
-		log.WithFields(log.Fields{
-			"url":  url,
-			"data": data,
-		}).WithError(err).Panic("error preparing request to token endpoint")
+		return "", fmt.Errorf("error preparing request to token endpoint: %w", err)
 	}
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	dump, _ := httputil.DumpRequest(req, true)
@@ -84,8 +88,8 @@ func FetchApiToken() string {
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		log.WithField("request", string(dump)).WithError(err).
-			Panic("error executing request to token endpoint")
+		return "", &ApiAuthFailedError{
+			Err: fmt.Errorf("error executing request to token endpoint: %w", err)}
 	}
 	dump, _ = httputil.DumpResponse(resp, true)
 	log.WithField("dump", string(dump)).Debug("response dump")
@@ -97,23 +101,25 @@ func FetchApiToken() string {
 	}
 	err = json.NewDecoder(resp.Body).Decode(&res)
 	if err != nil {
-		log.WithField("response", string(dump)).WithError(err).
-			Fatal("error parsing Lagoon API token")
+		return "", &ApiAuthFailedError{
+			Err: fmt.Errorf("error parsing token response: %w", err)}
 	}
 	if res.Error != "" {
-		log.WithFields(log.Fields{
-			"res.Error":            res.Error,
-			"res.ErrorDescription": res.ErrorDescription,
-		}).Fatal("error fetching Lagoon API token")
+		return "", &ApiAuthFailedError{
+			Err: fmt.Errorf("%s: %s", res.Error, res.ErrorDescription)}
 	}
-	return res.Token
+	return res.Token, nil
 }
 
-func InitApiClient() {
+func InitApiClient(ctx context.Context) error {
 	if GqlClient != nil {
-		return
+		return nil
 	}
-	src := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: FetchApiToken()})
+	token, err := FetchApiToken(ctx)
+	if err != nil {
+		return err
+	}
+	src := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
 	httpClient := &http.Client{
 		Transport: &oauth2.Transport{
 			Base:   interceptor.New(),
@@ -121,37 +127,60 @@ func InitApiClient() {
 		},
 	}
 	GqlClient = graphql.NewClient(fmt.Sprintf("http://api.lagoon.%s/graphql", platform.Hostname()), httpClient)
+	return nil
 }
 
-func GetRemotes() {
+func GetRemotes(ctx context.Context) error {
 	log.Info("fetching lagoon api remotes")
 	var query struct {
 		AllKubernetes []Remote
 	}
-	err := GqlClient.Query(context.Background(), &query, nil)
+	err := GqlClient.Query(ctx, &query, nil)
 	if err != nil {
-		log.WithError(err).Fatal("error fetching Lagoon remotes")
+		return fmt.Errorf("error fetching Lagoon remotes: %w", err)
 	}
+	remotesMu.Lock()
 	Remotes = query.AllKubernetes
+	remotesMu.Unlock()
+	return nil
+}
+
+// RemoteExists checks whether a remote with the given id and name has been
+// registered.
+func RemoteExists(id int, name string) bool {
+	remotesMu.Lock()
+	defer remotesMu.Unlock()
+	for _, re := range Remotes {
+		if re.Id == id && re.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
-func FetchUserInfo() {
+func FetchUserInfo(ctx context.Context) error {
 	log.Info("fetching lagoon user info")
-	err := GqlClient.Query(context.Background(), &lagoonUserinfo, nil)
+	err := GqlClient.Query(ctx, &lagoonUserinfo, nil)
 	if err != nil {
-		log.WithError(err).Fatal("error fetching Lagoon user info")
+		return fmt.Errorf("error fetching Lagoon user info: %w", err)
 	}
+	return nil
 }
 
-func AddSshKey() {
+func AddSshKey(ctx context.Context) error {
 	log.Info("adding ssh key for lagoon user")
 
-	keyValue, keyType, keyFingerpint, cmt := ssh.GetPublicKeyFingerprint()
-	FetchUserInfo()
+	keyValue, keyType, keyFingerpint, cmt, err := ssh.GetPublicKeyFingerprint()
+	if err != nil {
+		return err
+	}
+	if err := FetchUserInfo(ctx); err != nil {
+		return err
+	}
 	for _, k := range lagoonUserinfo.Me.SshKeys {
 		if k.KeyFingerprint == keyFingerpint {
 			log.Debug("lagoon ssh key had already been added")
-			return
+			return nil
 		}
 	}
 
@@ -170,14 +199,14 @@ func AddSshKey() {
 		"userEmail": graphql.String(lagoonUserinfo.Me.Email),
 		"userId":    graphql.String(lagoonUserinfo.Me.Id),
 	}
-	err := GqlClient.Mutate(context.Background(), &m, vars)
+	err = GqlClient.Mutate(ctx, &m, vars)
 	if err != nil {
-		log.WithField("vars", vars).WithError(err).
-			Fatal("error adding Lagoon ssh key")
+		return fmt.Errorf("error adding Lagoon ssh key: %w", err)
 	}
+	return nil
 }
 
-func AddRemote(re Remote, token string) {
+func AddRemote(ctx context.Context, re Remote, token string) error {
 	log.Info("adding lagoon remote to GraphQL API")
 	var m struct {
 		AddKubernetes struct {
@@ -191,10 +220,110 @@ func AddRemote(re Remote, token string) {
 		"token":        graphql.String(token),
 		"routePattern": graphql.String(re.RouterPattern),
 	}
-	err := GqlClient.Mutate(context.Background(), &m, vars)
+	err := GqlClient.Mutate(ctx, &m, vars)
 	if err != nil {
-		log.WithField("vars", vars).WithError(err).
-			Fatal("error adding Lagoon remote", re.Name, err)
+		return fmt.Errorf("error adding Lagoon remote %s: %w", re.Name, err)
 	}
+	remotesMu.Lock()
 	Remotes = append(Remotes, m.AddKubernetes.Remote)
+	remotesMu.Unlock()
+	return nil
+}
+
+// RemoteEnvironments returns the environments deployed to the remote.
+func RemoteEnvironments(ctx context.Context, id int) ([]Environment, error) {
+	log.WithField("remoteId", id).Debug("fetching lagoon environments")
+	var query struct {
+		AllEnvironments []Environment
+	}
+	err := GqlClient.Query(ctx, &query, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching Lagoon environments: %w", err)
+	}
+	envs := []Environment{}
+	for _, e := range query.AllEnvironments {
+		if e.Kubernetes.Id == id {
+			envs = append(envs, e)
+		}
+	}
+	return envs, nil
+}
+
+// MigrateRemote moves the environments and the projects deployed to the
+// remote from to the remote to; the environments need to be deployed again
+// for their workloads to be created on the new remote.
+func MigrateRemote(ctx context.Context, from int, to int) error {
+	logger := log.WithFields(log.Fields{"from": from, "to": to})
+	envs, err := RemoteEnvironments(ctx, from)
+	if err != nil {
+		return err
+	}
+	for _, e := range envs {
+		logger.WithFields(log.Fields{
+			"project":     e.Project.Name,
+			"environment": e.Name,
+		}).Info("migrating lagoon environment")
+		var m struct {
+			UpdateEnvironment struct {
+				Id int
+			} `graphql:"updateEnvironment(input: {id: $id, patch: {kubernetes: $kubernetes}})"`
+		}
+		vars := map[string]interface{}{
+			"id":         graphql.Int(e.Id),
+			"kubernetes": graphql.Int(to),
+		}
+		if err := GqlClient.Mutate(ctx, &m, vars); err != nil {
+			return fmt.Errorf("error migrating Lagoon environment %s: %w", e.Name, err)
+		}
+	}
+
+	var query struct {
+		AllProjects []Project
+	}
+	if err := GqlClient.Query(ctx, &query, nil); err != nil {
+		return fmt.Errorf("error fetching Lagoon projects: %w", err)
+	}
+	for _, p := range query.AllProjects {
+		if p.Kubernetes.Id != from {
+			continue
+		}
+		logger.WithField("project", p.Name).Info("migrating lagoon project")
+		var m struct {
+			UpdateProject struct {
+				Id int
+			} `graphql:"updateProject(input: {id: $id, patch: {kubernetes: $kubernetes}})"`
+		}
+		vars := map[string]interface{}{
+			"id":         graphql.Int(p.Id),
+			"kubernetes": graphql.Int(to),
+		}
+		if err := GqlClient.Mutate(ctx, &m, vars); err != nil {
+			return fmt.Errorf("error migrating Lagoon project %s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// DeleteRemote removes the remote from the Lagoon API.
+func DeleteRemote(ctx context.Context, name string) error {
+	log.WithField("remote", name).Info("deleting lagoon remote from GraphQL API")
+	var m struct {
+		DeleteKubernetes graphql.String `graphql:"deleteKubernetes(input: {name: $name})"`
+	}
+	vars := map[string]interface{}{
+		"name": graphql.String(name),
+	}
+	if err := GqlClient.Mutate(ctx, &m, vars); err != nil {
+		return fmt.Errorf("error deleting Lagoon remote %s: %w", name, err)
+	}
+	remotesMu.Lock()
+	defer remotesMu.Unlock()
+	remotes := []Remote{}
+	for _, re := range Remotes {
+		if re.Name != name {
+			remotes = append(remotes, re)
+		}
+	}
+	Remotes = remotes
+	return nil
 }
@@ -6,3 +6,27 @@ type Remote struct {
 	ConsoleUrl    string `json:"consoleUrl"`
 	RouterPattern string `json:"routerPattern"`
 }
+
+// Environment is a project's environment, along with the remote it is
+// deployed to.
+type Environment struct {
+	Id      int    `json:"id"`
+	Name    string `json:"name"`
+	Project struct {
+		Id   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"project"`
+	Kubernetes struct {
+		Id int `json:"id"`
+	} `json:"kubernetes"`
+}
+
+// Project is a Lagoon project, along with the remote its environments are
+// deployed to by default.
+type Project struct {
+	Id         int    `json:"id"`
+	Name       string `json:"name"`
+	Kubernetes struct {
+		Id int `json:"id"`
+	} `json:"kubernetes"`
+}
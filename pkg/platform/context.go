@@ -0,0 +1,19 @@
+package platform
+
+import (
+	"context"
+	"time"
+)
+
+// Sleep pauses for the given duration, returning early with the context's
+// error if it is done in the meantime.
+func Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
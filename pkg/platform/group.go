@@ -0,0 +1,88 @@
+package platform
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Group runs a function per cluster concurrently. The context passed to the
+// functions is cancelled as soon as one of them fails, so that the others can
+// stop early.
+type Group struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	results []ClusterResult
+}
+
+// ClusterResult holds the outcome of the function run for a cluster.
+type ClusterResult struct {
+	Cluster string
+	Err     error
+}
+
+// NewGroup creates a group whose context is derived from ctx.
+func NewGroup(ctx context.Context) *Group {
+	g := &Group{}
+	g.ctx, g.cancel = context.WithCancel(ctx)
+	return g
+}
+
+// Go runs f for the given cluster in a new goroutine. f is not run if the
+// group has already been cancelled.
+func (g *Group) Go(cn string, f func(ctx context.Context) error) {
+	g.mu.Lock()
+	i := len(g.results)
+	g.results = append(g.results, ClusterResult{Cluster: cn})
+	g.mu.Unlock()
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		err := g.ctx.Err()
+		if err == nil {
+			err = f(g.ctx)
+		}
+		g.mu.Lock()
+		g.results[i].Err = err
+		g.mu.Unlock()
+		if err != nil {
+			g.cancel()
+		}
+	}()
+}
+
+// Wait waits for all the functions to return, then returns the errors of the
+// failed clusters joined together. The cancellation errors resulting from
+// another cluster's failure are left out.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+
+	errs := []error{}
+	cancelled := []error{}
+	for _, r := range g.results {
+		if r.Err == nil {
+			continue
+		}
+		if errors.Is(r.Err, context.Canceled) {
+			cancelled = append(cancelled, r.Err)
+			continue
+		}
+		errs = append(errs, r.Err)
+	}
+	if len(errs) == 0 && len(cancelled) > 0 {
+		return cancelled[0]
+	}
+	return errors.Join(errs...)
+}
+
+// Results returns the outcome for each cluster, in the order the functions
+// were added. It should be called after Wait.
+func (g *Group) Results() []ClusterResult {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]ClusterResult{}, g.results...)
+}
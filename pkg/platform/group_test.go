@@ -0,0 +1,85 @@
+package platform
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGroupCancelsOnFirstError(t *testing.T) {
+	failed := errors.New("failed")
+	g := NewGroup(context.Background())
+	g.Go("a", func(ctx context.Context) error {
+		return failed
+	})
+	g.Go("b", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Minute):
+			return nil
+		}
+	})
+
+	err := g.Wait()
+	if !errors.Is(err, failed) || errors.Is(err, context.Canceled) {
+		t.Errorf("expected only the failure to be returned, got %v", err)
+	}
+	want := []ClusterResult{{Cluster: "a", Err: failed}, {Cluster: "b", Err: context.Canceled}}
+	if got := g.Results(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got results %+v, want %+v", got, want)
+	}
+
+	ran := false
+	g.Go("c", func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+	g.Wait()
+	if ran {
+		t.Error("expected no function to run once the group is cancelled")
+	}
+}
+
+func TestGroupCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	g := NewGroup(ctx)
+	g.Go("a", func(ctx context.Context) error {
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := g.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancellation to be returned, got %v", err)
+	}
+}
+
+func TestGroupResults(t *testing.T) {
+	failed := errors.New("failed")
+	done := make(chan struct{}, 2)
+	g := NewGroup(context.Background())
+	g.Go("a", func(ctx context.Context) error {
+		done <- struct{}{}
+		return nil
+	})
+	g.Go("b", func(ctx context.Context) error {
+		// Fail once the others have completed, so that they are not
+		// cancelled.
+		<-done
+		<-done
+		return failed
+	})
+	g.Go("c", func(ctx context.Context) error {
+		done <- struct{}{}
+		return nil
+	})
+	if err := g.Wait(); !errors.Is(err, failed) {
+		t.Errorf("expected the failure to be returned, got %v", err)
+	}
+	want := []ClusterResult{{Cluster: "a"}, {Cluster: "b", Err: failed}, {Cluster: "c"}}
+	if got := g.Results(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got results %+v, want %+v", got, want)
+	}
+}
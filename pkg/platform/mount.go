@@ -0,0 +1,82 @@
+package platform
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// HostStorageClass is the storage class of the volumes backed by the targets'
+// mounts.
+const HostStorageClass = "rockpool-host"
+
+// mountsDir is the directory of the nodes the host directories are mounted
+// in.
+const mountsDir = "/mnt/rockpool"
+
+// Mount is a host directory mounted in the nodes of a target, and exposed in
+// its cluster as a persistent volume, e.g, to mount a working copy in a Lagoon
+// environment.
+type Mount struct {
+	// Name is the name of the mount; its volume is named rockpool-host-<name>.
+	Name string `yaml:"name"`
+	// HostPath is the absolute path of the directory on the host.
+	HostPath string `yaml:"host-path"`
+	// Size is the capacity of the volume; it defaults to 10Gi, but is not
+	// enforced.
+	Size string `yaml:"size,omitempty"`
+}
+
+var mountNameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// ParseMount parses a mount given as <name>=<host path>; a relative host path
+// is made absolute.
+func ParseMount(s string) (Mount, error) {
+	name, path, ok := strings.Cut(s, "=")
+	if !ok || name == "" || path == "" {
+		return Mount{}, fmt.Errorf("invalid mount %q, expected <name>=<host path>", s)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return Mount{}, err
+	}
+	return Mount{Name: name, HostPath: abs}, nil
+}
+
+// NodePath returns the path of the directory in the nodes.
+func (m Mount) NodePath() string {
+	return mountsDir + "/" + m.Name
+}
+
+// VolumeName returns the name of the mount's persistent volume.
+func (m Mount) VolumeName() string {
+	return HostStorageClass + "-" + m.Name
+}
+
+// Capacity returns the size of the mount's volume.
+func (m Mount) Capacity() string {
+	if m.Size != "" {
+		return m.Size
+	}
+	return "10Gi"
+}
+
+// validateMounts ensures the target's mounts have valid and unique names, and
+// absolute host paths.
+func (t Target) validateMounts() error {
+	names := map[string]bool{}
+	for _, m := range t.Mounts {
+		if !mountNameRegex.MatchString(m.Name) {
+			return fmt.Errorf("invalid mount name %q for target %s", m.Name, t.Name)
+		}
+		if !filepath.IsAbs(m.HostPath) {
+			return fmt.Errorf("host path %q of mount %s is not absolute", m.HostPath, m.Name)
+		}
+		if names[m.Name] {
+			return fmt.Errorf("duplicate mount name %q for target %s", m.Name, t.Name)
+		}
+		names[m.Name] = true
+	}
+	return nil
+}
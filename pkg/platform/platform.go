@@ -2,16 +2,19 @@ package platform
 
 import (
 	"fmt"
+	"path/filepath"
 	"runtime"
 
 	"github.com/salsadigitalauorg/rockpool/pkg/docker"
 )
 
 var (
-	ConfigDir    string
-	Name         string
-	Domain       string
-	Arch         = runtime.GOARCH
+	ConfigDir string
+	Name      string
+	Domain    string
+	Arch      = runtime.GOARCH
+	// NumTargets is the number of targets generated when none is defined
+	// by name.
 	NumTargets   int
 	LagoonSshKey string
 )
@@ -31,13 +34,20 @@ func Hostname() string {
 }
 
 func TotalClusterNum() int {
-	return NumTargets + 1
+	return len(Targets) + 1
 }
 
 func ControllerClusterName() string {
 	return Name + "-controller"
 }
 
-func TargetClusterName(targetId int) string {
-	return Name + "-target-" + fmt.Sprint(targetId)
+// Network returns the name of the docker network of the platform's clusters
+// and registry.
+func Network() string {
+	return "k3d-" + Name
+}
+
+// Dir returns the directory holding the files specific to the platform.
+func Dir() string {
+	return filepath.Join(ConfigDir, Name)
 }
@@ -0,0 +1,136 @@
+package platform
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Ports are the host ports the platform's registry and the controller's
+// services are published on.
+type Ports struct {
+	HTTP  int `yaml:"http"`
+	HTTPS int `yaml:"https"`
+	// SSH is the port of Lagoon's ssh service.
+	SSH int `yaml:"ssh"`
+	// AMQP is the port of Lagoon's broker.
+	AMQP int `yaml:"amqp"`
+	// DNS is the port of the dnsmasq resolving the platform's hostname, on
+	// both tcp and udp.
+	DNS int `yaml:"dns"`
+	// Registry is the port of the platform's registry.
+	Registry int `yaml:"registry"`
+}
+
+// PublishedPort is a host port mapped to a port of a cluster.
+type PublishedPort struct {
+	Host      int
+	Container int
+	// Protocol is either tcp or udp.
+	Protocol string
+}
+
+func (p PublishedPort) String() string {
+	return fmt.Sprintf("%d/%s", p.Host, p.Protocol)
+}
+
+// DefaultPorts are the host ports of the first platform, unless remapped in
+// the config.
+var DefaultPorts = Ports{HTTP: 80, HTTPS: 443, SSH: 2022, AMQP: 5672, DNS: 6153, Registry: 5111}
+
+// HostPorts are the host ports of the current platform.
+var HostPorts = DefaultPorts
+
+// PortBlock returns the n-th block of ports allocated to the platforms; the
+// first one holds the default ports, and the following ones are ranges of 100
+// ports from 20100, e.g, 20180 for http and 20143 for https in the second one.
+func PortBlock(n int) Ports {
+	if n == 0 {
+		return DefaultPorts
+	}
+	base := 20000 + 100*n
+	return Ports{HTTP: base + 80, HTTPS: base + 43, SSH: base + 22, AMQP: base + 72,
+		DNS: base + 53, Registry: base + 11}
+}
+
+// Or returns the ports, with the unset ones taken from d.
+func (p Ports) Or(d Ports) Ports {
+	set := func(v *int, d int) {
+		if *v == 0 {
+			*v = d
+		}
+	}
+	set(&p.HTTP, d.HTTP)
+	set(&p.HTTPS, d.HTTPS)
+	set(&p.SSH, d.SSH)
+	set(&p.AMQP, d.AMQP)
+	set(&p.DNS, d.DNS)
+	set(&p.Registry, d.Registry)
+	return p
+}
+
+// IsSet checks whether all the ports have been set.
+func (p Ports) IsSet() bool {
+	return p.HTTP != 0 && p.HTTPS != 0 && p.SSH != 0 && p.AMQP != 0 && p.DNS != 0 &&
+		p.Registry != 0
+}
+
+// Overlaps checks whether any of the set ports is also one of o.
+func (p Ports) Overlaps(o Ports) bool {
+	used := map[int]bool{}
+	for _, np := range o.named() {
+		used[np.port] = np.port != 0
+	}
+	for _, np := range p.named() {
+		if used[np.port] {
+			return true
+		}
+	}
+	return false
+}
+
+type namedPort struct {
+	name string
+	port int
+}
+
+func (p Ports) named() []namedPort {
+	return []namedPort{{"http", p.HTTP}, {"https", p.HTTPS}, {"ssh", p.SSH},
+		{"amqp", p.AMQP}, {"dns", p.DNS}, {"registry", p.Registry}}
+}
+
+// Published returns the host ports mapped to the controller's services.
+func (p Ports) Published() []PublishedPort {
+	return []PublishedPort{
+		{p.HTTP, 80, "tcp"},
+		{p.HTTPS, 443, "tcp"},
+		{p.SSH, 22, "tcp"},
+		{p.AMQP, 5672, "tcp"},
+		{p.DNS, 6153, "udp"},
+		{p.DNS, 6153, "tcp"},
+	}
+}
+
+// Validate ensures the ports are valid and distinct.
+func (p Ports) Validate() error {
+	seen := map[int]string{}
+	for _, np := range p.named() {
+		name, port := np.name, np.port
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid %s port %d", name, port)
+		}
+		if other, ok := seen[port]; ok {
+			return fmt.Errorf("the %s and %s ports are both %d", other, name, port)
+		}
+		seen[port] = name
+	}
+	return nil
+}
+
+// HTTPHost returns the host and port at which the platform's http services
+// are reachable, e.g, gitea.lagoon.rockpool.k3d.local:8080.
+func HTTPHost(host string) string {
+	if HostPorts.HTTP == 80 {
+		return host
+	}
+	return host + ":" + strconv.Itoa(HostPorts.HTTP)
+}
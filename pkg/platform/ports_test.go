@@ -0,0 +1,41 @@
+package platform
+
+import "testing"
+
+func TestPorts(t *testing.T) {
+	p := Ports{SSH: 2222, AMQP: 5673}.Or(DefaultPorts)
+	if p != (Ports{HTTP: 80, HTTPS: 443, SSH: 2222, AMQP: 5673, DNS: 6153, Registry: 5111}) {
+		t.Errorf("got ports %+v", p)
+	}
+	if err := p.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	p.DNS = 2222
+	if err := p.Validate(); err == nil || err.Error() != "the ssh and dns ports are both 2222" {
+		t.Errorf("expected duplicate ports to be rejected, got %v", err)
+	}
+	p.DNS = 70000
+	if err := p.Validate(); err == nil {
+		t.Error("expected invalid port to be rejected")
+	}
+}
+
+func TestPortBlock(t *testing.T) {
+	if PortBlock(0) != DefaultPorts {
+		t.Errorf("expected the first block to hold the default ports, got %+v", PortBlock(0))
+	}
+	b := PortBlock(2)
+	if b != (Ports{HTTP: 20280, HTTPS: 20243, SSH: 20222, AMQP: 20272, DNS: 20253, Registry: 20211}) {
+		t.Errorf("got block %+v", b)
+	}
+	if err := b.Validate(); err != nil {
+		t.Error(err)
+	}
+	if b.Overlaps(PortBlock(1)) || b.Overlaps(DefaultPorts) {
+		t.Error("expected the blocks not to overlap")
+	}
+	if !b.Overlaps(Ports{SSH: 20243}) {
+		t.Error("expected a port of the block to overlap")
+	}
+}
@@ -0,0 +1,122 @@
+package platform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Target is a cluster running a Lagoon remote.
+type Target struct {
+	// Name is the short name of the target; its cluster is named
+	// <platform>-<name>.
+	Name string `yaml:"name"`
+	// RemoteId is the id of the target's remote in Lagoon.
+	RemoteId int `yaml:"remote-id"`
+	// RemoteName is the name of the target's remote in Lagoon; it defaults
+	// to Name.
+	RemoteName string `yaml:"remote-name,omitempty"`
+	// RouterPattern is the pattern of the routes of the environments
+	// deployed to the target; it defaults to
+	// ${environment}.${project}.<remote name>.<hostname>.
+	RouterPattern string `yaml:"router-pattern,omitempty"`
+	// Mounts are the host directories mounted in the target's nodes.
+	Mounts []Mount `yaml:"mounts,omitempty"`
+}
+
+// Targets are the targets of the platform, as defined in the config or
+// generated from NumTargets.
+var Targets []Target
+
+var targetNameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// NumberedTargets returns n targets named target-1 to target-n, as created by
+// the earlier versions; their remotes are named <platform>1 to <platform>n.
+func NumberedTargets(n int) []Target {
+	targets := []Target{}
+	for i := 1; i <= n; i++ {
+		targets = append(targets, Target{
+			Name:       fmt.Sprintf("target-%d", i),
+			RemoteId:   i,
+			RemoteName: fmt.Sprintf("%s%d", Name, i),
+		})
+	}
+	return targets
+}
+
+// ClusterName returns the name of the target's cluster.
+func (t Target) ClusterName() string {
+	return Name + "-" + t.Name
+}
+
+// Remote returns the name of the target's remote in Lagoon.
+func (t Target) Remote() string {
+	if t.RemoteName != "" {
+		return t.RemoteName
+	}
+	return t.Name
+}
+
+// Routes returns the router pattern of the target's remote.
+func (t Target) Routes() string {
+	if t.RouterPattern != "" {
+		return t.RouterPattern
+	}
+	return fmt.Sprintf("${environment}.${project}.%s.%s", t.Remote(), Hostname())
+}
+
+// ServerName returns an nginx server name matching the hosts of the
+// target's routes.
+func (t Target) ServerName() string {
+	parts := regexp.MustCompile(`\$\{\w+\}`).Split(t.Routes(), -1)
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return "~^" + strings.Join(parts, `[^.]+`) + "$"
+}
+
+// TargetFor returns the target whose cluster is cn.
+func TargetFor(cn string) (Target, bool) {
+	for _, t := range Targets {
+		if t.ClusterName() == cn {
+			return t, true
+		}
+	}
+	return Target{}, false
+}
+
+// ValidateTargets ensures the targets' names and remote ids are valid and
+// unique, as well as their mounts.
+func ValidateTargets(targets []Target) error {
+	names := map[string]bool{}
+	ids := map[int]bool{}
+	for _, t := range targets {
+		if !targetNameRegex.MatchString(t.Name) || t.Name == "controller" {
+			return fmt.Errorf("invalid target name %q", t.Name)
+		}
+		if t.RemoteId < 1 {
+			return fmt.Errorf("invalid remote id %d for target %s", t.RemoteId, t.Name)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate target name %q", t.Name)
+		}
+		if ids[t.RemoteId] {
+			return fmt.Errorf("duplicate remote id %d for target %s", t.RemoteId, t.Name)
+		}
+		if err := t.validateMounts(); err != nil {
+			return err
+		}
+		names[t.Name], ids[t.RemoteId] = true, true
+	}
+	return nil
+}
+
+// ClusterNames returns the names of the controller's and the targets'
+// clusters.
+func ClusterNames() []string {
+	names := []string{ControllerClusterName()}
+	for _, t := range Targets {
+		names = append(names, t.ClusterName())
+	}
+	return names
+}
@@ -0,0 +1,70 @@
+package platform
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTargets(t *testing.T) {
+	Name, Domain = "rockpool", "k3d.local"
+	Targets = append(NumberedTargets(1), Target{Name: "prod", RemoteId: 4})
+	t.Cleanup(func() { Targets = nil })
+
+	if got := ClusterNames(); !reflect.DeepEqual(got,
+		[]string{"rockpool-controller", "rockpool-target-1", "rockpool-prod"}) {
+		t.Errorf("got cluster names %q", got)
+	}
+	target, ok := TargetFor("rockpool-target-1")
+	if !ok || target.Remote() != "rockpool1" ||
+		target.Routes() != "${environment}.${project}.rockpool1.rockpool.k3d.local" {
+		t.Errorf("got target %+v", target)
+	}
+	prod, _ := TargetFor("rockpool-prod")
+	if prod.Remote() != "prod" || prod.ServerName() != `~^[^.]+\.[^.]+\.prod\.rockpool\.k3d\.local$` {
+		t.Errorf("got remote %s, server name %s", prod.Remote(), prod.ServerName())
+	}
+	if _, ok := TargetFor("rockpool-controller"); ok {
+		t.Error("the controller is not a target")
+	}
+}
+
+func TestValidateTargets(t *testing.T) {
+	if err := ValidateTargets(NumberedTargets(3)); err != nil {
+		t.Error(err)
+	}
+	for _, invalid := range [][]Target{
+		{{Name: "controller", RemoteId: 1}},
+		{{Name: "Prod", RemoteId: 1}},
+		{{Name: "prod"}},
+		{{Name: "prod", RemoteId: 1}, {Name: "prod", RemoteId: 2}},
+		{{Name: "prod", RemoteId: 1}, {Name: "dev", RemoteId: 1}},
+		{{Name: "dev", RemoteId: 1, Mounts: []Mount{{Name: "Site", HostPath: "/src"}}}},
+		{{Name: "dev", RemoteId: 1, Mounts: []Mount{{Name: "site", HostPath: "src"}}}},
+		{{Name: "dev", RemoteId: 1, Mounts: []Mount{
+			{Name: "site", HostPath: "/src"}, {Name: "site", HostPath: "/other"}}}},
+	} {
+		if err := ValidateTargets(invalid); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
+
+func TestParseMount(t *testing.T) {
+	m, err := ParseMount("site=/home/dev/site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.NodePath() != "/mnt/rockpool/site" || m.VolumeName() != "rockpool-host-site" ||
+		m.Capacity() != "10Gi" {
+		t.Errorf("got mount %+v", m)
+	}
+	if m, _ := ParseMount("site=src"); !filepath.IsAbs(m.HostPath) {
+		t.Errorf("expected the host path to be made absolute, got %q", m.HostPath)
+	}
+	for _, invalid := range []string{"site", "=/src", "site="} {
+		if _, err := ParseMount(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
@@ -28,9 +28,6 @@ func Render(tmplName string, values interface{}, destName string) (string, error
 
 	var rendered string
 	path := RenderedPath(true)
-	if tmplName == "registries.yaml" {
-		path = RenderedPath(false)
-	}
 	if destName != "" {
 		rendered = filepath.Join(path, destName)
 	} else if filepath.Ext(tmplName) == ".tmpl" {
@@ -39,14 +36,24 @@ func Render(tmplName string, values interface{}, destName string) (string, error
 		rendered = filepath.Join(path, tmplName)
 	}
 
-	f, err := os.Create(rendered)
+	// Render to a temporary file first, so that an interrupted run does not
+	// leave a partially written file behind.
+	f, err := os.CreateTemp(path, "."+filepath.Base(rendered)+"-*")
 	if err != nil {
 		return "", err
 	}
-
 	err = t.Execute(f, values)
-	f.Close()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), rendered)
+	}
 	if err != nil {
+		os.Remove(f.Name())
 		return "", err
 	}
 	log.WithFields(log.Fields{
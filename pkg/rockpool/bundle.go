@@ -0,0 +1,256 @@
+package rockpool
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/action"
+	"github.com/salsadigitalauorg/rockpool/pkg/bundle"
+	"github.com/salsadigitalauorg/rockpool/pkg/docker"
+	"github.com/salsadigitalauorg/rockpool/pkg/helm"
+	"github.com/salsadigitalauorg/rockpool/pkg/k3d"
+	"github.com/salsadigitalauorg/rockpool/pkg/kube"
+	"github.com/salsadigitalauorg/rockpool/pkg/lagoon"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform/templates"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// bundleChains returns the chains setting up the controller and a target.
+func bundleChains() ([]*action.Chain, error) {
+	chains := []*action.Chain{controllerChain(platform.ControllerClusterName())}
+	if len(platform.Targets) > 0 {
+		tc, err := targetChain(platform.Targets[0].ClusterName())
+		if err != nil {
+			return nil, err
+		}
+		chains = append(chains, tc)
+	}
+	return chains, nil
+}
+
+// bundleSources returns the helm installers and the manifest URLs of the
+// controller's and the targets' setup, without duplicates.
+func bundleSources() ([]helm.Installer, []string, error) {
+	chains, err := bundleChains()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	installers := []helm.Installer{}
+	charts := map[string]bool{}
+	urls := []string{}
+	for _, c := range chains {
+		for _, a := range c.Actions {
+			switch a := a.(type) {
+			case helm.Installer:
+				if !charts[a.Chart] {
+					charts[a.Chart] = true
+					installers = append(installers, a)
+				}
+			case kube.Applyer:
+				for _, u := range a.Urls {
+					if !slices.Contains(urls, u) {
+						urls = append(urls, u)
+					}
+				}
+			}
+		}
+	}
+	return installers, urls, nil
+}
+
+// bundleImages returns the images referenced by the charts, rendered with
+// their values, by the applied templates and by the downloaded manifests, by
+// component. The pinned images of the static manifest are added, since some
+// are only referenced through settings, e.g, the build-deploy image; its
+// floating tags are left out, as they may not match the deployed images.
+func bundleImages(ctx context.Context, static ImageManifest, manifests [][]byte) (ImageManifest, error) {
+	chains, err := bundleChains()
+	if err != nil {
+		return nil, err
+	}
+	im := ImageManifest{}
+	seen := map[string]bool{}
+	add := func(component string, images []string) {
+		for _, img := range images {
+			if !seen[img] {
+				seen[img] = true
+				im[component] = append(im[component], img)
+			}
+		}
+	}
+	for _, c := range chains {
+		for _, a := range c.Actions {
+			switch a := a.(type) {
+			case helm.Installer:
+				name, manifest, err := a.Template(ctx)
+				if err != nil {
+					return nil, err
+				}
+				images, err := kube.ManifestImages([]byte(manifest))
+				if err != nil {
+					return nil, fmt.Errorf("unable to read the images of chart %s: %w", a.Chart, err)
+				}
+				add(name, images)
+			case kube.Applyer:
+				if a.Template == "" {
+					continue
+				}
+				f, err := templates.Render(a.Template, platform.ToMap(), "")
+				if err != nil {
+					return nil, err
+				}
+				data, err := os.ReadFile(f)
+				if err != nil {
+					return nil, err
+				}
+				images, err := kube.ManifestImages(data)
+				if err != nil {
+					return nil, fmt.Errorf("unable to read the images of template %s: %w", a.Template, err)
+				}
+				add(strings.TrimSuffix(a.Template, ".yml.tmpl"), images)
+			}
+		}
+	}
+	for _, data := range manifests {
+		images, err := kube.ManifestImages(data)
+		if err != nil {
+			return nil, err
+		}
+		add("manifests", images)
+	}
+
+	components := []string{}
+	for c := range static {
+		components = append(components, c)
+	}
+	sort.Strings(components)
+	for _, c := range components {
+		for _, img := range static[c] {
+			if _, _, version := docker.ParseImage(img); version != ":latest" {
+				add(c, []string{img})
+			}
+		}
+	}
+	return im, nil
+}
+
+// CreateBundle writes a bundle of the charts, manifests and images needed to
+// set up the platform with its Lagoon version, for `up --bundle`. The extra
+// images are added to the images of the bundled components, see
+// bundleImages, and to those of k3d.
+func CreateBundle(ctx context.Context, file string, extraImages []string) error {
+	dir, err := os.MkdirTemp("", "rockpool-bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, data []byte) error {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), os.ModePerm); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, name), data, 0644)
+	}
+
+	m := bundle.Manifest{
+		LagoonVersion: lagoon.Version,
+		Charts:        map[string]string{},
+		Manifests:     map[string]string{},
+		Images:        "images.yml",
+		Archive:       "images.tar",
+	}
+	installers, urls, err := bundleSources()
+	if err != nil {
+		return err
+	}
+	for _, i := range installers {
+		opts, err := helm.ParseArgs(i.Args)
+		if err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{"chart": i.Chart, "version": opts.Version}).Info("fetching chart")
+		data, err := helm.ChartArchive(i.AddRepo, i.Chart, opts.Version)
+		if err != nil {
+			return err
+		}
+		// The charts of a repository are named after their reference, e.g,
+		// harbor-harbor.tgz, and the other ones after their URL.
+		name := path.Base(i.Chart)
+		if !strings.Contains(i.Chart, "://") {
+			name = strings.ReplaceAll(i.Chart, "/", "-") + ".tgz"
+		}
+		name = path.Join("charts", name)
+		if err := write(name, data); err != nil {
+			return err
+		}
+		m.Charts[i.Chart] = name
+	}
+	manifests := [][]byte{}
+	for n, u := range urls {
+		log.WithField("url", u).Info("fetching manifest")
+		data, err := kube.Download(ctx, u)
+		if err != nil {
+			return err
+		}
+		name := path.Join("manifests", fmt.Sprintf("%d-%s", n, path.Base(u)))
+		if err := write(name, data); err != nil {
+			return err
+		}
+		m.Manifests[u] = name
+		manifests = append(manifests, data)
+	}
+
+	static, err := LoadImageManifest("")
+	if err != nil {
+		return err
+	}
+	log.Info("rendering charts to find their images")
+	im, err := bundleImages(ctx, static, manifests)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(im)
+	if err != nil {
+		return err
+	}
+	if err := write(m.Images, data); err != nil {
+		return err
+	}
+	images, err := im.Images(nil)
+	if err != nil {
+		return err
+	}
+	k3dImages, err := k3d.Images(ctx)
+	if err != nil {
+		return err
+	}
+	for _, img := range append(k3dImages, extraImages...) {
+		if !slices.Contains(images, img) {
+			images = append(images, img)
+		}
+	}
+	for _, img := range images {
+		if !docker.ImageExists(ctx, img) {
+			log.WithField("image", img).Info("pulling image")
+			if err := docker.Pull(ctx, img); err != nil {
+				return err
+			}
+		}
+	}
+	log.WithField("images", len(images)).Info("saving images")
+	if err := docker.Save(ctx, filepath.Join(dir, m.Archive), images...); err != nil {
+		return err
+	}
+
+	log.WithField("bundle", file).Info("writing bundle")
+	return bundle.Write(dir, m, file)
+}
@@ -0,0 +1,124 @@
+package rockpool
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/helm"
+	"github.com/salsadigitalauorg/rockpool/pkg/kube"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform/templates"
+
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+func TestBundleSources(t *testing.T) {
+	setUp(t, "images-preload.yml")
+
+	installers, urls, err := bundleSources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	charts := []string{}
+	for _, i := range installers {
+		charts = append(charts, i.Chart)
+	}
+	for _, c := range []string{"jetstack/cert-manager", "harbor/harbor", "lagoon/lagoon-core",
+		"lagoon/lagoon-remote", "nicholaswilde/mariadb"} {
+		if !slices.Contains(charts, c) {
+			t.Errorf("expected chart %s in %v", c, charts)
+		}
+	}
+	if len(charts) != 8 {
+		t.Errorf("expected each chart once, got %v", charts)
+	}
+	if len(urls) != 3 {
+		t.Errorf("expected the dbaas-operator manifests, got %v", urls)
+	}
+}
+
+func TestBundleImages(t *testing.T) {
+	setUp(t, "images-preload.yml")
+	h := helm.NewFake()
+	h.Charts["jetstack/cert-manager"] = &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "cert-manager", Version: "1.14.4"},
+		Values:   map[string]interface{}{"tag": "v1.14.4"},
+		Templates: []*chart.File{{Name: "templates/deployment.yaml", Data: []byte(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: cert-manager
+spec:
+  template:
+    spec:
+      containers:
+        - name: controller
+          image: quay.io/jetstack/cert-manager-controller:{{ .Values.tag }}
+`)}},
+	}
+	t.Cleanup(h.Install())
+	static := ImageManifest{
+		"cert-manager":  {"quay.io/jetstack/cert-manager-controller:latest"},
+		"lagoon-remote": {"uselagoon/build-deploy-image:core-v2.12.0"},
+	}
+	manifest := []byte("apiVersion: v1\nkind: Pod\nmetadata:\n  name: operator\n" +
+		"spec:\n  containers:\n    - name: operator\n      image: amazeeio/dbaas-operator:v0.3.0\n")
+
+	im, err := bundleImages(context.Background(), static, [][]byte{manifest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, err := im.Images(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every image referenced by the rendered charts and templates is bundled.
+	chains, err := bundleChains()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"amazeeio/dbaas-operator:v0.3.0", "uselagoon/build-deploy-image:core-v2.12.0"}
+	for _, c := range chains {
+		for _, a := range c.Actions {
+			var rendered []byte
+			switch a := a.(type) {
+			case helm.Installer:
+				_, manifest, err := a.Template(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				rendered = []byte(manifest)
+			case kube.Applyer:
+				if a.Template == "" {
+					continue
+				}
+				f, err := templates.Render(a.Template, platform.ToMap(), "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if rendered, err = os.ReadFile(f); err != nil {
+					t.Fatal(err)
+				}
+			default:
+				continue
+			}
+			refs, err := kube.ManifestImages(rendered)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, refs...)
+		}
+	}
+	for _, img := range append(want, "quay.io/jetstack/cert-manager-controller:v1.14.4",
+		"ajoergensen/mailhog:latest") {
+		if !slices.Contains(images, img) {
+			t.Errorf("expected image %s in %v", img, images)
+		}
+	}
+	if slices.Contains(images, "quay.io/jetstack/cert-manager-controller:latest") {
+		t.Error("expected the floating tag of the static manifest to be left out")
+	}
+}
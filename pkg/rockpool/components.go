@@ -1,15 +1,17 @@
 package rockpool
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"strings"
 
+	"github.com/salsadigitalauorg/rockpool/pkg/cluster"
 	"github.com/salsadigitalauorg/rockpool/pkg/command"
 	"github.com/salsadigitalauorg/rockpool/pkg/docker"
 	"github.com/salsadigitalauorg/rockpool/pkg/helm"
-	"github.com/salsadigitalauorg/rockpool/pkg/k3d"
+	"github.com/salsadigitalauorg/rockpool/pkg/kind"
 	"github.com/salsadigitalauorg/rockpool/pkg/kube"
 	"github.com/salsadigitalauorg/rockpool/pkg/platform"
 	"github.com/salsadigitalauorg/rockpool/pkg/platform/templates"
@@ -21,185 +23,253 @@ var IngressNginxDefaultVersion = "4.5.2"
 var HarborSecretManifest string
 var HarborCaCrtFile string
 
-var ingressNginxInstaller = helm.Installer{
-	Info:        "installing ingress-nginx",
-	Namespace:   "ingress-nginx",
-	ReleaseName: "ingress-nginx",
-	Chart: fmt.Sprintf(
-		"https://github.com/kubernetes/ingress-nginx/releases/download/"+
-			"helm-chart-%s/ingress-nginx-%s.tgz",
-		IngressNginxDefaultVersion,
-		IngressNginxDefaultVersion),
-	Args: []string{
+// ingressNginxInstaller returns the installer for ingress-nginx on the given
+// cluster; a new one is created for each cluster so that they can be set up
+// concurrently.
+func ingressNginxInstaller(stage string, cn string, dependsOn ...string) helm.Installer {
+	args := []string{
 		"--create-namespace", "--wait",
 		"--set", "controller.config.ssl-redirect=false",
 		"--set", "controller.config.proxy-body-size=8m",
 		"--set", "controller.ingressClassResource.default=true",
 		"--set", "controller.watchIngressWithoutClass=true",
 		"--set", "server-name-hash-bucket-size=128",
-	},
+	}
+	if p, err := cluster.ProviderFor(cn); err == nil && p.Name() == (kind.Provider{}).Name() {
+		args = append(args, kind.IngressNginxArgs...)
+	}
+	return helm.Installer{
+		Stage:       stage,
+		Info:        "installing ingress-nginx",
+		ClusterName: cn,
+		Namespace:   "ingress-nginx",
+		ReleaseName: "ingress-nginx",
+		Chart: fmt.Sprintf(
+			"https://github.com/kubernetes/ingress-nginx/releases/download/"+
+				"helm-chart-%s/ingress-nginx-%s.tgz",
+			IngressNginxDefaultVersion,
+			IngressNginxDefaultVersion),
+		Args:      args,
+		DependsOn: dependsOn,
+	}
+}
+
+// readyWaiter returns a waiter for all the pods of the namespace a component
+// was installed in to be ready, so that the actions depending on the component
+// do not start while it is still starting or crash-looping.
+func readyWaiter(stage string, cn string, ns string, dependsOn ...string) kube.Waiter {
+	return kube.Waiter{
+		Stage:       stage,
+		Info:        "waiting for " + ns + " to be ready",
+		ClusterName: cn,
+		Namespace:   ns,
+		Resource:    "pod",
+		DependsOn:   dependsOn,
+	}
 }
 
-func FetchHarborCerts() {
+func FetchHarborCerts(ctx context.Context) error {
 	cn := platform.ControllerClusterName()
 	logger := log.WithField("clusterName", cn)
 	logger.Info("fetching harbor certificates")
 
-	certBytes, _ := kube.GetSecret(cn, "harbor", "harbor-harbor-ingress", "")
+	certBytes, _, err := kube.GetSecret(ctx, cn, "harbor", "harbor-harbor-ingress", "")
+	if err != nil {
+		return err
+	}
 	certData := struct {
 		Data map[string]string `json:"data"`
 	}{}
-	json.Unmarshal(certBytes, &certData)
+	if err := json.Unmarshal(certBytes, &certData); err != nil {
+		return fmt.Errorf("error parsing harbor certificate: %w", err)
+	}
 
 	secretManifest, err := templates.Render("harbor-cert.yml.tmpl", certData, "")
 	if err != nil {
-		logger.WithError(err).Fatal("error rendering harbor cert template")
+		return fmt.Errorf("error rendering harbor cert template: %w", err)
 	}
 	logger.WithField("secret", secretManifest).Debug("generated harbor cert")
 
 	cacrt := certData.Data["ca.crt"]
 	decoded, err := base64.URLEncoding.DecodeString(cacrt)
 	if err != nil {
-		logger.WithError(err).Fatal("error decoding ca.crt")
+		return fmt.Errorf("error decoding ca.crt: %w", err)
 	}
 	caCrtFile, err := templates.Render("harbor-ca.crt.tmpl", string(decoded), "")
 	if err != nil {
-		logger.WithError(err).Fatal("error rendering harbor ca.crt template")
+		return fmt.Errorf("error rendering harbor ca.crt template: %w", err)
 	}
 	logger.WithField("certificate", caCrtFile).Debug("generated harbor ca.crt")
 
 	HarborSecretManifest = secretManifest
 	HarborCaCrtFile = caCrtFile
+	return nil
 }
 
-func InstallHarborCerts(cn string) {
+func InstallHarborCerts(ctx context.Context, cn string) error {
 	if cn == platform.ControllerClusterName() {
-		return
+		return nil
 	}
 	logger := log.WithField("clusterName", cn)
 	logger.Info("installing harbor certificates on target")
 
-	exists, c := k3d.ClusterExists(cn)
+	exists, c := cluster.Exists(cn)
 	if !exists {
-		logger.Warn("cluster does not exist")
-		return
+		return &cluster.NotFoundError{Name: cn}
 	}
 
-	if err := kube.Apply(cn, "lagoon", HarborSecretManifest, true); err != nil {
-		logger.WithField("secret", HarborSecretManifest).WithError(err).
-			Fatal("error applying ca.crt")
+	if err := kube.Apply(ctx, cn, "lagoon", HarborSecretManifest, true); err != nil {
+		return err
 	}
 
 	// Add the cert to the nodes.
 	clusterUpdated := false
+	if len(c.Nodes) == 0 {
+		logger.Warn("the cluster's nodes are not accessible; harbor's CA certificate must be trusted by them")
+	}
 	for _, n := range c.Nodes {
-		if n.Role == "loadbalancer" {
-			continue
-		}
-
-		caCrtFileOut, _ := docker.Exec(n.Name, "ls /etc/ssl/certs/harbor-cert.crt").Output()
+		caCrtFileOut, _ := docker.ExecWith(ctx, n.Name, "sh", "ls /etc/ssl/certs/harbor-cert.crt").Output()
 		if strings.Trim(string(caCrtFileOut), "\n") == "/etc/ssl/certs/harbor-cert.crt" {
 			continue
 		}
 
 		// Add harbor's ca.crt to the target.
 		destCaCrt := fmt.Sprintf("%s:/etc/ssl/certs/harbor-cert.crt", n.Name)
-		_, err := docker.Cp(HarborCaCrtFile, destCaCrt)
+		_, err := docker.Cp(ctx, HarborCaCrtFile, destCaCrt)
 		if err != nil {
-			logger.WithFields(log.Fields{
-				"src":  HarborCaCrtFile,
-				"dest": destCaCrt,
-			}).WithError(command.GetMsgFromCommandError(err)).
-				Fatal("error copying ca.crt")
+			return fmt.Errorf("error copying ca.crt to %s: %w", n.Name,
+				command.GetMsgFromCommandError(err))
 		}
 		clusterUpdated = true
 	}
 
 	if clusterUpdated {
-		k3d.ClusterRestart(c.Name)
+		if err := cluster.Restart(ctx, c.Name); err != nil {
+			return err
+		}
 	}
 
 	// Patch lagoon-remote-lagoon-build-deploy to add the cert secret.
 	patchFile, err := templates.Render("patch-lagoon-remote-lagoon-build-deploy.yaml", nil, "")
 	if err != nil {
-		logger.WithError(err).
-			Fatal("error rendering the build deploy patch file")
-	}
-	_, err = kube.Patch(cn, "lagoon", "deployment", "lagoon-remote-lagoon-build-deploy", patchFile)
-	if err != nil {
-		logger.WithField("patchFile", patchFile).
-			WithError(command.GetMsgFromCommandError(err)).
-			Fatal("error patching the lagoon-build-deploy deployment")
+		return fmt.Errorf("error rendering the build deploy patch file: %w", err)
 	}
+	_, err = kube.Patch(ctx, cn, "lagoon", "deployment", "lagoon-remote-lagoon-build-deploy", patchFile)
+	return err
 }
 
 // AddHarborHostEntries adds host entries to the target nodes.
-func AddHarborHostEntries(cn string) {
+func AddHarborHostEntries(ctx context.Context, cn string) error {
 	if cn == platform.ControllerClusterName() {
-		return
+		return nil
 	}
 	logger := log.WithField("clusterName", cn)
 	logger.Info("adding harbor host entries on target")
 
-	exists, c := k3d.ClusterExists(cn)
+	exists, c := cluster.Exists(cn)
 	if !exists {
-		logger.Warn("cluster does not exist")
-		return
+		return &cluster.NotFoundError{Name: cn}
 	}
 
-	entry := fmt.Sprintf("%s\tharbor.lagoon.%s", k3d.ControllerIP(), platform.Hostname())
+	controllerIp, err := cluster.ControllerIP()
+	if err != nil {
+		return err
+	}
+	entry := fmt.Sprintf("%s\tharbor.lagoon.%s", controllerIp, platform.Hostname())
 	entryCmdStr := fmt.Sprintf("echo '%s' >> /etc/hosts", entry)
+	if len(c.Nodes) == 0 {
+		logger.Warn("the cluster's nodes are not accessible; harbor must be resolvable from them")
+	}
 	for _, n := range c.Nodes {
-		if n.Role == "loadbalancer" {
-			continue
-		}
-
-		hostsContent, _ := docker.Exec(n.Name, "cat /etc/hosts").Output()
+		hostsContent, _ := docker.ExecWith(ctx, n.Name, "sh", "cat /etc/hosts").Output()
 		if !strings.Contains(string(hostsContent), entry) {
 			logger.WithFields(log.Fields{
 				"node":  n.Name,
 				"entry": entry,
 			}).Debug("adding harbor host entry")
-			err := docker.Exec(n.Name, entryCmdStr).Run()
+			err := docker.ExecWith(ctx, n.Name, "sh", entryCmdStr).Run()
 			if err != nil {
-				logger.WithFields(log.Fields{
-					"node":  n.Name,
-					"entry": entry,
-				}).WithError(command.GetMsgFromCommandError(err)).
-					Fatal("error adding harbor host entry")
+				return fmt.Errorf("error adding harbor host entry to %s: %w",
+					n.Name, command.GetMsgFromCommandError(err))
 			}
 		}
 	}
+	return nil
 }
 
 // ConfigureTargetCoreDNS adds DNS records to targets for the required services.
-var ConfigureTargetCoreDNS = func(logger *log.Entry) bool {
+var ConfigureTargetCoreDNS = func(ctx context.Context, logger *log.Entry) error {
+	controllerIp, err := cluster.ControllerIP()
+	if err != nil {
+		return err
+	}
+	return updateCoreDNSHosts(ctx, logger, func(hosts string) string {
+		for _, entry := range coreDNSEntries(controllerIp) {
+			if !strings.Contains(hosts, entry) {
+				hosts += entry
+			}
+		}
+		return hosts
+	})
+}
+
+// RemoveTargetCoreDNS removes the DNS records added by ConfigureTargetCoreDNS,
+// for the targets whose cluster is kept when they are removed.
+func RemoveTargetCoreDNS(ctx context.Context, cn string) error {
+	logger := log.WithField("cluster", cn)
+	logger.Info("removing coredns records")
+	return updateCoreDNSHosts(ctx, logger, func(hosts string) string {
+		kept := []string{}
+		for _, l := range strings.SplitAfter(hosts, "\n") {
+			if !strings.HasSuffix(strings.TrimSpace(l), ".lagoon."+platform.Hostname()) {
+				kept = append(kept, l)
+			}
+		}
+		return strings.Join(kept, "")
+	})
+}
+
+// coreDNSEntries returns the host entries of the controller's services.
+func coreDNSEntries(controllerIp string) []string {
+	entries := []string{}
+	for _, h := range []string{"harbor", "broker", "ssh", "api", "gitea"} {
+		entries = append(entries, fmt.Sprintf("%s %s.lagoon.%s\n", controllerIp, h, platform.Hostname()))
+	}
+	return entries
+}
+
+// updateCoreDNSHosts updates the NodeHosts of the cluster's CoreDNS config
+// and restarts CoreDNS if they have changed.
+func updateCoreDNSHosts(ctx context.Context, logger *log.Entry, update func(hosts string) string) error {
 	cn := logger.Data["cluster"].(string)
-	cm := kube.GetConfigMap(cn, "kube-system", "coredns")
+	cm, err := kube.GetConfigMap(ctx, cn, "kube-system", "coredns")
+	if err != nil {
+		return err
+	}
 	corednsCm := CoreDNSConfigMap{}
-	err := json.Unmarshal(cm, &corednsCm)
+	err = json.Unmarshal(cm, &corednsCm)
 	if err != nil {
-		logger.WithError(err).Fatal("error parsing CoreDNS configmap")
+		return fmt.Errorf("error parsing CoreDNS configmap: %w", err)
 	}
-	for _, h := range []string{"harbor", "broker", "ssh", "api", "gitea"} {
-		entry := fmt.Sprintf("%s %s.lagoon.%s\n", k3d.ControllerIP(), h, platform.Hostname())
-		if !strings.Contains(corednsCm.Data.NodeHosts, entry) {
-			corednsCm.Data.NodeHosts += entry
-		}
+	hosts := update(corednsCm.Data.NodeHosts)
+	if hosts == corednsCm.Data.NodeHosts {
+		logger.Debug("coredns records are up to date")
+		return nil
 	}
+	corednsCm.Data.NodeHosts = hosts
 
 	cm, err = json.Marshal(corednsCm)
 	if err != nil {
-		logger.WithError(err).Fatal("error encoding CoreDNS configmap")
+		return fmt.Errorf("error encoding CoreDNS configmap: %w", err)
 	}
 
-	kube.Replace(cn, "kube-system", "coredns", string(cm))
+	if err := kube.Replace(ctx, cn, "kube-system", "coredns", string(cm)); err != nil {
+		return err
+	}
 
 	logger.Info("restarting coredns")
-	err = kube.Cmd(cn, "kube-system", "rollout", "restart",
-		"deployment/coredns").RunProgressive()
-	if err != nil {
-		logger.WithError(err).Fatal("CoreDNS restart failed")
+	if err := kube.RolloutRestart(ctx, cn, "kube-system", "deployment", "coredns"); err != nil {
+		return fmt.Errorf("CoreDNS restart failed: %w", err)
 	}
-	return true
+	return nil
 }
@@ -0,0 +1,146 @@
+package rockpool
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/cluster"
+	"github.com/salsadigitalauorg/rockpool/pkg/docker"
+	"github.com/salsadigitalauorg/rockpool/pkg/k3d"
+	"github.com/salsadigitalauorg/rockpool/pkg/lagoon"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform/templates"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// ImageManifest lists the images of the platform's components, by
+// component, e.g, lagoon-core or harbor.
+type ImageManifest map[string][]string
+
+// LoadImageManifest reads the manifest at path, or the default one for the
+// platform's Lagoon version if path is empty.
+func LoadImageManifest(path string) (ImageManifest, error) {
+	if path == "" {
+		rendered, err := templates.Render("images.yml.tmpl",
+			map[string]string{"LagoonVersion": lagoon.Version}, "")
+		if err != nil {
+			return nil, fmt.Errorf("unable to render images manifest: %w", err)
+		}
+		path = rendered
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read images manifest: %w", err)
+	}
+	m := ImageManifest{}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("unable to parse images manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Images returns the sorted images of the given components, or of all of
+// them if none is given.
+func (m ImageManifest) Images(components []string) ([]string, error) {
+	if len(components) == 0 {
+		for c := range m {
+			components = append(components, c)
+		}
+	}
+	seen := map[string]bool{}
+	images := []string{}
+	for _, c := range components {
+		imgs, ok := m[c]
+		if !ok {
+			return nil, fmt.Errorf("unknown component %s", c)
+		}
+		for _, img := range imgs {
+			if !seen[img] {
+				seen[img] = true
+				images = append(images, img)
+			}
+		}
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// PreloadImages pushes the images of the components into the platform's
+// registry. The images are taken from the archive if one is given, or else
+// from the host's docker daemon, which pulls the missing ones.
+func PreloadImages(ctx context.Context, manifest string, archive string, components []string) error {
+	m, err := LoadImageManifest(manifest)
+	if err != nil {
+		return err
+	}
+	images, err := m.Images(components)
+	if err != nil {
+		return err
+	}
+	if err := checkRegistryRefs(images); err != nil {
+		return err
+	}
+	if err := k3d.RegistryCheckPush(ctx); err != nil {
+		return err
+	}
+	if archive != "" {
+		log.WithField("archive", archive).Info("loading images")
+		if err := docker.Load(ctx, archive); err != nil {
+			return err
+		}
+	}
+
+	for _, img := range images {
+		logger := log.WithField("image", img)
+		if !docker.ImageExists(ctx, img) {
+			if archive != "" {
+				return fmt.Errorf("image %s is not in %s", img, archive)
+			}
+			logger.Info("pulling image")
+			if err := docker.Pull(ctx, img); err != nil {
+				return err
+			}
+		}
+		logger.Info("pushing image to registry")
+		if err := k3d.RegistryPush(ctx, img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRegistryRefs ensures the images are pushed to distinct references of
+// the main registry, which does not keep the host of their registry.
+func checkRegistryRefs(images []string) error {
+	hosts := map[string]string{}
+	for _, img := range images {
+		ref, err := k3d.RegistryRef(img)
+		if err != nil {
+			return err
+		}
+		host, _, _ := docker.ParseImage(img)
+		if other, ok := hosts[ref]; ok && other != host {
+			return fmt.Errorf("image %s collides with the one from %s: both would be pushed as %s",
+				img, other, ref)
+		}
+		hosts[ref] = host
+	}
+	return nil
+}
+
+// ImportImages loads images of the host's docker daemon into the nodes of a
+// cluster, e.g, to try out a locally built image without pushing it.
+func ImportImages(ctx context.Context, cn string, images []string) error {
+	for _, img := range images {
+		if !docker.ImageExists(ctx, img) {
+			return fmt.Errorf("image %s not found in the docker daemon", img)
+		}
+	}
+	if err := cluster.Fetch(ctx); err != nil {
+		return err
+	}
+	return cluster.ImportImages(ctx, cn, images)
+}
@@ -0,0 +1,63 @@
+package rockpool
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/lagoon"
+)
+
+func TestPreloadImages(t *testing.T) {
+	f := setUp(t, "images-preload.yml")
+	lagoon.Version = "v2.12.0"
+
+	m, err := LoadImageManifest("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Images([]string{"lagoon-core", "nginx"}); err == nil {
+		t.Error("expected an error for an unknown component")
+	}
+	images, err := m.Images([]string{"cert-manager", "gitea"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(images, []string{
+		"gitea/gitea:latest",
+		"quay.io/jetstack/cert-manager-cainjector:latest",
+		"quay.io/jetstack/cert-manager-controller:latest",
+		"quay.io/jetstack/cert-manager-webhook:latest",
+	}) {
+		t.Errorf("got images %v", images)
+	}
+
+	if err := PreloadImages(context.Background(), "", "", []string{"cert-manager", "gitea"}); err != nil {
+		t.Fatal(err)
+	}
+	if u := f.Unmatched(); len(u) > 0 {
+		t.Fatalf("unexpected commands: %v", u)
+	}
+	for _, c := range [][]string{
+		{"docker", "pull", "quay.io/jetstack/cert-manager-webhook:latest"},
+		{"docker", "tag", "gitea/gitea:latest", "localhost:5111/gitea/gitea:latest"},
+		{"docker", "push", "localhost:5111/jetstack/cert-manager-webhook:latest"},
+	} {
+		if !f.Called(c...) {
+			t.Errorf("expected command %q to be run", c)
+		}
+	}
+	if f.Called("docker", "pull", "gitea/gitea:latest") {
+		t.Error("expected the image of the docker daemon to be used")
+	}
+}
+
+func TestCheckRegistryRefs(t *testing.T) {
+	if err := checkRegistryRefs([]string{"nginx:1.25", "docker.io/library/nginx:1.25",
+		"quay.io/org/img:v1", "quay.io/org/img:v2"}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := checkRegistryRefs([]string{"quay.io/org/img:v1", "docker.io/org/img:v1"}); err == nil {
+		t.Error("expected an error for images from different registries with the same repository")
+	}
+}
@@ -0,0 +1,76 @@
+package rockpool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/cluster"
+	"github.com/salsadigitalauorg/rockpool/pkg/config"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+)
+
+// ListPlatforms writes the platforms whose config has been saved, along with
+// their host ports and the state of their clusters; the current platform is
+// marked with a '*'.
+func ListPlatforms(ctx context.Context, out io.Writer) error {
+	names, err := config.Platforms()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(out, "No platform found")
+		return nil
+	}
+	all, err := cluster.ListAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\tNAME\tHOSTNAME\tPORTS\tCLUSTERS\tSTATE")
+	for _, n := range names {
+		c, err := config.LoadPlatform(n)
+		if err != nil {
+			return err
+		}
+		cns := c.ClusterNames(n)
+		existing, running := 0, 0
+		for _, cl := range all {
+			if !slices.Contains(cns, cl.Name) {
+				continue
+			}
+			existing++
+			if cl.Running {
+				running++
+			}
+		}
+		// The existing clusters are not managed by rockpool.
+		for sn := range c.Kubeconfigs {
+			if slices.Contains(cns, n+"-"+sn) {
+				existing++
+				running++
+			}
+		}
+
+		state := "partially running"
+		switch {
+		case existing == 0:
+			state = "not created"
+		case running == len(cns):
+			state = "running"
+		case running == 0:
+			state = "stopped"
+		}
+		current := ""
+		if n == platform.Name {
+			current = "*"
+		}
+		p := c.Ports.Or(platform.DefaultPorts)
+		fmt.Fprintf(w, "%s\t%s\t%s.%s\thttp:%d https:%d ssh:%d\t%d/%d running\t%s\n",
+			current, n, n, c.Domain, p.HTTP, p.HTTPS, p.SSH, running, len(cns), state)
+	}
+	return w.Flush()
+}
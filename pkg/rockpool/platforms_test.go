@@ -0,0 +1,67 @@
+package rockpool
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/command"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+)
+
+func TestListPlatforms(t *testing.T) {
+	platform.ConfigDir = t.TempDir()
+	platform.Name = "rockpool"
+	// Only the providers whose binary is installed are listed.
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "k3d"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	for n, cfg := range map[string]string{
+		"rockpool": "domain: k3d.local\ntargets: 1\n",
+		"rc":       "domain: k3d.local\ntargets: 2\nports: {http: 20180, https: 20143, ssh: 20122}\n",
+		"old":      "domain: k3d.local\ntargets: 1\n",
+	} {
+		if err := os.MkdirAll(filepath.Join(platform.ConfigDir, n), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(platform.ConfigDir, n, "config.yaml"), []byte(cfg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := command.NewFakeCommander(command.Interaction{
+		Args: []string{"k3d", "cluster", "list", "-o", "json"},
+		Stdout: `[
+			{"name": "rockpool-controller", "serversCount": 1, "serversRunning": 1},
+			{"name": "rockpool-target-1", "serversCount": 1, "serversRunning": 1},
+			{"name": "rc-controller", "serversCount": 1, "serversRunning": 1},
+			{"name": "rc-target-1", "serversCount": 1},
+			{"name": "rc-target-2", "serversCount": 1}
+		]`,
+	})
+	t.Cleanup(f.Install())
+
+	var out bytes.Buffer
+	if err := ListPlatforms(context.Background(), &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected a header and 3 platforms, got:\n%s", out.String())
+	}
+	for i, want := range [][]string{
+		{"old", "old.k3d.local", "0/2 running", "not created"},
+		{"rc", "rc.k3d.local", "http:20180 https:20143 ssh:20122", "1/3 running", "partially running"},
+		{"*", "rockpool", "rockpool.k3d.local", "http:80 https:443 ssh:2022", "2/2 running", "running"},
+	} {
+		for _, w := range want {
+			if !strings.Contains(lines[i+1], w) {
+				t.Errorf("line %q does not contain %q", lines[i+1], w)
+			}
+		}
+	}
+}
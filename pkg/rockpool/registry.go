@@ -0,0 +1,69 @@
+package rockpool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/k3d"
+)
+
+// RegistryStatus writes the state, disk usage and number of repositories of
+// the platform's registries, followed by the repositories if requested.
+func RegistryStatus(ctx context.Context, out io.Writer, repositories bool) error {
+	usages, err := k3d.RegistryStatus(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tMIRRORS\tSTATE\tSIZE\tREPOSITORIES")
+	var total int64
+	for _, u := range usages {
+		mirrors := u.Upstream
+		if mirrors == "" {
+			mirrors = "-"
+		}
+		state, size, repos := "running", FormatSize(u.Size), fmt.Sprint(len(u.Repositories))
+		switch {
+		case !u.Exists:
+			state, size, repos = "not found", "-", "-"
+		case !u.Running:
+			state, size, repos = "stopped", "-", "-"
+		}
+		total += u.Size
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", u.Name, mirrors, state, size, repos)
+	}
+	fmt.Fprintf(w, "\t\ttotal\t%s\t\n", FormatSize(total))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !repositories {
+		return nil
+	}
+	for _, u := range usages {
+		if len(u.Repositories) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\n%s:\n", u.Name)
+		for _, r := range u.Repositories {
+			fmt.Fprintln(out, "  "+r)
+		}
+	}
+	return nil
+}
+
+// FormatSize returns a size in bytes in a human readable form, e.g, 1.5GiB.
+func FormatSize(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
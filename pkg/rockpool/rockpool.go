@@ -1,14 +1,19 @@
 package rockpool
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/salsadigitalauorg/rockpool/pkg/action"
+	"github.com/salsadigitalauorg/rockpool/pkg/bundle"
+	"github.com/salsadigitalauorg/rockpool/pkg/cluster"
 	"github.com/salsadigitalauorg/rockpool/pkg/command"
 	"github.com/salsadigitalauorg/rockpool/pkg/docker"
 	"github.com/salsadigitalauorg/rockpool/pkg/gitea"
@@ -22,49 +27,93 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func EnsureBinariesExist() {
+func init() {
+	action.Stages = []string{"controller-setup", "target-setup"}
+}
+
+func EnsureBinariesExist(ctx context.Context) error {
 	log.Debug("checking if binaries exist")
 	chain := &action.Chain{
 		FailOnFirstError: &[]bool{false}[0],
-		ErrorMsg:         "some requirements were not met; please review above",
+		ErrorMsg:         "some requirements were not met",
+	}
+	bins, err := cluster.Binaries()
+	if err != nil {
+		return err
+	}
+	// k3d is also used to run the registry.
+	if !slices.Contains(bins, "k3d") {
+		bins = append(bins, "k3d")
+	}
+	for _, b := range bins {
+		chain.Add(action.BinaryExists{Bin: b})
 	}
-	chain.Add(action.BinaryExists{Bin: "k3d"}).
-		Add(action.BinaryExists{Bin: "docker", VersionArgs: []string{"--format", "json"}}).
-		Add(action.BinaryExists{Bin: "kubectl", VersionArgs: []string{"--client", "--short"}}).
-		Add(action.BinaryExists{Bin: "helm"}).
+	return chain.Add(action.BinaryExists{Bin: "docker", VersionArgs: []string{"--format", "json"}}).
 		Add(action.BinaryExists{Bin: "lagoon"}).
-		Run()
+		RunContext(ctx).Err()
 }
 
-func Initialise() {
-	EnsureBinariesExist()
+func Initialise(ctx context.Context) error {
+	if err := EnsureBinariesExist(ctx); err != nil {
+		return err
+	}
 
 	// Create directory for rendered templates.
 	templDir := templates.RenderedPath(true)
 	log.WithField("dir", templDir).Debug("creating directory for rendered templates")
 	err := os.MkdirAll(templDir, os.ModePerm)
 	if err != nil {
-		log.WithField("dir", templDir).WithError(err).
-			Fatal("unable to create temp dir")
+		return fmt.Errorf("unable to create directory for rendered templates: %w", err)
 	}
+	return nil
 }
 
-func Up(desiredClusters []string) {
-	k3d.ClusterFetch()
+func Up(ctx context.Context, desiredClusters []string) error {
+	if action.DryRun {
+		return PlanUp(ctx, desiredClusters)
+	}
+
+	if !action.Resume {
+		action.ClearCheckpoints()
+	}
+
+	if err := cluster.Fetch(ctx); err != nil {
+		return err
+	}
 	if len(desiredClusters) == 0 {
-		if len(k3d.Clusters) > 0 {
-			desiredClusters = allClusters()
+		if len(cluster.Clusters) > 0 {
+			desiredClusters = cluster.Names()
 		} else {
-			desiredClusters = append(desiredClusters, platform.ControllerClusterName())
-			for i := 1; i <= platform.NumTargets; i++ {
-				desiredClusters = append(desiredClusters, platform.TargetClusterName(i))
-			}
+			desiredClusters = platform.ClusterNames()
+		}
+	}
+	// The bundle's images include the registry's and the clusters' ones.
+	if bundle.Current != nil {
+		if err := bundle.Current.LoadImages(ctx); err != nil {
+			return interrupted(ctx, "registry", "", err)
 		}
 	}
-	k3d.RegistryCreate()
-	k3d.RegistryRenderConfig()
-	k3d.RegistryStart()
-	CreateClusters(desiredClusters)
+	if err := k3d.RegistryCreate(ctx); err != nil {
+		return interrupted(ctx, "registry", "", err)
+	}
+	if err := k3d.RegistryRenderConfig(); err != nil {
+		return err
+	}
+	if err := k3d.RegistryStart(ctx); err != nil {
+		return interrupted(ctx, "registry", "", err)
+	}
+	if bundle.Current != nil {
+		err := PreloadImages(ctx, bundle.Current.Path(bundle.Current.Images), "", nil)
+		if err != nil {
+			return interrupted(ctx, "registry", "", err)
+		}
+	}
+	if err := CheckPorts(ctx, desiredClusters); err != nil {
+		return err
+	}
+	if err := CreateClusters(ctx, desiredClusters); err != nil {
+		return err
+	}
 
 	setupController := false
 	setupTargets := []string{}
@@ -77,135 +126,322 @@ func Up(desiredClusters []string) {
 	}
 
 	if setupController {
-		SetupLagoonController()
+		if err := SetupLagoonController(ctx); err != nil {
+			return err
+		}
 	}
 
-	lagoon.InitApiClient()
-	lagoon.GetRemotes()
-	if len(setupTargets) > 0 {
-		FetchHarborCerts()
+	if len(setupTargets) > 0 && action.StageSelected("target-setup") {
+		cn := platform.ControllerClusterName()
+		if err := lagoon.InitApiClient(ctx); err != nil {
+			return interrupted(ctx, "target-setup", cn, err)
+		}
+		if err := lagoon.GetRemotes(ctx); err != nil {
+			return interrupted(ctx, "target-setup", cn, err)
+		}
+		if err := FetchHarborCerts(ctx); err != nil {
+			return interrupted(ctx, "target-setup", cn, err)
+		}
+
+		g := platform.NewGroup(ctx)
 		for _, c := range setupTargets {
-			platform.WgAdd(1)
-			go SetupLagoonTarget(c)
+			c := c
+			g.Go(c, func(ctx context.Context) error {
+				return SetupLagoonTarget(ctx, c)
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return err
 		}
-		platform.WgWait()
 
-		SetupNginxReverseProxyForRemotes()
+		if err := SetupNginxReverseProxyForRemotes(ctx); err != nil {
+			return interrupted(ctx, "target-setup", cn, err)
+		}
 
 		// Do the following serially so as not to run into
 		// race conditions while doing the restarts.
 		for _, c := range setupTargets {
-			AddHarborHostEntries(c)
-			InstallHarborCerts(c)
+			if err := AddHarborHostEntries(ctx, c); err != nil {
+				return interrupted(ctx, "target-setup", c, err)
+			}
+			if err := InstallHarborCerts(ctx, c); err != nil {
+				return interrupted(ctx, "target-setup", c, err)
+			}
 		}
 	}
-	InstallResolver()
+	return interrupted(ctx, "resolver", "", InstallResolver(ctx))
 }
 
-func allClusters() []string {
-	cls := []string{}
-	for _, c := range k3d.Clusters {
-		cls = append(cls, c.Name)
+// interrupted wraps the error of a step outside an action chain in an
+// InterruptedError when ctx has been cancelled, so that the stage which was
+// interrupted is reported.
+func interrupted(ctx context.Context, stage string, cn string, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	var ie *action.InterruptedError
+	if errors.As(err, &ie) {
+		return err
 	}
-	return cls
+	return &action.InterruptedError{Stage: stage, Cluster: cn, Err: err}
 }
 
-func Start(clusters []string) {
-	k3d.RegistryStart()
+// PlanUp prints the steps Up would run for the given clusters, without
+// creating or modifying any of them.
+func PlanUp(ctx context.Context, desiredClusters []string) error {
+	if len(desiredClusters) == 0 {
+		desiredClusters = platform.ClusterNames()
+	}
+
+	if bundle.Current != nil {
+		action.Plan("registry", "", "load the images of bundle "+bundle.Current.File)
+	}
+	action.Plan("registry", "", "create and start registry "+k3d.RegistryName())
+	if bundle.Current != nil {
+		action.Plan("registry", "", "preload the images of bundle "+bundle.Current.File)
+	}
+	if err := k3d.RegistryRenderConfig(); err != nil {
+		return err
+	}
+	if err := CheckPorts(ctx, desiredClusters); err != nil {
+		return err
+	}
+	for _, c := range desiredClusters {
+		info := "create or start cluster and write its kubeconfig"
+		if p, err := cluster.ProviderFor(c); err != nil {
+			return err
+		} else if p.Name() == (k3d.Provider{}).Name() {
+			info = fmt.Sprintf("create or start cluster with k3s %s and write its kubeconfig",
+				k3d.ClusterK3sVersion(c))
+		}
+		action.Plan("cluster-create", c, info)
+	}
+
+	setupTargets := []string{}
+	for _, c := range desiredClusters {
+		if c == platform.ControllerClusterName() {
+			if err := SetupLagoonController(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		setupTargets = append(setupTargets, c)
+	}
+
+	if len(setupTargets) > 0 && action.StageSelected("target-setup") {
+		action.Plan("target-setup", platform.ControllerClusterName(),
+			"fetch harbor certificates")
+		for _, c := range setupTargets {
+			if err := SetupLagoonTarget(ctx, c); err != nil {
+				return err
+			}
+		}
+		desc := "set up nginx reverse proxy for remotes"
+		if f, err := renderNginxReverseProxy(); err == nil {
+			desc += kube.DescribeDiff(platform.ControllerClusterName(), "ingress-nginx", f)
+		}
+		action.Plan("target-setup", platform.ControllerClusterName(), desc)
+		for _, c := range setupTargets {
+			action.Plan("target-setup", c, "add harbor host entries and install harbor certificates")
+		}
+	}
+	action.Plan("resolver", "", "install resolver file for "+platform.Hostname())
+	return nil
+}
+
+func Start(ctx context.Context, clusters []string) error {
+	if err := k3d.RegistryStart(ctx); err != nil {
+		return err
+	}
 	log.WithField("clusters", clusters).Info("starting clusters")
-	k3d.ClusterFetch()
+	if err := cluster.Fetch(ctx); err != nil {
+		return err
+	}
 	if len(clusters) == 0 {
-		clusters = allClusters()
+		clusters = cluster.Names()
 	}
 	for _, cn := range clusters {
-		k3d.ClusterStart(cn)
-		AddHarborHostEntries(cn)
+		if err := cluster.Start(ctx, cn); err != nil {
+			return err
+		}
+		if err := AddHarborHostEntries(ctx, cn); err != nil {
+			return err
+		}
 		if cn != platform.ControllerClusterName() {
-			action.Handler{
+			err := action.Handler{
 				Stage:     "cluster-start",
+				Name:      "coredns",
 				Info:      "configuring coredns for target",
 				LogFields: log.Fields{"cluster": cn},
 				Func:      ConfigureTargetCoreDNS,
-			}.Execute()
+			}.Execute(ctx)
+			if err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
 
-func Stop(clusters []string) {
+func Stop(ctx context.Context, clusters []string) error {
 	log.WithField("clusters", clusters).Info("stopping clusters")
-	k3d.ClusterFetch()
+	if err := cluster.Fetch(ctx); err != nil {
+		return err
+	}
 	if len(clusters) == 0 {
-		clusters = allClusters()
+		clusters = cluster.Names()
 	}
+	g := platform.NewGroup(ctx)
 	for _, c := range clusters {
-		platform.WgAdd(1)
-		go func(c string) {
-			defer platform.WgDone()
-			k3d.ClusterStop(c)
-		}(c)
-	}
-	platform.WgWait()
-	k3d.RegistryStop()
+		c := c
+		g.Go(c, func(ctx context.Context) error {
+			return cluster.Stop(ctx, c)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	if err := cluster.Fetch(ctx); err != nil {
+		return err
+	}
+	return k3d.RegistryStop(ctx)
 }
 
-func Down(clusters []string) {
+// Down deletes the clusters and stops the registries; if purge is set and no
+// cluster of the platform is left, the registries are deleted too.
+func Down(ctx context.Context, clusters []string, purge bool) error {
 	log.WithField("clusters", clusters).Info("stopping and deleting clusters")
-	k3d.ClusterFetch()
+	if err := cluster.Fetch(ctx); err != nil {
+		return err
+	}
 	if len(clusters) == 0 {
-		clusters = allClusters()
+		clusters = cluster.Names()
 	}
+	g := platform.NewGroup(ctx)
 	for _, c := range clusters {
 		if c == platform.ControllerClusterName() {
-			LagoonCliDeleteConfig()
-			RemoveResolver()
+			if err := LagoonCliDeleteConfig(ctx); err != nil {
+				log.WithError(err).Warn("unable to delete lagoon config")
+			}
+			RemoveResolver(ctx)
+		}
+		c := c
+		g.Go(c, func(ctx context.Context) error {
+			return cluster.Delete(ctx, c)
+		})
+	}
+	err := g.Wait()
+	// The checkpoints of the clusters which could not be deleted are kept,
+	// so that up can still resume them.
+	for _, r := range g.Results() {
+		if r.Err == nil {
+			action.ClearClusterCheckpoints(r.Cluster)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := cluster.Fetch(ctx); err != nil {
+		return err
+	}
+	if err := k3d.RegistryStop(ctx); err != nil || !purge {
+		return err
+	}
+	if len(cluster.Clusters) > 0 {
+		log.WithField("clusters", cluster.Names()).
+			Warn("not deleting the registries, which are used by the remaining clusters")
+		return nil
+	}
+	return k3d.RegistryDelete(ctx)
+}
+
+// CheckPorts ensures the host ports published by the clusters about to be
+// created are available, reporting all the conflicts at once; the clusters
+// which already exist are skipped.
+func CheckPorts(ctx context.Context, clusters []string) error {
+	chain := &action.Chain{
+		FailOnFirstError: &[]bool{false}[0],
+		ErrorMsg:         "some host ports are already in use; they can be remapped under 'ports' in the config",
+	}
+	for _, c := range clusters {
+		if exists, _ := cluster.Exists(c); exists {
+			continue
+		}
+		ports, err := cluster.PublishedPorts(c, c == platform.ControllerClusterName())
+		if err != nil {
+			return err
+		}
+		for _, p := range ports {
+			chain.Add(action.PortAvailable{ClusterName: c, Port: p.Host, Protocol: p.Protocol})
 		}
-		platform.WgAdd(1)
-		go k3d.ClusterDelete(c)
 	}
-	platform.WgWait()
-	k3d.RegistryStop()
+	return interrupted(ctx, "preflight", "", chain.RunContext(ctx).Err())
 }
 
-func CreateClusters(clusters []string) {
+func CreateClusters(ctx context.Context, clusters []string) error {
 	for _, c := range clusters {
-		k3d.ClusterCreate(c, c == platform.ControllerClusterName())
-		k3d.WriteKubeConfig(c)
+		if err := cluster.Create(ctx, c, c == platform.ControllerClusterName()); err != nil {
+			return interrupted(ctx, "cluster-create", c, err)
+		}
+		if err := cluster.WriteKubeConfig(ctx, c); err != nil {
+			return interrupted(ctx, "cluster-create", c, err)
+		}
 	}
+	return nil
 }
 
-func SetupLagoonController() {
-	clusterName := platform.ControllerClusterName()
+func SetupLagoonController(ctx context.Context) error {
+	return controllerChain(platform.ControllerClusterName()).RunContext(ctx).Err()
+}
 
-	chain := action.Chain{}
+// controllerChain returns the actions setting up the components of the
+// controller.
+func controllerChain(clusterName string) *action.Chain {
+	chain := &action.Chain{}
 
-	chain.Add(kube.Applyer{
+	mailhog := kube.Applyer{
 		Stage:       "controller-setup",
 		Info:        "installing mailhog",
 		ClusterName: clusterName,
 		Namespace:   "default",
 		Force:       true,
 		Template:    "mailhog.yml.tmpl",
-	})
+	}
+	chain.Add(mailhog)
 
-	chain.Add(action.Handler{
-		Func: func(logger *log.Entry) bool {
-			helm.FetchInstalledReleases(clusterName)
-			return true
+	fetchReleases := action.Handler{
+		Stage:     "controller-setup",
+		Name:      "fetch-releases",
+		Info:      "fetching installed helm releases",
+		LogFields: log.Fields{"cluster": clusterName},
+		AlwaysRun: true,
+		Func: func(ctx context.Context, logger *log.Entry) error {
+			return helm.FetchInstalledReleases(ctx, logger.Data["cluster"].(string))
 		},
-	})
+	}
+	chain.Add(fetchReleases)
 
-	ingressNginxInstaller.Stage = "controller-setup"
-	ingressNginxInstaller.ClusterName = clusterName
-	chain.Add(ingressNginxInstaller)
+	ingressNginx := ingressNginxInstaller("controller-setup", clusterName,
+		fetchReleases.GetName())
+	ingressNginxReady := readyWaiter("controller-setup", clusterName,
+		ingressNginx.Namespace, ingressNginx.GetName())
+	chain.Add(ingressNginx).Add(ingressNginxReady)
 
-	chain.Add(kube.Applyer{
+	certManager := helm.Installer{
 		Stage:       "controller-setup",
 		Info:        "installing cert-manager",
 		ClusterName: clusterName,
-		Namespace:   "",
-		Template:    "cert-manager.yaml",
-		Force:       true,
-	}).Add(kube.Waiter{
+		AddRepo: helm.HelmRepo{
+			Name: "jetstack",
+			Url:  "https://charts.jetstack.io",
+		},
+		Namespace:   "cert-manager",
+		ReleaseName: "cert-manager",
+		Chart:       "jetstack/cert-manager",
+		Args:        []string{"--create-namespace", "--set", "installCRDs=true"},
+		DependsOn:   []string{fetchReleases.GetName()},
+	}
+	certManagerWebhook := kube.Waiter{
 		Stage:       "controller-setup",
 		ClusterName: clusterName,
 		Namespace:   "cert-manager",
@@ -213,7 +449,9 @@ func SetupLagoonController() {
 		Condition:   "Available=true",
 		Retries:     10,
 		Delay:       5,
-	}).Add(kube.Applyer{
+		DependsOn:   []string{certManager.GetName()},
+	}
+	ca := kube.Applyer{
 		Stage:       "controller-setup",
 		ClusterName: clusterName,
 		Namespace:   "cert-manager",
@@ -221,7 +459,9 @@ func SetupLagoonController() {
 		Force:       true,
 		Retries:     30,
 		Delay:       10,
-	})
+		DependsOn:   []string{certManagerWebhook.GetName()},
+	}
+	chain.Add(certManager).Add(certManagerWebhook).Add(ca)
 
 	chain.Add(kube.Applyer{
 		Stage:       "controller-setup",
@@ -241,7 +481,7 @@ func SetupLagoonController() {
 	// 	Template:    "gitlab.yml.tmpl",
 	// })
 
-	chain.Add(helm.Installer{
+	giteaInstaller := helm.Installer{
 		Stage:       "controller-setup",
 		Info:        "installing gitea",
 		ClusterName: clusterName,
@@ -255,15 +495,23 @@ func SetupLagoonController() {
 		Args:               []string{"--create-namespace", "--wait"},
 		ValuesTemplate:     "gitea-values.yml.tmpl",
 		ValuesTemplateVars: platform.ToMap(),
-	}).Add(action.Handler{
-		Func: func(logger *log.Entry) bool {
-			// Create test repo.
-			gitea.CreateRepo()
-			return true
+		DependsOn: []string{fetchReleases.GetName(),
+			ingressNginxReady.GetName()},
+	}
+	giteaReady := readyWaiter("controller-setup", clusterName,
+		giteaInstaller.Namespace, giteaInstaller.GetName())
+	chain.Add(giteaInstaller).Add(giteaReady).Add(action.Handler{
+		Stage:     "controller-setup",
+		Name:      "gitea-test-repo",
+		Info:      "setting up gitea test repo",
+		LogFields: log.Fields{"cluster": clusterName},
+		Func: func(ctx context.Context, logger *log.Entry) error {
+			return gitea.CreateRepo(ctx)
 		},
+		DependsOn: []string{giteaReady.GetName()},
 	})
 
-	chain.Add(helm.Installer{
+	harbor := helm.Installer{
 		Stage:       "controller-setup",
 		Info:        "installing harbor",
 		ClusterName: clusterName,
@@ -277,11 +525,16 @@ func SetupLagoonController() {
 		Args:               []string{"--create-namespace", "--wait", "--version=1.5.6"},
 		ValuesTemplate:     "harbor-values.yml.tmpl",
 		ValuesTemplateVars: platform.ToMap(),
-	})
+		DependsOn: []string{fetchReleases.GetName(),
+			ingressNginxReady.GetName(), ca.GetName()},
+	}
+	harborReady := readyWaiter("controller-setup", clusterName, harbor.Namespace,
+		harbor.GetName())
+	chain.Add(harbor).Add(harborReady)
 
 	lagoonValues := platform.ToMap()
 	lagoonValues["LagoonVersion"] = lagoon.Version
-	chain.Add(helm.Installer{
+	lagoonCore := helm.Installer{
 		Stage:       "controller-setup",
 		Info:        "installing lagoon core",
 		ClusterName: clusterName,
@@ -295,46 +548,55 @@ func SetupLagoonController() {
 		Args:               []string{"--create-namespace", "--wait", "--timeout", "30m0s"},
 		ValuesTemplate:     "lagoon-core-values.yml.tmpl",
 		ValuesTemplateVars: lagoonValues,
-	}).Add(action.Handler{
+		DependsOn: []string{fetchReleases.GetName(),
+			ingressNginxReady.GetName(), harborReady.GetName()},
+	}
+	// lagoon-core's pods, keycloak's in particular, may still be starting or
+	// crash-looping once the chart is installed.
+	lagoonCoreReady := readyWaiter("controller-setup", clusterName,
+		lagoonCore.Namespace, lagoonCore.GetName())
+	lagoonCoreReady.Timeout = 30 * time.Minute
+	dbTables := action.Handler{
 		Stage:     "controller-setup",
+		Name:      "db-tables",
 		Info:      "ensuring db tables have been created",
 		LogFields: log.Fields{"cluster": clusterName},
-		Func: func(logger *log.Entry) bool {
+		Func: func(ctx context.Context, logger *log.Entry) error {
 			cn := logger.Data["cluster"].(string)
 
 			logger.Debug("checking if tables exist")
-			out, err := kube.Cmd(cn, "lagoon-core", "exec",
-				"sts/lagoon-core-api-db", "--", "bash", "-c",
+			out, err := kube.ExecIn(ctx, cn, "lagoon-core",
+				"sts/lagoon-core-api-db", nil, "bash", "-c",
 				"mysql -u$MARIADB_USER -p$MARIADB_PASSWORD $MARIADB_DATABASE -e 'SHOW TABLES;'",
-			).Output()
+			)
 			if err != nil {
-				logger.WithError(command.GetMsgFromCommandError(err)).
-					Fatal("error getting tables")
+				return fmt.Errorf("error getting tables: %w", err)
 			}
 			if string(out) != "" {
-				return true
+				return nil
 			}
 
 			logger.Debug("running the db init script")
-			err = kube.Cmd(cn, "lagoon-core", "exec", "sts/lagoon-core-api-db",
-				"--", "/legacy_rerun_initdb.sh").Run()
+			_, err = kube.ExecIn(ctx, cn, "lagoon-core", "sts/lagoon-core-api-db",
+				nil, "/legacy_rerun_initdb.sh")
 			if err != nil {
-				logger.WithError(command.GetMsgFromCommandError(err)).
-					Fatal("error running db init")
+				return fmt.Errorf("error running db init: %w", err)
 			}
-
-			return true
+			return nil
 		},
-	})
+		DependsOn: []string{lagoonCoreReady.GetName()},
+	}
+	chain.Add(lagoonCore).Add(lagoonCoreReady).Add(dbTables)
 
-	chain.Add(action.Handler{
+	keycloak := action.Handler{
 		Stage:     "controller-setup",
+		Name:      "keycloak",
 		Info:      "configuring keycloak",
 		LogFields: log.Fields{"cluster": clusterName},
-		Func: func(logger *log.Entry) bool {
+		Func: func(ctx context.Context, logger *log.Entry) error {
 			logger.Debug("logging into keycloak")
 			cn := logger.Data["cluster"].(string)
-			if err := kube.Exec(
+			if _, err := kube.Exec(ctx,
 				cn, "lagoon-core", "lagoon-core-keycloak", `
 set -e
 rm -f /tmp/kcadm.config
@@ -343,21 +605,19 @@ rm -f /tmp/kcadm.config
   --user $KEYCLOAK_ADMIN_USER --password $KEYCLOAK_ADMIN_PASSWORD \
   --config /tmp/kcadm.config
 `,
-			).Run(); err != nil {
-				logger.WithError(command.GetMsgFromCommandError(err)).
-					Fatal("error logging in to Keycloak")
+			); err != nil {
+				return fmt.Errorf("error logging in to Keycloak: %w", err)
 			}
 
 			logger.Debug("checking if keycloak has already been configured")
-			if out, err := kube.Exec(
+			if out, err := kube.Exec(ctx,
 				cn, "lagoon-core", "lagoon-core-keycloak", `
 set -e
 /opt/jboss/keycloak/bin/kcadm.sh get realms/lagoon \
 	--fields 'smtpServer(from)' --config /tmp/kcadm.config
 `,
-			).Output(); err != nil {
-				logger.WithError(command.GetMsgFromCommandError(err)).
-					Fatal("error checking keycloak configuration")
+			); err != nil {
+				return fmt.Errorf("error checking keycloak configuration: %w", err)
 			} else {
 				s := struct {
 					SmtpServer struct {
@@ -366,16 +626,16 @@ set -e
 				}{}
 				err := json.Unmarshal(out, &s)
 				if err != nil {
-					logger.WithError(err).Fatal("error parsing keycloak configuration")
+					return fmt.Errorf("error parsing keycloak configuration: %w", err)
 				}
 				if s.SmtpServer.From == "lagoon@k3d-rockpool" {
 					logger.Debug("keycloak already configured")
-					return true
+					return nil
 				}
 			}
 
 			// Configure keycloak.
-			err := kube.Exec(cn, "lagoon-core", "lagoon-core-keycloak", `
+			_, err := kube.Exec(ctx, cn, "lagoon-core", "lagoon-core-keycloak", `
 set -e
 
 /opt/jboss/keycloak/bin/kcadm.sh update realms/lagoon \
@@ -398,53 +658,79 @@ client_id=$(echo ${client%,*} | sed 's/"//g')
 /opt/jboss/keycloak/bin/kcadm.sh update realms/lagoon/clients/${client_id} \
 	-s directAccessGrantsEnabled=true --config /tmp/kcadm.config
 `,
-			).Run()
+			)
 			if err != nil {
-				logger.WithError(command.GetMsgFromCommandError(err)).
-					Fatal("error configuring keycloak")
+				return fmt.Errorf("error configuring keycloak: %w", err)
 			}
-			return true
+			return nil
 		},
-	})
+		DependsOn: []string{lagoonCoreReady.GetName(), mailhog.GetName()},
+	}
+	chain.Add(keycloak)
 
 	chain.Add(action.Handler{
 		Stage:     "controller-setup",
+		Name:      "lagoon-client",
 		Info:      "configuring lagoon client",
 		LogFields: log.Fields{"cluster": clusterName},
-		Func: func(logger *log.Entry) bool {
-			lagoon.InitApiClient()
-			lagoon.AddSshKey()
-			LagoonCliAddConfig()
-			return true
+		Func: func(ctx context.Context, logger *log.Entry) error {
+			if err := lagoon.InitApiClient(ctx); err != nil {
+				return err
+			}
+			if err := lagoon.AddSshKey(ctx); err != nil {
+				return err
+			}
+			return LagoonCliAddConfig(ctx)
 		},
+		DependsOn: []string{keycloak.GetName(), dbTables.GetName()},
 	})
 
-	chain.Run()
+	return chain
 }
 
-func SetupLagoonTarget(clusterName string) {
-	chain := action.Chain{}
+func SetupLagoonTarget(ctx context.Context, clusterName string) error {
+	chain, err := targetChain(clusterName)
+	if err != nil {
+		return err
+	}
+	return chain.RunContext(ctx).Err()
+}
 
-	defer platform.WgDone()
+// targetChain returns the actions setting up the components of a target.
+func targetChain(clusterName string) (*action.Chain, error) {
+	target, ok := platform.TargetFor(clusterName)
+	if !ok {
+		return nil, fmt.Errorf("no target defined for cluster %s", clusterName)
+	}
+	chain := &action.Chain{}
 
-	chain.Add(action.Handler{
+	coreDNS := action.Handler{
 		Stage:     "target-setup",
+		Name:      "coredns",
 		Info:      "configuring coredns for target",
 		LogFields: log.Fields{"cluster": clusterName},
 		Func:      ConfigureTargetCoreDNS,
-	})
+	}
+	chain.Add(coreDNS)
 
-	chain.Add(action.Handler{
-		Func: func(logger *log.Entry) bool {
-			helm.FetchInstalledReleases(clusterName)
-			return true
+	fetchReleases := action.Handler{
+		Stage:     "target-setup",
+		Name:      "fetch-releases",
+		Info:      "fetching installed helm releases",
+		LogFields: log.Fields{"cluster": clusterName},
+		AlwaysRun: true,
+		Func: func(ctx context.Context, logger *log.Entry) error {
+			return helm.FetchInstalledReleases(ctx, logger.Data["cluster"].(string))
 		},
-	})
-	ingressNginxInstaller.Stage = "target-setup"
-	ingressNginxInstaller.ClusterName = clusterName
-	chain.Add(ingressNginxInstaller)
-
-	chain.Add(helm.Installer{
+	}
+	chain.Add(fetchReleases)
+	ingressNginx := ingressNginxInstaller("target-setup", clusterName,
+		fetchReleases.GetName())
+	ingressNginxReady := readyWaiter("target-setup", clusterName,
+		ingressNginx.Namespace, ingressNginx.GetName())
+	chain.Add(ingressNginx).Add(ingressNginxReady)
+
+	nfs := helm.Installer{
 		Stage:       "target-setup",
 		Info:        "installing nfs provisioner",
 		ClusterName: clusterName,
@@ -458,9 +744,23 @@ func SetupLagoonTarget(clusterName string) {
 		Args:               []string{"--create-namespace", "--wait"},
 		ValuesTemplate:     "nfs-server-provisioner-values.yml.tmpl",
 		ValuesTemplateVars: platform.ToMap(),
-	})
+		DependsOn:          []string{fetchReleases.GetName()},
+	}
+	nfsReady := readyWaiter("target-setup", clusterName, nfs.Namespace, nfs.GetName())
+	chain.Add(nfs).Add(nfsReady)
+	if len(target.Mounts) > 0 {
+		chain.Add(action.Handler{
+			Stage:     "target-setup",
+			Name:      "host-mounts",
+			Info:      "creating host mount volumes",
+			LogFields: log.Fields{"cluster": clusterName},
+			Func: func(ctx context.Context, logger *log.Entry) error {
+				return ApplyHostMounts(ctx, target)
+			},
+		})
+	}
 
-	chain.Add(kube.Applyer{
+	dbaas := kube.Applyer{
 		Stage:       "target-setup",
 		Info:        "applying dbaas-operator manifests",
 		ClusterName: clusterName,
@@ -471,7 +771,8 @@ func SetupLagoonTarget(clusterName string) {
 			"https://raw.githubusercontent.com/amazeeio/charts/main/charts/dbaas-operator/crds/postgres.yaml",
 		},
 		Force: true,
-	}).Add(helm.Installer{
+	}
+	mariadbProduction := helm.Installer{
 		Stage:       "target-setup",
 		Info:        "installing mariadb-production",
 		ClusterName: clusterName,
@@ -488,7 +789,9 @@ func SetupLagoonTarget(clusterName string) {
 			"--set", "secret.MYSQL_ROOT_PASSWORD=mariadbpass",
 			"--set", "persistence.config.enabled=true",
 		},
-	}).Add(helm.Installer{
+		DependsOn: []string{fetchReleases.GetName()},
+	}
+	mariadbDevelopment := helm.Installer{
 		Stage:       "target-setup",
 		Info:        "installing mariadb-development",
 		ClusterName: clusterName,
@@ -505,17 +808,35 @@ func SetupLagoonTarget(clusterName string) {
 			"--set", "secret.MYSQL_ROOT_PASSWORD=mariadbpass",
 			"--set", "persistence.config.enabled=true",
 		},
-	})
+		DependsOn: []string{fetchReleases.GetName()},
+	}
+	mariadbReady := readyWaiter("target-setup", clusterName, "mariadb",
+		mariadbProduction.GetName(), mariadbDevelopment.GetName())
+	chain.Add(dbaas).Add(mariadbProduction).Add(mariadbDevelopment).Add(mariadbReady)
 
 	lagoonValues := platform.ToMap()
 	lagoonValues["LagoonVersion"] = lagoon.Version
-	lagoonValues["TargetId"] = fmt.Sprint(kube.GetTargetIdFromCn(clusterName))
-	_, lagoonValues["RabbitMQPassword"] = kube.GetSecret(platform.ControllerClusterName(),
-		"lagoon-core",
-		"lagoon-core-broker",
-		"RABBITMQ_PASSWORD",
-	)
-	chain.Add(helm.Installer{
+	lagoonValues["RemoteName"] = target.Remote()
+	rabbitMQPassword := action.Handler{
+		Stage:     "target-setup",
+		Name:      "rabbitmq-password",
+		Info:      "fetching rabbitmq password from lagoon core",
+		LogFields: log.Fields{"cluster": clusterName},
+		AlwaysRun: true,
+		Func: func(ctx context.Context, logger *log.Entry) error {
+			// The values map is shared with the lagoon remote installer
+			// below, which renders it when executed.
+			_, password, err := kube.GetSecret(ctx,
+				platform.ControllerClusterName(),
+				"lagoon-core",
+				"lagoon-core-broker",
+				"RABBITMQ_PASSWORD",
+			)
+			lagoonValues["RabbitMQPassword"] = password
+			return err
+		},
+	}
+	lagoonRemote := helm.Installer{
 		Stage:       "target-setup",
 		Info:        "installing lagoon remote",
 		ClusterName: clusterName,
@@ -529,70 +850,115 @@ func SetupLagoonTarget(clusterName string) {
 		Args:               []string{"--create-namespace", "--wait"},
 		ValuesTemplate:     "lagoon-remote-values.yml.tmpl",
 		ValuesTemplateVars: lagoonValues,
-	})
+		ValuesFile:         clusterName + "-lagoon-remote-values.yml",
+		DependsOn: []string{
+			coreDNS.GetName(),
+			fetchReleases.GetName(),
+			ingressNginxReady.GetName(),
+			nfsReady.GetName(),
+			dbaas.GetName(),
+			mariadbReady.GetName(),
+			rabbitMQPassword.GetName(),
+		},
+	}
+	lagoonRemoteReady := readyWaiter("target-setup", clusterName,
+		lagoonRemote.Namespace, lagoonRemote.GetName())
+	chain.Add(rabbitMQPassword).Add(lagoonRemote).Add(lagoonRemoteReady)
 
 	chain.Add(action.Handler{
 		Stage:     "target-setup",
+		Name:      "register-remote",
 		Info:      "registering lagoon remote",
 		LogFields: log.Fields{"cluster": clusterName},
-		Func: func(logger *log.Entry) bool {
+		Func: func(ctx context.Context, logger *log.Entry) error {
 			cn := logger.Data["cluster"].(string)
-			cId := kube.GetTargetIdFromCn(cn)
-			rName := platform.Name + fmt.Sprint(cId)
-			re := lagoon.Remote{
-				Id:            cId,
-				Name:          rName,
-				ConsoleUrl:    fmt.Sprintf("https://%s:6443", k3d.TargetIP(cn)),
-				RouterPattern: fmt.Sprintf("${environment}.${project}.%s.%s", rName, platform.Hostname()),
+			_, c := cluster.Exists(cn)
+			if c.API == "" {
+				return fmt.Errorf("no API server address found for cluster %s", cn)
 			}
-			for _, existingRe := range lagoon.Remotes {
-				if existingRe.Id == re.Id && existingRe.Name == re.Name {
-					logger.WithField("remote", re.Name).Debug("Lagoon remote already exists")
-					return true
-				}
+			re := lagoon.Remote{
+				Id:            target.RemoteId,
+				Name:          target.Remote(),
+				ConsoleUrl:    c.API,
+				RouterPattern: target.Routes(),
 			}
-			b64Token, err := kube.Cmd(cn, "lagoon", "get", "secret",
-				"-o=jsonpath='{.items[?(@.metadata.annotations.kubernetes\\.io/service-account\\.name==\"lagoon-remote-kubernetes-build-deploy\")].data.token}'").Output()
-			if err != nil {
-				logger.WithError(command.GetMsgFromCommandError(err)).
-					Fatal("error fetching lagoon remote token")
+			if lagoon.RemoteExists(re.Id, re.Name) {
+				logger.WithField("remote", re.Name).Debug("Lagoon remote already exists")
+				return nil
 			}
-			token, err := base64.URLEncoding.DecodeString(strings.Trim(string(b64Token), "'"))
+			token, err := kube.ServiceAccountToken(ctx, cn, "lagoon",
+				"lagoon-remote-kubernetes-build-deploy")
 			if err != nil {
-				logger.WithError(err).Fatal("error decoding lagoon remote token")
+				return fmt.Errorf("error fetching lagoon remote token: %w", err)
 			}
-			lagoon.AddRemote(re, string(token))
-			return true
+			return lagoon.AddRemote(ctx, re, token)
 		},
+		DependsOn: []string{lagoonRemoteReady.GetName()},
 	})
 
-	chain.Run()
+	return chain, nil
 }
 
-func SetupNginxReverseProxyForRemotes() {
+// ApplyHostMounts creates the storage class and the persistent volumes of the
+// target's mounts; the host directories are mounted in the nodes when the
+// cluster is created.
+func ApplyHostMounts(ctx context.Context, t platform.Target) error {
+	cn := t.ClusterName()
+	logger := log.WithFields(log.Fields{"clusterName": cn, "mounts": len(t.Mounts)})
+	if _, c := cluster.Exists(cn); c.Provider == cluster.ExistingProviderName {
+		logger.Warn("the host directories can't be mounted in an existing cluster; skipping the volumes")
+		return nil
+	}
+	f, err := templates.Render("host-mounts.yml.tmpl", map[string]interface{}{
+		"StorageClass": platform.HostStorageClass,
+		"Mounts":       t.Mounts,
+	}, cn+"-host-mounts.yml")
+	if err != nil {
+		return fmt.Errorf("error rendering template: %w", err)
+	}
+	logger.Debug("applying host mount volumes")
+	return kube.Apply(ctx, cn, "", f, true)
+}
+
+func SetupNginxReverseProxyForRemotes(ctx context.Context) error {
 	cn := platform.ControllerClusterName()
 	logger := log.WithField("clusterName", cn)
 	logger.Info("setting up nginx reverse proxy for remotes")
 
+	patchFile, err := renderNginxReverseProxy()
+	if err != nil {
+		return err
+	}
+	return kube.Apply(ctx, cn, "ingress-nginx", patchFile, true)
+}
+
+// renderNginxReverseProxy renders the ingress-nginx config proxying the
+// routes of the targets' environments to their clusters.
+func renderNginxReverseProxy() (string, error) {
 	cm := map[string]interface{}{
 		"Name":   platform.Name,
 		"Domain": platform.Domain,
 	}
-	targets := map[int]string{}
-	for i := 0; i < platform.NumTargets; i++ {
-		targets[i+1] = k3d.TargetIP(platform.TargetClusterName(i + 1))
+	// The routes of the targets' environments resolve to the controller,
+	// which proxies them to the target.
+	targets := map[string]string{}
+	for _, t := range platform.Targets {
+		ip, err := cluster.IP(t.ClusterName())
+		if err != nil {
+			return "", err
+		}
+		targets[t.ServerName()] = ip
 	}
 	cm["Targets"] = targets
 
 	patchFile, err := templates.Render("ingress-nginx-values.yml.tmpl", cm, "")
 	if err != nil {
-		logger.WithError(err).Fatal("error rendering template")
+		return "", fmt.Errorf("error rendering template: %w", err)
 	}
-
-	kube.Apply(cn, "ingress-nginx", patchFile, true)
+	return patchFile, nil
 }
 
-func InstallResolver() {
+func InstallResolver(ctx context.Context) error {
 	nameserverIp := docker.GetVmIp()
 
 	dest := filepath.Join("/etc/resolver", platform.Hostname())
@@ -601,58 +967,54 @@ func InstallResolver() {
 
 	data := fmt.Sprintf(`
 nameserver %s
-port 6153
-`, nameserverIp)
+port %d
+`, nameserverIp, platform.HostPorts.DNS)
 
 	var tmpFile *os.File
 	var err error
 
 	if _, err := os.Stat(dest); err == nil {
 		logger.Debug("resolver file already exists")
-		return
+		return nil
 	}
 
 	logger.Info("creating resolver file")
 	if tmpFile, err = os.CreateTemp("", "rockpool-resolver-"); err != nil {
-		logger.WithError(err).Panic("unable to create temporary file")
+		return fmt.Errorf("unable to create temporary file: %w", err)
 	}
 	if err = os.Chmod(tmpFile.Name(), 0777); err != nil {
-		logger.WithField("tempFile", tmpFile.Name()).WithError(err).
-			Panic("unable to set file permissions")
+		return fmt.Errorf("unable to set permissions on %s: %w", tmpFile.Name(), err)
 	}
 	if _, err = tmpFile.WriteString(data); err != nil {
-		logger.WithField("tempFile", tmpFile.Name()).WithError(err).
-			Panic("unable to write to temporary file")
+		return fmt.Errorf("unable to write to %s: %w", tmpFile.Name(), err)
 	}
-	if err = command.ShellCommander("sudo", "mv", tmpFile.Name(), dest).Run(); err != nil {
-		logger.WithFields(log.Fields{
-			"tempFile":    tmpFile.Name(),
-			"destination": dest,
-		}).WithError(command.GetMsgFromCommandError(err)).
-			Panic("unable to move file")
+	if err = command.ShellCommander(ctx, "sudo", "mv", tmpFile.Name(), dest).Run(); err != nil {
+		return fmt.Errorf("unable to move %s to %s: %w", tmpFile.Name(), dest,
+			command.GetMsgFromCommandError(err))
 	}
+	return nil
 }
 
-func RemoveResolver() {
+func RemoveResolver(ctx context.Context) {
 	dest := filepath.Join("/etc/resolver", platform.Hostname())
 	logger := log.WithField("resolverFile", dest)
 	logger.Info("removing resolver file")
-	if err := command.ShellCommander("rm", "-f", dest).Run(); err != nil {
+	if err := command.ShellCommander(ctx, "rm", "-f", dest).Run(); err != nil {
 		logger.WithError(command.GetMsgFromCommandError(err)).
 			Warn("error when deleting resolver file")
 	}
 }
 
-func LagoonCliAddConfig() {
-	graphql := fmt.Sprintf("http://api.lagoon.%s/graphql", platform.Hostname())
-	ui := fmt.Sprintf("http://ui.lagoon.%s", platform.Hostname())
+func LagoonCliAddConfig(ctx context.Context) error {
+	graphql := fmt.Sprintf("http://%s/graphql", platform.HTTPHost("api.lagoon."+platform.Hostname()))
+	ui := "http://" + platform.HTTPHost("ui.lagoon."+platform.Hostname())
 
 	// Get list of existing configs.
-	out, err := command.ShellCommander("lagoon", "config", "list",
+	out, err := command.ShellCommander(command.Query(ctx), "lagoon", "config", "list",
 		"--output-json").Output()
 	if err != nil {
-		log.WithError(command.GetMsgFromCommandError(err)).
-			Panic("could not get lagoon configs")
+		return fmt.Errorf("could not get lagoon configs: %w",
+			command.GetMsgFromCommandError(err))
 	}
 	var configs struct {
 		Data []struct {
@@ -663,7 +1025,7 @@ func LagoonCliAddConfig() {
 	}
 	err = json.Unmarshal(out, &configs)
 	if err != nil {
-		log.WithError(err).Panic("could not parse lagoon configs")
+		return fmt.Errorf("could not parse lagoon configs: %w", err)
 	}
 
 	logger := log.WithFields(log.Fields{
@@ -675,30 +1037,36 @@ func LagoonCliAddConfig() {
 
 	// Add the config.
 	logger.Info("adding lagoon config")
-	err = command.ShellCommander("lagoon", "config", "add", "--lagoon",
+	err = command.ShellCommander(ctx, "lagoon", "config", "add", "--lagoon",
 		platform.Name, "--graphql", graphql, "--ui", ui, "--hostname",
-		"127.0.0.1", "--port", "2022").Run()
+		"127.0.0.1", "--port", strconv.Itoa(platform.HostPorts.SSH)).Run()
 	if err != nil {
-		log.WithError(command.GetMsgFromCommandError(err)).
-			Panic("could not add lagoon config")
+		return fmt.Errorf("could not add lagoon config: %w",
+			command.GetMsgFromCommandError(err))
 	}
+	return nil
 }
 
-func LagoonCliDeleteConfig() {
-	// Get list of existing configs.
-	err := command.ShellCommander("lagoon", "config", "delete", "--lagoon",
+func LagoonCliDeleteConfig(ctx context.Context) error {
+	err := command.ShellCommander(ctx, "lagoon", "config", "delete", "--lagoon",
 		platform.Name, "--force").Run()
 	if err != nil {
-		log.WithError(command.GetMsgFromCommandError(err)).
-			Panic("could not delete lagoon config")
+		return fmt.Errorf("could not delete lagoon config: %w",
+			command.GetMsgFromCommandError(err))
 	}
+	return nil
 }
 
-func Status() {
-	k3d.ClusterFetch()
-	if len(k3d.Clusters) == 0 {
+func Status(ctx context.Context) error {
+	if err := cluster.Fetch(ctx); err != nil {
+		return err
+	}
+	if len(cluster.Clusters) == 0 {
 		fmt.Printf("No cluster found for '%s'\n", platform.Name)
-		return
+		return nil
+	}
+	if err := k3d.RegistryGet(ctx); err != nil {
+		return err
 	}
 
 	fmt.Print("Registry: ")
@@ -710,27 +1078,40 @@ func Status() {
 
 	runningClusters := 0
 	fmt.Println("Clusters:")
-	for _, c := range k3d.Clusters {
-		isRunning := k3d.ClusterIsRunning(c.Name)
-		fmt.Printf("  %s: ", c.Name)
-		if isRunning {
-			fmt.Println("running")
+	for _, c := range cluster.Clusters {
+		state := "stopped"
+		if c.Running {
+			state = "running"
 			runningClusters++
-		} else {
-			fmt.Println("stopped")
+		}
+		if c.Version != "" {
+			state += ", k3s " + c.Version
+		}
+		if c.Provider != cluster.DefaultProvider {
+			state += " (" + c.Provider + ")"
+		}
+		fmt.Printf("  %s: %s\n", c.Name, state)
+		if len(c.Nodes) > 1 {
+			for _, n := range c.Nodes {
+				nodeState := "stopped"
+				if n.Running {
+					nodeState = "running"
+				}
+				fmt.Printf("    %s: %s, %s\n", n.Name, n.Role, nodeState)
+			}
 		}
 	}
 
 	if runningClusters == 0 {
 		fmt.Println("No running cluster")
-		return
+		return nil
 	}
 
 	fmt.Println("Kubeconfig:")
 	fmt.Println("  Controller:", kube.KubeconfigPath(platform.ControllerClusterName()))
-	if len(k3d.Clusters) > 1 {
+	if len(cluster.Clusters) > 1 {
 		fmt.Println("  Targets:")
-		for _, c := range k3d.Clusters {
+		for _, c := range cluster.Clusters {
 			if c.Name == platform.ControllerClusterName() {
 				continue
 			}
@@ -739,21 +1120,22 @@ func Status() {
 	}
 
 	fmt.Println("Gitea:")
-	fmt.Printf("  http://gitea.lagoon.%s\n", platform.Hostname())
+	fmt.Printf("  http://%s\n", platform.HTTPHost("gitea.lagoon."+platform.Hostname()))
 	fmt.Println("  User: rockpool")
 	fmt.Println("  Pass: pass")
 
 	fmt.Println("Keycloak:")
-	fmt.Printf("  http://keycloak.lagoon.%s/auth/admin\n", platform.Hostname())
+	fmt.Printf("  http://%s/auth/admin\n", platform.HTTPHost("keycloak.lagoon."+platform.Hostname()))
 	fmt.Println("  User: admin")
 	fmt.Println("  Pass: pass")
 
-	fmt.Printf("Lagoon UI: http://ui.lagoon.%s\n", platform.Hostname())
+	fmt.Printf("Lagoon UI: http://%s\n", platform.HTTPHost("ui.lagoon."+platform.Hostname()))
 	fmt.Println("  User: lagoonadmin")
 	fmt.Println("  Pass: pass")
 
-	fmt.Printf("Lagoon GraphQL: http://api.lagoon.%s/graphql\n", platform.Hostname())
-	fmt.Println("Lagoon SSH: ssh -p 2022 lagoon@localhost")
+	fmt.Printf("Lagoon GraphQL: http://%s/graphql\n", platform.HTTPHost("api.lagoon."+platform.Hostname()))
+	fmt.Printf("Lagoon SSH: ssh -p %d lagoon@localhost\n", platform.HostPorts.SSH)
 
 	fmt.Println()
+	return nil
 }
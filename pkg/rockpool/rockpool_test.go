@@ -0,0 +1,336 @@
+package rockpool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/action"
+	"github.com/salsadigitalauorg/rockpool/pkg/cluster"
+	"github.com/salsadigitalauorg/rockpool/pkg/command"
+	"github.com/salsadigitalauorg/rockpool/pkg/helm"
+	"github.com/salsadigitalauorg/rockpool/pkg/k3d"
+	"github.com/salsadigitalauorg/rockpool/pkg/kube"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// setUp configures the platform to use temporary directories and replays
+// the commands from the fixture.
+func setUp(t *testing.T, fixture string) *command.FakeCommander {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	platform.ConfigDir = t.TempDir()
+	platform.Name = "rockpool"
+	platform.Domain = "k3d.local"
+	platform.Targets = platform.NumberedTargets(1)
+	k3d.Clusters = nil
+	cluster.Clusters = nil
+	t.Cleanup(func() {
+		k3d.Clusters = nil
+		cluster.Clusters = nil
+	})
+	if err := os.MkdirAll(filepath.Join(platform.ConfigDir, "rendered", platform.Name), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := command.NewFakeCommanderFromFixture(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(f.Install())
+	return f
+}
+
+// markCompleted records the given actions as completed and enables resuming,
+// so that the actions talking to the services over http are skipped.
+func markCompleted(t *testing.T, keys ...string) {
+	t.Helper()
+	s := action.State{Completed: map[string]time.Time{}}
+	for _, k := range keys {
+		s.Completed[k] = time.Now()
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(platform.Dir(), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(action.StatePath(), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	action.Resume = true
+	t.Cleanup(func() { action.Resume = false })
+}
+
+// fakeControllerClient creates a fake client for the controller, whose
+// keycloak has already been configured.
+func fakeControllerClient(t *testing.T, scripts *[]string) {
+	t.Helper()
+	available := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "cert-manager-webhook", Namespace: "cert-manager"},
+		Status: appsv1.DeploymentStatus{Conditions: []appsv1.DeploymentCondition{
+			{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionTrue},
+		}},
+	}
+	workload := func(kind string, name string) runtime.Object {
+		selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}}
+		meta := metav1.ObjectMeta{Name: name, Namespace: "lagoon-core"}
+		if kind == "sts" {
+			return &appsv1.StatefulSet{ObjectMeta: meta, Spec: appsv1.StatefulSetSpec{Selector: selector}}
+		}
+		return &appsv1.Deployment{ObjectMeta: meta, Spec: appsv1.DeploymentSpec{Selector: selector}}
+	}
+	podIn := func(ns string, name string) runtime.Object {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name + "-0", Namespace: ns,
+				Labels: map[string]string{"app": name}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: name}}},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, Conditions: []corev1.PodCondition{
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+			}},
+		}
+	}
+	pod := func(name string) runtime.Object { return podIn("lagoon-core", name) }
+	// The scripts are run by concurrent actions.
+	var mu sync.Mutex
+	exec := func(ctx context.Context, ns string, pod string, container string,
+		cmd []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+		script := strings.Join(cmd, " ")
+		mu.Lock()
+		*scripts = append(*scripts, script)
+		mu.Unlock()
+		switch {
+		case strings.Contains(script, "SHOW TABLES"):
+			fmt.Fprintln(stdout, "Tables_in_infrastructure")
+		case strings.Contains(script, "kcadm.sh get realms/lagoon"):
+			fmt.Fprint(stdout, `{"smtpServer": {"from": "lagoon@k3d-rockpool"}}`)
+		}
+		return nil
+	}
+	c := kube.NewFakeClient(exec, available,
+		workload("sts", "lagoon-core-api-db"), pod("lagoon-core-api-db"),
+		workload("deploy", "lagoon-core-keycloak"), pod("lagoon-core-keycloak"),
+		podIn("ingress-nginx", "ingress-nginx-controller"), podIn("gitea", "gitea"),
+		podIn("harbor", "harbor-core"))
+	t.Cleanup(kube.InstallFakeClient(c))
+}
+
+func TestUpController(t *testing.T) {
+	f := setUp(t, "up-controller.yml")
+	scripts := []string{}
+	fakeControllerClient(t, &scripts)
+	h := helm.NewFake()
+	t.Cleanup(h.Install())
+	markCompleted(t,
+		"controller-setup/rockpool-controller/handler:gitea-test-repo",
+		"controller-setup/rockpool-controller/handler:lagoon-client",
+	)
+
+	err := Up(context.Background(), []string{platform.ControllerClusterName()})
+	for _, u := range f.Unmatched() {
+		t.Logf("unmatched: %q", u)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range [][]string{
+		{"k3d", "registry", "create", "rockpool-registry", "--port", "5111"},
+		{"k3d", "registry", "create", "rockpool-registry-docker-io"},
+		{"docker", "exec", "k3d-rockpool-registry-docker-io", "ash", "-c",
+			"echo 'proxy:\n  remoteurl: https://registry-1.docker.io' >> /etc/docker/registry/config.yml"},
+		{"k3d", "registry", "create", "rockpool-registry-ghcr-io"},
+		{"k3d", "cluster", "create", "**"},
+		{"k3d", "kubeconfig", "write", "rockpool-controller"},
+		{"sudo", "mv", "*", "/etc/resolver/rockpool.k3d.local"},
+	} {
+		if !f.Called(c...) {
+			t.Errorf("expected command %q to be run", c)
+		}
+	}
+	if err := helm.FetchInstalledReleases(context.Background(), "rockpool-controller"); err != nil {
+		t.Fatal(err)
+	}
+	releases, _ := helm.GetReleases("rockpool-controller")
+	installed := map[string]string{}
+	for _, r := range releases {
+		installed[r.Name] = r.Namespace + "/" + r.Chart
+	}
+	if !reflect.DeepEqual(installed, map[string]string{
+		"ingress-nginx": "ingress-nginx/ingress-nginx-4.5.2-0.1.0",
+		"cert-manager":  "cert-manager/cert-manager-0.1.0",
+		"gitea":         "gitea/gitea-0.1.0",
+		"harbor":        "harbor/harbor-1.5.6",
+		"lagoon-core":   "lagoon-core/lagoon-core-0.1.0",
+	}) {
+		t.Errorf("got releases %v", installed)
+	}
+	if len(scripts) != 3 {
+		t.Errorf("expected the db tables and keycloak to be checked, got %q", scripts)
+	}
+	for _, s := range scripts {
+		if strings.Contains(s, "update realms/lagoon") {
+			t.Error("keycloak should not be configured again")
+		}
+	}
+	if !action.IsCompleted(action.Handler{Stage: "controller-setup",
+		LogFields: log.Fields{"cluster": "rockpool-controller"}, Name: "keycloak"}) {
+		t.Error("expected keycloak configuration to be recorded as completed")
+	}
+}
+
+// TestUpControllerResume ensures the releases are fetched again when resuming,
+// even if fetching them was recorded as completed, since the installers which
+// did not complete need them.
+func TestUpControllerResume(t *testing.T) {
+	f := setUp(t, "up-controller.yml")
+	scripts := []string{}
+	fakeControllerClient(t, &scripts)
+	h := helm.NewFake()
+	t.Cleanup(h.Install())
+	helm.Releases.Delete("rockpool-controller")
+	markCompleted(t,
+		"controller-setup/rockpool-controller/handler:fetch-releases",
+		"controller-setup/rockpool-controller/handler:gitea-test-repo",
+		"controller-setup/rockpool-controller/handler:lagoon-client",
+	)
+
+	err := Up(context.Background(), []string{platform.ControllerClusterName()})
+	for _, u := range f.Unmatched() {
+		t.Logf("unmatched: %q", u)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := helm.GetReleases("rockpool-controller"); err != nil {
+		t.Errorf("expected the releases to be fetched again: %s", err)
+	}
+}
+
+func TestUpDryRun(t *testing.T) {
+	f := setUp(t, "up-controller.yml")
+	action.DryRun = true
+	t.Cleanup(func() { action.DryRun = false })
+
+	if err := Up(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Only the docker context is looked up, to render the templates.
+	for _, c := range f.Calls() {
+		if c[0] != "docker" || c[1] != "context" {
+			t.Errorf("unexpected command in dry-run mode: %q", c)
+		}
+	}
+}
+
+func TestSetupNginxReverseProxyForRemotes(t *testing.T) {
+	setUp(t, "up-controller.yml")
+	platform.Targets = []platform.Target{
+		{Name: "prod", RemoteId: 1},
+		{Name: "dev", RemoteId: 3, RouterPattern: "${project}-${environment}.dev.rockpool.k3d.local"},
+	}
+	cluster.Clusters = []cluster.Cluster{
+		{Name: "rockpool-prod", IP: "172.18.0.3"},
+		{Name: "rockpool-dev", IP: "172.18.0.4"},
+	}
+	c := kube.NewFakeClient(nil)
+	t.Cleanup(kube.InstallFakeClient(c))
+
+	if err := SetupNginxReverseProxyForRemotes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	cm, err := kube.GetConfigMap(context.Background(), "rockpool-controller",
+		"ingress-nginx", "ingress-nginx-controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`server_name ~^[^.]+\\.[^.]+\\.prod\\.rockpool\\.k3d\\.local$;`,
+		`proxy_pass http://172.18.0.3/;`,
+		`server_name ~^[^.]+-[^.]+\\.dev\\.rockpool\\.k3d\\.local$;`,
+		`proxy_pass http://172.18.0.4/;`,
+	} {
+		if !strings.Contains(string(cm), want) {
+			t.Errorf("config map does not contain %q:\n%s", want, cm)
+		}
+	}
+}
+
+func TestApplyHostMounts(t *testing.T) {
+	setUp(t, "up-controller.yml")
+	target := platform.Target{Name: "dev", RemoteId: 1, Mounts: []platform.Mount{
+		{Name: "site", HostPath: "/home/dev/site", Size: "1Gi"},
+	}}
+	c := kube.NewFakeClient(nil)
+	t.Cleanup(kube.InstallFakeClient(c))
+
+	if err := ApplyHostMounts(context.Background(), target); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := c.Dynamic.Resource(schema.GroupVersionResource{Group: "storage.k8s.io",
+		Version: "v1", Resource: "storageclasses"}).Get(ctx, "rockpool-host", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the storage class to be created: %s", err)
+	}
+	pv, err := c.Dynamic.Resource(schema.GroupVersionResource{Version: "v1",
+		Resource: "persistentvolumes"}).Get(ctx, "rockpool-host-site", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, _, _ := unstructured.NestedString(pv.Object, "spec", "hostPath", "path")
+	size, _, _ := unstructured.NestedString(pv.Object, "spec", "capacity", "storage")
+	if path != "/mnt/rockpool/site" || size != "1Gi" {
+		t.Errorf("got host path %q and capacity %q", path, size)
+	}
+}
+
+func TestCheckPorts(t *testing.T) {
+	setUp(t, "up-controller.yml")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	platform.HostPorts.AMQP = port
+	t.Cleanup(func() { platform.HostPorts = platform.DefaultPorts })
+
+	f := command.NewFakeCommander(
+		command.Interaction{Args: []string{"docker", "ps", "**"}},
+		command.Interaction{Args: []string{"lsof", "**"}, Stdout: "p4242\ncrabbitmq\n"},
+	)
+	t.Cleanup(f.Install())
+
+	err = CheckPorts(context.Background(), []string{platform.ControllerClusterName()})
+	want := fmt.Sprintf("port %d/tcp is already in use by rabbitmq (pid 4242)", port)
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error containing %q, got %v", want, err)
+	}
+	if !f.Called("lsof", "-nP", fmt.Sprintf("-iTCP:%d", port), "-Fpc", "-sTCP:LISTEN") {
+		t.Errorf("expected the owner to be looked up, got %q", f.Calls())
+	}
+
+	// The ports of an existing cluster are not checked.
+	cluster.Clusters = []cluster.Cluster{{Name: platform.ControllerClusterName()}}
+	if err := CheckPorts(context.Background(), []string{platform.ControllerClusterName()}); err != nil {
+		t.Error(err)
+	}
+}
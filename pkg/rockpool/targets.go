@@ -0,0 +1,153 @@
+package rockpool
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/action"
+	"github.com/salsadigitalauorg/rockpool/pkg/cluster"
+	"github.com/salsadigitalauorg/rockpool/pkg/k3d"
+	"github.com/salsadigitalauorg/rockpool/pkg/kube"
+	"github.com/salsadigitalauorg/rockpool/pkg/lagoon"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NextTarget returns the definition of a new target, numbered after the
+// existing ones when they are all numbered, or using the next free remote id.
+func NextTarget(name string) platform.Target {
+	n := len(platform.Targets)
+	numbered := platform.NumberedTargets(n + 1)
+	isNumbered := slices.EqualFunc(platform.Targets, numbered[:n], func(a, b platform.Target) bool {
+		return reflect.DeepEqual(a, b)
+	})
+	if isNumbered && (name == "" || name == numbered[n].Name) {
+		return numbered[n]
+	}
+	if name == "" {
+		name = numbered[n].Name
+	}
+	id := 0
+	for _, t := range platform.Targets {
+		id = max(id, t.RemoteId)
+	}
+	return platform.Target{Name: name, RemoteId: id + 1}
+}
+
+// AddTarget adds the target to the platform, then creates and sets up its
+// cluster, registering its remote in Lagoon.
+func AddTarget(ctx context.Context, t platform.Target) error {
+	targets := append(append([]platform.Target{}, platform.Targets...), t)
+	if err := platform.ValidateTargets(targets); err != nil {
+		return err
+	}
+	if err := cluster.Fetch(ctx); err != nil {
+		return err
+	}
+	if !cluster.IsRunning(platform.ControllerClusterName()) {
+		return fmt.Errorf("the controller needs to be running to add a target")
+	}
+	platform.Targets = targets
+	log.WithFields(log.Fields{
+		"target":   t.Name,
+		"remoteId": t.RemoteId,
+	}).Info("adding target")
+	return Up(ctx, []string{t.ClusterName()})
+}
+
+// RemoveTarget deletes the target's remote from Lagoon, and its cluster,
+// unless it is an existing one. The environments deployed to the target are
+// migrated to the target migrateTo if given; otherwise, the target is only
+// removed if it has no environment.
+func RemoveTarget(ctx context.Context, name string, migrateTo string) error {
+	var t, to platform.Target
+	targets := []platform.Target{}
+	for _, pt := range platform.Targets {
+		switch pt.Name {
+		case name:
+			t = pt
+			continue
+		case migrateTo:
+			to = pt
+		}
+		targets = append(targets, pt)
+	}
+	if t.Name == "" {
+		return fmt.Errorf("unknown target '%s'", name)
+	}
+	if migrateTo != "" && to.Name == "" {
+		return fmt.Errorf("unknown target '%s' to migrate to", migrateTo)
+	}
+	logger := log.WithField("target", t.Name)
+
+	if err := cluster.Fetch(ctx); err != nil {
+		return err
+	}
+	if cluster.IsRunning(platform.ControllerClusterName()) {
+		if err := removeRemote(ctx, t, to); err != nil {
+			return err
+		}
+	} else {
+		logger.Warn("the controller is not running; the lagoon remote is not deleted")
+	}
+
+	platform.Targets = targets
+	cn := t.ClusterName()
+	if exists, c := cluster.Exists(cn); exists && c.Provider == cluster.ExistingProviderName {
+		if err := RemoveTargetCoreDNS(ctx, cn); err != nil {
+			logger.WithError(err).Warn("unable to remove coredns records")
+		}
+	} else if err := cluster.Delete(ctx, cn); err != nil {
+		return err
+	}
+	action.ClearClusterCheckpoints(cn)
+	delete(kube.Kubeconfigs, cn)
+	delete(k3d.K3sVersions, cn)
+	delete(cluster.Topologies, cn)
+
+	if !cluster.IsRunning(platform.ControllerClusterName()) {
+		return nil
+	}
+	return SetupNginxReverseProxyForRemotes(ctx)
+}
+
+// removeRemote deletes the target's remote, after migrating its environments
+// to the target to, if any.
+func removeRemote(ctx context.Context, t platform.Target, to platform.Target) error {
+	if err := lagoon.InitApiClient(ctx); err != nil {
+		return err
+	}
+	if err := lagoon.GetRemotes(ctx); err != nil {
+		return err
+	}
+	if !lagoon.RemoteExists(t.RemoteId, t.Remote()) {
+		log.WithField("remote", t.Remote()).Debug("lagoon remote does not exist")
+		return nil
+	}
+	envs, err := lagoon.RemoteEnvironments(ctx, t.RemoteId)
+	if err != nil {
+		return err
+	}
+	if len(envs) > 0 && to.Name == "" {
+		names := []string{}
+		for _, e := range envs {
+			names = append(names, e.Project.Name+"/"+e.Name)
+		}
+		return fmt.Errorf("target %s has environments deployed: %s; they can be moved to another target using --migrate-to",
+			t.Name, strings.Join(names, ", "))
+	}
+	if to.Name != "" {
+		if err := lagoon.MigrateRemote(ctx, t.RemoteId, to.RemoteId); err != nil {
+			return err
+		}
+		if len(envs) > 0 {
+			log.WithField("target", to.Name).
+				Warn("the migrated environments need to be deployed again")
+		}
+	}
+	return lagoon.DeleteRemote(ctx, t.Remote())
+}
@@ -0,0 +1,111 @@
+package rockpool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/salsadigitalauorg/rockpool/pkg/lagoon"
+	"github.com/salsadigitalauorg/rockpool/pkg/platform"
+
+	"github.com/shurcooL/graphql"
+)
+
+func TestNextTarget(t *testing.T) {
+	platform.Name = "rockpool"
+	t.Cleanup(func() { platform.Targets = nil })
+
+	for _, tc := range []struct {
+		targets []platform.Target
+		name    string
+		want    platform.Target
+	}{
+		{platform.NumberedTargets(1), "",
+			platform.Target{Name: "target-2", RemoteId: 2, RemoteName: "rockpool2"}},
+		{platform.NumberedTargets(2), "target-3",
+			platform.Target{Name: "target-3", RemoteId: 3, RemoteName: "rockpool3"}},
+		{platform.NumberedTargets(1), "staging",
+			platform.Target{Name: "staging", RemoteId: 2}},
+		{[]platform.Target{{Name: "prod", RemoteId: 1}, {Name: "dev", RemoteId: 5}}, "",
+			platform.Target{Name: "target-3", RemoteId: 6}},
+	} {
+		platform.Targets = tc.targets
+		if got := NextTarget(tc.name); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("NextTarget(%q) with %v: got %+v, want %+v", tc.name, tc.targets, got, tc.want)
+		}
+	}
+}
+
+// fakeLagoonApi serves the remotes and an environment deployed to the remote
+// 1, recording the mutations received.
+func fakeLagoonApi(t *testing.T, mutations *[]string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		var q struct{ Query string }
+		if err := json.Unmarshal(body, &q); err != nil {
+			t.Error(err)
+		}
+		switch {
+		case strings.HasPrefix(q.Query, "mutation"):
+			*mutations = append(*mutations, q.Query)
+			fmt.Fprint(w, `{"data": {}}`)
+		case strings.Contains(q.Query, "allKubernetes"):
+			fmt.Fprint(w, `{"data": {"allKubernetes": [
+				{"id": 1, "name": "prod"}, {"id": 2, "name": "dev"}]}}`)
+		case strings.Contains(q.Query, "allEnvironments"):
+			fmt.Fprint(w, `{"data": {"allEnvironments": [
+				{"id": 10, "name": "main", "project": {"id": 3, "name": "site"}, "kubernetes": {"id": 1}}]}}`)
+		case strings.Contains(q.Query, "allProjects"):
+			fmt.Fprint(w, `{"data": {"allProjects": [
+				{"id": 3, "name": "site", "kubernetes": {"id": 1}}]}}`)
+		default:
+			t.Errorf("unexpected query %q", q.Query)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	lagoon.GqlClient = graphql.NewClient(srv.URL, nil)
+	t.Cleanup(func() { lagoon.GqlClient = nil })
+}
+
+func TestRemoveRemote(t *testing.T) {
+	prod := platform.Target{Name: "prod", RemoteId: 1}
+	dev := platform.Target{Name: "dev", RemoteId: 2}
+
+	t.Run("refuses with environments", func(t *testing.T) {
+		mutations := []string{}
+		fakeLagoonApi(t, &mutations)
+		err := removeRemote(context.Background(), prod, platform.Target{})
+		if err == nil || !strings.Contains(err.Error(), "site/main") {
+			t.Errorf("expected the environments to be reported, got %v", err)
+		}
+		if len(mutations) > 0 {
+			t.Errorf("expected no mutation, got %q", mutations)
+		}
+	})
+
+	t.Run("migrates the environments", func(t *testing.T) {
+		mutations := []string{}
+		fakeLagoonApi(t, &mutations)
+		if err := removeRemote(context.Background(), prod, dev); err != nil {
+			t.Fatal(err)
+		}
+		if len(mutations) != 3 {
+			t.Fatalf("expected 3 mutations, got %q", mutations)
+		}
+		for i, want := range []string{"updateEnvironment", "updateProject", "deleteKubernetes"} {
+			if !strings.Contains(mutations[i], want) {
+				t.Errorf("expected mutation %d to be %s, got %q", i, want, mutations[i])
+			}
+		}
+		if lagoon.RemoteExists(prod.RemoteId, prod.Remote()) {
+			t.Error("expected the remote to be removed")
+		}
+	})
+}
@@ -1,17 +1,17 @@
 package ssh
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/salsadigitalauorg/rockpool/pkg/platform"
 
-	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 )
 
-func GetPublicKey() []byte {
+func GetPublicKey() ([]byte, error) {
 	home, _ := os.UserHomeDir()
 	var keyFile string
 	idEd25519 := filepath.Join(home, ".ssh", "id_ed25519.pub")
@@ -25,16 +25,21 @@ func GetPublicKey() []byte {
 	}
 	data, err := os.ReadFile(keyFile)
 	if err != nil {
-		log.WithField("keyFile", keyFile).WithError(err).Fatal("error reading ssh key")
+		return nil, fmt.Errorf("error reading ssh key '%s': %w", keyFile, err)
 	}
-	return data
+	return data, nil
 }
 
-func GetPublicKeyFingerprint() (string, string, string, string) {
-	key := GetPublicKey()
+// GetPublicKeyFingerprint returns the value, type, fingerprint and comment of
+// the public key.
+func GetPublicKeyFingerprint() (string, string, string, string, error) {
+	key, err := GetPublicKey()
+	if err != nil {
+		return "", "", "", "", err
+	}
 	pk, comment, _, _, err := ssh.ParseAuthorizedKey(key)
 	if err != nil {
-		panic(err)
+		return "", "", "", "", fmt.Errorf("error parsing ssh key: %w", err)
 	}
-	return strings.Split(string(key), " ")[1], pk.Type(), ssh.FingerprintSHA256(pk), comment
+	return strings.Split(string(key), " ")[1], pk.Type(), ssh.FingerprintSHA256(pk), comment, nil
 }